@@ -3,6 +3,7 @@ module main
 go 1.25.3
 
 require (
+	github.com/yuin/gopher-lua v1.1.1
 	google.golang.org/grpc v1.78.0
 	google.golang.org/protobuf v1.36.11
 	gopkg.in/yaml.v3 v3.0.1
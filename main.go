@@ -2,6 +2,7 @@ package main
 
 import (
 	"main/src/config"
+	"main/src/raft"
 	"main/src/service"
 	"os"
 	"os/signal"
@@ -23,18 +24,60 @@ func main() {
 	}
 
 	storageService := service.NewStorageService(cfg, log.Named("StorageService"))
-	redisService := service.NewRedisServices(storageService, cfg, log.Named("RedisService"))
+	storageService.StartExpirer()
+	defer storageService.StopExpirer()
+	// Peers of the cluster, shared by slot redirects and the broadcaster
+	network := raft.NewNetwork(cfg.Network)
+	redisService := service.NewRedisServices(storageService, network, cfg, log.Named("RedisService"))
 	tcpManager := service.NewTcpServiceManager(redisService, cfg, log.Named("TcpServiceManager"))
 	if err := tcpManager.Start(); err != nil {
 		panic(err)
 	}
 
+	var httpManager *service.HttpServiceManager
+	if cfg.HTTP.Enabled {
+		httpManager = service.NewHttpServiceManager(storageService, cfg, log.Named("HttpServiceManager"))
+		if err := httpManager.Start(); err != nil {
+			panic(err)
+		}
+	}
+
+	grpcManager := service.NewGrpcServiceManager(cfg, log.Named("GrpcServiceManager"))
+	// Committed writes are streamed to change data capture clients
+	service.NewWatchService(storageService, log.Named("WatchService")).Register(grpcManager.Server())
+	// Typed access to the same keyspace as the redis port
+	service.NewKeyValueService(storageService, log.Named("KeyValueService")).Register(grpcManager.Server())
+	// Locks and leases shared with LOCK and LEASE commands of the redis port
+	service.NewLockService(storageService, log.Named("LockService")).Register(grpcManager.Server())
+	service.NewLeaseService(storageService, log.Named("LeaseService")).Register(grpcManager.Server())
+
+	// Messages published on this node are forwarded to subscribers on other nodes
+	pubsub := redisService.PubSub()
+	broadcaster := raft.NewBroadcaster(network, log.Named("Broadcaster"), func(channel, message string) {
+		pubsub.Deliver(channel, message)
+	})
+	broadcaster.Register(grpcManager.Server())
+	if err := grpcManager.Start(); err != nil {
+		panic(err)
+	}
+	broadcaster.Start()
+	pubsub.SetForwarder(broadcaster.Broadcast)
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Info("Shutting down server...")
+	broadcaster.Stop()
+	if err := grpcManager.Stop(); err != nil {
+		log.Error("Error stopping gRPC server: %v", err)
+	}
+	if httpManager != nil {
+		if err := httpManager.Stop(); err != nil {
+			log.Error("Error stopping HTTP server: %v", err)
+		}
+	}
 	if err := tcpManager.Stop(); err != nil {
 		log.Error("Error stopping server: %v", err)
 	}
@@ -8,9 +8,12 @@ import (
 
 type Config struct {
 	Network  NetworkConfig  `yaml:"network"`
+	Storage  StorageConfig  `yaml:"storage"`
 	Snapshot SnapshotConfig `yaml:"snapshot"`
 	WAL      WALConfig      `yaml:"wal"`
 	Redis    RedisConfig    `yaml:"redis"`
+	Expire   ExpireConfig   `yaml:"expire"`
+	HTTP     HTTPConfig     `yaml:"http"`
 	Logger   LoggerConfig   `yaml:"logger"`
 }
 
@@ -19,8 +22,9 @@ type LoggerConfig struct {
 }
 
 type PeerConfig struct {
-	ID      string `yaml:"id"`
-	Address string `yaml:"address"`
+	ID           string `yaml:"id"`
+	Address      string `yaml:"address"`
+	RedisAddress string `yaml:"redis_address"` // host:port of the redis port, used for MOVED redirects
 }
 
 type NetworkConfig struct {
@@ -28,6 +32,15 @@ type NetworkConfig struct {
 	Peers []PeerConfig `yaml:"peers"`
 }
 
+type StorageConfig struct {
+	Engine           string `yaml:"engine"`            // "hash", "ordered", "lsm", "sharded" or "tiered"
+	Dir              string `yaml:"dir"`               // directory of "lsm" tables and "tiered" value log
+	HotSize          int64  `yaml:"hot_size"`          // bytes of values "tiered" keeps in memory
+	MaxMemory        int64  `yaml:"maxmemory"`         // bytes, 0 means no limit
+	MaxMemoryPolicy  string `yaml:"maxmemory_policy"`  // eviction policy once maxmemory is reached
+	MaxMemorySamples int    `yaml:"maxmemory_samples"` // number of keys sampled to pick a key to evict
+}
+
 type SnapshotConfig struct {
 	Path      string `yaml:"path"`
 	Interval  int    `yaml:"interval"`  // in seconds
@@ -35,7 +48,20 @@ type SnapshotConfig struct {
 }
 
 type WALConfig struct {
-	Path string `yaml:"path"`
+	Path         string `yaml:"path"`
+	WatchHistory int    `yaml:"watch_history"` // number of committed entries kept for watchers
+}
+
+type ExpireConfig struct {
+	Interval   int `yaml:"interval"`    // in milliseconds, 0 disables active expiration
+	SampleSize int `yaml:"sample_size"` // number of keys with expiration checked per round
+}
+
+type HTTPConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Address      string `yaml:"address"`        // host:port of the HTTP listener
+	MaxValueSize int64  `yaml:"max_value_size"` // max request body size in bytes
+	MaxPageSize  int    `yaml:"max_page_size"`  // max number of keys returned by a single listing
 }
 
 type RedisConfig struct {
@@ -48,17 +74,28 @@ type RedisConfig struct {
 	BaseWorkers              int    `yaml:"base_workers"`                // number of idle workers to keep alive
 	WorkerTTL                int    `yaml:"worker_ttl"`                  // in seconds
 	IdleConnectionsPerWorker int    `yaml:"idle_connections_per_worker"` // idle connections per worker threshold
+	ScriptTimeLimit          int    `yaml:"script_time_limit"`           // in milliseconds, 0 means no limit
+	PubSubOutputLimit        int64  `yaml:"pubsub_output_limit"`         // max bytes queued for a subscriber, 0 means no limit
+	NotifyKeyspaceEvents     string `yaml:"notify_keyspace_events"`      // redis notify-keyspace-events flags, empty disables notifications
 }
 
 func DefaultConfig() *Config {
 	return &Config{
+		Storage: StorageConfig{
+			Engine:           "hash",
+			Dir:              ".data/lsm",
+			HotSize:          64 * 1024 * 1024,
+			MaxMemoryPolicy:  "noeviction",
+			MaxMemorySamples: 5,
+		},
 		Snapshot: SnapshotConfig{
 			Path:      ".data/snapshot.db",
 			Interval:  3600,
 			Threshold: 1024 * 1024, // 1MB
 		},
 		WAL: WALConfig{
-			Path: ".data/wal.log",
+			Path:         ".data/wal.log",
+			WatchHistory: 10000,
 		},
 		Redis: RedisConfig{
 			Host:                     "localhost",
@@ -70,6 +107,18 @@ func DefaultConfig() *Config {
 			BaseWorkers:              10,
 			WorkerTTL:                10,
 			IdleConnectionsPerWorker: 3,
+			ScriptTimeLimit:          5000,
+			PubSubOutputLimit:        32 * 1024 * 1024, // 32MB
+		},
+		Expire: ExpireConfig{
+			Interval:   100,
+			SampleSize: 20,
+		},
+		HTTP: HTTPConfig{
+			Enabled:      false,
+			Address:      "localhost:8080",
+			MaxValueSize: 10 * 1024 * 1024, // 10MB
+			MaxPageSize:  1000,
 		},
 		Network: NetworkConfig{
 			Self: PeerConfig{
@@ -0,0 +1,42 @@
+package protocol
+
+import "encoding/json"
+
+// JSON renderings of RESP2 values for HTTP clients.
+// Simple and bulk strings become JSON strings, integers numbers, arrays JSON arrays,
+// nil becomes null and errors are objects {"error": message}.
+// Bulk strings are binary safe, invalid UTF-8 is replaced by encoding/json.
+
+// JSONValue converts value to a form which encoding/json renders as described above
+func JSONValue(value Resp2Value) any {
+	switch v := value.(type) {
+	case Resp2SimpleString:
+		return string(v)
+	case Resp2BulkString:
+		return string(v)
+	case Resp2Error:
+		return map[string]string{"error": string(v)}
+	case Resp2Integer:
+		return int64(v)
+	case Resp2Array:
+		return jsonArray(v)
+	case []Resp2Value:
+		return jsonArray(v)
+	default:
+		// nil and plain Go values used for convenience (string, int64, int)
+		return v
+	}
+}
+
+func jsonArray(values []Resp2Value) []any {
+	result := make([]any, 0, len(values))
+	for _, v := range values {
+		result = append(result, JSONValue(v))
+	}
+	return result
+}
+
+// RenderJSON renders value as JSON
+func RenderJSON(value Resp2Value) ([]byte, error) {
+	return json.Marshal(JSONValue(value))
+}
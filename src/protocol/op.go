@@ -4,26 +4,178 @@ import "fmt"
 
 type OpType int
 
+// New operation types must be appended at the end,
+// numeric values are persisted in the WAL.
 const (
 	GET OpType = iota
 	SET
 	DELETE
 	PING
+	SADD
+	SREM
+	SMEMBERS
+	SISMEMBER
+	SCARD
+	SINTER
+	SUNION
+	SDIFF
+	SINTERSTORE
+	SUNIONSTORE
+	SDIFFSTORE
+	ZADD
+	ZREM
+	ZCARD
+	ZSCORE
+	ZRANK
+	ZINCRBY
+	ZRANGE
+	XADD
+	XRANGE
+	XLEN
+	XTRIM
+	XREAD
+	XGROUP
+	XREADGROUP
+	XACK
+	XPENDING
+	XCLAIM
+	EXPIRE
+	TTL
+	PERSIST
+	// BATCH is never parsed from clients, it groups WAL entries which have to be applied together
+	BATCH
+	INCRBY
+	INCRBYFLOAT
+	APPEND
+	GETRANGE
+	SETRANGE
+	STRLEN
+	GETSET
+	GETDEL
+	DEL
+	EXISTS
+	MGET
+	MSET
+	MULTI
+	EXEC
+	DISCARD
+	WATCH
+	UNWATCH
+	EVAL
+	EVALSHA
+	SCRIPT
+	SUBSCRIBE
+	UNSUBSCRIBE
+	PSUBSCRIBE
+	PUNSUBSCRIBE
+	PUBLISH
+	SSUBSCRIBE
+	SUNSUBSCRIBE
+	SPUBLISH
+	CONFIG
+	GETREV
+	SETREV
+	DELREV
+	LOCK
+	LOCKRENEW
+	UNLOCK
+	LEASE
+	SCAN
+	KEYS
+	TYPE
+	RENAME
+	COPY
+	RANDOMKEY
+	DBSIZE
 )
 
+var opNames = map[OpType]string{
+	GET:          "GET",
+	SET:          "SET",
+	DELETE:       "DELETE",
+	PING:         "PING",
+	SADD:         "SADD",
+	SREM:         "SREM",
+	SMEMBERS:     "SMEMBERS",
+	SISMEMBER:    "SISMEMBER",
+	SCARD:        "SCARD",
+	SINTER:       "SINTER",
+	SUNION:       "SUNION",
+	SDIFF:        "SDIFF",
+	SINTERSTORE:  "SINTERSTORE",
+	SUNIONSTORE:  "SUNIONSTORE",
+	SDIFFSTORE:   "SDIFFSTORE",
+	ZADD:         "ZADD",
+	ZREM:         "ZREM",
+	ZCARD:        "ZCARD",
+	ZSCORE:       "ZSCORE",
+	ZRANK:        "ZRANK",
+	ZINCRBY:      "ZINCRBY",
+	ZRANGE:       "ZRANGE",
+	XADD:         "XADD",
+	XRANGE:       "XRANGE",
+	XLEN:         "XLEN",
+	XTRIM:        "XTRIM",
+	XREAD:        "XREAD",
+	XGROUP:       "XGROUP",
+	XREADGROUP:   "XREADGROUP",
+	XACK:         "XACK",
+	XPENDING:     "XPENDING",
+	XCLAIM:       "XCLAIM",
+	EXPIRE:       "EXPIRE",
+	TTL:          "TTL",
+	PERSIST:      "PERSIST",
+	BATCH:        "BATCH",
+	INCRBY:       "INCRBY",
+	INCRBYFLOAT:  "INCRBYFLOAT",
+	APPEND:       "APPEND",
+	GETRANGE:     "GETRANGE",
+	SETRANGE:     "SETRANGE",
+	STRLEN:       "STRLEN",
+	GETSET:       "GETSET",
+	GETDEL:       "GETDEL",
+	DEL:          "DEL",
+	EXISTS:       "EXISTS",
+	MGET:         "MGET",
+	MSET:         "MSET",
+	MULTI:        "MULTI",
+	EXEC:         "EXEC",
+	DISCARD:      "DISCARD",
+	WATCH:        "WATCH",
+	UNWATCH:      "UNWATCH",
+	EVAL:         "EVAL",
+	EVALSHA:      "EVALSHA",
+	SCRIPT:       "SCRIPT",
+	SUBSCRIBE:    "SUBSCRIBE",
+	UNSUBSCRIBE:  "UNSUBSCRIBE",
+	PSUBSCRIBE:   "PSUBSCRIBE",
+	PUNSUBSCRIBE: "PUNSUBSCRIBE",
+	PUBLISH:      "PUBLISH",
+	SSUBSCRIBE:   "SSUBSCRIBE",
+	SUNSUBSCRIBE: "SUNSUBSCRIBE",
+	SPUBLISH:     "SPUBLISH",
+	CONFIG:       "CONFIG",
+	GETREV:       "GETREV",
+	SETREV:       "SETREV",
+	DELREV:       "DELREV",
+	LOCK:         "LOCK",
+	LOCKRENEW:    "LOCKRENEW",
+	UNLOCK:       "UNLOCK",
+	LEASE:        "LEASE",
+	SCAN:         "SCAN",
+	KEYS:         "KEYS",
+	TYPE:         "TYPE",
+	RENAME:       "RENAME",
+	COPY:         "COPY",
+	RANDOMKEY:    "RANDOMKEY",
+	DBSIZE:       "DBSIZE",
+}
+
 func (o OpType) String() string {
-	switch o {
-	case GET:
-		return "GET"
-	case SET:
-		return "SET"
-	case DELETE:
-		return "DELETE"
-	case PING:
-		return "PING"
-	default:
-		return fmt.Sprintf("UNKNOWN(%d)", int(o))
+	if name, ok := opNames[o]; ok {
+		return name
 	}
+	return fmt.Sprintf("UNKNOWN(%d)", int(o))
 }
 
 type OpPayloadPing struct {
@@ -32,12 +184,23 @@ type OpPayloadPing struct {
 type OpPayloadSet struct {
 	Key   string
 	Value Resp2Value
+
+	// Expiration options, ExpireMs is a duration or unix time when ExpireAbsolute is set,
+	// 0 means the key does not expire
+	ExpireMs       int64
+	ExpireAbsolute bool
+	KeepTTL        bool
+
+	NX  bool // only set if key does not exist
+	XX  bool // only set if key already exists
+	Get bool // reply with the old value
 }
 
 type OpPayloadGet struct {
 	Key string
 }
 
+// OpPayloadDelete is used by the single key DELETE command, see OpPayloadDel for redis DEL
 type OpPayloadDelete struct {
 	Key string
 }
@@ -95,6 +258,11 @@ func (p *OpParser) Parse() (*Op, error) {
 		return nil, fmt.Errorf("expected RESP2 array for operation")
 	}
 
+	return ParseOp(array)
+}
+
+// ParseOp parses operation from already decoded command array
+func ParseOp(array []Resp2Value) (*Op, error) {
 	if len(array) == 0 {
 		return nil, fmt.Errorf("expected RESP2 array for operation")
 	}
@@ -118,19 +286,27 @@ func (p *OpParser) Parse() (*Op, error) {
 			},
 		}, nil
 	case "SET":
-		if len(array) != 3 {
+		if len(array) < 3 {
 			return nil, fmt.Errorf("SET operation requires 2 arguments")
 		}
 		key := extractString(array[1])
 		if key == "" && array[1] != nil {
 			return nil, fmt.Errorf("SET operation key must be a string")
 		}
+		payload := OpPayloadSet{
+			Key:   key,
+			Value: array[2],
+		}
+		options, err := extractMembers("SET", array[3:])
+		if err != nil {
+			return nil, err
+		}
+		if err := parseSetOptions(&payload, options); err != nil {
+			return nil, err
+		}
 		return &Op{
-			Kind: SET,
-			Payload: OpPayloadSet{
-				Key:   key,
-				Value: array[2],
-			},
+			Kind:    SET,
+			Payload: payload,
 		}, nil
 	case "DELETE":
 		if len(array) != 2 {
@@ -154,6 +330,37 @@ func (p *OpParser) Parse() (*Op, error) {
 			Kind:    PING,
 			Payload: OpPayloadPing{},
 		}, nil
+	case "SADD", "SREM", "SMEMBERS", "SISMEMBER", "SCARD",
+		"SINTER", "SUNION", "SDIFF", "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		return parseSetOp(opTypeStr, array)
+	case "ZADD", "ZREM", "ZCARD", "ZSCORE", "ZRANK", "ZREVRANK", "ZINCRBY",
+		"ZRANGE", "ZREVRANGE", "ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZRANGEBYLEX", "ZREVRANGEBYLEX":
+		return parseZSetOp(opTypeStr, array)
+	case "XADD", "XRANGE", "XREVRANGE", "XLEN", "XTRIM", "XREAD",
+		"XGROUP", "XREADGROUP", "XACK", "XPENDING", "XCLAIM":
+		return parseStreamOp(opTypeStr, array)
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT",
+		"TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME", "PERSIST":
+		return parseExpireOp(opTypeStr, array)
+	case "INCR", "DECR", "INCRBY", "DECRBY", "INCRBYFLOAT", "APPEND",
+		"GETRANGE", "SETRANGE", "STRLEN", "GETSET", "GETDEL":
+		return parseStringOp(opTypeStr, array)
+	case "DEL", "EXISTS", "MGET", "MSET", "MSETNX", "SCAN", "KEYS", "TYPE", "RENAME", "COPY", "RANDOMKEY", "DBSIZE":
+		return parseKeysOp(opTypeStr, array)
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+		return parseTxOp(opTypeStr, array)
+	case "EVAL", "EVALSHA", "SCRIPT":
+		return parseScriptOp(opTypeStr, array)
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "PUBLISH", "SSUBSCRIBE", "SUNSUBSCRIBE", "SPUBLISH":
+		return parsePubSubOp(opTypeStr, array)
+	case "CONFIG":
+		return parseConfigOp(opTypeStr, array)
+	case "GETREV", "SETREV", "DELREV":
+		return parseRevisionOp(opTypeStr, array)
+	case "LOCK", "LOCKRENEW", "UNLOCK":
+		return parseLockOp(opTypeStr, array)
+	case "LEASE":
+		return parseLeaseOp(opTypeStr, array)
 	default:
 		return nil, fmt.Errorf("unknown operation type: %s", opTypeStr)
 	}
@@ -177,6 +384,9 @@ func (p *OpParser) Render(op *Op) ([]byte, error) {
 			Resp2BulkString(payload.Key),
 			payload.Value,
 		}
+		for _, arg := range renderSetOptions(payload) {
+			array = append(array, Resp2BulkString(arg))
+		}
 	case DELETE:
 		payload := op.Payload.(OpPayloadDelete)
 		array = Resp2Array{
@@ -187,6 +397,85 @@ func (p *OpParser) Render(op *Op) ([]byte, error) {
 		array = Resp2Array{
 			Resp2SimpleString("PING"),
 		}
+	case SADD, SREM, SMEMBERS, SISMEMBER, SCARD,
+		SINTER, SUNION, SDIFF, SINTERSTORE, SUNIONSTORE, SDIFFSTORE:
+		var err error
+		array, err = renderSetOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case ZADD, ZREM, ZCARD, ZSCORE, ZRANK, ZINCRBY, ZRANGE:
+		var err error
+		array, err = renderZSetOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case XADD, XRANGE, XLEN, XTRIM, XREAD, XGROUP, XREADGROUP, XACK, XPENDING, XCLAIM:
+		var err error
+		array, err = renderStreamOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case EXPIRE, TTL, PERSIST:
+		var err error
+		array, err = renderExpireOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case INCRBY, INCRBYFLOAT, APPEND, GETRANGE, SETRANGE, STRLEN, GETSET, GETDEL:
+		var err error
+		array, err = renderStringOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case DEL, EXISTS, MGET, MSET, SCAN, KEYS, TYPE, RENAME, COPY, RANDOMKEY, DBSIZE:
+		var err error
+		array, err = renderKeysOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case MULTI, EXEC, DISCARD, WATCH, UNWATCH:
+		var err error
+		array, err = renderTxOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case EVAL, EVALSHA, SCRIPT:
+		var err error
+		array, err = renderScriptOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE, PUNSUBSCRIBE, PUBLISH, SSUBSCRIBE, SUNSUBSCRIBE, SPUBLISH:
+		var err error
+		array, err = renderPubSubOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case CONFIG:
+		var err error
+		array, err = renderConfigOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case GETREV, SETREV, DELREV:
+		var err error
+		array, err = renderRevisionOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case LOCK, LOCKRENEW, UNLOCK:
+		var err error
+		array, err = renderLockOp(op)
+		if err != nil {
+			return nil, err
+		}
+	case LEASE:
+		var err error
+		array, err = renderLeaseOp(op)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("unknown operation type: %v", op.Kind)
 	}
@@ -194,6 +483,30 @@ func (p *OpParser) Render(op *Op) ([]byte, error) {
 	return respParser.Render(array)
 }
 
+// extractKeys extracts string keys from command arguments,
+// failing with "<OP> operation key must be a string" like single key commands do.
+func extractKeys(op string, values []Resp2Value) ([]string, error) {
+	keys := make([]string, 0, len(values))
+	for _, v := range values {
+		key := extractString(v)
+		if key == "" && v != nil {
+			return nil, fmt.Errorf("%s operation key must be a string", op)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// renderCommand renders command name followed by its string arguments
+func renderCommand(name string, args ...string) Resp2Array {
+	array := make(Resp2Array, 0, len(args)+1)
+	array = append(array, Resp2SimpleString(name))
+	for _, arg := range args {
+		array = append(array, Resp2BulkString(arg))
+	}
+	return array
+}
+
 // extractString extracts a string from various RESP2 string types
 func extractString(value Resp2Value) string {
 	switch v := value.(type) {
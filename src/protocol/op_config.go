@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CONFIG payloads.
+// Parameter names are case insensitive and kept in lower case.
+
+type OpPayloadConfigGet struct {
+	Patterns []string
+}
+
+type OpPayloadConfigSet struct {
+	Parameter string
+	Value     string
+}
+
+func parseConfigOp(name string, array []Resp2Value) (*Op, error) {
+	args, err := extractMembers(name, array[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
+		return nil, fmt.Errorf("CONFIG operation requires a subcommand")
+	}
+
+	sub := strings.ToUpper(args[0])
+	switch {
+	case sub == "GET" && len(args) >= 2:
+		patterns := make([]string, 0, len(args)-1)
+		for _, pattern := range args[1:] {
+			patterns = append(patterns, strings.ToLower(pattern))
+		}
+		return &Op{Kind: CONFIG, Payload: OpPayloadConfigGet{Patterns: patterns}}, nil
+	case sub == "SET" && len(args) == 3:
+		return &Op{Kind: CONFIG, Payload: OpPayloadConfigSet{Parameter: strings.ToLower(args[1]), Value: args[2]}}, nil
+	default:
+		return nil, fmt.Errorf("unknown subcommand or wrong number of arguments for 'CONFIG %s'", args[0])
+	}
+}
+
+func renderConfigOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadConfigGet:
+		return renderCommand("CONFIG", append([]string{"GET"}, payload.Patterns...)...), nil
+	case OpPayloadConfigSet:
+		return renderCommand("CONFIG", "SET", payload.Parameter, payload.Value), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
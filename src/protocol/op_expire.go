@@ -0,0 +1,157 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key expiration payloads.
+// All durations and deadlines are kept in milliseconds.
+
+type ExpireFlags struct {
+	NX bool // only when key has no expiration
+	XX bool // only when key already has an expiration
+	GT bool // only when new expiration is greater than the current one
+	LT bool // only when new expiration is less than the current one
+}
+
+// OpPayloadExpire is used by EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT.
+// Ms is a duration or, when Absolute is set, unix time in milliseconds.
+type OpPayloadExpire struct {
+	Key      string
+	Ms       int64
+	Absolute bool
+	Flags    ExpireFlags
+}
+
+// OpPayloadTTL is used by TTL, PTTL, EXPIRETIME and PEXPIRETIME
+type OpPayloadTTL struct {
+	Key      string
+	Ms       bool // reply in milliseconds instead of seconds
+	Absolute bool // reply with unix time of the deadline instead of remaining time
+}
+
+type OpPayloadPersist struct {
+	Key string
+}
+
+func parseExpireOp(name string, array []Resp2Value) (*Op, error) {
+	args := array[1:]
+
+	switch name {
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s operation requires at least 2 arguments", name)
+		}
+		keys, err := extractKeys(name, args[:1])
+		if err != nil {
+			return nil, err
+		}
+		strArgs, err := extractMembers(name, args[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := strconv.ParseInt(strArgs[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value is not an integer or out of range")
+		}
+		payload := OpPayloadExpire{Key: keys[0], Ms: n, Absolute: strings.HasSuffix(name, "AT")}
+		if !strings.HasPrefix(name, "P") {
+			if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+				return nil, fmt.Errorf("invalid expire time in '%s' command", strings.ToLower(name))
+			}
+			payload.Ms = n * 1000
+		}
+		if !payload.Absolute && payload.Ms > math.MaxInt64-time.Now().UnixMilli() {
+			return nil, fmt.Errorf("invalid expire time in '%s' command", strings.ToLower(name))
+		}
+
+		for _, flag := range strArgs[1:] {
+			switch strings.ToUpper(flag) {
+			case "NX":
+				payload.Flags.NX = true
+			case "XX":
+				payload.Flags.XX = true
+			case "GT":
+				payload.Flags.GT = true
+			case "LT":
+				payload.Flags.LT = true
+			default:
+				return nil, fmt.Errorf("Unsupported option %s", flag)
+			}
+		}
+		flags := payload.Flags
+		if flags.NX && (flags.XX || flags.GT || flags.LT) {
+			return nil, fmt.Errorf("NX and XX, GT or LT options at the same time are not compatible")
+		}
+		if flags.GT && flags.LT {
+			return nil, fmt.Errorf("GT and LT options at the same time are not compatible")
+		}
+		return &Op{Kind: EXPIRE, Payload: payload}, nil
+	case "TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s operation requires 1 argument", name)
+		}
+		keys, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: TTL, Payload: OpPayloadTTL{
+			Key:      keys[0],
+			Ms:       strings.HasPrefix(name, "P"),
+			Absolute: strings.HasSuffix(name, "TIME"),
+		}}, nil
+	case "PERSIST":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("PERSIST operation requires 1 argument")
+		}
+		keys, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: PERSIST, Payload: OpPayloadPersist{Key: keys[0]}}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation type: %s", name)
+	}
+}
+
+func renderExpireOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadExpire:
+		name := "PEXPIRE"
+		if payload.Absolute {
+			name = "PEXPIREAT"
+		}
+		args := []string{payload.Key, strconv.FormatInt(payload.Ms, 10)}
+		if payload.Flags.NX {
+			args = append(args, "NX")
+		}
+		if payload.Flags.XX {
+			args = append(args, "XX")
+		}
+		if payload.Flags.GT {
+			args = append(args, "GT")
+		}
+		if payload.Flags.LT {
+			args = append(args, "LT")
+		}
+		return renderCommand(name, args...), nil
+	case OpPayloadTTL:
+		name := "TTL"
+		if payload.Absolute {
+			name = "EXPIRETIME"
+		}
+		if payload.Ms {
+			name = "P" + name
+		}
+		return renderCommand(name, payload.Key), nil
+	case OpPayloadPersist:
+		return renderCommand("PERSIST", payload.Key), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
@@ -0,0 +1,235 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Multi-key and keyspace operations payloads.
+// DEL is the redis compatible variant of DELETE, it accepts several keys
+// and replies with number of deleted keys.
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type] replies [next cursor, keys],
+// the iteration is complete when the next cursor is 0.
+
+type OpPayloadDel struct {
+	Keys []string
+}
+
+type OpPayloadExists struct {
+	Keys []string
+}
+
+type OpPayloadMGet struct {
+	Keys []string
+}
+
+// OpPayloadMSet is used by MSET and MSETNX, Values[i] is stored under Keys[i]
+type OpPayloadMSet struct {
+	Keys   []string
+	Values []Resp2Value
+	NX     bool // only set when none of the keys exists
+}
+
+type OpPayloadScan struct {
+	Cursor  uint64
+	Pattern string // empty matches all keys
+	Count   int64  // 0 uses the default
+	Type    string // empty matches all types
+}
+
+type OpPayloadKeys struct {
+	Pattern string
+}
+
+type OpPayloadType struct {
+	Key string
+}
+
+type OpPayloadRename struct {
+	Key    string
+	NewKey string
+}
+
+type OpPayloadCopy struct {
+	Source      string
+	Destination string
+	Replace     bool
+}
+
+type OpPayloadRandomKey struct{}
+
+type OpPayloadDBSize struct{}
+
+func parseKeysOp(name string, array []Resp2Value) (*Op, error) {
+	args := array[1:]
+
+	switch name {
+	case "DEL", "EXISTS", "MGET":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%s operation requires at least 1 argument", name)
+		}
+		keys, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "DEL":
+			return &Op{Kind: DEL, Payload: OpPayloadDel{Keys: keys}}, nil
+		case "EXISTS":
+			return &Op{Kind: EXISTS, Payload: OpPayloadExists{Keys: keys}}, nil
+		default:
+			return &Op{Kind: MGET, Payload: OpPayloadMGet{Keys: keys}}, nil
+		}
+	case "MSET", "MSETNX":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return nil, fmt.Errorf("%s operation requires key value pairs", name)
+		}
+		payload := OpPayloadMSet{NX: name == "MSETNX"}
+		for i := 0; i < len(args); i += 2 {
+			keys, err := extractKeys(name, args[i:i+1])
+			if err != nil {
+				return nil, err
+			}
+			payload.Keys = append(payload.Keys, keys[0])
+			payload.Values = append(payload.Values, args[i+1])
+		}
+		return &Op{Kind: MSET, Payload: payload}, nil
+	case "SCAN":
+		return parseScanOp(args)
+	case "KEYS", "TYPE":
+		keys, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) != 1 {
+			return nil, fmt.Errorf("%s operation requires 1 argument", name)
+		}
+		if name == "KEYS" {
+			return &Op{Kind: KEYS, Payload: OpPayloadKeys{Pattern: keys[0]}}, nil
+		}
+		return &Op{Kind: TYPE, Payload: OpPayloadType{Key: keys[0]}}, nil
+	case "RENAME":
+		keys, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) != 2 {
+			return nil, fmt.Errorf("RENAME operation requires 2 arguments")
+		}
+		return &Op{Kind: RENAME, Payload: OpPayloadRename{Key: keys[0], NewKey: keys[1]}}, nil
+	case "COPY":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("COPY operation requires source, destination and optional REPLACE")
+		}
+		keys, err := extractKeys(name, args[:2])
+		if err != nil {
+			return nil, err
+		}
+		payload := OpPayloadCopy{Source: keys[0], Destination: keys[1]}
+		if len(args) == 3 {
+			if option, _ := extractMembers(name, args[2:]); len(option) != 1 || strings.ToUpper(option[0]) != "REPLACE" {
+				return nil, fmt.Errorf("syntax error")
+			}
+			payload.Replace = true
+		}
+		return &Op{Kind: COPY, Payload: payload}, nil
+	case "RANDOMKEY", "DBSIZE":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s operation requires no arguments", name)
+		}
+		if name == "RANDOMKEY" {
+			return &Op{Kind: RANDOMKEY, Payload: OpPayloadRandomKey{}}, nil
+		}
+		return &Op{Kind: DBSIZE, Payload: OpPayloadDBSize{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation type: %s", name)
+	}
+}
+
+func renderKeysOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadDel:
+		return renderCommand("DEL", payload.Keys...), nil
+	case OpPayloadExists:
+		return renderCommand("EXISTS", payload.Keys...), nil
+	case OpPayloadMGet:
+		return renderCommand("MGET", payload.Keys...), nil
+	case OpPayloadMSet:
+		name := "MSET"
+		if payload.NX {
+			name = "MSETNX"
+		}
+		array := renderCommand(name)
+		for i, key := range payload.Keys {
+			array = append(array, Resp2BulkString(key), payload.Values[i])
+		}
+		return array, nil
+	case OpPayloadScan:
+		args := []string{strconv.FormatUint(payload.Cursor, 10)}
+		if payload.Pattern != "" {
+			args = append(args, "MATCH", payload.Pattern)
+		}
+		if payload.Count > 0 {
+			args = append(args, "COUNT", strconv.FormatInt(payload.Count, 10))
+		}
+		if payload.Type != "" {
+			args = append(args, "TYPE", payload.Type)
+		}
+		return renderCommand("SCAN", args...), nil
+	case OpPayloadKeys:
+		return renderCommand("KEYS", payload.Pattern), nil
+	case OpPayloadType:
+		return renderCommand("TYPE", payload.Key), nil
+	case OpPayloadRename:
+		return renderCommand("RENAME", payload.Key, payload.NewKey), nil
+	case OpPayloadCopy:
+		args := []string{payload.Source, payload.Destination}
+		if payload.Replace {
+			args = append(args, "REPLACE")
+		}
+		return renderCommand("COPY", args...), nil
+	case OpPayloadRandomKey:
+		return renderCommand("RANDOMKEY"), nil
+	case OpPayloadDBSize:
+		return renderCommand("DBSIZE"), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
+
+// parseScanOp parses cursor [MATCH pattern] [COUNT count] [TYPE type]
+func parseScanOp(values []Resp2Value) (*Op, error) {
+	args, err := extractMembers("SCAN", values)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
+		return nil, fmt.Errorf("SCAN operation requires a cursor")
+	}
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	payload := OpPayloadScan{Cursor: cursor}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("syntax error")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			payload.Pattern = args[i+1]
+		case "COUNT":
+			count, err := parseNonNegative(args[i+1])
+			if err != nil || count == 0 {
+				return nil, fmt.Errorf("syntax error")
+			}
+			payload.Count = count
+		case "TYPE":
+			payload.Type = strings.ToLower(args[i+1])
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+	}
+	return &Op{Kind: SCAN, Payload: payload}, nil
+}
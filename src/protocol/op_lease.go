@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LEASE payloads.
+// LEASE GRANT ttl replies id of the new lease, LEASE ATTACH id key [key ...] replies number
+// of attached keys, LEASE KEEPALIVE id and LEASE REVOKE id reply 1 when the lease was alive,
+// LEASE TTL id replies [remaining, granted, [keys]] or nil and LEASE LIST replies ids of alive leases.
+// Time to live is in milliseconds.
+
+type OpPayloadLeaseGrant struct {
+	TTL int64
+}
+
+type OpPayloadLeaseAttach struct {
+	ID   uint64
+	Keys []string
+}
+
+type OpPayloadLeaseKeepAlive struct {
+	ID uint64
+}
+
+type OpPayloadLeaseRevoke struct {
+	ID uint64
+}
+
+type OpPayloadLeaseTTL struct {
+	ID uint64
+}
+
+type OpPayloadLeaseList struct{}
+
+func parseLeaseOp(name string, array []Resp2Value) (*Op, error) {
+	args, err := extractMembers(name, array[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
+		return nil, fmt.Errorf("LEASE operation requires a subcommand")
+	}
+
+	sub := strings.ToUpper(args[0])
+	var id uint64
+	if sub != "GRANT" && sub != "LIST" && len(args) >= 2 {
+		if id, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("lease id is not a non-negative integer or out of range")
+		}
+	}
+	switch {
+	case sub == "GRANT" && len(args) == 2:
+		ttl, err := parseLease(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if ttl > math.MaxInt64-time.Now().UnixMilli() {
+			return nil, fmt.Errorf("invalid lease time, deadline is out of range")
+		}
+		return &Op{Kind: LEASE, Payload: OpPayloadLeaseGrant{TTL: ttl}}, nil
+	case sub == "ATTACH" && len(args) >= 3:
+		return &Op{Kind: LEASE, Payload: OpPayloadLeaseAttach{ID: id, Keys: args[2:]}}, nil
+	case sub == "KEEPALIVE" && len(args) == 2:
+		return &Op{Kind: LEASE, Payload: OpPayloadLeaseKeepAlive{ID: id}}, nil
+	case sub == "REVOKE" && len(args) == 2:
+		return &Op{Kind: LEASE, Payload: OpPayloadLeaseRevoke{ID: id}}, nil
+	case sub == "TTL" && len(args) == 2:
+		return &Op{Kind: LEASE, Payload: OpPayloadLeaseTTL{ID: id}}, nil
+	case sub == "LIST" && len(args) == 1:
+		return &Op{Kind: LEASE, Payload: OpPayloadLeaseList{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown subcommand or wrong number of arguments for 'LEASE %s'", args[0])
+	}
+}
+
+func renderLeaseOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadLeaseGrant:
+		return renderCommand("LEASE", "GRANT", strconv.FormatInt(payload.TTL, 10)), nil
+	case OpPayloadLeaseAttach:
+		return renderCommand("LEASE", append([]string{"ATTACH", strconv.FormatUint(payload.ID, 10)}, payload.Keys...)...), nil
+	case OpPayloadLeaseKeepAlive:
+		return renderCommand("LEASE", "KEEPALIVE", strconv.FormatUint(payload.ID, 10)), nil
+	case OpPayloadLeaseRevoke:
+		return renderCommand("LEASE", "REVOKE", strconv.FormatUint(payload.ID, 10)), nil
+	case OpPayloadLeaseTTL:
+		return renderCommand("LEASE", "TTL", strconv.FormatUint(payload.ID, 10)), nil
+	case OpPayloadLeaseList:
+		return renderCommand("LEASE", "LIST"), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
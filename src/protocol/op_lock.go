@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Distributed lock payloads.
+// LOCK name owner ttl [BLOCK ms] replies fencing token or nil when the lock is held,
+// LOCKRENEW name token ttl and UNLOCK name token reply 1 when the lock was held with the token.
+// Leases are in milliseconds.
+
+type OpPayloadLock struct {
+	Name  string
+	Owner string
+	TTL   int64
+	Block int64 // milliseconds to wait for the lock, -1 does not wait, 0 waits forever
+}
+
+type OpPayloadLockRenew struct {
+	Name  string
+	Token uint64
+	TTL   int64
+}
+
+type OpPayloadUnlock struct {
+	Name  string
+	Token uint64
+}
+
+func parseLease(s string) (int64, error) {
+	ttl, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid lease time, it must be a positive number of milliseconds")
+	}
+	return ttl, nil
+}
+
+func parseToken(s string) (uint64, error) {
+	token, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("token is not a non-negative integer or out of range")
+	}
+	return token, nil
+}
+
+func parseLockOp(name string, array []Resp2Value) (*Op, error) {
+	keys, err := extractKeys(name, array[1:min(len(array), 2)])
+	if err != nil {
+		return nil, err
+	}
+	args, err := extractMembers(name, array[min(len(array), 2):])
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "LOCK":
+		if len(keys) != 1 || (len(args) != 2 && len(args) != 4) {
+			return nil, fmt.Errorf("LOCK operation requires name, owner, ttl and optional BLOCK ms")
+		}
+		ttl, err := parseLease(args[1])
+		if err != nil {
+			return nil, err
+		}
+		payload := OpPayloadLock{Name: keys[0], Owner: args[0], TTL: ttl, Block: -1}
+		if len(args) == 4 {
+			if strings.ToUpper(args[2]) != "BLOCK" {
+				return nil, fmt.Errorf("syntax error")
+			}
+			if payload.Block, err = parseNonNegative(args[3]); err != nil {
+				return nil, err
+			}
+		}
+		return &Op{Kind: LOCK, Payload: payload}, nil
+	case "LOCKRENEW":
+		if len(keys) != 1 || len(args) != 2 {
+			return nil, fmt.Errorf("LOCKRENEW operation requires 3 arguments")
+		}
+		token, err := parseToken(args[0])
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := parseLease(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: LOCKRENEW, Payload: OpPayloadLockRenew{Name: keys[0], Token: token, TTL: ttl}}, nil
+	case "UNLOCK":
+		if len(keys) != 1 || len(args) != 1 {
+			return nil, fmt.Errorf("UNLOCK operation requires 2 arguments")
+		}
+		token, err := parseToken(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: UNLOCK, Payload: OpPayloadUnlock{Name: keys[0], Token: token}}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation type: %s", name)
+	}
+}
+
+func renderLockOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadLock:
+		args := []string{payload.Name, payload.Owner, strconv.FormatInt(payload.TTL, 10)}
+		if payload.Block >= 0 {
+			args = append(args, "BLOCK", strconv.FormatInt(payload.Block, 10))
+		}
+		return renderCommand("LOCK", args...), nil
+	case OpPayloadLockRenew:
+		return renderCommand("LOCKRENEW", payload.Name,
+			strconv.FormatUint(payload.Token, 10), strconv.FormatInt(payload.TTL, 10)), nil
+	case OpPayloadUnlock:
+		return renderCommand("UNLOCK", payload.Name, strconv.FormatUint(payload.Token, 10)), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
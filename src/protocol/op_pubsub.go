@@ -0,0 +1,74 @@
+package protocol
+
+import "fmt"
+
+// Pub/Sub payloads.
+// Messages are delivered only to connected subscribers, nothing is written to the WAL.
+// Sharded commands (SSUBSCRIBE, SUNSUBSCRIBE, SPUBLISH) use the same payloads.
+
+// OpPayloadSubscribe is used by SUBSCRIBE, PSUBSCRIBE and SSUBSCRIBE
+type OpPayloadSubscribe struct {
+	Channels []string
+}
+
+// OpPayloadUnsubscribe is used by UNSUBSCRIBE, PUNSUBSCRIBE and SUNSUBSCRIBE, no channels means all of them
+type OpPayloadUnsubscribe struct {
+	Channels []string
+}
+
+type OpPayloadPublish struct {
+	Channel string
+	Message string
+}
+
+func parsePubSubOp(name string, array []Resp2Value) (*Op, error) {
+	args := array[1:]
+
+	switch name {
+	case "SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%s operation requires at least 1 argument", name)
+		}
+		channels, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		kinds := map[string]OpType{"SUBSCRIBE": SUBSCRIBE, "PSUBSCRIBE": PSUBSCRIBE, "SSUBSCRIBE": SSUBSCRIBE}
+		return &Op{Kind: kinds[name], Payload: OpPayloadSubscribe{Channels: channels}}, nil
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE", "SUNSUBSCRIBE":
+		channels, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		kinds := map[string]OpType{"UNSUBSCRIBE": UNSUBSCRIBE, "PUNSUBSCRIBE": PUNSUBSCRIBE, "SUNSUBSCRIBE": SUNSUBSCRIBE}
+		return &Op{Kind: kinds[name], Payload: OpPayloadUnsubscribe{Channels: channels}}, nil
+	case "PUBLISH", "SPUBLISH":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s operation requires 2 arguments", name)
+		}
+		strArgs, err := extractMembers(name, args)
+		if err != nil {
+			return nil, err
+		}
+		kind := PUBLISH
+		if name == "SPUBLISH" {
+			kind = SPUBLISH
+		}
+		return &Op{Kind: kind, Payload: OpPayloadPublish{Channel: strArgs[0], Message: strArgs[1]}}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation type: %s", name)
+	}
+}
+
+func renderPubSubOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadSubscribe:
+		return renderCommand(op.Kind.String(), payload.Channels...), nil
+	case OpPayloadUnsubscribe:
+		return renderCommand(op.Kind.String(), payload.Channels...), nil
+	case OpPayloadPublish:
+		return renderCommand(op.Kind.String(), payload.Channel, payload.Message), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
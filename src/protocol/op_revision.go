@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Versioned keys payloads.
+// GETREV key replies [value, revision], SETREV key revision value and DELREV key revision
+// modify the key only when its modification revision matches, revision 0 stands for a missing key.
+
+type OpPayloadGetRev struct {
+	Key string
+}
+
+type OpPayloadSetRev struct {
+	Key      string
+	Revision uint64
+	Value    Resp2Value
+}
+
+type OpPayloadDelRev struct {
+	Key      string
+	Revision uint64
+}
+
+func parseRevisionOp(name string, array []Resp2Value) (*Op, error) {
+	args := array[1:]
+
+	arity := map[string]int{"GETREV": 1, "SETREV": 3, "DELREV": 2}
+	if len(args) != arity[name] {
+		if arity[name] == 1 {
+			return nil, fmt.Errorf("%s operation requires 1 argument", name)
+		}
+		return nil, fmt.Errorf("%s operation requires %d arguments", name, arity[name])
+	}
+	keys, err := extractKeys(name, args[:1])
+	if err != nil {
+		return nil, err
+	}
+	key := keys[0]
+	if name == "GETREV" {
+		return &Op{Kind: GETREV, Payload: OpPayloadGetRev{Key: key}}, nil
+	}
+
+	revision, err := strconv.ParseUint(extractString(args[1]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("revision is not a non-negative integer or out of range")
+	}
+	if name == "SETREV" {
+		return &Op{Kind: SETREV, Payload: OpPayloadSetRev{Key: key, Revision: revision, Value: args[2]}}, nil
+	}
+	return &Op{Kind: DELREV, Payload: OpPayloadDelRev{Key: key, Revision: revision}}, nil
+}
+
+func renderRevisionOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadGetRev:
+		return renderCommand("GETREV", payload.Key), nil
+	case OpPayloadSetRev:
+		return Resp2Array{
+			Resp2SimpleString("SETREV"),
+			Resp2BulkString(payload.Key),
+			Resp2BulkString(strconv.FormatUint(payload.Revision, 10)),
+			payload.Value,
+		}, nil
+	case OpPayloadDelRev:
+		return renderCommand("DELREV", payload.Key, strconv.FormatUint(payload.Revision, 10)), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
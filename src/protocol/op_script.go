@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Scripting payloads.
+// Scripts are never written to the WAL, only the commands they execute are.
+
+type OpPayloadEval struct {
+	Script string
+	Keys   []string
+	Args   []string
+}
+
+// OpPayloadEvalSha runs a cached script, SHA is lower case hex SHA1 of its source
+type OpPayloadEvalSha struct {
+	SHA  string
+	Keys []string
+	Args []string
+}
+
+type OpPayloadScriptLoad struct {
+	Script string
+}
+
+type OpPayloadScriptExists struct {
+	SHAs []string
+}
+
+type OpPayloadScriptFlush struct {
+}
+
+func parseScriptOp(name string, array []Resp2Value) (*Op, error) {
+	args, err := extractMembers(name, array[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "EVAL", "EVALSHA":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s operation requires at least 2 arguments", name)
+		}
+		numKeys, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value is not an integer or out of range")
+		}
+		if numKeys < 0 {
+			return nil, fmt.Errorf("Number of keys can't be negative")
+		}
+		if numKeys > int64(len(args)-2) {
+			return nil, fmt.Errorf("Number of keys can't be greater than number of args")
+		}
+		keys := args[2 : 2+numKeys]
+		rest := args[2+numKeys:]
+		if name == "EVAL" {
+			return &Op{Kind: EVAL, Payload: OpPayloadEval{Script: args[0], Keys: keys, Args: rest}}, nil
+		}
+		return &Op{Kind: EVALSHA, Payload: OpPayloadEvalSha{SHA: strings.ToLower(args[0]), Keys: keys, Args: rest}}, nil
+	case "SCRIPT":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("SCRIPT operation requires a subcommand")
+		}
+		sub := strings.ToUpper(args[0])
+		switch {
+		case sub == "LOAD" && len(args) == 2:
+			return &Op{Kind: SCRIPT, Payload: OpPayloadScriptLoad{Script: args[1]}}, nil
+		case sub == "EXISTS" && len(args) >= 2:
+			shas := make([]string, 0, len(args)-1)
+			for _, sha := range args[1:] {
+				shas = append(shas, strings.ToLower(sha))
+			}
+			return &Op{Kind: SCRIPT, Payload: OpPayloadScriptExists{SHAs: shas}}, nil
+		case sub == "FLUSH" && len(args) <= 2:
+			// ASYNC and SYNC flush the cache the same way
+			if len(args) == 2 && !strings.EqualFold(args[1], "ASYNC") && !strings.EqualFold(args[1], "SYNC") {
+				return nil, fmt.Errorf("SCRIPT FLUSH only support SYNC|ASYNC option")
+			}
+			return &Op{Kind: SCRIPT, Payload: OpPayloadScriptFlush{}}, nil
+		default:
+			return nil, fmt.Errorf("unknown subcommand or wrong number of arguments for 'SCRIPT %s'", args[0])
+		}
+	default:
+		return nil, fmt.Errorf("unknown operation type: %s", name)
+	}
+}
+
+func renderScriptOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadEval:
+		return renderEval("EVAL", payload.Script, payload.Keys, payload.Args), nil
+	case OpPayloadEvalSha:
+		return renderEval("EVALSHA", payload.SHA, payload.Keys, payload.Args), nil
+	case OpPayloadScriptLoad:
+		return renderCommand("SCRIPT", "LOAD", payload.Script), nil
+	case OpPayloadScriptExists:
+		return renderCommand("SCRIPT", append([]string{"EXISTS"}, payload.SHAs...)...), nil
+	case OpPayloadScriptFlush:
+		return renderCommand("SCRIPT", "FLUSH"), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
+
+func renderEval(name, script string, keys, args []string) Resp2Array {
+	all := make([]string, 0, len(keys)+len(args)+2)
+	all = append(all, script, strconv.Itoa(len(keys)))
+	all = append(all, keys...)
+	all = append(all, args...)
+	return renderCommand(name, all...)
+}
@@ -0,0 +1,155 @@
+package protocol
+
+import "fmt"
+
+// Set operations payloads
+
+type OpPayloadSAdd struct {
+	Key     string
+	Members []string
+}
+
+type OpPayloadSRem struct {
+	Key     string
+	Members []string
+}
+
+type OpPayloadSMembers struct {
+	Key string
+}
+
+type OpPayloadSIsMember struct {
+	Key    string
+	Member string
+}
+
+type OpPayloadSCard struct {
+	Key string
+}
+
+// OpPayloadSetAlgebra is used by SINTER, SUNION and SDIFF
+type OpPayloadSetAlgebra struct {
+	Keys []string
+}
+
+// OpPayloadSetAlgebraStore is used by SINTERSTORE, SUNIONSTORE and SDIFFSTORE
+type OpPayloadSetAlgebraStore struct {
+	Destination string
+	Keys        []string
+}
+
+var setAlgebraOps = map[string]OpType{
+	"SINTER":      SINTER,
+	"SUNION":      SUNION,
+	"SDIFF":       SDIFF,
+	"SINTERSTORE": SINTERSTORE,
+	"SUNIONSTORE": SUNIONSTORE,
+	"SDIFFSTORE":  SDIFFSTORE,
+}
+
+// extractMembers extracts set members, unlike keys members may be empty strings
+func extractMembers(op string, values []Resp2Value) ([]string, error) {
+	members := make([]string, 0, len(values))
+	for _, v := range values {
+		switch m := v.(type) {
+		case Resp2BulkString:
+			members = append(members, string(m))
+		case Resp2SimpleString:
+			members = append(members, string(m))
+		default:
+			return nil, fmt.Errorf("%s operation member must be a string", op)
+		}
+	}
+	return members, nil
+}
+
+func parseSetOp(name string, array []Resp2Value) (*Op, error) {
+	args := array[1:]
+
+	switch name {
+	case "SADD", "SREM":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s operation requires at least 2 arguments", name)
+		}
+		keys, err := extractKeys(name, args[:1])
+		if err != nil {
+			return nil, err
+		}
+		members, err := extractMembers(name, args[1:])
+		if err != nil {
+			return nil, err
+		}
+		if name == "SADD" {
+			return &Op{Kind: SADD, Payload: OpPayloadSAdd{Key: keys[0], Members: members}}, nil
+		}
+		return &Op{Kind: SREM, Payload: OpPayloadSRem{Key: keys[0], Members: members}}, nil
+	case "SMEMBERS", "SCARD":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s operation requires 1 argument", name)
+		}
+		keys, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		if name == "SMEMBERS" {
+			return &Op{Kind: SMEMBERS, Payload: OpPayloadSMembers{Key: keys[0]}}, nil
+		}
+		return &Op{Kind: SCARD, Payload: OpPayloadSCard{Key: keys[0]}}, nil
+	case "SISMEMBER":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("SISMEMBER operation requires 2 arguments")
+		}
+		keys, err := extractKeys(name, args[:1])
+		if err != nil {
+			return nil, err
+		}
+		members, err := extractMembers(name, args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: SISMEMBER, Payload: OpPayloadSIsMember{Key: keys[0], Member: members[0]}}, nil
+	case "SINTER", "SUNION", "SDIFF":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%s operation requires at least 1 argument", name)
+		}
+		keys, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: setAlgebraOps[name], Payload: OpPayloadSetAlgebra{Keys: keys}}, nil
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s operation requires at least 2 arguments", name)
+		}
+		keys, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: setAlgebraOps[name], Payload: OpPayloadSetAlgebraStore{Destination: keys[0], Keys: keys[1:]}}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation type: %s", name)
+	}
+}
+
+func renderSetOp(op *Op) (Resp2Array, error) {
+	name := op.Kind.String()
+
+	switch payload := op.Payload.(type) {
+	case OpPayloadSAdd:
+		return renderCommand(name, append([]string{payload.Key}, payload.Members...)...), nil
+	case OpPayloadSRem:
+		return renderCommand(name, append([]string{payload.Key}, payload.Members...)...), nil
+	case OpPayloadSMembers:
+		return renderCommand(name, payload.Key), nil
+	case OpPayloadSIsMember:
+		return renderCommand(name, payload.Key, payload.Member), nil
+	case OpPayloadSCard:
+		return renderCommand(name, payload.Key), nil
+	case OpPayloadSetAlgebra:
+		return renderCommand(name, payload.Keys...), nil
+	case OpPayloadSetAlgebraStore:
+		return renderCommand(name, append([]string{payload.Destination}, payload.Keys...)...), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for operation %v", op.Payload, op.Kind)
+	}
+}
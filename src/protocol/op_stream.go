@@ -0,0 +1,714 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// StreamID identifies stream entry, ordered by milliseconds time first and sequence second.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinStreamID = StreamID{}
+	MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// Next returns the smallest ID greater than id, ok is false on overflow.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{Ms: id.Ms + 1}, true
+	default:
+		return id, false
+	}
+}
+
+// Prev returns the greatest ID smaller than id, ok is false on underflow.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	default:
+		return id, false
+	}
+}
+
+// ParseStreamID parses "<ms>-<seq>" or "<ms>", missing sequence is replaced by defaultSeq.
+func ParseStreamID(s string, defaultSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("Invalid stream ID specified as stream command argument")
+	}
+	seq := defaultSeq
+	if hasSeq {
+		seq, err = strconv.ParseUint(seqPart, 10, 64)
+		if err != nil {
+			return StreamID{}, fmt.Errorf("Invalid stream ID specified as stream command argument")
+		}
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// parseStreamRangeBound parses XRANGE bound: "-", "+", "(" exclusive prefix and incomplete IDs.
+func parseStreamRangeBound(s string, isStart bool) (StreamID, error) {
+	switch s {
+	case "-":
+		return MinStreamID, nil
+	case "+":
+		return MaxStreamID, nil
+	}
+
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	defaultSeq := uint64(0)
+	if !isStart {
+		defaultSeq = math.MaxUint64
+	}
+	id, err := ParseStreamID(s, defaultSeq)
+	if err != nil {
+		return id, err
+	}
+	if !exclusive {
+		return id, nil
+	}
+
+	var ok bool
+	if isStart {
+		id, ok = id.Next()
+	} else {
+		id, ok = id.Prev()
+	}
+	if !ok {
+		return id, fmt.Errorf("invalid start or end ID for the interval")
+	}
+	return id, nil
+}
+
+// StreamTrim is MAXLEN or MINID trimming strategy of XADD and XTRIM.
+// Approximate trimming removes only whole stream segments.
+type StreamTrim struct {
+	MinID     bool
+	Approx    bool
+	MaxLen    int64
+	Threshold StreamID
+}
+
+// StreamReadID is an ID argument of XREAD/XREADGROUP/XGROUP,
+// "$" stands for last ID in the stream and ">" for never delivered entries.
+type StreamReadID struct {
+	ID   StreamID
+	Last bool
+	New  bool
+}
+
+func (id StreamReadID) String() string {
+	switch {
+	case id.Last:
+		return "$"
+	case id.New:
+		return ">"
+	default:
+		return id.ID.String()
+	}
+}
+
+type OpPayloadXAdd struct {
+	Key        string
+	NoMkStream bool
+	Trim       *StreamTrim
+	ID         StreamID
+	AutoID     bool // "*"
+	AutoSeq    bool // "<ms>-*"
+	Fields     []string
+}
+
+// OpPayloadXRange is used by both XRANGE and XREVRANGE,
+// Start is always the lower bound.
+type OpPayloadXRange struct {
+	Key   string
+	Start StreamID
+	End   StreamID
+	Count int64 // negative means no limit
+	Rev   bool
+}
+
+type OpPayloadXLen struct {
+	Key string
+}
+
+type OpPayloadXTrim struct {
+	Key  string
+	Trim StreamTrim
+}
+
+type OpPayloadXRead struct {
+	Count int64 // negative means no limit
+	Block int64 // in milliseconds, negative means do not block, 0 blocks forever
+	Keys  []string
+	IDs   []StreamReadID
+}
+
+type OpPayloadXGroup struct {
+	Subcommand string // CREATE or DESTROY
+	Key        string
+	Group      string
+	ID         StreamReadID
+	MkStream   bool
+}
+
+type OpPayloadXReadGroup struct {
+	Group    string
+	Consumer string
+	Count    int64
+	Block    int64
+	NoAck    bool
+	Keys     []string
+	IDs      []StreamReadID
+}
+
+type OpPayloadXAck struct {
+	Key   string
+	Group string
+	IDs   []StreamID
+}
+
+// OpPayloadXPending is either the summary form (Extended false) or
+// extended form listing pending entries between Start and End.
+type OpPayloadXPending struct {
+	Key      string
+	Group    string
+	Extended bool
+	Idle     int64
+	Start    StreamID
+	End      StreamID
+	Count    int64
+	Consumer string
+}
+
+type OpPayloadXClaim struct {
+	Key      string
+	Group    string
+	Consumer string
+	MinIdle  int64
+	IDs      []StreamID
+	JustID   bool
+}
+
+func parseStreamOp(name string, array []Resp2Value) (*Op, error) {
+	args, err := extractMembers(name, array[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "XADD":
+		return parseXAdd(args)
+	case "XRANGE", "XREVRANGE":
+		return parseXRange(name, args)
+	case "XLEN":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("XLEN operation requires 1 argument")
+		}
+		return &Op{Kind: XLEN, Payload: OpPayloadXLen{Key: args[0]}}, nil
+	case "XTRIM":
+		if len(args) < 3 {
+			return nil, fmt.Errorf("XTRIM operation requires at least 3 arguments")
+		}
+		trim, n, err := parseStreamTrim(args[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n != len(args)-1 {
+			return nil, fmt.Errorf("syntax error")
+		}
+		return &Op{Kind: XTRIM, Payload: OpPayloadXTrim{Key: args[0], Trim: *trim}}, nil
+	case "XREAD":
+		return parseXRead(args)
+	case "XGROUP":
+		return parseXGroup(args)
+	case "XREADGROUP":
+		return parseXReadGroup(args)
+	case "XACK":
+		if len(args) < 3 {
+			return nil, fmt.Errorf("XACK operation requires at least 3 arguments")
+		}
+		ids, err := parseStreamIDs(args[2:])
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: XACK, Payload: OpPayloadXAck{Key: args[0], Group: args[1], IDs: ids}}, nil
+	case "XPENDING":
+		return parseXPending(args)
+	case "XCLAIM":
+		return parseXClaim(args)
+	default:
+		return nil, fmt.Errorf("unknown operation type: %s", name)
+	}
+}
+
+func parseStreamIDs(args []string) ([]StreamID, error) {
+	ids := make([]StreamID, 0, len(args))
+	for _, arg := range args {
+		id, err := ParseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func parseNonNegative(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("value is not an integer or out of range")
+	}
+	return n, nil
+}
+
+// parseStreamTrim parses "MAXLEN|MINID [=|~] threshold", returns number of consumed arguments.
+func parseStreamTrim(args []string) (*StreamTrim, int, error) {
+	trim := &StreamTrim{}
+	switch strings.ToUpper(args[0]) {
+	case "MAXLEN":
+	case "MINID":
+		trim.MinID = true
+	default:
+		return nil, 0, fmt.Errorf("syntax error")
+	}
+
+	i := 1
+	if i < len(args) && (args[i] == "~" || args[i] == "=") {
+		trim.Approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, fmt.Errorf("syntax error")
+	}
+
+	var err error
+	if trim.MinID {
+		trim.Threshold, err = ParseStreamID(args[i], 0)
+	} else {
+		trim.MaxLen, err = parseNonNegative(args[i])
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return trim, i + 1, nil
+}
+
+func parseXAdd(args []string) (*Op, error) {
+	if len(args) < 4 {
+		return nil, fmt.Errorf("XADD operation requires at least 4 arguments")
+	}
+	payload := OpPayloadXAdd{Key: args[0]}
+
+	i := 1
+options:
+	for i < len(args) {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			payload.NoMkStream = true
+			i++
+		case "MAXLEN", "MINID":
+			trim, n, err := parseStreamTrim(args[i:])
+			if err != nil {
+				return nil, err
+			}
+			payload.Trim = trim
+			i += n
+		default:
+			break options
+		}
+	}
+
+	if i >= len(args) {
+		return nil, fmt.Errorf("syntax error")
+	}
+	id := args[i]
+	switch {
+	case id == "*":
+		payload.AutoID = true
+	case strings.HasSuffix(id, "-*"):
+		ms, err := strconv.ParseUint(strings.TrimSuffix(id, "-*"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid stream ID specified as stream command argument")
+		}
+		payload.ID = StreamID{Ms: ms}
+		payload.AutoSeq = true
+	default:
+		parsed, err := ParseStreamID(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		if parsed == MinStreamID {
+			return nil, fmt.Errorf("The ID specified in XADD must be greater than 0-0")
+		}
+		payload.ID = parsed
+	}
+
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("wrong number of arguments for 'xadd' command")
+	}
+	payload.Fields = fields
+	return &Op{Kind: XADD, Payload: payload}, nil
+}
+
+func parseXRange(name string, args []string) (*Op, error) {
+	if len(args) != 3 && len(args) != 5 {
+		return nil, fmt.Errorf("%s operation requires 3 or 5 arguments", name)
+	}
+	payload := OpPayloadXRange{Key: args[0], Count: -1, Rev: name == "XREVRANGE"}
+
+	lo, hi := args[1], args[2]
+	if payload.Rev {
+		lo, hi = hi, lo
+	}
+	var err error
+	if payload.Start, err = parseStreamRangeBound(lo, true); err != nil {
+		return nil, err
+	}
+	if payload.End, err = parseStreamRangeBound(hi, false); err != nil {
+		return nil, err
+	}
+
+	if len(args) == 5 {
+		if strings.ToUpper(args[3]) != "COUNT" {
+			return nil, fmt.Errorf("syntax error")
+		}
+		if payload.Count, err = parseNonNegative(args[4]); err != nil {
+			return nil, err
+		}
+	}
+	return &Op{Kind: XRANGE, Payload: payload}, nil
+}
+
+// parseStreamsArgs parses "key [key ...] id [id ...]" which follows STREAMS keyword.
+func parseStreamsArgs(args []string, allowNew bool) ([]string, []StreamReadID, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, nil, fmt.Errorf("Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	n := len(args) / 2
+	keys := args[:n]
+	ids := make([]StreamReadID, 0, n)
+	for _, arg := range args[n:] {
+		switch {
+		case arg == "$" && !allowNew:
+			ids = append(ids, StreamReadID{Last: true})
+		case arg == ">" && allowNew:
+			ids = append(ids, StreamReadID{New: true})
+		default:
+			id, err := ParseStreamID(arg, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			ids = append(ids, StreamReadID{ID: id})
+		}
+	}
+	return keys, ids, nil
+}
+
+// parseReadOptions parses [COUNT n] [BLOCK ms] [NOACK] STREAMS ..., returns index after STREAMS
+func parseReadOptions(args []string, allowNoAck bool) (count int64, block int64, noack bool, rest int, err error) {
+	count, block = -1, -1
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT", "BLOCK":
+			if i+1 >= len(args) {
+				return 0, 0, false, 0, fmt.Errorf("syntax error")
+			}
+			n, err := parseNonNegative(args[i+1])
+			if err != nil {
+				return 0, 0, false, 0, err
+			}
+			if strings.ToUpper(args[i]) == "COUNT" {
+				count = n
+			} else {
+				block = n
+			}
+			i++
+		case "NOACK":
+			if !allowNoAck {
+				return 0, 0, false, 0, fmt.Errorf("syntax error")
+			}
+			noack = true
+		case "STREAMS":
+			return count, block, noack, i + 1, nil
+		default:
+			return 0, 0, false, 0, fmt.Errorf("syntax error")
+		}
+	}
+	return 0, 0, false, 0, fmt.Errorf("syntax error")
+}
+
+func parseXRead(args []string) (*Op, error) {
+	count, block, _, rest, err := parseReadOptions(args, false)
+	if err != nil {
+		return nil, err
+	}
+	keys, ids, err := parseStreamsArgs(args[rest:], false)
+	if err != nil {
+		return nil, err
+	}
+	return &Op{Kind: XREAD, Payload: OpPayloadXRead{Count: count, Block: block, Keys: keys, IDs: ids}}, nil
+}
+
+func parseXReadGroup(args []string) (*Op, error) {
+	if len(args) < 3 || strings.ToUpper(args[0]) != "GROUP" {
+		return nil, fmt.Errorf("syntax error")
+	}
+	count, block, noack, rest, err := parseReadOptions(args[3:], true)
+	if err != nil {
+		return nil, err
+	}
+	keys, ids, err := parseStreamsArgs(args[3+rest:], true)
+	if err != nil {
+		return nil, err
+	}
+	return &Op{Kind: XREADGROUP, Payload: OpPayloadXReadGroup{
+		Group:    args[1],
+		Consumer: args[2],
+		Count:    count,
+		Block:    block,
+		NoAck:    noack,
+		Keys:     keys,
+		IDs:      ids,
+	}}, nil
+}
+
+func parseXGroup(args []string) (*Op, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("XGROUP operation requires a subcommand")
+	}
+	sub := strings.ToUpper(args[0])
+	switch sub {
+	case "CREATE":
+		if len(args) != 4 && !(len(args) == 5 && strings.ToUpper(args[4]) == "MKSTREAM") {
+			return nil, fmt.Errorf("syntax error")
+		}
+		payload := OpPayloadXGroup{Subcommand: sub, Key: args[1], Group: args[2], MkStream: len(args) == 5}
+		if args[3] == "$" {
+			payload.ID = StreamReadID{Last: true}
+		} else {
+			id, err := ParseStreamID(args[3], 0)
+			if err != nil {
+				return nil, err
+			}
+			payload.ID = StreamReadID{ID: id}
+		}
+		return &Op{Kind: XGROUP, Payload: payload}, nil
+	case "DESTROY":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("syntax error")
+		}
+		return &Op{Kind: XGROUP, Payload: OpPayloadXGroup{Subcommand: sub, Key: args[1], Group: args[2]}}, nil
+	default:
+		return nil, fmt.Errorf("unknown XGROUP subcommand: %s", args[0])
+	}
+}
+
+func parseXPending(args []string) (*Op, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("XPENDING operation requires at least 2 arguments")
+	}
+	payload := OpPayloadXPending{Key: args[0], Group: args[1]}
+	rest := args[2:]
+	if len(rest) == 0 {
+		return &Op{Kind: XPENDING, Payload: payload}, nil
+	}
+
+	payload.Extended = true
+	if strings.ToUpper(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("syntax error")
+		}
+		idle, err := parseNonNegative(rest[1])
+		if err != nil {
+			return nil, err
+		}
+		payload.Idle = idle
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return nil, fmt.Errorf("syntax error")
+	}
+
+	var err error
+	if payload.Start, err = parseStreamRangeBound(rest[0], true); err != nil {
+		return nil, err
+	}
+	if payload.End, err = parseStreamRangeBound(rest[1], false); err != nil {
+		return nil, err
+	}
+	if payload.Count, err = parseNonNegative(rest[2]); err != nil {
+		return nil, err
+	}
+	if len(rest) == 4 {
+		payload.Consumer = rest[3]
+	}
+	return &Op{Kind: XPENDING, Payload: payload}, nil
+}
+
+func parseXClaim(args []string) (*Op, error) {
+	if len(args) < 5 {
+		return nil, fmt.Errorf("XCLAIM operation requires at least 5 arguments")
+	}
+	minIdle, err := parseNonNegative(args[3])
+	if err != nil {
+		return nil, err
+	}
+	payload := OpPayloadXClaim{Key: args[0], Group: args[1], Consumer: args[2], MinIdle: minIdle}
+
+	rest := args[4:]
+	if strings.ToUpper(rest[len(rest)-1]) == "JUSTID" {
+		payload.JustID = true
+		rest = rest[:len(rest)-1]
+	}
+	if len(rest) == 0 {
+		return nil, fmt.Errorf("syntax error")
+	}
+	if payload.IDs, err = parseStreamIDs(rest); err != nil {
+		return nil, err
+	}
+	return &Op{Kind: XCLAIM, Payload: payload}, nil
+}
+
+func renderStreamTrim(trim StreamTrim) []string {
+	args := []string{"MAXLEN"}
+	if trim.MinID {
+		args[0] = "MINID"
+	}
+	if trim.Approx {
+		args = append(args, "~")
+	}
+	if trim.MinID {
+		return append(args, trim.Threshold.String())
+	}
+	return append(args, strconv.FormatInt(trim.MaxLen, 10))
+}
+
+func renderReadOptions(count, block int64) []string {
+	var args []string
+	if count >= 0 {
+		args = append(args, "COUNT", strconv.FormatInt(count, 10))
+	}
+	if block >= 0 {
+		args = append(args, "BLOCK", strconv.FormatInt(block, 10))
+	}
+	return args
+}
+
+func renderStreams(keys []string, ids []StreamReadID) []string {
+	args := append([]string{"STREAMS"}, keys...)
+	for _, id := range ids {
+		args = append(args, id.String())
+	}
+	return args
+}
+
+func renderStreamOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadXAdd:
+		args := []string{payload.Key}
+		if payload.NoMkStream {
+			args = append(args, "NOMKSTREAM")
+		}
+		if payload.Trim != nil {
+			args = append(args, renderStreamTrim(*payload.Trim)...)
+		}
+		switch {
+		case payload.AutoID:
+			args = append(args, "*")
+		case payload.AutoSeq:
+			args = append(args, strconv.FormatUint(payload.ID.Ms, 10)+"-*")
+		default:
+			args = append(args, payload.ID.String())
+		}
+		return renderCommand("XADD", append(args, payload.Fields...)...), nil
+	case OpPayloadXRange:
+		args := []string{payload.Key, payload.Start.String(), payload.End.String()}
+		name := "XRANGE"
+		if payload.Rev {
+			name = "XREVRANGE"
+			args[1], args[2] = args[2], args[1]
+		}
+		if payload.Count >= 0 {
+			args = append(args, "COUNT", strconv.FormatInt(payload.Count, 10))
+		}
+		return renderCommand(name, args...), nil
+	case OpPayloadXLen:
+		return renderCommand("XLEN", payload.Key), nil
+	case OpPayloadXTrim:
+		return renderCommand("XTRIM", append([]string{payload.Key}, renderStreamTrim(payload.Trim)...)...), nil
+	case OpPayloadXRead:
+		args := renderReadOptions(payload.Count, payload.Block)
+		return renderCommand("XREAD", append(args, renderStreams(payload.Keys, payload.IDs)...)...), nil
+	case OpPayloadXGroup:
+		if payload.Subcommand == "DESTROY" {
+			return renderCommand("XGROUP", "DESTROY", payload.Key, payload.Group), nil
+		}
+		args := []string{"CREATE", payload.Key, payload.Group, payload.ID.String()}
+		if payload.MkStream {
+			args = append(args, "MKSTREAM")
+		}
+		return renderCommand("XGROUP", args...), nil
+	case OpPayloadXReadGroup:
+		args := []string{"GROUP", payload.Group, payload.Consumer}
+		args = append(args, renderReadOptions(payload.Count, payload.Block)...)
+		if payload.NoAck {
+			args = append(args, "NOACK")
+		}
+		return renderCommand("XREADGROUP", append(args, renderStreams(payload.Keys, payload.IDs)...)...), nil
+	case OpPayloadXAck:
+		args := []string{payload.Key, payload.Group}
+		for _, id := range payload.IDs {
+			args = append(args, id.String())
+		}
+		return renderCommand("XACK", args...), nil
+	case OpPayloadXPending:
+		args := []string{payload.Key, payload.Group}
+		if payload.Extended {
+			if payload.Idle > 0 {
+				args = append(args, "IDLE", strconv.FormatInt(payload.Idle, 10))
+			}
+			args = append(args, payload.Start.String(), payload.End.String(), strconv.FormatInt(payload.Count, 10))
+			if payload.Consumer != "" {
+				args = append(args, payload.Consumer)
+			}
+		}
+		return renderCommand("XPENDING", args...), nil
+	case OpPayloadXClaim:
+		args := []string{payload.Key, payload.Group, payload.Consumer, strconv.FormatInt(payload.MinIdle, 10)}
+		for _, id := range payload.IDs {
+			args = append(args, id.String())
+		}
+		if payload.JustID {
+			args = append(args, "JUSTID")
+		}
+		return renderCommand("XCLAIM", args...), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for operation %v", op.Payload, op.Kind)
+	}
+}
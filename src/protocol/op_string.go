@@ -0,0 +1,240 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String operations payloads
+
+// OpPayloadIncrBy is used by INCR, DECR, INCRBY and DECRBY
+type OpPayloadIncrBy struct {
+	Key   string
+	Delta int64
+}
+
+type OpPayloadIncrByFloat struct {
+	Key   string
+	Delta float64
+}
+
+type OpPayloadAppend struct {
+	Key   string
+	Value string
+}
+
+type OpPayloadGetRange struct {
+	Key   string
+	Start int64
+	End   int64
+}
+
+type OpPayloadSetRange struct {
+	Key    string
+	Offset int64
+	Value  string
+}
+
+type OpPayloadStrLen struct {
+	Key string
+}
+
+type OpPayloadGetSet struct {
+	Key   string
+	Value Resp2Value
+}
+
+type OpPayloadGetDel struct {
+	Key string
+}
+
+func parseInteger(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value is not an integer or out of range")
+	}
+	return n, nil
+}
+
+func parseStringOp(name string, array []Resp2Value) (*Op, error) {
+	args := array[1:]
+
+	arity := map[string]int{
+		"INCR": 1, "DECR": 1, "INCRBY": 2, "DECRBY": 2, "INCRBYFLOAT": 2, "APPEND": 2,
+		"GETRANGE": 3, "SETRANGE": 3, "STRLEN": 1, "GETSET": 2, "GETDEL": 1,
+	}
+	if len(args) != arity[name] {
+		if arity[name] == 1 {
+			return nil, fmt.Errorf("%s operation requires 1 argument", name)
+		}
+		return nil, fmt.Errorf("%s operation requires %d arguments", name, arity[name])
+	}
+	keys, err := extractKeys(name, args[:1])
+	if err != nil {
+		return nil, err
+	}
+	key := keys[0]
+
+	if name == "GETSET" {
+		return &Op{Kind: GETSET, Payload: OpPayloadGetSet{Key: key, Value: args[1]}}, nil
+	}
+	strArgs, err := extractMembers(name, args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "INCR":
+		return &Op{Kind: INCRBY, Payload: OpPayloadIncrBy{Key: key, Delta: 1}}, nil
+	case "DECR":
+		return &Op{Kind: INCRBY, Payload: OpPayloadIncrBy{Key: key, Delta: -1}}, nil
+	case "INCRBY", "DECRBY":
+		delta, err := parseInteger(strArgs[0])
+		if err != nil {
+			return nil, err
+		}
+		if name == "DECRBY" {
+			if delta == math.MinInt64 {
+				return nil, fmt.Errorf("decrement would overflow")
+			}
+			delta = -delta
+		}
+		return &Op{Kind: INCRBY, Payload: OpPayloadIncrBy{Key: key, Delta: delta}}, nil
+	case "INCRBYFLOAT":
+		delta, err := strconv.ParseFloat(strArgs[0], 64)
+		if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+			return nil, fmt.Errorf("value is not a valid float")
+		}
+		return &Op{Kind: INCRBYFLOAT, Payload: OpPayloadIncrByFloat{Key: key, Delta: delta}}, nil
+	case "APPEND":
+		return &Op{Kind: APPEND, Payload: OpPayloadAppend{Key: key, Value: strArgs[0]}}, nil
+	case "GETRANGE":
+		start, err := parseInteger(strArgs[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseInteger(strArgs[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: GETRANGE, Payload: OpPayloadGetRange{Key: key, Start: start, End: end}}, nil
+	case "SETRANGE":
+		offset, err := parseInteger(strArgs[0])
+		if err != nil {
+			return nil, err
+		}
+		if offset < 0 {
+			return nil, fmt.Errorf("offset is out of range")
+		}
+		return &Op{Kind: SETRANGE, Payload: OpPayloadSetRange{Key: key, Offset: offset, Value: strArgs[1]}}, nil
+	case "STRLEN":
+		return &Op{Kind: STRLEN, Payload: OpPayloadStrLen{Key: key}}, nil
+	case "GETDEL":
+		return &Op{Kind: GETDEL, Payload: OpPayloadGetDel{Key: key}}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation type: %s", name)
+	}
+}
+
+// parseSetOptions parses SET options NX/XX/GET and EX/PX/EXAT/PXAT/KEEPTTL into payload
+func parseSetOptions(payload *OpPayloadSet, args []string) error {
+	expireSet := false
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch opt {
+		case "NX", "XX":
+			if payload.NX || payload.XX {
+				return fmt.Errorf("syntax error")
+			}
+			payload.NX, payload.XX = opt == "NX", opt == "XX"
+			continue
+		case "GET":
+			payload.Get = true
+			continue
+		}
+
+		if expireSet {
+			return fmt.Errorf("syntax error")
+		}
+		expireSet = true
+
+		if opt == "KEEPTTL" {
+			payload.KeepTTL = true
+			continue
+		}
+		if opt != "EX" && opt != "PX" && opt != "EXAT" && opt != "PXAT" {
+			return fmt.Errorf("syntax error")
+		}
+		if i+1 >= len(args) {
+			return fmt.Errorf("syntax error")
+		}
+		i++
+		n, err := parseInteger(args[i])
+		if err != nil {
+			return err
+		}
+		if n <= 0 || (strings.HasPrefix(opt, "E") && n > math.MaxInt64/1000) {
+			return fmt.Errorf("invalid expire time in 'set' command")
+		}
+		if strings.HasPrefix(opt, "E") {
+			n *= 1000
+		}
+		if !strings.HasSuffix(opt, "AT") && n > math.MaxInt64-time.Now().UnixMilli() {
+			return fmt.Errorf("invalid expire time in 'set' command")
+		}
+		payload.ExpireMs = n
+		payload.ExpireAbsolute = strings.HasSuffix(opt, "AT")
+	}
+	return nil
+}
+
+// renderSetOptions renders SET options, expiration is always in milliseconds
+func renderSetOptions(payload OpPayloadSet) []string {
+	var args []string
+	if payload.NX {
+		args = append(args, "NX")
+	}
+	if payload.XX {
+		args = append(args, "XX")
+	}
+	if payload.Get {
+		args = append(args, "GET")
+	}
+	switch {
+	case payload.KeepTTL:
+		args = append(args, "KEEPTTL")
+	case payload.ExpireMs == 0:
+	case payload.ExpireAbsolute:
+		args = append(args, "PXAT", strconv.FormatInt(payload.ExpireMs, 10))
+	default:
+		args = append(args, "PX", strconv.FormatInt(payload.ExpireMs, 10))
+	}
+	return args
+}
+
+func renderStringOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadIncrBy:
+		return renderCommand("INCRBY", payload.Key, strconv.FormatInt(payload.Delta, 10)), nil
+	case OpPayloadIncrByFloat:
+		return renderCommand("INCRBYFLOAT", payload.Key, strconv.FormatFloat(payload.Delta, 'g', -1, 64)), nil
+	case OpPayloadAppend:
+		return renderCommand("APPEND", payload.Key, payload.Value), nil
+	case OpPayloadGetRange:
+		return renderCommand("GETRANGE", payload.Key,
+			strconv.FormatInt(payload.Start, 10), strconv.FormatInt(payload.End, 10)), nil
+	case OpPayloadSetRange:
+		return renderCommand("SETRANGE", payload.Key, strconv.FormatInt(payload.Offset, 10), payload.Value), nil
+	case OpPayloadStrLen:
+		return renderCommand("STRLEN", payload.Key), nil
+	case OpPayloadGetSet:
+		return Resp2Array{Resp2SimpleString("GETSET"), Resp2BulkString(payload.Key), payload.Value}, nil
+	case OpPayloadGetDel:
+		return renderCommand("GETDEL", payload.Key), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import "fmt"
+
+// Transaction payloads.
+// MULTI, EXEC, DISCARD and WATCH change state of the connection only,
+// they are never written to the WAL.
+
+type OpPayloadMulti struct {
+}
+
+type OpPayloadExec struct {
+}
+
+type OpPayloadDiscard struct {
+}
+
+type OpPayloadWatch struct {
+	Keys []string
+}
+
+type OpPayloadUnwatch struct {
+}
+
+func parseTxOp(name string, array []Resp2Value) (*Op, error) {
+	args := array[1:]
+
+	if name == "WATCH" {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("WATCH operation requires at least 1 argument")
+		}
+		keys, err := extractKeys(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: WATCH, Payload: OpPayloadWatch{Keys: keys}}, nil
+	}
+
+	if len(args) != 0 {
+		return nil, fmt.Errorf("%s operation requires no arguments", name)
+	}
+	switch name {
+	case "MULTI":
+		return &Op{Kind: MULTI, Payload: OpPayloadMulti{}}, nil
+	case "EXEC":
+		return &Op{Kind: EXEC, Payload: OpPayloadExec{}}, nil
+	case "DISCARD":
+		return &Op{Kind: DISCARD, Payload: OpPayloadDiscard{}}, nil
+	case "UNWATCH":
+		return &Op{Kind: UNWATCH, Payload: OpPayloadUnwatch{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation type: %s", name)
+	}
+}
+
+func renderTxOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadWatch:
+		return renderCommand("WATCH", payload.Keys...), nil
+	case OpPayloadMulti, OpPayloadExec, OpPayloadDiscard, OpPayloadUnwatch:
+		return renderCommand(op.Kind.String()), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for %v", op.Payload, op.Kind)
+	}
+}
@@ -0,0 +1,443 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sorted set operations payloads
+
+type ZScoreMember struct {
+	Score  float64
+	Member string
+}
+
+type ZAddFlags struct {
+	NX   bool // only add new elements
+	XX   bool // only update existing elements
+	GT   bool // only update when new score is greater
+	LT   bool // only update when new score is lower
+	CH   bool // reply with number of changed elements instead of added
+	Incr bool // behave like ZINCRBY
+}
+
+type OpPayloadZAdd struct {
+	Key     string
+	Flags   ZAddFlags
+	Members []ZScoreMember
+}
+
+type OpPayloadZRem struct {
+	Key     string
+	Members []string
+}
+
+type OpPayloadZCard struct {
+	Key string
+}
+
+type OpPayloadZScore struct {
+	Key    string
+	Member string
+}
+
+// OpPayloadZRank is used by both ZRANK and ZREVRANK
+type OpPayloadZRank struct {
+	Key    string
+	Member string
+	Rev    bool
+}
+
+type OpPayloadZIncrBy struct {
+	Key       string
+	Increment float64
+	Member    string
+}
+
+type ZRangeBy int
+
+const (
+	ZRangeByRank ZRangeBy = iota
+	ZRangeByScore
+	ZRangeByLex
+)
+
+// ScoreBound is a score range boundary like "1.5", "(1.5", "-inf" or "+inf".
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+// LexBound is a lexicographical range boundary like "[a", "(a", "-" or "+".
+// Inf is -1 for "-", 1 for "+" and 0 for regular bounds.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Inf       int
+}
+
+// OpPayloadZRange is a normalized form of ZRANGE, ZREVRANGE,
+// ZRANGEBYSCORE, ZREVRANGEBYSCORE, ZRANGEBYLEX and ZREVRANGEBYLEX.
+// Only fields matching By are meaningful. Bounds are always stored as min/max,
+// regardless of the argument order used by REV variants.
+type OpPayloadZRange struct {
+	Key        string
+	By         ZRangeBy
+	Start      int64 // ByRank
+	Stop       int64 // ByRank
+	MinScore   ScoreBound
+	MaxScore   ScoreBound
+	MinLex     LexBound
+	MaxLex     LexBound
+	Rev        bool
+	Offset     int64
+	Count      int64 // negative means no limit
+	WithScores bool
+}
+
+// FormatScore renders score the way redis does, shortest representation
+// which parses back into the same float.
+func FormatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	}
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// ParseScore parses score accepting inf variants, NaN is rejected.
+func ParseScore(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, fmt.Errorf("value is not a valid float")
+	}
+	return f, nil
+}
+
+func ParseScoreBound(s string) (ScoreBound, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	f, err := ParseScore(s)
+	if err != nil {
+		return ScoreBound{}, fmt.Errorf("min or max is not a float")
+	}
+	return ScoreBound{Value: f, Exclusive: exclusive}, nil
+}
+
+func (b ScoreBound) String() string {
+	if b.Exclusive {
+		return "(" + FormatScore(b.Value)
+	}
+	return FormatScore(b.Value)
+}
+
+func ParseLexBound(s string) (LexBound, error) {
+	switch {
+	case s == "-":
+		return LexBound{Inf: -1}, nil
+	case s == "+":
+		return LexBound{Inf: 1}, nil
+	case strings.HasPrefix(s, "("):
+		return LexBound{Value: s[1:], Exclusive: true}, nil
+	case strings.HasPrefix(s, "["):
+		return LexBound{Value: s[1:]}, nil
+	default:
+		return LexBound{}, fmt.Errorf("min or max not valid string range item")
+	}
+}
+
+func (b LexBound) String() string {
+	switch {
+	case b.Inf < 0:
+		return "-"
+	case b.Inf > 0:
+		return "+"
+	case b.Exclusive:
+		return "(" + b.Value
+	default:
+		return "[" + b.Value
+	}
+}
+
+func parseZSetOp(name string, array []Resp2Value) (*Op, error) {
+	if len(array) < 2 {
+		return nil, fmt.Errorf("%s operation requires at least 1 argument", name)
+	}
+	keys, err := extractKeys(name, array[1:2])
+	if err != nil {
+		return nil, err
+	}
+	key := keys[0]
+	// Scores, members and options, members might be empty strings
+	args, err := extractMembers(name, array[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "ZADD":
+		return parseZAdd(key, args)
+	case "ZREM":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("ZREM operation requires at least 2 arguments")
+		}
+		return &Op{Kind: ZREM, Payload: OpPayloadZRem{Key: key, Members: args}}, nil
+	case "ZCARD":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("ZCARD operation requires 1 argument")
+		}
+		return &Op{Kind: ZCARD, Payload: OpPayloadZCard{Key: key}}, nil
+	case "ZSCORE":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("ZSCORE operation requires 2 arguments")
+		}
+		return &Op{Kind: ZSCORE, Payload: OpPayloadZScore{Key: key, Member: args[0]}}, nil
+	case "ZRANK", "ZREVRANK":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s operation requires 2 arguments", name)
+		}
+		return &Op{Kind: ZRANK, Payload: OpPayloadZRank{Key: key, Member: args[0], Rev: name == "ZREVRANK"}}, nil
+	case "ZINCRBY":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("ZINCRBY operation requires 3 arguments")
+		}
+		incr, err := ParseScore(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Kind: ZINCRBY, Payload: OpPayloadZIncrBy{Key: key, Increment: incr, Member: args[1]}}, nil
+	default:
+		return parseZRange(name, key, args)
+	}
+}
+
+func parseZAdd(key string, args []string) (*Op, error) {
+	var flags ZAddFlags
+	i := 0
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			flags.NX = true
+		case "XX":
+			flags.XX = true
+		case "GT":
+			flags.GT = true
+		case "LT":
+			flags.LT = true
+		case "CH":
+			flags.CH = true
+		case "INCR":
+			flags.Incr = true
+		default:
+			break flags
+		}
+	}
+
+	if flags.NX && flags.XX {
+		return nil, fmt.Errorf("XX and NX options at the same time are not compatible")
+	}
+	if (flags.GT && flags.LT) || (flags.NX && (flags.GT || flags.LT)) {
+		return nil, fmt.Errorf("GT, LT, and/or NX options at the same time are not compatible")
+	}
+
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		return nil, fmt.Errorf("syntax error")
+	}
+	if flags.Incr && len(rest) != 2 {
+		return nil, fmt.Errorf("INCR option supports a single increment-element pair")
+	}
+
+	members := make([]ZScoreMember, 0, len(rest)/2)
+	for j := 0; j < len(rest); j += 2 {
+		score, err := ParseScore(rest[j])
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ZScoreMember{Score: score, Member: rest[j+1]})
+	}
+
+	return &Op{Kind: ZADD, Payload: OpPayloadZAdd{Key: key, Flags: flags, Members: members}}, nil
+}
+
+func parseZRange(name string, key string, args []string) (*Op, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("%s operation requires at least 3 arguments", name)
+	}
+	payload := OpPayloadZRange{Key: key, Count: -1}
+	options := args[2:]
+
+	switch name {
+	case "ZRANGE":
+	case "ZREVRANGE":
+		payload.Rev = true
+	case "ZRANGEBYSCORE":
+		payload.By = ZRangeByScore
+	case "ZREVRANGEBYSCORE":
+		payload.By = ZRangeByScore
+		payload.Rev = true
+	case "ZRANGEBYLEX":
+		payload.By = ZRangeByLex
+	case "ZREVRANGEBYLEX":
+		payload.By = ZRangeByLex
+		payload.Rev = true
+	}
+
+	hasLimit := false
+	for i := 0; i < len(options); i++ {
+		switch opt := strings.ToUpper(options[i]); {
+		case opt == "WITHSCORES" && name != "ZRANGEBYLEX" && name != "ZREVRANGEBYLEX":
+			payload.WithScores = true
+		case opt == "BYSCORE" && name == "ZRANGE":
+			payload.By = ZRangeByScore
+		case opt == "BYLEX" && name == "ZRANGE":
+			payload.By = ZRangeByLex
+		case opt == "REV" && name == "ZRANGE":
+			payload.Rev = true
+		case opt == "LIMIT" && name != "ZREVRANGE":
+			if i+2 >= len(options) {
+				return nil, fmt.Errorf("syntax error")
+			}
+			offset, err1 := strconv.ParseInt(options[i+1], 10, 64)
+			count, err2 := strconv.ParseInt(options[i+2], 10, 64)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("value is not an integer or out of range")
+			}
+			payload.Offset, payload.Count = offset, count
+			hasLimit = true
+			i += 2
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+	}
+
+	if hasLimit && payload.By == ZRangeByRank {
+		return nil, fmt.Errorf("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if payload.WithScores && payload.By == ZRangeByLex {
+		return nil, fmt.Errorf("syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	// REV variants take bounds in max, min order
+	lo, hi := args[0], args[1]
+	if payload.Rev && payload.By != ZRangeByRank {
+		lo, hi = hi, lo
+	}
+
+	var err error
+	switch payload.By {
+	case ZRangeByRank:
+		var err1, err2 error
+		payload.Start, err1 = strconv.ParseInt(lo, 10, 64)
+		payload.Stop, err2 = strconv.ParseInt(hi, 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("value is not an integer or out of range")
+		}
+	case ZRangeByScore:
+		if payload.MinScore, err = ParseScoreBound(lo); err != nil {
+			return nil, err
+		}
+		if payload.MaxScore, err = ParseScoreBound(hi); err != nil {
+			return nil, err
+		}
+	case ZRangeByLex:
+		if payload.MinLex, err = ParseLexBound(lo); err != nil {
+			return nil, err
+		}
+		if payload.MaxLex, err = ParseLexBound(hi); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Op{Kind: ZRANGE, Payload: payload}, nil
+}
+
+func renderZSetOp(op *Op) (Resp2Array, error) {
+	switch payload := op.Payload.(type) {
+	case OpPayloadZAdd:
+		args := []string{payload.Key}
+		for _, f := range []struct {
+			set  bool
+			name string
+		}{
+			{payload.Flags.NX, "NX"},
+			{payload.Flags.XX, "XX"},
+			{payload.Flags.GT, "GT"},
+			{payload.Flags.LT, "LT"},
+			{payload.Flags.CH, "CH"},
+			{payload.Flags.Incr, "INCR"},
+		} {
+			if f.set {
+				args = append(args, f.name)
+			}
+		}
+		for _, m := range payload.Members {
+			args = append(args, FormatScore(m.Score), m.Member)
+		}
+		return renderCommand("ZADD", args...), nil
+	case OpPayloadZRem:
+		return renderCommand("ZREM", append([]string{payload.Key}, payload.Members...)...), nil
+	case OpPayloadZCard:
+		return renderCommand("ZCARD", payload.Key), nil
+	case OpPayloadZScore:
+		return renderCommand("ZSCORE", payload.Key, payload.Member), nil
+	case OpPayloadZRank:
+		if payload.Rev {
+			return renderCommand("ZREVRANK", payload.Key, payload.Member), nil
+		}
+		return renderCommand("ZRANK", payload.Key, payload.Member), nil
+	case OpPayloadZIncrBy:
+		return renderCommand("ZINCRBY", payload.Key, FormatScore(payload.Increment), payload.Member), nil
+	case OpPayloadZRange:
+		return renderZRange(payload), nil
+	default:
+		return nil, fmt.Errorf("unexpected payload %T for operation %v", op.Payload, op.Kind)
+	}
+}
+
+// renderZRange always renders the unified ZRANGE form
+func renderZRange(payload OpPayloadZRange) Resp2Array {
+	var lo, hi string
+	switch payload.By {
+	case ZRangeByRank:
+		lo, hi = strconv.FormatInt(payload.Start, 10), strconv.FormatInt(payload.Stop, 10)
+	case ZRangeByScore:
+		lo, hi = payload.MinScore.String(), payload.MaxScore.String()
+	case ZRangeByLex:
+		lo, hi = payload.MinLex.String(), payload.MaxLex.String()
+	}
+	if payload.Rev && payload.By != ZRangeByRank {
+		lo, hi = hi, lo
+	}
+
+	args := []string{payload.Key, lo, hi}
+	switch payload.By {
+	case ZRangeByScore:
+		args = append(args, "BYSCORE")
+	case ZRangeByLex:
+		args = append(args, "BYLEX")
+	}
+	if payload.Rev {
+		args = append(args, "REV")
+	}
+	if payload.By != ZRangeByRank && (payload.Offset != 0 || payload.Count >= 0) {
+		args = append(args, "LIMIT", strconv.FormatInt(payload.Offset, 10), strconv.FormatInt(payload.Count, 10))
+	}
+	if payload.WithScores {
+		args = append(args, "WITHSCORES")
+	}
+	return renderCommand("ZRANGE", args...)
+}
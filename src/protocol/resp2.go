@@ -186,6 +186,11 @@ func (p *Resp2Parser) Parse() (Resp2Value, error) {
 	return p.parseValue()
 }
 
+// BytesRead returns number of bytes consumed by the last Parse, also when it failed
+func (p *Resp2Parser) BytesRead() int64 {
+	return p.bytesRead
+}
+
 func (p *Resp2Parser) parseValue() (Resp2Value, error) {
 	var kindByte byte
 	var err error
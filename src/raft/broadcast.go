@@ -0,0 +1,188 @@
+package raft
+
+import (
+	"context"
+	"io"
+	"main/src/config"
+	"main/src/raft/pb"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Broadcaster forwards published messages to all peers of the network.
+// Every node keeps one client stream per peer and sends its own messages only,
+// so a message crosses the network once per peer. Messages resent after a broken
+// stream are recognized by their (origin, epoch, seq) and delivered only once.
+//
+// Delivery is best effort like redis pub/sub, messages for a peer which does not
+// keep up are dropped instead of blocking publishers.
+
+const (
+	broadcastQueueSize     = 1024
+	broadcastRetryInterval = 500 * time.Millisecond
+)
+
+type Broadcaster struct {
+	pb.UnimplementedBroadcastServer
+
+	network *Network
+	logger  *config.Logger
+	deliver func(channel, message string)
+
+	epoch int64
+	seq   atomic.Uint64
+
+	mu   sync.Mutex
+	seen map[string]lastMessage // by origin node
+
+	links  []*peerLink
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type lastMessage struct {
+	epoch int64
+	seq   uint64
+}
+
+type peerLink struct {
+	peer  Peer
+	queue chan *pb.PublishMessage
+}
+
+// NewBroadcaster creates broadcaster which passes messages received from peers to deliver
+func NewBroadcaster(network *Network, logger *config.Logger, deliver func(channel, message string)) *Broadcaster {
+	return &Broadcaster{
+		network: network,
+		logger:  logger,
+		deliver: deliver,
+		epoch:   time.Now().UnixNano(),
+		seen:    make(map[string]lastMessage),
+	}
+}
+
+// Register adds the broadcast service to the server
+func (b *Broadcaster) Register(server grpc.ServiceRegistrar) {
+	pb.RegisterBroadcastServer(server, b)
+}
+
+// Start opens streams to all other peers, they are reconnected until Stop
+func (b *Broadcaster) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	for peer := range b.network.AvailablePeersIterator(true) {
+		link := &peerLink{peer: peer, queue: make(chan *pb.PublishMessage, broadcastQueueSize)}
+		b.links = append(b.links, link)
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.run(ctx, link)
+		}()
+	}
+}
+
+func (b *Broadcaster) Stop() {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+}
+
+// Broadcast queues message for every peer
+func (b *Broadcaster) Broadcast(channel, message string) {
+	msg := &pb.PublishMessage{
+		Origin:  b.network.GetMe(),
+		Epoch:   b.epoch,
+		Seq:     b.seq.Add(1),
+		Channel: channel,
+		Message: []byte(message),
+	}
+	for _, link := range b.links {
+		select {
+		case link.queue <- msg:
+		default:
+			b.logger.Warn("Broadcast queue of %s is full, message dropped", link.peer.ID)
+		}
+	}
+}
+
+// Publish receives messages sent by a peer
+func (b *Broadcaster) Publish(stream pb.Broadcast_PublishServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.PublishAck{})
+		}
+		if err != nil {
+			return err
+		}
+		if b.accept(msg) {
+			b.deliver(msg.Channel, string(msg.Message))
+		}
+	}
+}
+
+// accept reports whether message was not delivered yet.
+// Origin sends its messages in order, so it is enough to remember the last one.
+func (b *Broadcaster) accept(msg *pb.PublishMessage) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	last, ok := b.seen[msg.Origin]
+	if ok && (msg.Epoch < last.epoch || (msg.Epoch == last.epoch && msg.Seq <= last.seq)) {
+		return false
+	}
+	b.seen[msg.Origin] = lastMessage{epoch: msg.Epoch, seq: msg.Seq}
+	return true
+}
+
+// run sends queued messages to the peer, message which failed is sent again on a new stream
+func (b *Broadcaster) run(ctx context.Context, link *peerLink) {
+	conn, err := grpc.NewClient(link.peer.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		b.logger.Error("Failed to create client for %s: %v", link.peer.ID, err)
+		return
+	}
+	defer conn.Close()
+	client := pb.NewBroadcastClient(conn)
+
+	var pending *pb.PublishMessage
+	for {
+		pending, err = b.stream(ctx, client, link, pending)
+		if ctx.Err() != nil {
+			return
+		}
+		b.logger.Debug("Broadcast stream to %s broken: %v", link.peer.ID, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(broadcastRetryInterval):
+		}
+	}
+}
+
+// stream sends messages until the stream breaks, returns message which was not sent
+func (b *Broadcaster) stream(ctx context.Context, client pb.BroadcastClient, link *peerLink, pending *pb.PublishMessage) (*pb.PublishMessage, error) {
+	stream, err := client.Publish(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		return pending, err
+	}
+	for {
+		if pending == nil {
+			select {
+			case pending = <-link.queue:
+			case <-ctx.Done():
+				stream.CloseAndRecv()
+				return nil, ctx.Err()
+			}
+		}
+		if err := stream.Send(pending); err != nil {
+			return pending, err
+		}
+		pending = nil
+	}
+}
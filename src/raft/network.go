@@ -7,9 +7,10 @@ import (
 )
 
 type Peer struct {
-	ID        string
-	Address   string
-	Available bool
+	ID           string
+	Address      string
+	RedisAddress string // address clients are redirected to, empty when not configured
+	Available    bool
 }
 
 type Network struct {
@@ -21,9 +22,10 @@ func NewNetwork(peerInfos NetworkConfig) *Network {
 	peers := make([]Peer, 0, len(peerInfos.Peers))
 	for _, p := range peerInfos.Peers {
 		peers = append(peers, Peer{
-			ID:        p.ID,
-			Address:   p.Address,
-			Available: true,
+			ID:           p.ID,
+			Address:      p.Address,
+			RedisAddress: p.RedisAddress,
+			Available:    true,
 		})
 	}
 	return &Network{
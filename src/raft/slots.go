@@ -0,0 +1,43 @@
+package raft
+
+import "strings"
+
+// Key slots like in redis cluster.
+// Slots are split evenly between peers in the order they are configured,
+// every node has the same configuration so all of them agree on the owners.
+
+const SlotCount = 16384
+
+// KeySlot returns slot of the key, only {hashtag} is hashed when the key has one
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % SlotCount
+}
+
+// crc16 is CRC16-CCITT (XMODEM) used by redis cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// SlotOwner returns peer serving the slot, network without peers serves all slots itself
+func (n *Network) SlotOwner(slot int) Peer {
+	if len(n.peers) == 0 {
+		return Peer{ID: n.me, Available: true}
+	}
+	return n.peers[slot*len(n.peers)/SlotCount]
+}
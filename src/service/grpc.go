@@ -0,0 +1,47 @@
+package service
+
+import (
+	"main/src/config"
+	"net"
+
+	"google.golang.org/grpc"
+)
+
+// GrpcServiceManager serves node-to-node and client gRPC services on the network address of the node.
+// Services have to be registered on Server before Start.
+type GrpcServiceManager struct {
+	server  *grpc.Server
+	address string
+	logger  *config.Logger
+}
+
+func NewGrpcServiceManager(cfg *config.Config, logger *config.Logger) *GrpcServiceManager {
+	return &GrpcServiceManager{
+		server:  grpc.NewServer(),
+		address: cfg.Network.Self.Address,
+		logger:  logger,
+	}
+}
+
+func (s *GrpcServiceManager) Server() *grpc.Server {
+	return s.server
+}
+
+func (s *GrpcServiceManager) Start() error {
+	l, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Listening for gRPC on %s", l.Addr())
+	go func() {
+		if err := s.server.Serve(l); err != nil {
+			s.logger.Error("gRPC server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (s *GrpcServiceManager) Stop() error {
+	s.server.GracefulStop()
+	return nil
+}
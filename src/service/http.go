@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"main/src/config"
+	"main/src/protocol"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HttpServiceManager serves HTTP/JSON gateway to StorageService for tools which speak only HTTP.
+//
+//	GET    /v1/keys/{key}       value of a key of any type, composite values are rendered as JSON arrays
+//	PUT    /v1/keys/{key}       stores request body as string value, ?ttl=<ms> sets expiration
+//	DELETE /v1/keys/{key}       deletes a key of any type
+//	GET    /v1/keys             lists string keys in order, ?prefix=, ?limit= and ?cursor= of the previous page
+//	GET    /v1/admin/status     node id and current revision
+//	POST   /v1/admin/snapshot   makes snapshot of the storage
+//
+// Responses are JSON objects, errors are reported as {"error": message} with a matching status code.
+type HttpServiceManager struct {
+	storage *StorageService
+	cfg     config.HTTPConfig
+	nodeID  string
+	server  *http.Server
+	logger  *config.Logger
+}
+
+func NewHttpServiceManager(storage *StorageService, cfg *config.Config, logger *config.Logger) *HttpServiceManager {
+	h := &HttpServiceManager{
+		storage: storage,
+		cfg:     cfg.HTTP,
+		nodeID:  cfg.Network.Self.ID,
+		logger:  logger,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/keys/{key...}", h.getKey)
+	mux.HandleFunc("PUT /v1/keys/{key...}", h.putKey)
+	mux.HandleFunc("DELETE /v1/keys/{key...}", h.deleteKey)
+	mux.HandleFunc("GET /v1/keys", h.listKeys)
+	mux.HandleFunc("GET /v1/admin/status", h.status)
+	mux.HandleFunc("POST /v1/admin/snapshot", h.snapshot)
+	h.server = &http.Server{Addr: cfg.HTTP.Address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return h
+}
+
+// Handler returns handler of the gateway, it can be served without Start
+func (h *HttpServiceManager) Handler() http.Handler {
+	return h.server.Handler
+}
+
+func (h *HttpServiceManager) Start() error {
+	l, err := net.Listen("tcp", h.server.Addr)
+	if err != nil {
+		return err
+	}
+	h.logger.Info("Listening for HTTP on %s", l.Addr())
+	go func() {
+		if err := h.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			h.logger.Error("HTTP server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (h *HttpServiceManager) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return h.server.Shutdown(ctx)
+}
+
+type keyResponse struct {
+	Key         string `json:"key"`
+	Type        string `json:"type,omitempty"`
+	Value       any    `json:"value,omitempty"`
+	ExpireAt    int64  `json:"expire_at,omitempty"` // unix milliseconds
+	ModRevision uint64 `json:"mod_revision,omitempty"`
+	Deleted     *int   `json:"deleted,omitempty"`
+	Revision    uint64 `json:"revision,omitempty"`
+}
+
+type listResponse struct {
+	Keys     []keyResponse `json:"keys"`
+	Cursor   string        `json:"cursor,omitempty"` // pass as ?cursor= to get the next page, empty on the last page
+	Revision uint64        `json:"revision"`
+}
+
+type errorResponse struct {
+	Error    string `json:"error"`
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+func (h *HttpServiceManager) getKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	entry, revision, err := h.storage.Lookup(key)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if entry == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "key not found", Revision: revision})
+		return
+	}
+	writeJSON(w, http.StatusOK, keyResponse{
+		Key:         key,
+		Type:        entry.Kind,
+		Value:       protocol.JSONValue(entry.Value),
+		ExpireAt:    entry.ExpireAt,
+		ModRevision: entry.Revision,
+		Revision:    revision,
+	})
+}
+
+func (h *HttpServiceManager) putKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	var ttl int64
+	if param := r.URL.Query().Get("ttl"); param != "" {
+		var err error
+		if ttl, err = strconv.ParseInt(param, 10, 64); err != nil || ttl <= 0 {
+			h.writeError(w, http.StatusBadRequest, errors.New("ttl must be a positive number of milliseconds"))
+			return
+		}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.cfg.MaxValueSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	revision, err := h.storage.Update(func(tx *StorageService) error {
+		_, _, err := tx.SetWithOptions(protocol.OpPayloadSet{Key: key, Value: protocol.Resp2BulkString(body), ExpireMs: ttl})
+		return err
+	})
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, keyResponse{Key: key, Revision: revision})
+}
+
+func (h *HttpServiceManager) deleteKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	var deleted int
+	revision, err := h.storage.Update(func(tx *StorageService) (err error) {
+		deleted, err = tx.Del([]string{key})
+		return err
+	})
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if deleted == 0 {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "key not found", Revision: revision})
+		return
+	}
+	writeJSON(w, http.StatusOK, keyResponse{Key: key, Deleted: &deleted, Revision: revision})
+}
+
+// listKeys pages through string keys with the prefix, cursor holds the last key of the previous page
+func (h *HttpServiceManager) listKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	limit := h.cfg.MaxPageSize
+	if param := query.Get("limit"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n <= 0 {
+			h.writeError(w, http.StatusBadRequest, errors.New("limit must be a positive number"))
+			return
+		}
+		limit = min(n, limit)
+	}
+	start := prefix
+	if cursor := query.Get("cursor"); cursor != "" {
+		last, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errors.New("invalid cursor"))
+			return
+		}
+		start = max(start, string(last)+"\x00")
+	}
+
+	kvs, more, revision, err := h.storage.Range(start, prefixEnd(prefix), limit)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := listResponse{Keys: make([]keyResponse, 0, len(kvs)), Revision: revision}
+	for _, kv := range kvs {
+		resp.Keys = append(resp.Keys, keyResponse{Key: kv.Key, Type: "string", Value: kv.Value, ModRevision: kv.Revision})
+	}
+	if more {
+		resp.Cursor = base64.RawURLEncoding.EncodeToString([]byte(kvs[len(kvs)-1].Key))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *HttpServiceManager) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"node":     h.nodeID,
+		"revision": h.storage.Revision(),
+	})
+}
+
+func (h *HttpServiceManager) snapshot(w http.ResponseWriter, r *http.Request) {
+	revision := h.storage.Revision()
+	if err := h.storage.Snapshot(); err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"revision": revision})
+}
+
+func (h *HttpServiceManager) writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		h.logger.Error("HTTP request failed: %v", err)
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// prefixEnd returns the first key after all keys with the prefix
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return RangeAll
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft/pb"
+	"main/src/storage"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// KeyValueService exposes string keys of StorageService over gRPC,
+// so services can use a typed API instead of RESP.
+type KeyValueService struct {
+	pb.UnimplementedKeyValueServer
+
+	storage *StorageService
+	logger  *config.Logger
+}
+
+func NewKeyValueService(storage *StorageService, logger *config.Logger) *KeyValueService {
+	return &KeyValueService{storage: storage, logger: logger}
+}
+
+// Register adds the key-value service to the server
+func (k *KeyValueService) Register(server grpc.ServiceRegistrar) {
+	pb.RegisterKeyValueServer(server, k)
+}
+
+func (k *KeyValueService) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	if err := k.awaitRevision(ctx, req.Consistency, req.MinRevision); err != nil {
+		return nil, err
+	}
+	kvs, _, revision, err := k.storage.Range(req.Key, "", 0)
+	if err != nil {
+		return nil, k.statusError(err)
+	}
+	resp := &pb.GetResponse{Header: &pb.ResponseHeader{Revision: revision}}
+	if len(kvs) > 0 {
+		resp.Kv = keyValuePair(kvs[0], false)
+	}
+	return resp, nil
+}
+
+func (k *KeyValueService) Put(ctx context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	var resp *pb.PutResponse
+	revision, err := k.storage.Update(func(tx *StorageService) (err error) {
+		resp, err = put(tx, req)
+		return err
+	})
+	if err != nil {
+		return nil, k.statusError(err)
+	}
+	resp.Header = &pb.ResponseHeader{Revision: revision}
+	return resp, nil
+}
+
+func (k *KeyValueService) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	var resp *pb.DeleteResponse
+	revision, err := k.storage.Update(func(tx *StorageService) (err error) {
+		resp, err = deleteRange(tx, req)
+		return err
+	})
+	if err != nil {
+		return nil, k.statusError(err)
+	}
+	resp.Header = &pb.ResponseHeader{Revision: revision}
+	return resp, nil
+}
+
+func (k *KeyValueService) Range(ctx context.Context, req *pb.RangeRequest) (*pb.RangeResponse, error) {
+	if err := validateRange(req); err != nil {
+		return nil, err
+	}
+	if err := k.awaitRevision(ctx, req.Consistency, req.MinRevision); err != nil {
+		return nil, err
+	}
+	resp, revision, err := rangeStrings(k.storage, req)
+	if err != nil {
+		return nil, k.statusError(err)
+	}
+	resp.Header = &pb.ResponseHeader{Revision: revision}
+	return resp, nil
+}
+
+// Txn evaluates compares and runs operations in a single StorageService transaction.
+// Consistency options of nested ranges are ignored, they observe the state of the transaction.
+func (k *KeyValueService) Txn(ctx context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	if err := validateTxn(req); err != nil {
+		return nil, err
+	}
+	resp := &pb.TxnResponse{Succeeded: true}
+	revision, err := k.storage.Update(func(tx *StorageService) error {
+		for _, c := range req.Compare {
+			ok, err := compare(tx, c)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				resp.Succeeded = false
+				break
+			}
+		}
+		ops := req.Success
+		if !resp.Succeeded {
+			ops = req.Failure
+		}
+		// Nothing is written when any of the puts would fail
+		var puts []*pb.PutRequest
+		for _, op := range ops {
+			if r, ok := op.Request.(*pb.RequestOp_RequestPut); ok {
+				puts = append(puts, r.RequestPut)
+			}
+		}
+		if err := checkLeases(tx, puts...); err != nil {
+			return err
+		}
+		for _, op := range ops {
+			r, err := runOp(tx, op)
+			if err != nil {
+				return err
+			}
+			resp.Responses = append(resp.Responses, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, k.statusError(err)
+	}
+	resp.Header = &pb.ResponseHeader{Revision: revision}
+	return resp, nil
+}
+
+// awaitRevision makes sure the store observed minRevision before a read
+func (k *KeyValueService) awaitRevision(ctx context.Context, consistency pb.Consistency, minRevision uint64) error {
+	current := k.storage.Revision()
+	if current >= minRevision {
+		return nil
+	}
+	if consistency == pb.Consistency_SERIALIZABLE {
+		return status.Errorf(codes.FailedPrecondition, "revision %d is not committed yet, current revision is %d", minRevision, current)
+	}
+	if err := k.storage.WaitRevision(ctx, minRevision); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+func (k *KeyValueService) statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, storage.ErrWrongType) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, errLeaseNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	k.logger.Error("Key-value request failed: %v", err)
+	return status.Error(codes.Internal, err.Error())
+}
+
+func put(tx *StorageService, req *pb.PutRequest) (*pb.PutResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	if err := checkLeases(tx, req); err != nil {
+		return nil, err
+	}
+	resp := &pb.PutResponse{}
+	if req.PrevKv {
+		prev, _, err := rangeStrings(tx, &pb.RangeRequest{Key: req.Key})
+		if err != nil {
+			return nil, err
+		}
+		if len(prev.Kvs) > 0 {
+			resp.PrevKv = prev.Kvs[0]
+		}
+	}
+	if err := tx.Set(req.Key, protocol.Resp2BulkString(req.Value)); err != nil {
+		return nil, err
+	}
+	if req.Lease != 0 {
+		if _, err := tx.AttachLease(req.Lease, []string{req.Key}); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// checkLeases makes sure leases of the puts are alive
+func checkLeases(tx *StorageService, puts ...*pb.PutRequest) error {
+	for _, req := range puts {
+		if req.Lease != 0 && tx.LeaseTimeToLive(req.Lease) == nil {
+			return errLeaseNotFound
+		}
+	}
+	return nil
+}
+
+// deleteRange deletes a single key of any type, or string keys of the range
+func deleteRange(tx *StorageService, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	resp := &pb.DeleteResponse{}
+	keys := []string{req.Key}
+	if req.RangeEnd != "" || req.PrevKv {
+		matched, _, err := rangeStrings(tx, &pb.RangeRequest{Key: req.Key, RangeEnd: req.RangeEnd})
+		if err != nil {
+			return nil, err
+		}
+		if req.RangeEnd != "" {
+			keys = keys[:0]
+			for _, kv := range matched.Kvs {
+				keys = append(keys, kv.Key)
+			}
+		}
+		if req.PrevKv {
+			resp.PrevKvs = matched.Kvs
+		}
+	}
+	if len(keys) == 0 {
+		return resp, nil
+	}
+	deleted, err := tx.Del(keys)
+	if err != nil {
+		return nil, err
+	}
+	resp.Deleted = int64(deleted)
+	return resp, nil
+}
+
+func compare(tx *StorageService, c *pb.Compare) (bool, error) {
+	switch target := c.Target.(type) {
+	case *pb.Compare_Exists:
+		exists, err := tx.Exists(c.Key)
+		if err != nil {
+			return false, err
+		}
+		return (exists == target.Exists) == (c.Result == pb.Compare_EQUAL), nil
+	case *pb.Compare_Value:
+		kvs, _, _, err := tx.Range(c.Key, "", 0)
+		if errors.Is(err, storage.ErrWrongType) || len(kvs) == 0 {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return compareResult(c.Result, strings.Compare(kvs[0].Value, string(target.Value))), nil
+	case *pb.Compare_ModRevision:
+		revision, err := tx.keyRevision(c.Key)
+		if err != nil {
+			return false, err
+		}
+		return compareResult(c.Result, cmp.Compare(revision, target.ModRevision)), nil
+	}
+	return false, nil
+}
+
+func compareResult(result pb.Compare_Result, order int) bool {
+	switch result {
+	case pb.Compare_EQUAL:
+		return order == 0
+	case pb.Compare_NOT_EQUAL:
+		return order != 0
+	case pb.Compare_GREATER:
+		return order > 0
+	default:
+		return order < 0
+	}
+}
+
+func runOp(tx *StorageService, op *pb.RequestOp) (*pb.ResponseOp, error) {
+	switch r := op.Request.(type) {
+	case *pb.RequestOp_RequestRange:
+		resp, _, err := rangeStrings(tx, r.RequestRange)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: resp}}, nil
+	case *pb.RequestOp_RequestPut:
+		resp, err := put(tx, r.RequestPut)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: resp}}, nil
+	default:
+		resp, err := deleteRange(tx, op.GetRequestDelete())
+		if err != nil {
+			return nil, err
+		}
+		return &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDelete{ResponseDelete: resp}}, nil
+	}
+}
+
+func validateRange(req *pb.RangeRequest) error {
+	if req.Key == "" {
+		return status.Error(codes.InvalidArgument, "key is required")
+	}
+	if req.Limit < 0 {
+		return status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	return nil
+}
+
+// validateTxn checks the whole request up front, so invalid transactions do not modify anything
+func validateTxn(req *pb.TxnRequest) error {
+	for _, c := range req.Compare {
+		switch c.Target.(type) {
+		case *pb.Compare_Exists:
+			if c.Result != pb.Compare_EQUAL && c.Result != pb.Compare_NOT_EQUAL {
+				return status.Error(codes.InvalidArgument, "existence can be compared only with EQUAL or NOT_EQUAL")
+			}
+		case *pb.Compare_Value, *pb.Compare_ModRevision:
+		default:
+			return status.Error(codes.InvalidArgument, "compare target is required")
+		}
+		if c.Key == "" {
+			return status.Error(codes.InvalidArgument, "key is required")
+		}
+	}
+	for _, op := range append(append([]*pb.RequestOp(nil), req.Success...), req.Failure...) {
+		var err error
+		switch r := op.Request.(type) {
+		case *pb.RequestOp_RequestRange:
+			err = validateRange(r.RequestRange)
+		case *pb.RequestOp_RequestPut:
+			if r.RequestPut.Key == "" {
+				err = status.Error(codes.InvalidArgument, "key is required")
+			}
+		case *pb.RequestOp_RequestDelete:
+			if r.RequestDelete.Key == "" {
+				err = status.Error(codes.InvalidArgument, "key is required")
+			}
+		default:
+			err = status.Error(codes.InvalidArgument, "operation is required")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeStrings reads the range skipping composite values, also when the range is a single key,
+// so operations of a transaction never fail because of the type of a key
+func rangeStrings(s *StorageService, req *pb.RangeRequest) (*pb.RangeResponse, uint64, error) {
+	kvs, more, revision, err := s.Range(req.Key, req.RangeEnd, int(req.Limit))
+	if err != nil && !errors.Is(err, storage.ErrWrongType) {
+		return nil, 0, err
+	}
+	resp := &pb.RangeResponse{More: more}
+	for _, kv := range kvs {
+		resp.Kvs = append(resp.Kvs, keyValuePair(kv, req.KeysOnly))
+	}
+	return resp, revision, nil
+}
+
+func keyValuePair(kv KeyValue, keyOnly bool) *pb.KeyValuePair {
+	pair := &pb.KeyValuePair{Key: kv.Key, ModRevision: kv.Revision}
+	if !keyOnly {
+		pair.Value = []byte(kv.Value)
+	}
+	return pair
+}
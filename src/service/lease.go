@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"main/src/config"
+	"main/src/raft/pb"
+	"main/src/storage"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LeaseService exposes leases of StorageService over gRPC,
+// they are the same leases as LEASE commands use.
+type LeaseService struct {
+	pb.UnimplementedLeaseServer
+
+	storage *StorageService
+	logger  *config.Logger
+}
+
+func NewLeaseService(storage *StorageService, logger *config.Logger) *LeaseService {
+	return &LeaseService{storage: storage, logger: logger}
+}
+
+// Register adds the lease service to the server
+func (l *LeaseService) Register(server grpc.ServiceRegistrar) {
+	pb.RegisterLeaseServer(server, l)
+}
+
+func (l *LeaseService) Grant(ctx context.Context, req *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
+	if req.TtlMs <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl_ms must be positive")
+	}
+	id, err := l.storage.GrantLease(req.TtlMs)
+	if err != nil {
+		return nil, l.statusError(err)
+	}
+	return &pb.LeaseGrantResponse{Header: &pb.ResponseHeader{Revision: id}, Id: id, TtlMs: req.TtlMs}, nil
+}
+
+func (l *LeaseService) Revoke(ctx context.Context, req *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+	ok, err := l.storage.RevokeLease(req.Id)
+	if err != nil {
+		return nil, l.statusError(err)
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, errLeaseNotFound.Error())
+	}
+	return &pb.LeaseRevokeResponse{Header: &pb.ResponseHeader{Revision: l.storage.Revision()}}, nil
+}
+
+func (l *LeaseService) KeepAlive(stream pb.Lease_KeepAliveServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ttl, err := l.storage.KeepAliveLease(req.Id)
+		if err != nil {
+			return l.statusError(err)
+		}
+		if err := stream.Send(&pb.LeaseKeepAliveResponse{Id: req.Id, TtlMs: ttl}); err != nil {
+			return err
+		}
+	}
+}
+
+func (l *LeaseService) TimeToLive(ctx context.Context, req *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	info := l.storage.LeaseTimeToLive(req.Id)
+	if info == nil {
+		return &pb.LeaseTimeToLiveResponse{Id: req.Id, TtlMs: -1}, nil
+	}
+	resp := &pb.LeaseTimeToLiveResponse{Id: req.Id, TtlMs: info.Remaining, GrantedTtlMs: info.TTL}
+	if req.Keys {
+		resp.Keys = info.Keys
+	}
+	return resp, nil
+}
+
+func (l *LeaseService) Leases(ctx context.Context, req *pb.LeaseLeasesRequest) (*pb.LeaseLeasesResponse, error) {
+	return &pb.LeaseLeasesResponse{Ids: l.storage.Leases()}, nil
+}
+
+func (l *LeaseService) statusError(err error) error {
+	if errors.Is(err, storage.ErrWrongType) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	l.logger.Error("Lease request failed: %v", err)
+	return status.Error(codes.Internal, err.Error())
+}
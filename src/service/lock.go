@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"main/src/config"
+	"main/src/raft/pb"
+	"main/src/storage"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LockService exposes distributed locks of StorageService over gRPC,
+// they are the same locks as LOCK/LOCKRENEW/UNLOCK commands use.
+type LockService struct {
+	pb.UnimplementedLockServer
+
+	storage *StorageService
+	logger  *config.Logger
+}
+
+func NewLockService(storage *StorageService, logger *config.Logger) *LockService {
+	return &LockService{storage: storage, logger: logger}
+}
+
+// Register adds the lock service to the server
+func (l *LockService) Register(server grpc.ServiceRegistrar) {
+	pb.RegisterLockServer(server, l)
+}
+
+func (l *LockService) Acquire(ctx context.Context, req *pb.AcquireRequest) (*pb.AcquireResponse, error) {
+	if err := validateLock(req.Name, req.TtlMs); err != nil {
+		return nil, err
+	}
+	block := int64(-1)
+	if req.Wait {
+		block = 0
+	}
+	token, err := l.storage.Lock(ctx, req.Name, req.Owner, req.TtlMs, block)
+	if err != nil {
+		return nil, l.statusError(err)
+	}
+	return &pb.AcquireResponse{Acquired: token > 0, Token: token}, nil
+}
+
+func (l *LockService) Renew(ctx context.Context, req *pb.RenewRequest) (*pb.RenewResponse, error) {
+	if err := validateLock(req.Name, req.TtlMs); err != nil {
+		return nil, err
+	}
+	ok, err := l.storage.RenewLock(req.Name, req.Token, req.TtlMs)
+	if err != nil {
+		return nil, l.statusError(err)
+	}
+	return &pb.RenewResponse{Renewed: ok}, nil
+}
+
+func (l *LockService) Release(ctx context.Context, req *pb.ReleaseRequest) (*pb.ReleaseResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	ok, err := l.storage.Unlock(req.Name, req.Token)
+	if err != nil {
+		return nil, l.statusError(err)
+	}
+	return &pb.ReleaseResponse{Released: ok}, nil
+}
+
+func validateLock(name string, ttl int64) error {
+	if name == "" {
+		return status.Error(codes.InvalidArgument, "name is required")
+	}
+	if ttl <= 0 {
+		return status.Error(codes.InvalidArgument, "ttl_ms must be positive")
+	}
+	return nil
+}
+
+func (l *LockService) statusError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	if errors.Is(err, storage.ErrWrongType) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	l.logger.Error("Lock request failed: %v", err)
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"fmt"
+	"main/src/protocol"
+	"main/src/storage"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Keyspace notifications of StorageService.
+// Events are derived from committed WAL entries, so every way of modifying a key
+// (commands, transactions, scripts, expiration) is covered by a single place.
+// Like in redis, notifications are delivered only to subscribers of this node.
+
+// keyspaceEvents is a set of notify-keyspace-events flags
+type keyspaceEvents uint32
+
+const (
+	notifyKeyspace keyspaceEvents = 1 << iota // K, __keyspace@0__:<key> channel
+	notifyKeyevent                            // E, __keyevent@0__:<event> channel
+	notifyGeneric                             // g, del, expire, persist
+	notifyString                              // $
+	notifyList                                // l
+	notifySet                                 // s
+	notifyHash                                // h
+	notifyZSet                                // z
+	notifyExpired                             // x
+	notifyEvicted                             // e
+	notifyStream                              // t
+	notifyKeyMiss                             // m
+	notifyNew                                 // n
+
+	// A is alias of all classes except key miss and new key events
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZSet | notifyExpired | notifyEvicted | notifyStream
+)
+
+var keyspaceEventFlags = []struct {
+	flag  byte
+	event keyspaceEvents
+}{
+	{'g', notifyGeneric}, {'$', notifyString}, {'l', notifyList}, {'s', notifySet},
+	{'h', notifyHash}, {'z', notifyZSet}, {'x', notifyExpired}, {'e', notifyEvicted},
+	{'t', notifyStream}, {'K', notifyKeyspace}, {'E', notifyKeyevent}, {'m', notifyKeyMiss}, {'n', notifyNew},
+}
+
+func parseKeyspaceEvents(flags string) (keyspaceEvents, error) {
+	var events keyspaceEvents
+	for i := 0; i < len(flags); i++ {
+		if flags[i] == 'A' {
+			events |= notifyAll
+			continue
+		}
+		found := false
+		for _, f := range keyspaceEventFlags {
+			if f.flag == flags[i] {
+				events |= f.event
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Invalid event class character '%c'", flags[i])
+		}
+	}
+	return events, nil
+}
+
+// String renders flags in the same canonical form as redis CONFIG GET
+func (e keyspaceEvents) String() string {
+	var sb strings.Builder
+	if e&notifyAll == notifyAll {
+		sb.WriteByte('A')
+	}
+	for _, f := range keyspaceEventFlags {
+		if e&f.event == 0 || (f.event&notifyAll != 0 && e&notifyAll == notifyAll) {
+			continue
+		}
+		sb.WriteByte(f.flag)
+	}
+	return sb.String()
+}
+
+// keyspaceNotifier is shared by StorageService and its transaction copies
+type keyspaceNotifier struct {
+	events atomic.Uint32
+
+	mu      sync.RWMutex
+	deliver func(channel, message string)
+}
+
+func (n *keyspaceNotifier) enabled(class keyspaceEvents) bool {
+	events := keyspaceEvents(n.events.Load())
+	return events&class != 0 && events&(notifyKeyspace|notifyKeyevent) != 0
+}
+
+func (n *keyspaceNotifier) notify(class keyspaceEvents, event, key string) {
+	if !n.enabled(class) {
+		return
+	}
+	n.mu.RLock()
+	deliver := n.deliver
+	n.mu.RUnlock()
+	if deliver == nil {
+		return
+	}
+
+	events := keyspaceEvents(n.events.Load())
+	if events&notifyKeyspace != 0 {
+		deliver("__keyspace@0__:"+key, event)
+	}
+	if events&notifyKeyevent != 0 {
+		deliver("__keyevent@0__:"+event, key)
+	}
+}
+
+// SetKeyspaceNotifier sets function delivering notifications to subscribers
+func (s *StorageService) SetKeyspaceNotifier(deliver func(channel, message string)) {
+	s.notifier.mu.Lock()
+	defer s.notifier.mu.Unlock()
+	s.notifier.deliver = deliver
+}
+
+// SetKeyspaceEvents changes enabled notifications, flags are the same as redis notify-keyspace-events
+func (s *StorageService) SetKeyspaceEvents(flags string) error {
+	events, err := parseKeyspaceEvents(flags)
+	if err != nil {
+		return err
+	}
+	s.notifier.events.Store(uint32(events))
+	return nil
+}
+
+// KeyspaceEvents returns enabled notifications in canonical form
+func (s *StorageService) KeyspaceEvents() string {
+	return keyspaceEvents(s.notifier.events.Load()).String()
+}
+
+// entryEvent returns class and name of the event caused by the entry,
+// empty name when the entry does not cause any notification.
+// Caller must hold the write lock, expired keys are checked before the entry is applied.
+func (s *StorageService) entryEvent(e storage.WalEntry[protocol.Resp2Value]) (keyspaceEvents, string) {
+	switch e.OpType {
+	case protocol.SET:
+		return notifyString, "set"
+	case protocol.DELETE:
+		if s.isExpired(e.Key) {
+			return notifyExpired, "expired"
+		}
+		if s.evicting {
+			return notifyEvicted, "evicted"
+		}
+		return notifyGeneric, "del"
+	case protocol.EXPIRE:
+		return notifyGeneric, "expire"
+	case protocol.PERSIST:
+		return notifyGeneric, "persist"
+	case protocol.LOCK:
+		return notifyGeneric, "lock"
+	case protocol.RENAME:
+		return notifyGeneric, "rename_to"
+	case protocol.COPY:
+		return notifyGeneric, "copy_to"
+	case protocol.SADD, protocol.SREM, protocol.SINTERSTORE, protocol.SUNIONSTORE, protocol.SDIFFSTORE:
+		return notifySet, strings.ToLower(e.OpType.String())
+	case protocol.ZADD, protocol.ZREM:
+		return notifyZSet, strings.ToLower(e.OpType.String())
+	case protocol.XADD, protocol.XTRIM:
+		return notifyStream, strings.ToLower(e.OpType.String())
+	default:
+		return 0, ""
+	}
+}
@@ -0,0 +1,437 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"main/src/protocol"
+	"main/src/raft"
+	"net"
+	"sync"
+	"time"
+)
+
+// Pub/Sub of RedisService.
+// Messages are queued to subscribers and written by a goroutine of each subscriber,
+// so publishers never wait for slow clients. Subscriber whose queue grows over
+// the output limit is disconnected.
+//
+// In a cluster PUBLISH is forwarded to all other nodes, sharded channels are
+// served only by the node owning their slot, like in redis cluster.
+
+var (
+	errOutputLimit = errors.New("subscriber output buffer limit exceeded")
+	errMoved       = errors.New("MOVED")
+	errCrossSlot   = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+)
+
+// subscriptionKind tells which commands manage a subscription
+type subscriptionKind int
+
+const (
+	channelSubscription subscriptionKind = iota // SUBSCRIBE
+	patternSubscription                         // PSUBSCRIBE
+	shardSubscription                           // SSUBSCRIBE
+)
+
+// PubSub is a registry of channel, pattern and shard channel subscriptions
+type PubSub struct {
+	mu       sync.RWMutex
+	registry [3]map[string]map[*subscriber]struct{} // by subscriptionKind
+	forward  func(channel, message string)
+}
+
+func NewPubSub() *PubSub {
+	p := &PubSub{}
+	for i := range p.registry {
+		p.registry[i] = make(map[string]map[*subscriber]struct{})
+	}
+	return p
+}
+
+// SetForwarder sets function which sends published messages to other nodes
+func (p *PubSub) SetForwarder(forward func(channel, message string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.forward = forward
+}
+
+func (p *PubSub) subscribe(sub *subscriber, channel string, kind subscriptionKind) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	registry := p.registry[kind]
+	subs, ok := registry[channel]
+	if !ok {
+		subs = make(map[*subscriber]struct{})
+		registry[channel] = subs
+	}
+	subs[sub] = struct{}{}
+}
+
+func (p *PubSub) unsubscribe(sub *subscriber, channel string, kind subscriptionKind) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	registry := p.registry[kind]
+	if subs, ok := registry[channel]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(registry, channel)
+		}
+	}
+}
+
+// Publish delivers message to local subscribers and forwards it to other nodes,
+// returns number of local subscribers which received it.
+func (p *PubSub) Publish(channel, message string) int {
+	n := p.Deliver(channel, message)
+	p.mu.RLock()
+	forward := p.forward
+	p.mu.RUnlock()
+	if forward != nil {
+		forward(channel, message)
+	}
+	return n
+}
+
+// Deliver sends message to subscribers of the channel and of matching patterns on this node,
+// returns number of subscribers which received it.
+func (p *PubSub) Deliver(channel, message string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	n := 0
+	if subs := p.registry[channelSubscription][channel]; len(subs) > 0 {
+		data := renderPush(protocol.Resp2BulkString("message"), protocol.Resp2BulkString(channel), protocol.Resp2BulkString(message))
+		for sub := range subs {
+			sub.send(data)
+			n++
+		}
+	}
+	for pattern, subs := range p.registry[patternSubscription] {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+		data := renderPush(protocol.Resp2BulkString("pmessage"), protocol.Resp2BulkString(pattern),
+			protocol.Resp2BulkString(channel), protocol.Resp2BulkString(message))
+		for sub := range subs {
+			sub.send(data)
+			n++
+		}
+	}
+	return n
+}
+
+// PublishShard sends message to subscribers of the shard channel,
+// all of them are connected to this node as it owns the slot.
+func (p *PubSub) PublishShard(channel, message string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	subs := p.registry[shardSubscription][channel]
+	if len(subs) == 0 {
+		return 0
+	}
+	data := renderPush(protocol.Resp2BulkString("smessage"), protocol.Resp2BulkString(channel), protocol.Resp2BulkString(message))
+	for sub := range subs {
+		sub.send(data)
+	}
+	return len(subs)
+}
+
+func renderPush(values ...protocol.Resp2Value) []byte {
+	data, _ := protocol.NewResp2ParserFromBytes(nil).Render(values)
+	return data
+}
+
+// subscriber is a connection which subscribed to a channel at least once.
+// Every write to the connection goes through its queue since then, so replies
+// and messages are never interleaved.
+type subscriber struct {
+	conn  net.Conn
+	limit int64 // in bytes, 0 means no limit
+
+	mu      sync.Mutex
+	queue   [][]byte
+	pending int64
+	closing bool
+	killed  bool
+	wake    chan struct{}
+	done    chan struct{}
+
+	// Owned by the connection goroutine
+	subscriptions [3]map[string]struct{} // by subscriptionKind
+}
+
+func newSubscriber(conn net.Conn, limit int64) *subscriber {
+	sub := &subscriber{
+		conn:  conn,
+		limit: limit,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	for i := range sub.subscriptions {
+		sub.subscriptions[i] = make(map[string]struct{})
+	}
+	go sub.writeLoop()
+	return sub
+}
+
+// count returns number of subscriptions reported in replies of the kind,
+// shard channels are counted separately like redis does
+func (s *subscriber) count(kind subscriptionKind) int {
+	if kind == shardSubscription {
+		return len(s.subscriptions[shardSubscription])
+	}
+	return len(s.subscriptions[channelSubscription]) + len(s.subscriptions[patternSubscription])
+}
+
+// active reports whether the connection is in subscriber mode
+func (s *subscriber) active() bool {
+	return s.count(channelSubscription)+s.count(shardSubscription) > 0
+}
+
+// send queues data, the connection is aborted when the queue exceeds the limit
+func (s *subscriber) send(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.killed || s.closing {
+		return
+	}
+	if s.limit > 0 && s.pending+int64(len(data)) > s.limit {
+		s.killed = true
+		s.queue = nil
+		// Unblocks both the reader and the writer of the connection
+		s.conn.SetDeadline(time.Unix(1, 0))
+		return
+	}
+	s.queue = append(s.queue, data)
+	s.pending += int64(len(data))
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// setDeadline changes deadline of the connection unless it was already aborted
+func (s *subscriber) setDeadline(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.killed {
+		s.conn.SetDeadline(t)
+	}
+}
+
+func (s *subscriber) isKilled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.killed
+}
+
+// close flushes queued data and stops the writer
+func (s *subscriber) close() {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	<-s.done
+}
+
+func (s *subscriber) writeLoop() {
+	defer close(s.done)
+	for range s.wake {
+		s.mu.Lock()
+		batch := s.queue
+		s.queue = nil
+		closing := s.closing
+		s.mu.Unlock()
+
+		for _, data := range batch {
+			if _, err := s.conn.Write(data); err != nil {
+				s.mu.Lock()
+				s.killed = true
+				s.queue = nil
+				s.mu.Unlock()
+				return
+			}
+			s.mu.Lock()
+			s.pending -= int64(len(data))
+			s.mu.Unlock()
+		}
+
+		s.mu.Lock()
+		done := s.killed || (closing && len(s.queue) == 0)
+		s.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// globMatch reports whether s matches glob pattern with *, ?, [...] and \ escapes like redis does
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end, ok := matchClass(pattern, s[0])
+			if !ok {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[end:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against [...] class at the start of pattern,
+// returns length of the class and whether c matched it.
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	// Unterminated class is treated as if it ended at the end of pattern
+	if i < len(pattern) {
+		i++
+	}
+	return i, matched != negate
+}
+
+var subscriptionReplies = [3]struct{ subscribe, unsubscribe string }{
+	channelSubscription: {"subscribe", "unsubscribe"},
+	patternSubscription: {"psubscribe", "punsubscribe"},
+	shardSubscription:   {"ssubscribe", "sunsubscribe"},
+}
+
+// subscribe replies with one confirmation per channel like redis does
+func (s *RedisService) subscribe(c *client, channels []string, kind subscriptionKind) protocol.Resp2Value {
+	if kind == shardSubscription {
+		if err := s.checkSlots(channels); err != nil {
+			return errorValue(err)
+		}
+	}
+	if c.sub == nil {
+		c.sub = newSubscriber(c.conn, s.cfg.Redis.PubSubOutputLimit)
+	}
+	reply := subscriptionReplies[kind].subscribe
+
+	replies := make(multiReply, 0, len(channels))
+	subscriptions := c.sub.subscriptions[kind]
+	for _, channel := range channels {
+		if _, ok := subscriptions[channel]; !ok {
+			subscriptions[channel] = struct{}{}
+			s.pubsub.subscribe(c.sub, channel, kind)
+		}
+		replies = append(replies, []protocol.Resp2Value{
+			protocol.Resp2BulkString(reply), protocol.Resp2BulkString(channel), protocol.Resp2Integer(c.sub.count(kind)),
+		})
+	}
+	return replies
+}
+
+// unsubscribe removes subscriptions, no channels means all of them
+func (s *RedisService) unsubscribe(c *client, channels []string, kind subscriptionKind) protocol.Resp2Value {
+	reply := subscriptionReplies[kind].unsubscribe
+	var subscriptions map[string]struct{}
+	if c.sub != nil {
+		subscriptions = c.sub.subscriptions[kind]
+	}
+	if len(channels) == 0 {
+		for channel := range subscriptions {
+			channels = append(channels, channel)
+		}
+	}
+	count := func() protocol.Resp2Value {
+		if c.sub == nil {
+			return protocol.Resp2Integer(0)
+		}
+		return protocol.Resp2Integer(c.sub.count(kind))
+	}
+	if len(channels) == 0 {
+		return []protocol.Resp2Value{protocol.Resp2BulkString(reply), nil, count()}
+	}
+
+	replies := make(multiReply, 0, len(channels))
+	for _, channel := range channels {
+		if _, ok := subscriptions[channel]; ok {
+			delete(subscriptions, channel)
+			s.pubsub.unsubscribe(c.sub, channel, kind)
+		}
+		replies = append(replies, []protocol.Resp2Value{protocol.Resp2BulkString(reply), protocol.Resp2BulkString(channel), count()})
+	}
+	return replies
+}
+
+// checkSlots redirects the client when shard channels are not served by this node,
+// all channels of a command have to be in the same slot.
+func (s *RedisService) checkSlots(channels []string) error {
+	slot := raft.KeySlot(channels[0])
+	for _, channel := range channels[1:] {
+		if raft.KeySlot(channel) != slot {
+			return errCrossSlot
+		}
+	}
+	owner := s.network.SlotOwner(slot)
+	if s.network.IsMe(owner.ID) {
+		return nil
+	}
+	address := owner.RedisAddress
+	if address == "" {
+		address = owner.Address
+	}
+	return fmt.Errorf("%w %d %s", errMoved, slot, address)
+}
@@ -1,11 +1,17 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"main/src/config"
 	"main/src/protocol"
+	"main/src/raft"
+	"main/src/storage"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,9 +21,19 @@ type RedisService struct {
 	cfg             *config.Config
 	logger          *config.Logger
 	timeoutDuration time.Duration
+	scripts         *scriptCache
+	pubsub          *PubSub
+	network         *raft.Network
 }
 
-func NewRedisServices(storage *StorageService, cfg *config.Config, logger *config.Logger) *RedisService {
+// NewRedisServices serves the keyspace of storage, network is the one shared with
+// the broadcaster, so slot ownership and fan-out see the same peers
+func NewRedisServices(storage *StorageService, network *raft.Network, cfg *config.Config, logger *config.Logger) *RedisService {
+	pubsub := NewPubSub()
+	// Keyspace notifications are delivered only to subscribers of this node
+	storage.SetKeyspaceNotifier(func(channel, message string) {
+		pubsub.Deliver(channel, message)
+	})
 	return &RedisService{
 		meta: TcpMetadata{
 			BaseMetadata: BaseMetadata{
@@ -31,28 +47,64 @@ func NewRedisServices(storage *StorageService, cfg *config.Config, logger *confi
 		cfg:             cfg,
 		logger:          logger,
 		timeoutDuration: time.Duration(cfg.Redis.Timeout) * time.Second,
+		scripts:         newScriptCache(),
+		pubsub:          pubsub,
+		network:         network,
 	}
 }
 
-func errorResponse(err error) []byte {
-	return []byte(fmt.Sprintf("-ERR %v\r\n", err))
+// PubSub returns registry of subscriptions, used to connect it with other nodes
+func (s *RedisService) PubSub() *PubSub {
+	return s.pubsub
 }
 
-func okResponse() []byte {
-	return []byte("+OK\r\n")
+// errorValue converts error into RESP2 error reply.
+// Errors which already carry a redis error prefix (e.g. WRONGTYPE) are passed as is.
+func errorValue(err error) protocol.Resp2Value {
+	for _, prefixed := range []error{storage.ErrWrongType, storage.ErrNoGroup, storage.ErrBusyGroup, errNoScript, errMoved, errCrossSlot, errOOM} {
+		if errors.Is(err, prefixed) {
+			return protocol.Resp2Error(err.Error())
+		}
+	}
+	return protocol.Resp2Error(fmt.Sprintf("ERR %v", err))
+}
+
+func okValue() protocol.Resp2Value {
+	return protocol.Resp2SimpleString("OK")
 }
 
-func pongResponse() []byte {
-	return []byte("+PONG\r\n")
+func pongValue() protocol.Resp2Value {
+	return protocol.Resp2SimpleString("PONG")
+}
+
+func boolValue(b bool) protocol.Resp2Value {
+	if b {
+		return protocol.Resp2Integer(1)
+	}
+	return protocol.Resp2Integer(0)
+}
+
+func stringsValue(values []string) protocol.Resp2Value {
+	arr := make([]protocol.Resp2Value, 0, len(values))
+	for _, v := range values {
+		arr = append(arr, protocol.Resp2BulkString(v))
+	}
+	return arr
 }
 
 func (s *RedisService) OnMessage(conn net.Conn) error {
 	parser := protocol.NewResp2Parser(conn, s.cfg.Redis.MaxMessageSize)
 	opParser := protocol.MakeOpParser(parser)
+	c := &client{conn: conn}
+	defer s.disconnect(c)
 
 	for {
 		op, err := opParser.Parse()
 		if err != nil {
+			if c.sub != nil && c.sub.isKilled() {
+				s.logger.Warn("Subscriber disconnected: %v", errOutputLimit)
+				return errOutputLimit
+			}
 			if err == io.EOF {
 				return nil
 			}
@@ -65,52 +117,716 @@ func (s *RedisService) OnMessage(conn net.Conn) error {
 
 		s.logger.Debug("Processing operation: %s", op.Kind)
 
-		var response []byte
+		response, err := renderReply(parser, s.dispatch(c, op))
+		if err != nil {
+			response, _ = parser.Render(errorValue(err))
+		}
+
+		err = c.write(response)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				s.logger.Warn("Connection timed out during write: %v", netErr)
+				return err
+			}
+			return fmt.Errorf("failed to write response: %w", err)
+		}
+
+		c.extendDeadline(s.timeoutDuration)
+	}
+}
+
+// client is state of a single connection
+type client struct {
+	conn net.Conn
+	tx   transaction
+	sub  *subscriber // created by the first SUBSCRIBE, all writes go through it since then
+}
+
+func (c *client) write(data []byte) error {
+	if c.sub != nil {
+		c.sub.send(data)
+		return nil
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
 
+// extendDeadline moves deadline of the connection after a processed command,
+// subscribed connections wait for messages without a timeout.
+func (c *client) extendDeadline(timeout time.Duration) {
+	var deadline time.Time
+	if timeout > 0 && (c.sub == nil || !c.sub.active()) {
+		deadline = time.Now().Add(timeout)
+	}
+	switch {
+	case c.sub != nil:
+		c.sub.setDeadline(deadline)
+	case timeout > 0:
+		c.conn.SetDeadline(deadline)
+	}
+}
+
+// disconnect releases everything the connection holds
+func (s *RedisService) disconnect(c *client) {
+	s.storage.Unwatch(c.tx.reset())
+	if c.sub != nil {
+		for _, kind := range []subscriptionKind{channelSubscription, patternSubscription, shardSubscription} {
+			s.unsubscribe(c, nil, kind)
+		}
+		c.sub.close()
+	}
+}
+
+// multiReply is rendered as several consecutive replies
+type multiReply []protocol.Resp2Value
+
+func renderReply(parser *protocol.Resp2Parser, reply protocol.Resp2Value) ([]byte, error) {
+	multi, ok := reply.(multiReply)
+	if !ok {
+		return parser.Render(reply)
+	}
+	var data []byte
+	for _, r := range multi {
+		rendered, err := parser.Render(r)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, rendered...)
+	}
+	return data, nil
+}
+
+// dispatch handles connection level commands and passes the rest to handle
+func (s *RedisService) dispatch(c *client, op *protocol.Op) protocol.Resp2Value {
+	switch op.Kind {
+	case protocol.SUBSCRIBE, protocol.PSUBSCRIBE, protocol.SSUBSCRIBE,
+		protocol.UNSUBSCRIBE, protocol.PUNSUBSCRIBE, protocol.SUNSUBSCRIBE:
+		if c.tx.active {
+			return errorValue(fmt.Errorf("%s is not allowed inside MULTI", op.Kind))
+		}
 		switch op.Kind {
-		case protocol.GET:
-			val, err := s.storage.Get(op.Payload.(protocol.OpPayloadGet).Key)
+		case protocol.SUBSCRIBE:
+			return s.subscribe(c, op.Payload.(protocol.OpPayloadSubscribe).Channels, channelSubscription)
+		case protocol.PSUBSCRIBE:
+			return s.subscribe(c, op.Payload.(protocol.OpPayloadSubscribe).Channels, patternSubscription)
+		case protocol.SSUBSCRIBE:
+			return s.subscribe(c, op.Payload.(protocol.OpPayloadSubscribe).Channels, shardSubscription)
+		case protocol.UNSUBSCRIBE:
+			return s.unsubscribe(c, op.Payload.(protocol.OpPayloadUnsubscribe).Channels, channelSubscription)
+		case protocol.PUNSUBSCRIBE:
+			return s.unsubscribe(c, op.Payload.(protocol.OpPayloadUnsubscribe).Channels, patternSubscription)
+		default:
+			return s.unsubscribe(c, op.Payload.(protocol.OpPayloadUnsubscribe).Channels, shardSubscription)
+		}
+	}
+
+	if c.sub != nil && c.sub.active() {
+		if op.Kind == protocol.PING {
+			return []protocol.Resp2Value{protocol.Resp2BulkString("pong"), protocol.Resp2BulkString("")}
+		}
+		return errorValue(fmt.Errorf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context",
+			strings.ToLower(op.Kind.String())))
+	}
+	return s.handle(&c.tx, op)
+}
+
+// transaction is MULTI/EXEC state of a connection
+type transaction struct {
+	active  bool
+	queued  []*protocol.Op
+	watched []WatchedKey
+}
+
+// reset leaves MULTI and returns keys which have to be unwatched
+func (t *transaction) reset() []WatchedKey {
+	watched := t.watched
+	*t = transaction{}
+	return watched
+}
+
+// handle runs op, or queues it when the connection is inside MULTI
+func (s *RedisService) handle(tx *transaction, op *protocol.Op) protocol.Resp2Value {
+	switch op.Kind {
+	case protocol.MULTI:
+		if tx.active {
+			return errorValue(fmt.Errorf("MULTI calls can not be nested"))
+		}
+		tx.active = true
+		return okValue()
+	case protocol.EXEC:
+		if !tx.active {
+			return errorValue(fmt.Errorf("EXEC without MULTI"))
+		}
+		return s.exec(tx)
+	case protocol.DISCARD:
+		if !tx.active {
+			return errorValue(fmt.Errorf("DISCARD without MULTI"))
+		}
+		s.storage.Unwatch(tx.reset())
+		return okValue()
+	case protocol.WATCH:
+		if tx.active {
+			return errorValue(fmt.Errorf("WATCH inside MULTI is not allowed"))
+		}
+		watched, err := s.storage.Watch(op.Payload.(protocol.OpPayloadWatch).Keys)
+		if err != nil {
+			return errorValue(err)
+		}
+		tx.watched = append(tx.watched, watched...)
+		return okValue()
+	case protocol.UNWATCH:
+		if !tx.active {
+			s.storage.Unwatch(tx.watched)
+			tx.watched = nil
+			return okValue()
+		}
+	}
+
+	if tx.active {
+		tx.queued = append(tx.queued, op)
+		return protocol.Resp2SimpleString("QUEUED")
+	}
+	return s.execute(op)
+}
+
+// exec runs queued operations atomically, replies with nil array when a watched key changed
+func (s *RedisService) exec(tx *transaction) protocol.Resp2Value {
+	queued := tx.queued
+	watched := tx.reset()
+	defer s.storage.Unwatch(watched)
+
+	replies := make([]protocol.Resp2Value, 0, len(queued))
+	ok, err := s.storage.Transaction(watched, func(store *StorageService) {
+		txService := *s
+		txService.storage = store
+		for _, op := range queued {
+			replies = append(replies, txService.execute(op))
+		}
+	})
+	if err != nil {
+		return errorValue(err)
+	}
+	if !ok {
+		return []protocol.Resp2Value(nil)
+	}
+	return replies
+}
+
+// execute runs a single operation against storage and returns the reply.
+func (s *RedisService) execute(op *protocol.Op) protocol.Resp2Value {
+	switch op.Kind {
+	case protocol.GET:
+		val, found, err := s.storage.Get(op.Payload.(protocol.OpPayloadGet).Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		if !found {
+			return nil
+		}
+		if val == nil {
+			// Stored nil value exists, only a missing key is replied as null
+			return protocol.Resp2BulkString("")
+		}
+		return val
+	case protocol.SET:
+		payload := op.Payload.(protocol.OpPayloadSet)
+		old, ok, err := s.storage.SetWithOptions(payload)
+		if err != nil {
+			return errorValue(err)
+		}
+		if payload.Get {
+			return old
+		}
+		if !ok {
+			return nil
+		}
+		return okValue()
+	case protocol.DELETE:
+		if err := s.storage.Delete(op.Payload.(protocol.OpPayloadDelete).Key); err != nil {
+			return errorValue(err)
+		}
+		return okValue()
+	case protocol.PING:
+		return pongValue()
+	case protocol.UNWATCH:
+		// Queued inside MULTI, watched keys are released by EXEC anyway
+		return okValue()
+	case protocol.SADD, protocol.SREM, protocol.SMEMBERS, protocol.SISMEMBER, protocol.SCARD,
+		protocol.SINTER, protocol.SUNION, protocol.SDIFF,
+		protocol.SINTERSTORE, protocol.SUNIONSTORE, protocol.SDIFFSTORE:
+		return s.executeSet(op)
+	case protocol.ZADD, protocol.ZREM, protocol.ZCARD, protocol.ZSCORE,
+		protocol.ZRANK, protocol.ZINCRBY, protocol.ZRANGE:
+		return s.executeZSet(op)
+	case protocol.XADD, protocol.XRANGE, protocol.XLEN, protocol.XTRIM, protocol.XREAD,
+		protocol.XGROUP, protocol.XREADGROUP, protocol.XACK, protocol.XPENDING, protocol.XCLAIM:
+		return s.executeStream(op)
+	case protocol.EXPIRE:
+		ok, err := s.storage.Expire(op.Payload.(protocol.OpPayloadExpire))
+		if err != nil {
+			return errorValue(err)
+		}
+		return boolValue(ok)
+	case protocol.TTL:
+		ttl, err := s.storage.TTL(op.Payload.(protocol.OpPayloadTTL))
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(ttl)
+	case protocol.PERSIST:
+		ok, err := s.storage.Persist(op.Payload.(protocol.OpPayloadPersist).Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		return boolValue(ok)
+	case protocol.INCRBY, protocol.INCRBYFLOAT, protocol.APPEND, protocol.GETRANGE,
+		protocol.SETRANGE, protocol.STRLEN, protocol.GETSET, protocol.GETDEL:
+		return s.executeString(op)
+	case protocol.DEL:
+		n, err := s.storage.Del(op.Payload.(protocol.OpPayloadDel).Keys)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.EXISTS:
+		n, err := s.storage.CountExisting(op.Payload.(protocol.OpPayloadExists).Keys)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.MGET:
+		values, err := s.storage.MGet(op.Payload.(protocol.OpPayloadMGet).Keys)
+		if err != nil {
+			return errorValue(err)
+		}
+		return values
+	case protocol.SCAN:
+		keys, next, err := s.storage.Scan(op.Payload.(protocol.OpPayloadScan))
+		if err != nil {
+			return errorValue(err)
+		}
+		return []protocol.Resp2Value{protocol.Resp2BulkString(strconv.FormatUint(next, 10)), stringsValue(keys)}
+	case protocol.KEYS:
+		return stringsValue(s.storage.Keys(op.Payload.(protocol.OpPayloadKeys).Pattern))
+	case protocol.TYPE:
+		kind, err := s.storage.Type(op.Payload.(protocol.OpPayloadType).Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2SimpleString(kind)
+	case protocol.RENAME:
+		payload := op.Payload.(protocol.OpPayloadRename)
+		if err := s.storage.Rename(payload.Key, payload.NewKey); err != nil {
+			return errorValue(err)
+		}
+		return okValue()
+	case protocol.COPY:
+		ok, err := s.storage.Copy(op.Payload.(protocol.OpPayloadCopy))
+		if err != nil {
+			return errorValue(err)
+		}
+		return boolValue(ok)
+	case protocol.RANDOMKEY:
+		key, ok := s.storage.RandomKey()
+		if !ok {
+			return nil
+		}
+		return protocol.Resp2BulkString(key)
+	case protocol.DBSIZE:
+		return protocol.Resp2Integer(s.storage.DBSize())
+	case protocol.EVAL, protocol.EVALSHA, protocol.SCRIPT:
+		return s.executeScript(op)
+	case protocol.PUBLISH:
+		payload := op.Payload.(protocol.OpPayloadPublish)
+		return protocol.Resp2Integer(s.pubsub.Publish(payload.Channel, payload.Message))
+	case protocol.SPUBLISH:
+		payload := op.Payload.(protocol.OpPayloadPublish)
+		if err := s.checkSlots([]string{payload.Channel}); err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(s.pubsub.PublishShard(payload.Channel, payload.Message))
+	case protocol.CONFIG:
+		return s.executeConfig(op)
+	case protocol.GETREV:
+		value, revision, found, err := s.storage.GetWithRevision(op.Payload.(protocol.OpPayloadGetRev).Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		if !found {
+			return nil
+		}
+		if value == nil {
+			value = protocol.Resp2BulkString("")
+		}
+		return []protocol.Resp2Value{value, protocol.Resp2Integer(revision)}
+	case protocol.SETREV:
+		payload := op.Payload.(protocol.OpPayloadSetRev)
+		revision, ok, err := s.storage.CompareAndSet(payload.Key, payload.Revision, payload.Value)
+		if err != nil {
+			return errorValue(err)
+		}
+		if !ok {
+			return nil
+		}
+		return protocol.Resp2Integer(revision)
+	case protocol.LOCK:
+		payload := op.Payload.(protocol.OpPayloadLock)
+		token, err := s.storage.Lock(context.Background(), payload.Name, payload.Owner, payload.TTL, payload.Block)
+		if err != nil {
+			return errorValue(err)
+		}
+		if token == 0 {
+			return nil
+		}
+		return protocol.Resp2Integer(token)
+	case protocol.LOCKRENEW:
+		payload := op.Payload.(protocol.OpPayloadLockRenew)
+		ok, err := s.storage.RenewLock(payload.Name, payload.Token, payload.TTL)
+		if err != nil {
+			return errorValue(err)
+		}
+		return boolValue(ok)
+	case protocol.UNLOCK:
+		payload := op.Payload.(protocol.OpPayloadUnlock)
+		ok, err := s.storage.Unlock(payload.Name, payload.Token)
+		if err != nil {
+			return errorValue(err)
+		}
+		return boolValue(ok)
+	case protocol.LEASE:
+		return s.executeLease(op)
+	case protocol.DELREV:
+		payload := op.Payload.(protocol.OpPayloadDelRev)
+		ok, err := s.storage.CompareAndDelete(payload.Key, payload.Revision)
+		if err != nil {
+			return errorValue(err)
+		}
+		return boolValue(ok)
+	case protocol.MSET:
+		payload := op.Payload.(protocol.OpPayloadMSet)
+		ok, err := s.storage.MSet(payload)
+		if err != nil {
+			return errorValue(err)
+		}
+		if payload.NX {
+			return boolValue(ok)
+		}
+		return okValue()
+	default:
+		// It is an error on the client side, respond with error
+		return errorValue(fmt.Errorf("unknown operation"))
+	}
+}
+
+func (s *RedisService) executeString(op *protocol.Op) protocol.Resp2Value {
+	switch payload := op.Payload.(type) {
+	case protocol.OpPayloadIncrBy:
+		n, err := s.storage.IncrBy(payload.Key, payload.Delta)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadIncrByFloat:
+		value, err := s.storage.IncrByFloat(payload.Key, payload.Delta)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2BulkString(value)
+	case protocol.OpPayloadAppend:
+		n, err := s.storage.Append(payload.Key, payload.Value)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadGetRange:
+		value, err := s.storage.GetRange(payload.Key, payload.Start, payload.End)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2BulkString(value)
+	case protocol.OpPayloadSetRange:
+		n, err := s.storage.SetRange(payload.Key, payload.Offset, payload.Value)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadStrLen:
+		n, err := s.storage.StrLen(payload.Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadGetSet:
+		old, err := s.storage.GetSet(payload.Key, payload.Value)
+		if err != nil {
+			return errorValue(err)
+		}
+		return old
+	case protocol.OpPayloadGetDel:
+		value, err := s.storage.GetDel(payload.Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		return value
+	default:
+		return errorValue(fmt.Errorf("unexpected payload %T", op.Payload))
+	}
+}
+
+func (s *RedisService) executeSet(op *protocol.Op) protocol.Resp2Value {
+	switch payload := op.Payload.(type) {
+	case protocol.OpPayloadSAdd:
+		n, err := s.storage.SAdd(payload.Key, payload.Members)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadSRem:
+		n, err := s.storage.SRem(payload.Key, payload.Members)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadSMembers:
+		members, err := s.storage.SMembers(payload.Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		return stringsValue(members)
+	case protocol.OpPayloadSIsMember:
+		ok, err := s.storage.SIsMember(payload.Key, payload.Member)
+		if err != nil {
+			return errorValue(err)
+		}
+		return boolValue(ok)
+	case protocol.OpPayloadSCard:
+		n, err := s.storage.SCard(payload.Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadSetAlgebra:
+		members, err := s.storage.SetAlgebra(op.Kind, payload.Keys)
+		if err != nil {
+			return errorValue(err)
+		}
+		return stringsValue(members)
+	case protocol.OpPayloadSetAlgebraStore:
+		n, err := s.storage.SetAlgebraStore(op.Kind, payload.Destination, payload.Keys)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	default:
+		return errorValue(fmt.Errorf("unexpected payload %T", op.Payload))
+	}
+}
+
+func (s *RedisService) executeZSet(op *protocol.Op) protocol.Resp2Value {
+	switch payload := op.Payload.(type) {
+	case protocol.OpPayloadZAdd:
+		if payload.Flags.Incr {
+			m := payload.Members[0]
+			score, ok, err := s.storage.ZIncrBy(payload.Key, payload.Flags, m.Score, m.Member)
 			if err != nil {
-				response = errorResponse(err)
-			} else {
-				response, err = parser.Render(val)
-				if err != nil {
-					response = errorResponse(err)
-				}
+				return errorValue(err)
 			}
-		case protocol.SET:
-			err = s.storage.Set(op.Payload.(protocol.OpPayloadSet).Key, op.Payload.(protocol.OpPayloadSet).Value)
-			if err != nil {
-				response = errorResponse(err)
-			} else {
-				response = okResponse()
+			if !ok {
+				return nil
 			}
-		case protocol.DELETE:
-			err := s.storage.Delete(op.Payload.(protocol.OpPayloadDelete).Key)
-			if err != nil {
-				response = errorResponse(err)
-			} else {
-				response = okResponse()
+			return protocol.Resp2BulkString(protocol.FormatScore(score))
+		}
+		n, err := s.storage.ZAdd(payload.Key, payload.Flags, payload.Members)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadZRem:
+		n, err := s.storage.ZRem(payload.Key, payload.Members)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadZCard:
+		n, err := s.storage.ZCard(payload.Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadZScore:
+		score, ok, err := s.storage.ZScore(payload.Key, payload.Member)
+		if err != nil {
+			return errorValue(err)
+		}
+		if !ok {
+			return nil
+		}
+		return protocol.Resp2BulkString(protocol.FormatScore(score))
+	case protocol.OpPayloadZRank:
+		rank, ok, err := s.storage.ZRank(payload.Key, payload.Member, payload.Rev)
+		if err != nil {
+			return errorValue(err)
+		}
+		if !ok {
+			return nil
+		}
+		return protocol.Resp2Integer(rank)
+	case protocol.OpPayloadZIncrBy:
+		score, _, err := s.storage.ZIncrBy(payload.Key, protocol.ZAddFlags{}, payload.Increment, payload.Member)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2BulkString(protocol.FormatScore(score))
+	case protocol.OpPayloadZRange:
+		entries, err := s.storage.ZRange(payload)
+		if err != nil {
+			return errorValue(err)
+		}
+		arr := make([]protocol.Resp2Value, 0, len(entries))
+		for _, e := range entries {
+			arr = append(arr, protocol.Resp2BulkString(e.Member))
+			if payload.WithScores {
+				arr = append(arr, protocol.Resp2BulkString(protocol.FormatScore(e.Score)))
 			}
-		case protocol.PING:
-			response = pongResponse()
-		default:
-			// It is an error on the client side, respond with error
-			response = errorResponse(fmt.Errorf("unknown operation"))
 		}
+		return arr
+	default:
+		return errorValue(fmt.Errorf("unexpected payload %T", op.Payload))
+	}
+}
+
+func streamEntriesValue(entries []storage.StreamEntry) protocol.Resp2Value {
+	arr := make([]protocol.Resp2Value, 0, len(entries))
+	for _, e := range entries {
+		if e.Fields == nil {
+			// Entry was deleted but is still pending
+			arr = append(arr, []protocol.Resp2Value{protocol.Resp2BulkString(e.ID.String()), []protocol.Resp2Value(nil)})
+			continue
+		}
+		arr = append(arr, storage.EncodeStreamEntry(e))
+	}
+	return arr
+}
+
+func streamReadValue(results []StreamReadResult) protocol.Resp2Value {
+	if results == nil {
+		return []protocol.Resp2Value(nil)
+	}
+	arr := make([]protocol.Resp2Value, 0, len(results))
+	for _, r := range results {
+		arr = append(arr, []protocol.Resp2Value{protocol.Resp2BulkString(r.Key), streamEntriesValue(r.Entries)})
+	}
+	return arr
+}
 
-		_, err = conn.Write(response)
+func (s *RedisService) executeStream(op *protocol.Op) protocol.Resp2Value {
+	switch payload := op.Payload.(type) {
+	case protocol.OpPayloadXAdd:
+		id, ok, err := s.storage.XAdd(payload)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				s.logger.Warn("Connection timed out during write: %v", netErr)
-				return err
+			return errorValue(err)
+		}
+		if !ok {
+			return nil
+		}
+		return protocol.Resp2BulkString(id.String())
+	case protocol.OpPayloadXRange:
+		entries, err := s.storage.XRange(payload)
+		if err != nil {
+			return errorValue(err)
+		}
+		return streamEntriesValue(entries)
+	case protocol.OpPayloadXLen:
+		n, err := s.storage.XLen(payload.Key)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadXTrim:
+		n, err := s.storage.XTrim(payload.Key, payload.Trim)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadXRead:
+		results, err := s.storage.XRead(payload)
+		if err != nil {
+			return errorValue(err)
+		}
+		return streamReadValue(results)
+	case protocol.OpPayloadXGroup:
+		if payload.Subcommand == "DESTROY" {
+			ok, err := s.storage.XGroupDestroy(payload.Key, payload.Group)
+			if err != nil {
+				return errorValue(err)
 			}
-			return fmt.Errorf("failed to write response: %w", err)
+			return boolValue(ok)
 		}
-
-		if s.timeoutDuration > 0 {
-			conn.SetDeadline(time.Now().Add(s.timeoutDuration))
+		if err := s.storage.XGroupCreate(payload.Key, payload.Group, payload.ID, payload.MkStream); err != nil {
+			return errorValue(err)
+		}
+		return okValue()
+	case protocol.OpPayloadXReadGroup:
+		results, err := s.storage.XReadGroup(payload)
+		if err != nil {
+			return errorValue(err)
+		}
+		return streamReadValue(results)
+	case protocol.OpPayloadXAck:
+		n, err := s.storage.XAck(payload.Key, payload.Group, payload.IDs)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadXPending:
+		if !payload.Extended {
+			summary, err := s.storage.XPendingSummary(payload.Key, payload.Group)
+			if err != nil {
+				return errorValue(err)
+			}
+			if summary.Count == 0 {
+				return []protocol.Resp2Value{protocol.Resp2Integer(0), nil, nil, []protocol.Resp2Value(nil)}
+			}
+			consumers := make([]protocol.Resp2Value, 0, len(summary.Consumers))
+			for i, c := range summary.Consumers {
+				consumers = append(consumers, stringsValue([]string{c, strconv.Itoa(summary.Counts[i])}))
+			}
+			return []protocol.Resp2Value{
+				protocol.Resp2Integer(summary.Count),
+				protocol.Resp2BulkString(summary.Min.String()),
+				protocol.Resp2BulkString(summary.Max.String()),
+				consumers,
+			}
+		}
+		pending, err := s.storage.XPending(payload)
+		if err != nil {
+			return errorValue(err)
+		}
+		arr := make([]protocol.Resp2Value, 0, len(pending))
+		for _, p := range pending {
+			arr = append(arr, []protocol.Resp2Value{
+				protocol.Resp2BulkString(p.ID.String()),
+				protocol.Resp2BulkString(p.Consumer),
+				protocol.Resp2Integer(p.DeliveryTime),
+				protocol.Resp2Integer(p.DeliveryCount),
+			})
+		}
+		return arr
+	case protocol.OpPayloadXClaim:
+		entries, err := s.storage.XClaim(payload)
+		if err != nil {
+			return errorValue(err)
+		}
+		if !payload.JustID {
+			return streamEntriesValue(entries)
+		}
+		ids := make([]string, 0, len(entries))
+		for _, e := range entries {
+			ids = append(ids, e.ID.String())
 		}
+		return stringsValue(ids)
+	default:
+		return errorValue(fmt.Errorf("unexpected payload %T", op.Payload))
 	}
 }
 
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"fmt"
+	"main/src/protocol"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CONFIG GET/SET of RedisService.
+// Only parameters which can be changed at runtime are exposed.
+
+type configParameter struct {
+	name string
+	get  func(s *RedisService) string
+	set  func(s *RedisService, value string) error
+}
+
+var configParameters = []configParameter{
+	{
+		name: "notify-keyspace-events",
+		get:  func(s *RedisService) string { return s.storage.KeyspaceEvents() },
+		set:  func(s *RedisService, value string) error { return s.storage.SetKeyspaceEvents(value) },
+	},
+	{
+		name: "maxmemory",
+		get:  func(s *RedisService) string { return strconv.FormatInt(s.storage.MaxMemory(), 10) },
+		set: func(s *RedisService, value string) error {
+			bytes, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+			return s.storage.SetMaxMemory(bytes)
+		},
+	},
+	{
+		name: "maxmemory-policy",
+		get:  func(s *RedisService) string { return s.storage.MaxMemoryPolicy() },
+		set:  func(s *RedisService, value string) error { return s.storage.SetMaxMemoryPolicy(value) },
+	},
+	{
+		name: "maxmemory-samples",
+		get:  func(s *RedisService) string { return strconv.Itoa(s.storage.MaxMemorySamples()) },
+		set: func(s *RedisService, value string) error {
+			samples, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("argument must be an integer")
+			}
+			return s.storage.SetMaxMemorySamples(samples)
+		},
+	},
+}
+
+// parseMemory parses bytes with optional unit like redis configuration does, e.g. 100mb
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	lower := strings.ToLower(value)
+	scale := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, scale = strings.TrimSuffix(lower, unit.suffix), unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/scale {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return n * scale, nil
+}
+
+func (s *RedisService) executeConfig(op *protocol.Op) protocol.Resp2Value {
+	switch payload := op.Payload.(type) {
+	case protocol.OpPayloadConfigGet:
+		replies := make([]protocol.Resp2Value, 0)
+		for _, param := range configParameters {
+			for _, pattern := range payload.Patterns {
+				if globMatch(pattern, param.name) {
+					replies = append(replies, protocol.Resp2BulkString(param.name), protocol.Resp2BulkString(param.get(s)))
+					break
+				}
+			}
+		}
+		return replies
+	case protocol.OpPayloadConfigSet:
+		for _, param := range configParameters {
+			if param.name != payload.Parameter {
+				continue
+			}
+			if err := param.set(s, payload.Value); err != nil {
+				return errorValue(fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %v", param.name, err))
+			}
+			return okValue()
+		}
+		return errorValue(fmt.Errorf("Unknown option or number of arguments for CONFIG SET - '%s'", payload.Parameter))
+	default:
+		return errorValue(fmt.Errorf("unexpected payload %T", op.Payload))
+	}
+}
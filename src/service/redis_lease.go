@@ -0,0 +1,56 @@
+package service
+
+import (
+	"fmt"
+	"main/src/protocol"
+)
+
+// LEASE subcommands of RedisService.
+
+func (s *RedisService) executeLease(op *protocol.Op) protocol.Resp2Value {
+	switch payload := op.Payload.(type) {
+	case protocol.OpPayloadLeaseGrant:
+		id, err := s.storage.GrantLease(payload.TTL)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(id)
+	case protocol.OpPayloadLeaseAttach:
+		n, err := s.storage.AttachLease(payload.ID, payload.Keys)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2Integer(n)
+	case protocol.OpPayloadLeaseKeepAlive:
+		ttl, err := s.storage.KeepAliveLease(payload.ID)
+		if err != nil {
+			return errorValue(err)
+		}
+		return boolValue(ttl > 0)
+	case protocol.OpPayloadLeaseRevoke:
+		ok, err := s.storage.RevokeLease(payload.ID)
+		if err != nil {
+			return errorValue(err)
+		}
+		return boolValue(ok)
+	case protocol.OpPayloadLeaseTTL:
+		info := s.storage.LeaseTimeToLive(payload.ID)
+		if info == nil {
+			return nil
+		}
+		keys := make([]protocol.Resp2Value, 0, len(info.Keys))
+		for _, key := range info.Keys {
+			keys = append(keys, protocol.Resp2BulkString(key))
+		}
+		return []protocol.Resp2Value{protocol.Resp2Integer(info.Remaining), protocol.Resp2Integer(info.TTL), keys}
+	case protocol.OpPayloadLeaseList:
+		ids := s.storage.Leases()
+		replies := make([]protocol.Resp2Value, 0, len(ids))
+		for _, id := range ids {
+			replies = append(replies, protocol.Resp2Integer(id))
+		}
+		return replies
+	default:
+		return errorValue(fmt.Errorf("unexpected payload %T", op.Payload))
+	}
+}
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"main/src/protocol"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Lua scripting of RedisService.
+// A script runs as a single transaction, commands called from it go through the
+// regular handlers, so only their effects are written to the WAL and replay never
+// runs the script again.
+
+var errNoScript = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+
+// scriptCache holds compiled scripts by SHA1 of their source
+type scriptCache struct {
+	mu      sync.RWMutex
+	scripts map[string]*lua.FunctionProto
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{scripts: make(map[string]*lua.FunctionProto)}
+}
+
+func scriptSHA(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// load compiles script and caches it, compiled scripts are shared by all connections
+func (c *scriptCache) load(script string) (string, *lua.FunctionProto, error) {
+	sha := scriptSHA(script)
+	if proto, ok := c.get(sha); ok {
+		return sha, proto, nil
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(script), "user_script")
+	if err != nil {
+		return "", nil, fmt.Errorf("Error compiling script: %v", err)
+	}
+	proto, err := lua.Compile(chunk, "user_script")
+	if err != nil {
+		return "", nil, fmt.Errorf("Error compiling script: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts[sha] = proto
+	return sha, proto, nil
+}
+
+func (c *scriptCache) get(sha string) (*lua.FunctionProto, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	proto, ok := c.scripts[sha]
+	return proto, ok
+}
+
+func (c *scriptCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = make(map[string]*lua.FunctionProto)
+}
+
+func (s *RedisService) executeScript(op *protocol.Op) protocol.Resp2Value {
+	switch payload := op.Payload.(type) {
+	case protocol.OpPayloadEval:
+		_, proto, err := s.scripts.load(payload.Script)
+		if err != nil {
+			return errorValue(err)
+		}
+		return s.eval(proto, payload.Keys, payload.Args)
+	case protocol.OpPayloadEvalSha:
+		proto, ok := s.scripts.get(payload.SHA)
+		if !ok {
+			return errorValue(errNoScript)
+		}
+		return s.eval(proto, payload.Keys, payload.Args)
+	case protocol.OpPayloadScriptLoad:
+		sha, _, err := s.scripts.load(payload.Script)
+		if err != nil {
+			return errorValue(err)
+		}
+		return protocol.Resp2BulkString(sha)
+	case protocol.OpPayloadScriptExists:
+		replies := make([]protocol.Resp2Value, 0, len(payload.SHAs))
+		for _, sha := range payload.SHAs {
+			_, ok := s.scripts.get(sha)
+			replies = append(replies, boolValue(ok))
+		}
+		return replies
+	case protocol.OpPayloadScriptFlush:
+		s.scripts.flush()
+		return okValue()
+	default:
+		return errorValue(fmt.Errorf("unexpected payload %T", op.Payload))
+	}
+}
+
+// eval runs script atomically, nothing else is executed until it finishes or hits the time limit
+func (s *RedisService) eval(proto *lua.FunctionProto, keys, args []string) protocol.Resp2Value {
+	var reply protocol.Resp2Value
+	_, err := s.storage.Transaction(nil, func(store *StorageService) {
+		txService := *s
+		txService.storage = store
+		reply = txService.runScript(proto, keys, args)
+	})
+	if err != nil {
+		return errorValue(err)
+	}
+	return reply
+}
+
+func (s *RedisService) runScript(proto *lua.FunctionProto, keys, args []string) protocol.Resp2Value {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+
+	if limit := s.cfg.Redis.ScriptTimeLimit; limit > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(limit)*time.Millisecond)
+		defer cancel()
+		L.SetContext(ctx)
+	}
+
+	openScriptLibs(L)
+	L.SetGlobal("KEYS", stringsTable(L, keys))
+	L.SetGlobal("ARGV", stringsTable(L, args))
+	L.SetGlobal("redis", s.redisTable(L))
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if ctx := L.Context(); ctx != nil && ctx.Err() != nil {
+			return errorValue(fmt.Errorf("Script exceeded time limit of %d ms", s.cfg.Redis.ScriptTimeLimit))
+		}
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			// Errors raised by redis.call keep their original reply
+			if reply, ok := luaToResp(apiErr.Object).(protocol.Resp2Error); ok {
+				return reply
+			}
+			return errorValue(fmt.Errorf("Error running script: %s", apiErr.Object.String()))
+		}
+		return errorValue(fmt.Errorf("Error running script: %v", err))
+	}
+	return luaToResp(L.Get(-1))
+}
+
+// openScriptLibs opens only libraries which can not reach the file system or the process
+func openScriptLibs(L *lua.LState) {
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "print"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+}
+
+func stringsTable(L *lua.LState, values []string) *lua.LTable {
+	table := L.CreateTable(len(values), 0)
+	for _, v := range values {
+		table.Append(lua.LString(v))
+	}
+	return table
+}
+
+func (s *RedisService) redisTable(L *lua.LState) *lua.LTable {
+	table := L.NewTable()
+	L.SetFuncs(table, map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			reply := s.scriptCall(L)
+			if e, ok := reply.(protocol.Resp2Error); ok {
+				L.Error(errorTable(L, string(e)), 1)
+				return 0
+			}
+			L.Push(respToLua(L, reply))
+			return 1
+		},
+		"pcall": func(L *lua.LState) int {
+			L.Push(respToLua(L, s.scriptCall(L)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			status := L.CreateTable(0, 1)
+			status.RawSetString("ok", lua.LString(L.CheckString(1)))
+			L.Push(status)
+			return 1
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(errorTable(L, L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(scriptSHA(L.CheckString(1))))
+			return 1
+		},
+	})
+	return table
+}
+
+// scriptCall executes command given by arguments of redis.call and redis.pcall
+func (s *RedisService) scriptCall(L *lua.LState) protocol.Resp2Value {
+	n := L.GetTop()
+	if n == 0 {
+		return errorValue(fmt.Errorf("Please specify at least one argument for this redis lib call"))
+	}
+	array := make([]protocol.Resp2Value, 0, n)
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			array = append(array, protocol.Resp2BulkString(v))
+		case lua.LNumber:
+			array = append(array, protocol.Resp2BulkString(formatLuaNumber(v)))
+		default:
+			return errorValue(fmt.Errorf("Lua redis lib command arguments must be strings or integers"))
+		}
+	}
+	// Commands are case insensitive for scripts like for redis clients
+	array[0] = protocol.Resp2BulkString(strings.ToUpper(string(array[0].(protocol.Resp2BulkString))))
+
+	op, err := protocol.ParseOp(array)
+	if err != nil {
+		return errorValue(err)
+	}
+	switch op.Kind {
+	case protocol.MULTI, protocol.EXEC, protocol.DISCARD, protocol.WATCH, protocol.UNWATCH,
+		protocol.EVAL, protocol.EVALSHA, protocol.SCRIPT, protocol.CONFIG:
+		return errorValue(fmt.Errorf("This Redis command is not allowed from script"))
+	}
+	return s.execute(op)
+}
+
+func formatLuaNumber(n lua.LNumber) string {
+	f := float64(n)
+	if f == math.Trunc(f) && math.Abs(f) < 1e17 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', 17, 64)
+}
+
+func errorTable(L *lua.LState, message string) *lua.LTable {
+	table := L.CreateTable(0, 1)
+	table.RawSetString("err", lua.LString(message))
+	return table
+}
+
+// respToLua converts command reply to lua value the same way redis does
+func respToLua(L *lua.LState, value protocol.Resp2Value) lua.LValue {
+	switch v := value.(type) {
+	case protocol.Resp2Integer:
+		return lua.LNumber(v)
+	case protocol.Resp2BulkString:
+		return lua.LString(v)
+	case protocol.Resp2SimpleString:
+		status := L.CreateTable(0, 1)
+		status.RawSetString("ok", lua.LString(v))
+		return status
+	case protocol.Resp2Error:
+		return errorTable(L, string(v))
+	case []protocol.Resp2Value:
+		if v == nil {
+			return lua.LFalse
+		}
+		table := L.CreateTable(len(v), 0)
+		for _, elem := range v {
+			table.Append(respToLua(L, elem))
+		}
+		return table
+	case protocol.Resp2Array:
+		return respToLua(L, []protocol.Resp2Value(v))
+	case nil:
+		return lua.LFalse
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
+// luaToResp converts value returned by script to reply the same way redis does,
+// numbers are truncated to integers and arrays stop at the first nil.
+func luaToResp(value lua.LValue) protocol.Resp2Value {
+	switch v := value.(type) {
+	case lua.LString:
+		return protocol.Resp2BulkString(v)
+	case lua.LNumber:
+		return protocol.Resp2Integer(int64(v))
+	case lua.LBool:
+		if v {
+			return protocol.Resp2Integer(1)
+		}
+		return nil
+	case *lua.LTable:
+		if e, ok := v.RawGetString("err").(lua.LString); ok {
+			return protocol.Resp2Error(e)
+		}
+		if status, ok := v.RawGetString("ok").(lua.LString); ok {
+			return protocol.Resp2SimpleString(status)
+		}
+		array := make([]protocol.Resp2Value, 0, v.Len())
+		for i := 1; ; i++ {
+			elem := v.RawGetInt(i)
+			if elem == lua.LNil {
+				break
+			}
+			array = append(array, luaToResp(elem))
+		}
+		return array
+	default:
+		return nil
+	}
+}
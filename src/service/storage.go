@@ -4,6 +4,8 @@ import (
 	"main/src/config"
 	"main/src/protocol"
 	"main/src/storage"
+	"os"
+	"slices"
 	"sync"
 	"time"
 )
@@ -15,20 +17,86 @@ import (
 type StorageService struct {
 	wal          storage.Wal[protocol.Resp2Value]
 	snapshotter  storage.Snapshoter[protocol.Resp2Value]
-	storage      storage.Storage[protocol.Resp2Value]
+	storage      storage.Storage[protocol.Resp2Value] // live view of data, hides expired keys
+	data         storage.Storage[protocol.Resp2Value]
 	cfg          *config.Config
 	logger       *config.Logger
 	mu           sync.RWMutex
+	snapMu       sync.Mutex // serializes snapshots, they read and replace the same file
+	walMu        sync.Mutex // serializes appends of writers holding only their keys locked
 	lastSnapTime int64
+
+	// Locks of key stripes, nil when storage is not safe for concurrent use, see lockKeys
+	keyLocks []sync.RWMutex
+
+	// Readers blocked in XREAD/XREADGROUP woken up by XADD to the key,
+	// and LOCK waiters woken up when the lock is deleted
+	waiters *keyWaiters
+
+	// Versions of keys watched by transactions
+	versions map[string]*keyVersion
+	// Entries committed by a transaction, nil outside of transactions
+	txEntries  *[]storage.WalEntry[protocol.Resp2Value]
+	txRollback *storage.Rollback[protocol.Resp2Value] // restores keys modified by the transaction
+
+	notifier *keyspaceNotifier
+	// Committed entries for watchers, also numbers the entries
+	feed *changeFeed
+
+	memory *memoryLimit
+	// Set while evicted keys are committed, so their deletion is notified as eviction
+	evicting bool
+
+	stopExpirer chan struct{}
+}
+
+// newSnapshotter returns snapshotter loading the configured engine,
+// LSM storage keeps data on disk by itself, so it is its own snapshotter.
+// Tiered storage keeps its value log in the storage directory.
+func newSnapshotter(config *config.Config) (storage.Snapshoter[protocol.Resp2Value], error) {
+	if config.Storage.Engine == storage.EngineLSM {
+		lsm, err := storage.OpenLSMStorage[protocol.Resp2Value](config.Storage.Dir, storage.DefaultLSMOptions())
+		if err != nil {
+			return nil, err
+		}
+		return lsm, nil
+	}
+	if config.Storage.Engine == storage.EngineTiered {
+		if err := os.MkdirAll(config.Storage.Dir, 0755); err != nil {
+			return nil, err
+		}
+		opts := storage.DefaultTieredOptions()
+		if config.Storage.HotSize > 0 {
+			opts.HotSize = config.Storage.HotSize
+		}
+		return storage.NewSimpleSnapshotter[protocol.Resp2Value](config.Snapshot.Path).WithStorage(func() storage.Storage[protocol.Resp2Value] {
+			return storage.MakeTieredStorage[protocol.Resp2Value](config.Storage.Dir, opts)
+		}), nil
+	}
+	newStorage, err := storage.NewEngine[protocol.Resp2Value](config.Storage.Engine)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewSimpleSnapshotter[protocol.Resp2Value](config.Snapshot.Path).WithStorage(newStorage), nil
 }
 
 func NewStorageService(config *config.Config, logger *config.Logger) *StorageService {
-	snapshotter := storage.NewSimpleSnapshotter[protocol.Resp2Value](config.Snapshot.Path)
 	wal, err := storage.NewSimpleWal[protocol.Resp2Value](config.WAL.Path)
 	if err != nil {
 		logger.Error("Failed to create WAL: %v", err)
 		panic(err)
 	}
+	return NewStorageServiceWithWal(config, logger, wal)
+}
+
+// NewStorageServiceWithWal restores the service from the snapshot and the given log,
+// which is used for all following writes
+func NewStorageServiceWithWal(config *config.Config, logger *config.Logger, wal storage.Wal[protocol.Resp2Value]) *StorageService {
+	snapshotter, err := newSnapshotter(config)
+	if err != nil {
+		logger.Error("Failed to open storage engine: %v", err)
+		panic(err)
+	}
 	storageInstance, err := snapshotter.LoadSnapshot()
 	if err != nil {
 		logger.Error("Failed to load snapshot: %v", err)
@@ -42,22 +110,68 @@ func NewStorageService(config *config.Config, logger *config.Logger) *StorageSer
 
 	// TODO might require some change after consensus implementation
 	for _, entry := range entries {
-		storageInstance.Set(entry.Key, entry.Value)
+		if err := storage.Apply(storageInstance, entry); err != nil {
+			logger.Error("Failed to apply WAL entry: %v", err)
+			panic(err)
+		}
 	}
 
-	return &StorageService{
+	var keyLocks []sync.RWMutex
+	if concurrent, ok := storageInstance.(storage.Concurrent); ok {
+		keyLocks = make([]sync.RWMutex, concurrent.Shards())
+	}
+
+	notifier := &keyspaceNotifier{}
+	if events, err := parseKeyspaceEvents(config.Redis.NotifyKeyspaceEvents); err != nil {
+		logger.Error("Invalid notify_keyspace_events, notifications are disabled: %v", err)
+	} else {
+		notifier.events.Store(uint32(events))
+	}
+
+	svc := &StorageService{
 		wal:          wal,
 		snapshotter:  snapshotter,
-		storage:      storageInstance,
+		storage:      newLiveStorage(storageInstance),
+		data:         storageInstance,
 		cfg:          config,
 		logger:       logger,
 		lastSnapTime: time.Now().Unix(),
 		mu:           sync.RWMutex{},
+		keyLocks:     keyLocks,
+		waiters:      &keyWaiters{byKey: make(map[string][]chan struct{})},
+		versions:     make(map[string]*keyVersion),
+		notifier:     notifier,
+		memory:       &memoryLimit{},
+		feed:         newChangeFeed(config.WAL.WatchHistory, storage.AppliedIndex(storageInstance), entries),
+	}
+	if err := svc.SetMaxMemory(config.Storage.MaxMemory); err != nil {
+		logger.Error("Invalid maxmemory, memory is not limited: %v", err)
+	}
+	if err := svc.SetMaxMemoryPolicy(config.Storage.MaxMemoryPolicy); err != nil {
+		logger.Error("Invalid maxmemory_policy, keys are not evicted: %v", err)
 	}
+	if err := svc.SetMaxMemorySamples(config.Storage.MaxMemorySamples); err != nil {
+		logger.Error("Invalid maxmemory_samples, using 5: %v", err)
+		svc.memory.samples.Store(5)
+	}
+	if _, ok := storageInstance.(storage.Evictable); !ok && config.Storage.MaxMemory > 0 {
+		logger.Warn("Storage engine %q does not account memory, maxmemory is ignored", config.Storage.Engine)
+	}
+	return svc
 }
 
 func (s *StorageService) Snapshot() error {
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+
+	// Writers append to the WAL holding the lock, so it is rotated holding it too,
+	// storage persisting data by itself checkpoints entries of the rotated log at the same time
+	s.mu.Lock()
 	rotatedWal, err := s.wal.Rotate()
+	if err == nil {
+		err = storage.Checkpoint(s.data)
+	}
+	s.mu.Unlock()
 	if err != nil {
 		return err
 	}
@@ -78,40 +192,195 @@ func (s *StorageService) SnapshotIfNeeded() error {
 	return nil
 }
 
-func (s *StorageService) Set(key string, value protocol.Resp2Value) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	err := s.wal.Append(storage.WalEntry[protocol.Resp2Value]{
-		Key:   key,
-		Value: value,
-	}, true)
-	if err != nil {
-		return err
+// lockKeys locks the service for a command on the keys and returns function releasing the locks.
+// Storage which is not safe for concurrent use is locked as a whole. Concurrent storage
+// is shared under the read lock and only stripes of the keys are locked, so commands
+// on different keys run in parallel, while holders of the write lock (transactions,
+// leases, expiration cycle, snapshots) still exclude all of them.
+// Writers evict keys first when memory is over the limit.
+// Inside a transaction the write lock is already held and nothing is locked.
+func (s *StorageService) lockKeys(write bool, keys ...string) func() {
+	if s.txEntries != nil {
+		return func() {}
+	}
+	if s.keyLocks == nil {
+		if write {
+			s.mu.Lock()
+			s.reclaim()
+			return s.mu.Unlock
+		}
+		s.mu.RLock()
+		return s.mu.RUnlock
+	}
+	if write && s.overLimit() {
+		s.mu.Lock()
+		s.reclaim()
+		s.mu.Unlock()
+	}
+
+	// Stripes are locked in order, so commands on overlapping keys do not deadlock
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, int(storage.KeyHash(key)%uint64(len(s.keyLocks))))
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+
+	s.mu.RLock()
+	for _, i := range stripes {
+		if write {
+			s.keyLocks[i].Lock()
+		} else {
+			s.keyLocks[i].RLock()
+		}
+	}
+	return func() {
+		for _, i := range slices.Backward(stripes) {
+			if write {
+				s.keyLocks[i].Unlock()
+			} else {
+				s.keyLocks[i].RUnlock()
+			}
+		}
+		s.mu.RUnlock()
 	}
-	return s.storage.Set(key, value)
 }
 
-func (s *StorageService) Get(key string) (protocol.Resp2Value, error) {
+// lockRevision locks the whole keyspace for reading at a single revision.
+// Writers of concurrent storage log entries before applying them,
+// so they all have to be excluded for the last logged entry to be applied.
+func (s *StorageService) lockRevision() func() {
+	if s.txEntries != nil {
+		return func() {}
+	}
+	if s.keyLocks != nil {
+		s.mu.Lock()
+		return s.mu.Unlock
+	}
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.storage.Get(key)
+	return s.mu.RUnlock
 }
 
-func (s *StorageService) Delete(key string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	err := s.wal.Append(storage.WalEntry[protocol.Resp2Value]{
-		Key:   key,
-		Value: nil,
-	}, true)
+// commit appends entries to the WAL as a single record and applies them to the storage.
+// Keys which already expired are deleted first, so entries never act on stale values.
+// Keyspace notifications are sent once the entries are applied.
+// Entries which may grow memory are rejected when it is over the limit and can not be reclaimed.
+// Caller must hold the write lock of the entry keys, see lockKeys.
+func (s *StorageService) commit(entries ...storage.WalEntry[protocol.Resp2Value]) error {
+	batch := make([]storage.WalEntry[protocol.Resp2Value], 0, len(entries))
+	checked := make(map[string]bool)
+	for _, e := range entries {
+		if e.OpType != protocol.BATCH && e.OpType != protocol.DELETE && !checked[e.Key] && s.isExpired(e.Key) {
+			batch = append(batch, storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: e.Key})
+		}
+		checked[e.Key] = true
+		batch = append(batch, e)
+	}
+	if slices.ContainsFunc(batch, growsMemory) && s.outOfMemory() {
+		return errOOM
+	}
+	type event struct {
+		class keyspaceEvents
+		name  string
+		key   string
+	}
+	var events []event
+	for _, e := range batch {
+		s.touch(e.Key)
+		// Source of a rename is modified too
+		if source, ok := renameSource(e); ok {
+			s.touch(source)
+			if s.notifier.enabled(notifyGeneric) {
+				events = append(events, event{notifyGeneric, "rename_from", source})
+			}
+		}
+		if class, name := s.entryEvent(e); name != "" && s.notifier.enabled(class) {
+			events = append(events, event{class, name, e.Key})
+		}
+	}
+	notify := func() {
+		for _, e := range events {
+			s.notifier.notify(e.class, e.name, e.key)
+		}
+	}
+	// Writes count as accesses of eviction policies
+	access := func() {
+		if live := s.storage.(*liveStorage); live.evictable != nil {
+			now := nowMs()
+			for _, e := range batch {
+				live.evictable.Touch(e.Key, now)
+			}
+		}
+	}
+
+	record := storage.NewBatch[protocol.Resp2Value]()
+	for _, e := range batch {
+		record.Add(e)
+	}
+	entry, err := record.Entry()
 	if err != nil {
 		return err
 	}
-	return s.storage.Delete(key)
+
+	if s.txEntries != nil {
+		// Following commands of the transaction have to see the changes,
+		// entries are logged together once the transaction finishes and restored
+		// when that fails. The write lock is held until then, so the index
+		// of the record is already known.
+		entry.Index = s.feed.lastIndex() + 1
+		if err := s.txRollback.Apply(s.data, entry); err != nil {
+			return err
+		}
+		*s.txEntries = append(*s.txEntries, batch...)
+		access()
+		s.wakeWaiters(batch)
+		notify()
+		return nil
+	}
+
+	if _, err := s.applyAndLog(entry); err != nil {
+		return err
+	}
+	access()
+	s.wakeWaiters(batch)
+	notify()
+	return nil
+}
+
+// wakeWaiters wakes up clients waiting for deleted keys, e.g. for released locks
+func (s *StorageService) wakeWaiters(entries []storage.WalEntry[protocol.Resp2Value]) {
+	for _, e := range entries {
+		if e.OpType == protocol.DELETE {
+			s.notifyWaiters(e.Key)
+		}
+		if source, ok := renameSource(e); ok {
+			s.notifyWaiters(source)
+		}
+	}
+}
+
+// Set stores value and removes expiration of the key like redis SET without options
+func (s *StorageService) Set(key string, value protocol.Resp2Value) error {
+	_, _, err := s.SetWithOptions(protocol.OpPayloadSet{Key: key, Value: value})
+	return err
+}
+
+// Get returns plain value of the key and false when the key does not exist,
+// a stored nil value is found too. Composite values are reported as ErrWrongType
+func (s *StorageService) Get(key string) (protocol.Resp2Value, bool, error) {
+	defer s.lockKeys(false, key)()
+	return s.getPlain(key)
+}
+
+func (s *StorageService) Delete(key string) error {
+	defer s.lockKeys(true, key)()
+	return s.commit(storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.DELETE,
+		Key:    key,
+	})
 }
 
 func (s *StorageService) Exists(key string) (bool, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	defer s.lockKeys(false, key)()
 	return s.storage.Exists(key)
 }
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"main/src/protocol"
+	"main/src/storage"
+	"math"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Memory limit of StorageService.
+// Once memory estimated by the storage exceeds maxmemory, the next writer evicts keys
+// before it takes its own locks. Keys are picked from a random sample like redis does,
+// so policies are approximate. Evicted keys are deleted by DELETE entries written
+// to the WAL, so replicas replaying the log drop exactly the same keys.
+// Writes which may grow memory fail with OOM error when nothing can be evicted.
+
+var errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+type evictionPolicy uint32
+
+const (
+	policyNoEviction evictionPolicy = iota
+	policyAllKeysLRU
+	policyAllKeysLFU
+	policyVolatileLRU
+	policyVolatileTTL
+)
+
+var evictionPolicies = []string{"noeviction", "allkeys-lru", "allkeys-lfu", "volatile-lru", "volatile-ttl"}
+
+func parseEvictionPolicy(name string) (evictionPolicy, error) {
+	if i := slices.Index(evictionPolicies, strings.ToLower(name)); i >= 0 {
+		return evictionPolicy(i), nil
+	}
+	return 0, fmt.Errorf("unknown maxmemory policy %q", name)
+}
+
+func (p evictionPolicy) String() string {
+	return evictionPolicies[p]
+}
+
+// volatile reports whether only keys with expiration are evicted
+func (p evictionPolicy) volatile() bool {
+	return p == policyVolatileLRU || p == policyVolatileTTL
+}
+
+// score ranks sampled key, the key with the highest score is evicted
+func (p evictionPolicy) score(key storage.KeyStats, now int64) int64 {
+	switch p {
+	case policyAllKeysLFU:
+		return math.MaxUint8 - int64(key.DecayedFrequency(now))
+	case policyVolatileTTL:
+		return math.MaxInt64 - key.ExpireAt
+	default:
+		// Idle time, keys never accessed since they were loaded come first
+		return now - key.Access
+	}
+}
+
+// memoryLimit holds settings which can be changed at runtime by CONFIG SET
+type memoryLimit struct {
+	max     atomic.Int64 // bytes, 0 means no limit
+	policy  atomic.Uint32
+	samples atomic.Int32
+}
+
+// growsMemory reports whether the entry may need more memory, like commands
+// flagged denyoom in redis. Deletions and trimming are allowed over the limit.
+func growsMemory(e storage.WalEntry[protocol.Resp2Value]) bool {
+	switch e.OpType {
+	case protocol.SET, protocol.SADD, protocol.SINTERSTORE, protocol.SUNIONSTORE, protocol.SDIFFSTORE,
+		protocol.ZADD, protocol.XADD, protocol.LOCK, protocol.COPY:
+		return true
+	default:
+		return false
+	}
+}
+
+// UsedMemory returns estimated number of bytes held by keys, 0 when storage does not account memory
+func (s *StorageService) UsedMemory() int64 {
+	if live := s.storage.(*liveStorage); live.evictable != nil {
+		return live.evictable.UsedMemory()
+	}
+	return 0
+}
+
+func (s *StorageService) MaxMemory() int64 {
+	return s.memory.max.Load()
+}
+
+// SetMaxMemory changes the limit, 0 removes it. Lowering the limit evicts keys on the next write.
+func (s *StorageService) SetMaxMemory(bytes int64) error {
+	if bytes < 0 {
+		return fmt.Errorf("maxmemory must not be negative")
+	}
+	s.memory.max.Store(bytes)
+	return nil
+}
+
+func (s *StorageService) MaxMemoryPolicy() string {
+	return evictionPolicy(s.memory.policy.Load()).String()
+}
+
+func (s *StorageService) SetMaxMemoryPolicy(name string) error {
+	policy, err := parseEvictionPolicy(name)
+	if err != nil {
+		return err
+	}
+	s.memory.policy.Store(uint32(policy))
+	return nil
+}
+
+func (s *StorageService) MaxMemorySamples() int {
+	return int(s.memory.samples.Load())
+}
+
+func (s *StorageService) SetMaxMemorySamples(samples int) error {
+	if samples < 1 || samples > 64 {
+		return fmt.Errorf("maxmemory samples must be between 1 and 64")
+	}
+	s.memory.samples.Store(int32(samples))
+	return nil
+}
+
+// overLimit reports whether memory used by keys exceeds maxmemory
+func (s *StorageService) overLimit() bool {
+	max := s.memory.max.Load()
+	return max > 0 && s.UsedMemory() > max
+}
+
+// outOfMemory reports whether memory is over the limit and no key can be evicted.
+// Caller must hold the lock.
+func (s *StorageService) outOfMemory() bool {
+	if !s.overLimit() {
+		return false
+	}
+	policy := evictionPolicy(s.memory.policy.Load())
+	if policy == policyNoEviction {
+		return true
+	}
+	return len(s.storage.(*liveStorage).evictable.SampleKeys(1, policy.volatile())) == 0
+}
+
+// reclaim evicts keys while memory is over the limit. Failures are only logged,
+// writes are rejected by commit when memory can not be reclaimed.
+// Caller must hold the write lock.
+func (s *StorageService) reclaim() {
+	if !s.overLimit() {
+		return
+	}
+	if _, err := s.evict(); err != nil {
+		s.logger.Error("Failed to evict keys: %v", err)
+	}
+}
+
+// evict deletes keys picked by the policy until memory gets under the limit,
+// returns number of evicted keys.
+// Caller must hold the write lock.
+func (s *StorageService) evict() (int, error) {
+	live := s.storage.(*liveStorage)
+	policy := evictionPolicy(s.memory.policy.Load())
+	if live.evictable == nil || policy == policyNoEviction {
+		return 0, nil
+	}
+
+	evicted := 0
+	for s.overLimit() {
+		now := nowMs()
+		sample := live.evictable.SampleKeys(int(s.memory.samples.Load()), policy.volatile())
+		if len(sample) == 0 {
+			return evicted, nil
+		}
+		victim := sample[0]
+		for _, key := range sample[1:] {
+			if policy.score(key, now) > policy.score(victim, now) {
+				victim = key
+			}
+		}
+
+		s.evicting = true
+		err := s.commit(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: victim.Key})
+		s.evicting = false
+		if err != nil {
+			return evicted, err
+		}
+		evicted++
+	}
+	return evicted, nil
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"fmt"
+	"main/src/protocol"
+	"main/src/storage"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Key expiration of StorageService.
+// Expired keys are hidden from reads right away, but they are removed only by
+// DELETE entries written to the WAL (on write access or by the active expirer),
+// so replay and replication never depend on the clock of the node applying the log.
+
+// liveStorage hides keys whose deadline or whose lease deadline passed but which were not deleted yet
+type liveStorage struct {
+	storage.Storage[protocol.Resp2Value]
+	expirable storage.Expirable // nil when storage does not support expiration
+	leased    storage.Leased    // nil when storage does not support leases
+	evictable storage.Evictable // nil when storage does not account memory
+}
+
+func newLiveStorage(store storage.Storage[protocol.Resp2Value]) *liveStorage {
+	expirable, _ := store.(storage.Expirable)
+	leased, _ := store.(storage.Leased)
+	evictable, _ := store.(storage.Evictable)
+	return &liveStorage{Storage: store, expirable: expirable, leased: leased, evictable: evictable}
+}
+
+func (l *liveStorage) expired(key string, now int64) bool {
+	if l.expirable != nil {
+		if atMs, ok := l.expirable.GetExpire(key); ok && atMs <= now {
+			return true
+		}
+	}
+	if l.leased != nil {
+		if id, ok := l.leased.KeyLease(key); ok {
+			lease := l.leased.GetLease(id)
+			return lease != nil && lease.ExpireAt <= now
+		}
+	}
+	return false
+}
+
+func (l *liveStorage) Get(key string) (protocol.Resp2Value, bool, error) {
+	now := nowMs()
+	if l.expired(key, now) {
+		return nil, false, nil
+	}
+	if l.evictable != nil {
+		l.evictable.Touch(key, now)
+	}
+	return l.Storage.Get(key)
+}
+
+func (l *liveStorage) Exists(key string) (bool, error) {
+	if l.expired(key, nowMs()) {
+		return false, nil
+	}
+	return l.Storage.Exists(key)
+}
+
+func (l *liveStorage) Iterator() func(func(string, protocol.Resp2Value) bool) {
+	return func(yield func(string, protocol.Resp2Value) bool) {
+		now := nowMs()
+		l.Storage.Iterator()(func(key string, value protocol.Resp2Value) bool {
+			if l.expired(key, now) {
+				return true
+			}
+			return yield(key, value)
+		})
+	}
+}
+
+// isExpired reports whether key is still stored although its deadline passed.
+// Caller must hold the lock.
+func (s *StorageService) isExpired(key string) bool {
+	live := s.storage.(*liveStorage)
+	if !live.expired(key, nowMs()) {
+		return false
+	}
+	exists, _ := s.data.Exists(key)
+	return exists
+}
+
+// deadline returns expiration of a live key in unix milliseconds.
+// Caller must hold the lock.
+func (s *StorageService) deadline(key string) (int64, bool) {
+	live := s.storage.(*liveStorage)
+	if live.expirable == nil {
+		return 0, false
+	}
+	if exists, _ := s.storage.Exists(key); !exists {
+		return 0, false
+	}
+	return live.expirable.GetExpire(key)
+}
+
+func expireEntry(key string, atMs int64) storage.WalEntry[protocol.Resp2Value] {
+	return storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.EXPIRE,
+		Key:    key,
+		Value:  protocol.Resp2BulkString(strconv.FormatInt(atMs, 10)),
+	}
+}
+
+// Expire sets expiration of the key, returns false if the key does not exist
+// or flags prevented the update. Deadline in the past deletes the key.
+func (s *StorageService) Expire(payload protocol.OpPayloadExpire) (bool, error) {
+	defer s.lockKeys(true, payload.Key)()
+
+	exists, err := s.storage.Exists(payload.Key)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	now := nowMs()
+	atMs := payload.Ms
+	if !payload.Absolute {
+		if atMs > math.MaxInt64-now {
+			return false, fmt.Errorf("invalid expire time in 'pexpire' command")
+		}
+		atMs += now
+	}
+
+	current, hasDeadline := s.deadline(payload.Key)
+	flags := payload.Flags
+	switch {
+	case flags.NX && hasDeadline,
+		flags.XX && !hasDeadline,
+		// Key without expiration behaves like infinite TTL
+		flags.GT && (!hasDeadline || atMs <= current),
+		flags.LT && hasDeadline && atMs >= current:
+		return false, nil
+	}
+
+	if atMs <= now {
+		err = s.commit(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: payload.Key})
+	} else {
+		err = s.commit(expireEntry(payload.Key, atMs))
+	}
+	return err == nil, err
+}
+
+// TTL returns remaining time to live or deadline of the key like redis TTL commands do,
+// -2 if the key does not exist and -1 if it has no expiration.
+func (s *StorageService) TTL(payload protocol.OpPayloadTTL) (int64, error) {
+	defer s.lockKeys(false, payload.Key)()
+
+	exists, err := s.storage.Exists(payload.Key)
+	if err != nil || !exists {
+		return -2, err
+	}
+	atMs, ok := s.deadline(payload.Key)
+	if !ok {
+		return -1, nil
+	}
+
+	value := atMs
+	if !payload.Absolute {
+		value = max(atMs-nowMs(), 0)
+	}
+	if !payload.Ms {
+		// Remaining time is rounded like redis does
+		if payload.Absolute {
+			value /= 1000
+		} else {
+			value = (value + 500) / 1000
+		}
+	}
+	return value, nil
+}
+
+// Persist removes expiration of the key, returns false if it had none.
+func (s *StorageService) Persist(key string) (bool, error) {
+	defer s.lockKeys(true, key)()
+
+	if _, ok := s.deadline(key); !ok {
+		return false, nil
+	}
+	err := s.commit(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.PERSIST, Key: key})
+	return err == nil, err
+}
+
+// ExpireCycle samples keys with expiration and deletes the expired ones,
+// it repeats while a significant part of the sample was expired.
+// Returns number of deleted keys.
+func (s *StorageService) ExpireCycle(sample int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live := s.storage.(*liveStorage)
+	if live.expirable == nil || sample <= 0 {
+		return 0, nil
+	}
+
+	deleted := 0
+	for {
+		now := nowMs()
+		keys := live.expirable.SampleExpires(sample)
+		entries := make([]storage.WalEntry[protocol.Resp2Value], 0, len(keys))
+		for _, key := range keys {
+			if live.expired(key, now) {
+				entries = append(entries, storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: key})
+			}
+		}
+		if len(entries) > 0 {
+			if err := s.commit(entries...); err != nil {
+				return deleted, err
+			}
+			deleted += len(entries)
+		}
+		// Same stop condition as redis, less than 25% of the sample expired
+		if len(keys) < sample || len(entries)*4 <= len(keys) {
+			return deleted, nil
+		}
+	}
+}
+
+// StartExpirer runs ExpireCycle and ExpireLeases periodically until StopExpirer is called
+func (s *StorageService) StartExpirer() {
+	interval := time.Duration(s.cfg.Expire.Interval) * time.Millisecond
+	if interval <= 0 || s.stopExpirer != nil {
+		return
+	}
+	stop := make(chan struct{})
+	s.stopExpirer = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.ExpireCycle(s.cfg.Expire.SampleSize); err != nil {
+					s.logger.Error("Failed to expire keys: %v", err)
+				}
+				if _, err := s.ExpireLeases(); err != nil {
+					s.logger.Error("Failed to expire leases: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *StorageService) StopExpirer() {
+	if s.stopExpirer != nil {
+		close(s.stopExpirer)
+		s.stopExpirer = nil
+	}
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"errors"
+	"main/src/protocol"
+	"main/src/storage"
+	"slices"
+)
+
+var (
+	errNoSuchKey  = errors.New("no such key")
+	errSameObject = errors.New("source and destination objects are the same")
+)
+
+// Multi-key commands of StorageService.
+// Each command runs under a single lock and its modifications are committed
+// together, so they are written to the WAL as one record.
+
+// Del deletes the keys and returns how many of them existed
+func (s *StorageService) Del(keys []string) (int, error) {
+	defer s.lockKeys(true, keys...)()
+
+	seen := make(map[string]bool, len(keys))
+	var entries []storage.WalEntry[protocol.Resp2Value]
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		exists, err := s.storage.Exists(key)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			entries = append(entries, storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: key})
+		}
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	if err := s.commit(entries...); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// CountExisting returns number of existing keys, a key repeated in keys is counted each time
+func (s *StorageService) CountExisting(keys []string) (int, error) {
+	defer s.lockKeys(false, keys...)()
+
+	n := 0
+	for _, key := range keys {
+		exists, err := s.storage.Exists(key)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// MGet returns values of the keys, missing keys and composite values are returned as nil
+func (s *StorageService) MGet(keys []string) ([]protocol.Resp2Value, error) {
+	defer s.lockKeys(false, keys...)()
+
+	values := make([]protocol.Resp2Value, 0, len(keys))
+	for _, key := range keys {
+		value, _, err := s.getPlain(key)
+		if err == storage.ErrWrongType {
+			value, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// MSet stores all values and removes their expiration like SET does.
+// With payload.NX nothing is stored if any of the keys exists and false is returned.
+func (s *StorageService) MSet(payload protocol.OpPayloadMSet) (bool, error) {
+	defer s.lockKeys(true, payload.Keys...)()
+
+	if payload.NX {
+		for _, key := range payload.Keys {
+			exists, err := s.storage.Exists(key)
+			if err != nil {
+				return false, err
+			}
+			if exists {
+				return false, nil
+			}
+		}
+	}
+
+	entries := make([]storage.WalEntry[protocol.Resp2Value], 0, len(payload.Keys))
+	persisted := make(map[string]bool)
+	for i, key := range payload.Keys {
+		entries = append(entries, setEntry(key, payload.Values[i]))
+		if _, ok := s.deadline(key); ok && !persisted[key] {
+			persisted[key] = true
+			entries = append(entries, storage.WalEntry[protocol.Resp2Value]{OpType: protocol.PERSIST, Key: key})
+		}
+	}
+	if err := s.commit(entries...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Scan returns keys starting from the cursor and cursor of the next call, 0 once the iteration completes.
+// Keys are filtered by the glob pattern and type after they are read, like redis does,
+// so a call can return less than count keys even before the iteration completes.
+func (s *StorageService) Scan(payload protocol.OpPayloadScan) ([]string, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := int(payload.Count)
+	if count <= 0 {
+		count = 10
+	}
+	scanned, next := storage.Scan(s.storage, payload.Cursor, count)
+	keys := make([]string, 0, len(scanned))
+	for _, key := range scanned {
+		if payload.Pattern != "" && !globMatch(payload.Pattern, key) {
+			continue
+		}
+		if payload.Type != "" {
+			value, found, err := s.storage.Get(key)
+			if err != nil {
+				return nil, 0, err
+			}
+			if !found || storage.KindOf(value) != payload.Type {
+				continue
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, next, nil
+}
+
+// Keys returns all keys matching the glob pattern in order
+func (s *StorageService) Keys(pattern string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []string{}
+	s.storage.Iterator()(func(key string, _ protocol.Resp2Value) bool {
+		if globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	slices.Sort(keys)
+	return keys
+}
+
+// Type returns type of the value like redis TYPE, none for missing keys
+func (s *StorageService) Type(key string) (string, error) {
+	defer s.lockKeys(false, key)()
+
+	value, found, err := s.storage.Get(key)
+	if err != nil || !found {
+		return "none", err
+	}
+	return storage.KindOf(value), nil
+}
+
+// Rename moves value with its expiration to newKey, replacing it.
+// Keys attached to a lease are detached from it.
+func (s *StorageService) Rename(key, newKey string) error {
+	defer s.lockKeys(true, key, newKey)()
+
+	exists, err := s.storage.Exists(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errNoSuchKey
+	}
+	if key == newKey {
+		return nil
+	}
+	return s.commit(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.RENAME, Key: newKey, Value: protocol.Resp2BulkString(key)})
+}
+
+// Copy stores a copy of the value with its expiration under destination,
+// returns false when source does not exist or destination exists and replace is not set
+func (s *StorageService) Copy(payload protocol.OpPayloadCopy) (bool, error) {
+	defer s.lockKeys(true, payload.Source, payload.Destination)()
+
+	if payload.Source == payload.Destination {
+		return false, errSameObject
+	}
+	exists, err := s.storage.Exists(payload.Source)
+	if err != nil || !exists {
+		return false, err
+	}
+	if !payload.Replace {
+		if exists, err := s.storage.Exists(payload.Destination); err != nil || exists {
+			return false, err
+		}
+	}
+	err = s.commit(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.COPY, Key: payload.Destination, Value: protocol.Resp2BulkString(payload.Source)})
+	return err == nil, err
+}
+
+// RandomKey returns a random key, false when there are no keys
+func (s *StorageService) RandomKey() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Map iteration starts at a random position
+	var key string
+	var found bool
+	s.storage.Iterator()(func(k string, _ protocol.Resp2Value) bool {
+		key, found = k, true
+		return false
+	})
+	return key, found
+}
+
+// DBSize returns number of keys
+func (s *StorageService) DBSize() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	s.storage.Iterator()(func(string, protocol.Resp2Value) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// renameSource returns key moved away by a RENAME entry
+func renameSource(e storage.WalEntry[protocol.Resp2Value]) (string, bool) {
+	if e.OpType != protocol.RENAME {
+		return "", false
+	}
+	source, ok := e.Value.(protocol.Resp2BulkString)
+	return string(source), ok
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"main/src/protocol"
+	"main/src/storage"
+	"sort"
+)
+
+// Key-value access of StorageService for typed APIs.
+// Only plain string values are visible, revision of the store is index
+// of the last committed WAL entry, so every write gets a new revision.
+
+// RangeAll as end of a range selects all keys starting from its start
+const RangeAll = "\x00"
+
+// KeyValue is a key with its string value and modification revision
+type KeyValue struct {
+	Key      string
+	Value    string
+	Revision uint64
+}
+
+// Entry is a key with its value of any type
+type Entry struct {
+	Key      string
+	Kind     string              // "string" for plain values, otherwise kind of the composite value
+	Value    protocol.Resp2Value // composite values are encoded the same way as in snapshots
+	ExpireAt int64               // unix milliseconds, 0 when the key does not expire
+	Revision uint64              // modification revision of the key
+}
+
+// Revision returns index of the last committed write
+func (s *StorageService) Revision() uint64 {
+	return s.feed.lastIndex()
+}
+
+// WaitRevision blocks until the write with the revision is committed or ctx is done
+func (s *StorageService) WaitRevision(ctx context.Context, revision uint64) error {
+	for {
+		reached, added := s.feed.reached(revision)
+		if reached {
+			return nil
+		}
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Range returns string values of keys in [start, end) and revision the read observed, see rangeKeys
+func (s *StorageService) Range(start, end string, limit int) ([]KeyValue, bool, uint64, error) {
+	defer s.lockRevision()()
+	kvs, more, err := s.rangeKeys(start, end, limit)
+	return kvs, more, s.feed.lastIndex(), err
+}
+
+// Lookup returns the key with its value of any type, nil when the key does not exist,
+// and revision the read observed
+func (s *StorageService) Lookup(key string) (*Entry, uint64, error) {
+	defer s.lockKeys(false, key)()
+
+	revision := s.feed.lastIndex()
+	value, found, err := s.storage.Get(key)
+	if err != nil || !found {
+		return nil, revision, err
+	}
+	entry := &Entry{Key: key, Kind: "string", Value: value, Revision: storage.Revision(s.data, key)}
+	// Composite values are mutable, so they are encoded while the lock is held
+	if enc, ok := value.(storage.Encoder); ok {
+		entry.Kind = enc.Kind()
+		entry.Value = enc.Encode()
+	}
+	if expireAt, ok := s.deadline(key); ok {
+		entry.ExpireAt = expireAt
+	}
+	return entry, revision, nil
+}
+
+// rangeKeys returns string values of keys in [start, end) in lexicographical order,
+// at most limit of them when limit is positive, and whether more keys matched.
+// Empty end selects only the start key, which is reported as ErrWrongType when it holds
+// a composite value, ranges skip composite values.
+// Caller must hold the lock.
+func (s *StorageService) rangeKeys(start, end string, limit int) ([]KeyValue, bool, error) {
+	if end == "" {
+		value, ok, err := storage.GetString(s.storage, start)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		return []KeyValue{{Key: start, Value: value, Revision: storage.Revision(s.data, start)}}, false, nil
+	}
+
+	var kvs []KeyValue
+	collect := func(key string, value protocol.Resp2Value) {
+		if str, ok := storage.StringValue(value); ok {
+			kvs = append(kvs, KeyValue{Key: key, Value: str, Revision: storage.Revision(s.data, key)})
+		}
+	}
+	if ordered, ok := s.data.(storage.Ordered[protocol.Resp2Value]); ok {
+		// Keys come in order, so reading stops right after the limit
+		live := s.storage.(*liveStorage)
+		now := nowMs()
+		upper := end
+		if end == RangeAll {
+			upper = ""
+		}
+		ordered.Range(start, upper)(func(key string, value protocol.Resp2Value) bool {
+			if !live.expired(key, now) {
+				collect(key, value)
+			}
+			return limit <= 0 || len(kvs) <= limit
+		})
+	} else {
+		s.storage.Iterator()(func(key string, value protocol.Resp2Value) bool {
+			if key >= start && (end == RangeAll || key < end) {
+				collect(key, value)
+			}
+			return true
+		})
+		sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	}
+	if limit > 0 && len(kvs) > limit {
+		return kvs[:limit], true, nil
+	}
+	return kvs, false, nil
+}
+
+// Update runs fn holding the write lock, modifications made through tx are logged
+// as a single WAL record. Returns revision of the record, or the current revision
+// when fn modified nothing. Like in EXEC, modifications made before fn failed are kept.
+func (s *StorageService) Update(fn func(tx *StorageService) error) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reclaim()
+
+	var fnErr error
+	revision, err := s.transaction(func(tx *StorageService) {
+		fnErr = fn(tx)
+	})
+	if err == nil {
+		err = fnErr
+	}
+	if revision == 0 {
+		revision = s.feed.lastIndex()
+	}
+	return revision, err
+}
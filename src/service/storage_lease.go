@@ -0,0 +1,190 @@
+package service
+
+import (
+	"errors"
+	"main/src/protocol"
+	"main/src/storage"
+	"strconv"
+)
+
+// Leases of StorageService.
+// A lease is granted with time to live and keys attached to it are deleted together
+// with it, in a single WAL record, when it is revoked or expires. Like key expiration,
+// expiry is decided by the node running the expirer and logged as REVOKE,
+// expired leases and their keys are hidden from reads until then.
+
+var errLeaseNotFound = errors.New("lease not found")
+
+// LeaseInfo describes an alive lease
+type LeaseInfo struct {
+	ID        uint64
+	TTL       int64 // granted time to live in milliseconds
+	Remaining int64 // milliseconds until the lease expires
+	Keys      []string
+}
+
+func leaseEntry(args ...string) storage.WalEntry[protocol.Resp2Value] {
+	value := make([]protocol.Resp2Value, 0, len(args))
+	for _, arg := range args {
+		value = append(value, protocol.Resp2BulkString(arg))
+	}
+	return storage.WalEntry[protocol.Resp2Value]{OpType: protocol.LEASE, Value: value}
+}
+
+// aliveLease returns the lease when it exists and did not expire yet.
+// Caller must hold the lock.
+func (s *StorageService) aliveLease(id uint64) *storage.Lease {
+	live := s.storage.(*liveStorage)
+	if live.leased == nil {
+		return nil
+	}
+	lease := live.leased.GetLease(id)
+	if lease == nil || lease.ExpireAt <= nowMs() {
+		return nil
+	}
+	return lease
+}
+
+// GrantLease creates lease with time to live in milliseconds and returns its ID
+func (s *StorageService) GrantLease(ttl int64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	atMs := deadlineMs(ttl)
+	if err := s.commit(leaseEntry("GRANT", strconv.FormatInt(ttl, 10), strconv.FormatInt(atMs, 10))); err != nil {
+		return 0, err
+	}
+	// Lease gets index of the granting entry, which was applied last
+	return storage.AppliedIndex(s.data), nil
+}
+
+// KeepAliveLease renews the lease for its granted time to live and returns it,
+// 0 when the lease is not alive anymore
+func (s *StorageService) KeepAliveLease(id uint64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lease := s.aliveLease(id)
+	if lease == nil {
+		return 0, nil
+	}
+	atMs := deadlineMs(lease.TTL)
+	if err := s.commit(leaseEntry("KEEPALIVE", strconv.FormatUint(id, 10), strconv.FormatInt(atMs, 10))); err != nil {
+		return 0, err
+	}
+	return lease.TTL, nil
+}
+
+// AttachLease attaches existing keys to the lease and returns how many of them exist.
+// Keys attached to another lease are moved to this one.
+func (s *StorageService) AttachLease(id uint64, keys []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.aliveLease(id) == nil {
+		return 0, errLeaseNotFound
+	}
+	args := []string{"ATTACH", strconv.FormatUint(id, 10)}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		exists, err := s.storage.Exists(key)
+		if err != nil {
+			return 0, err
+		}
+		if exists && !seen[key] {
+			seen[key] = true
+			args = append(args, key)
+		}
+	}
+	if len(seen) == 0 {
+		return 0, nil
+	}
+	if err := s.commit(leaseEntry(args...)); err != nil {
+		return 0, err
+	}
+	return len(seen), nil
+}
+
+// RevokeLease deletes the lease with its keys, returns false when it was not alive
+func (s *StorageService) RevokeLease(id uint64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live := s.storage.(*liveStorage)
+	if live.leased == nil {
+		return false, nil
+	}
+	alive := s.aliveLease(id) != nil
+	// Expired lease which was not revoked yet is cleaned up too
+	if lease := live.leased.GetLease(id); lease != nil {
+		if err := s.revoke(lease); err != nil {
+			return false, err
+		}
+	}
+	return alive, nil
+}
+
+// revoke logs deletion of the attached keys and of the lease as a single record.
+// Caller must hold the write lock.
+func (s *StorageService) revoke(lease *storage.Lease) error {
+	keys := lease.SortedKeys()
+	entries := make([]storage.WalEntry[protocol.Resp2Value], 0, len(keys)+1)
+	for _, key := range keys {
+		entries = append(entries, storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: key})
+	}
+	entries = append(entries, leaseEntry("REVOKE", strconv.FormatUint(lease.ID, 10)))
+	return s.commit(entries...)
+}
+
+// LeaseTimeToLive describes the lease, nil when it is not alive
+func (s *StorageService) LeaseTimeToLive(id uint64) *LeaseInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lease := s.aliveLease(id)
+	if lease == nil {
+		return nil
+	}
+	return &LeaseInfo{ID: id, TTL: lease.TTL, Remaining: max(lease.ExpireAt-nowMs(), 0), Keys: lease.SortedKeys()}
+}
+
+// Leases returns IDs of alive leases in order
+func (s *StorageService) Leases() []uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	live := s.storage.(*liveStorage)
+	if live.leased == nil {
+		return nil
+	}
+	ids := []uint64{}
+	for _, lease := range live.leased.Leases() {
+		if s.aliveLease(lease.ID) != nil {
+			ids = append(ids, lease.ID)
+		}
+	}
+	return ids
+}
+
+// ExpireLeases revokes leases whose time to live passed, returns number of revoked leases
+func (s *StorageService) ExpireLeases() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live := s.storage.(*liveStorage)
+	if live.leased == nil {
+		return 0, nil
+	}
+	revoked := 0
+	now := nowMs()
+	for _, lease := range live.leased.Leases() {
+		if lease.ExpireAt > now {
+			continue
+		}
+		if err := s.revoke(lease); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"main/src/protocol"
+	"main/src/storage"
+	"time"
+)
+
+// Distributed locks of StorageService.
+// A lock is a key holding storage.Lock with expiration as its lease, so it is logged,
+// replicated and snapshotted like any other key. Fencing token of a lock is index
+// of the WAL entry which acquired it and stays the same while the lease is renewed.
+
+// Lock acquires lock name for owner with lease of ttl milliseconds and returns its fencing token.
+// Owner already holding the lock renews the lease and gets the same token.
+// Token 0 is returned when the lock is held by another owner and block milliseconds passed,
+// negative block does not wait and 0 waits until the lock is acquired or ctx is done.
+func (s *StorageService) Lock(ctx context.Context, name, owner string, ttl, block int64) (uint64, error) {
+	token, _, err := s.tryLock(name, owner, ttl)
+	// Commands inside a transaction never block, like in redis
+	if err != nil || token > 0 || block < 0 || s.txEntries != nil {
+		return token, err
+	}
+
+	var timeout <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(time.Duration(block) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	keys := []string{name}
+	for {
+		wake := s.addWaiter(keys)
+		// Lock might have been released before the waiter was registered
+		token, deadline, err := s.tryLock(name, owner, ttl)
+		if err != nil || token > 0 {
+			s.removeWaiter(keys, wake)
+			return token, err
+		}
+		// Expired lock is deleted by the expirer, which might be disabled, so waiter wakes up at the deadline too
+		var expired <-chan time.Time
+		var timer *time.Timer
+		if deadline > 0 {
+			timer = time.NewTimer(time.Until(time.UnixMilli(deadline)))
+			expired = timer.C
+		}
+		done := false
+		select {
+		case <-wake:
+		case <-expired:
+		case <-timeout:
+			done = true
+		case <-ctx.Done():
+			err, done = ctx.Err(), true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		s.removeWaiter(keys, wake)
+		if done {
+			return 0, err
+		}
+	}
+}
+
+// tryLock acquires the lock when it is free or held by owner,
+// otherwise it returns deadline of the current lease in unix milliseconds
+func (s *StorageService) tryLock(name, owner string, ttl int64) (uint64, int64, error) {
+	defer s.lockKeys(true, name)()
+
+	lock, err := storage.GetLock(s.storage, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	atMs := deadlineMs(ttl)
+	if lock != nil && lock.Owner != owner {
+		deadline, _ := s.deadline(name)
+		return 0, deadline, nil
+	}
+	if lock != nil {
+		if err := s.commit(expireEntry(name, atMs)); err != nil {
+			return 0, 0, err
+		}
+		return lock.Token, 0, nil
+	}
+
+	acquire := storage.WalEntry[protocol.Resp2Value]{OpType: protocol.LOCK, Key: name, Value: protocol.Resp2BulkString(owner)}
+	if err := s.commit(acquire, expireEntry(name, atMs)); err != nil {
+		return 0, 0, err
+	}
+	lock, err = storage.GetLock(s.data, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lock.Token, 0, nil
+}
+
+// heldLock returns the lock when it is held with the token.
+// Caller must hold the lock.
+func (s *StorageService) heldLock(name string, token uint64) (*storage.Lock, error) {
+	lock, err := storage.GetLock(s.storage, name)
+	if err != nil || lock == nil || lock.Token != token {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// RenewLock extends lease of the lock to ttl milliseconds from now,
+// returns false when the lock is not held with the token anymore
+func (s *StorageService) RenewLock(name string, token uint64, ttl int64) (bool, error) {
+	defer s.lockKeys(true, name)()
+
+	lock, err := s.heldLock(name, token)
+	if err != nil || lock == nil {
+		return false, err
+	}
+	if err := s.commit(expireEntry(name, deadlineMs(ttl))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Unlock releases the lock and wakes up its waiters,
+// returns false when the lock is not held with the token anymore
+func (s *StorageService) Unlock(name string, token uint64) (bool, error) {
+	defer s.lockKeys(true, name)()
+
+	lock, err := s.heldLock(name, token)
+	if err != nil || lock == nil {
+		return false, err
+	}
+	if err := s.commit(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: name}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
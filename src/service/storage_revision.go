@@ -0,0 +1,65 @@
+package service
+
+import (
+	"main/src/protocol"
+	"main/src/storage"
+)
+
+// Versioned keys of StorageService.
+// Every key carries modification revision, which is index of the last WAL entry
+// modifying it, so concurrent clients can update keys with compare-and-swap.
+// Revision 0 stands for a key which does not exist.
+
+// keyRevision returns modification revision of the key, 0 when it does not exist.
+// Caller must hold the lock.
+func (s *StorageService) keyRevision(key string) (uint64, error) {
+	exists, err := s.storage.Exists(key)
+	if err != nil || !exists {
+		return 0, err
+	}
+	return storage.Revision(s.data, key), nil
+}
+
+// GetWithRevision returns plain value of the key with its modification revision
+// and false when the key does not exist, a stored nil value is found too.
+func (s *StorageService) GetWithRevision(key string) (protocol.Resp2Value, uint64, bool, error) {
+	defer s.lockKeys(false, key)()
+
+	value, found, err := s.getPlain(key)
+	if err != nil || !found {
+		return nil, 0, false, err
+	}
+	revision, err := s.keyRevision(key)
+	return value, revision, true, err
+}
+
+// CompareAndSet stores value like SET when modification revision of the key equals revision,
+// revision 0 requires the key to not exist. Returns the new revision of the key,
+// or false when the revision did not match.
+func (s *StorageService) CompareAndSet(key string, revision uint64, value protocol.Resp2Value) (uint64, bool, error) {
+	defer s.lockKeys(true, key)()
+
+	current, err := s.keyRevision(key)
+	if err != nil || current != revision {
+		return 0, false, err
+	}
+	if _, _, err := s.set(protocol.OpPayloadSet{Key: key, Value: value}); err != nil {
+		return 0, false, err
+	}
+	return storage.Revision(s.data, key), true, nil
+}
+
+// CompareAndDelete deletes the key of any type when its modification revision equals revision.
+// Returns false when the key does not exist or the revision did not match.
+func (s *StorageService) CompareAndDelete(key string, revision uint64) (bool, error) {
+	defer s.lockKeys(true, key)()
+
+	current, err := s.keyRevision(key)
+	if err != nil || current == 0 || current != revision {
+		return false, err
+	}
+	if err := s.commit(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: key}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
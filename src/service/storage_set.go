@@ -0,0 +1,165 @@
+package service
+
+import (
+	"main/src/protocol"
+	"main/src/storage"
+)
+
+// Set commands of StorageService.
+// Only members which actually change the set are written to the WAL.
+
+func (s *StorageService) SAdd(key string, members []string) (int, error) {
+	defer s.lockKeys(true, key)()
+
+	set, err := storage.GetSet(s.storage, key)
+	if err != nil {
+		return 0, err
+	}
+
+	added := storage.NewSet()
+	for _, m := range members {
+		if !set.Contains(m) {
+			added.Add(m)
+		}
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	err = s.commit(storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.SADD,
+		Key:    key,
+		Value:  added.Encode(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(added), nil
+}
+
+func (s *StorageService) SRem(key string, members []string) (int, error) {
+	defer s.lockKeys(true, key)()
+
+	set, err := storage.GetSet(s.storage, key)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := storage.NewSet()
+	for _, m := range members {
+		if set.Contains(m) {
+			removed.Add(m)
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	err = s.commit(storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.SREM,
+		Key:    key,
+		Value:  removed.Encode(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+func (s *StorageService) SMembers(key string) ([]string, error) {
+	defer s.lockKeys(false, key)()
+
+	set, err := storage.GetSet(s.storage, key)
+	if err != nil {
+		return nil, err
+	}
+	return set.Members(), nil
+}
+
+func (s *StorageService) SIsMember(key string, member string) (bool, error) {
+	defer s.lockKeys(false, key)()
+
+	set, err := storage.GetSet(s.storage, key)
+	if err != nil {
+		return false, err
+	}
+	return set.Contains(member), nil
+}
+
+func (s *StorageService) SCard(key string) (int, error) {
+	defer s.lockKeys(false, key)()
+
+	set, err := storage.GetSet(s.storage, key)
+	if err != nil {
+		return 0, err
+	}
+	return len(set), nil
+}
+
+// SetAlgebra computes SINTER, SUNION or SDIFF over given keys.
+func (s *StorageService) SetAlgebra(kind protocol.OpType, keys []string) ([]string, error) {
+	defer s.lockKeys(false, keys...)()
+
+	result, err := s.setAlgebra(kind, keys)
+	if err != nil {
+		return nil, err
+	}
+	return result.Members(), nil
+}
+
+// SetAlgebraStore computes SINTERSTORE, SUNIONSTORE or SDIFFSTORE and stores result in destination.
+// Resulting members are written to the WAL so replay does not depend on source keys.
+func (s *StorageService) SetAlgebraStore(kind protocol.OpType, destination string, keys []string) (int, error) {
+	defer s.lockKeys(true, append([]string{destination}, keys...)...)()
+
+	var algebra protocol.OpType
+	switch kind {
+	case protocol.SINTERSTORE:
+		algebra = protocol.SINTER
+	case protocol.SUNIONSTORE:
+		algebra = protocol.SUNION
+	default:
+		algebra = protocol.SDIFF
+	}
+
+	result, err := s.setAlgebra(algebra, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	entries := []storage.WalEntry[protocol.Resp2Value]{{
+		OpType: kind,
+		Key:    destination,
+		Value:  result.Encode(),
+	}}
+	// Destination is overwritten as a whole like by SET, so its expiration is discarded.
+	// Empty result deletes it together with the expiration.
+	if _, ok := s.deadline(destination); ok && len(result) > 0 {
+		entries = append(entries, storage.WalEntry[protocol.Resp2Value]{OpType: protocol.PERSIST, Key: destination})
+	}
+	if err := s.commit(entries...); err != nil {
+		return 0, err
+	}
+	return len(result), nil
+}
+
+// Caller must hold at least read lock.
+func (s *StorageService) setAlgebra(kind protocol.OpType, keys []string) (storage.Set, error) {
+	sets := make([]storage.Set, 0, len(keys))
+	for _, key := range keys {
+		set, err := storage.GetSet(s.storage, key)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+
+	switch kind {
+	case protocol.SINTER:
+		return storage.InterSets(sets...), nil
+	case protocol.SUNION:
+		return storage.UnionSets(sets...), nil
+	default:
+		return storage.DiffSets(sets[0], sets[1:]...), nil
+	}
+}
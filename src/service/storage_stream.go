@@ -0,0 +1,548 @@
+package service
+
+import (
+	"errors"
+	"main/src/protocol"
+	"main/src/storage"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stream commands of StorageService.
+// Consumer group bookkeeping is logged with explicit IDs and timestamps,
+// so replay does not depend on the clock or on "$"/">" resolution.
+
+var errXGroupNoKey = errors.New("The XGROUP subcommand requires the key to exist. " +
+	"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+// StreamReadResult is a reply of XREAD/XREADGROUP for a single key
+type StreamReadResult struct {
+	Key     string
+	Entries []storage.StreamEntry // entries with nil Fields were deleted from the stream
+}
+
+// StreamPendingSummary is a reply of XPENDING in its summary form
+type StreamPendingSummary struct {
+	Count     int
+	Min       protocol.StreamID
+	Max       protocol.StreamID
+	Consumers []string
+	Counts    []int
+}
+
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// deadlineMs returns unix time ttl milliseconds from now, saturated instead of wrapping around
+func deadlineMs(ttl int64) int64 {
+	now := nowMs()
+	if ttl > math.MaxInt64-now {
+		return math.MaxInt64
+	}
+	return now + ttl
+}
+
+func streamIDsToStrings(ids []protocol.StreamID) []string {
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, id.String())
+	}
+	return result
+}
+
+func flagString(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// XAdd appends entry and returns its ID, ok is false when NOMKSTREAM prevented creating the stream.
+func (s *StorageService) XAdd(payload protocol.OpPayloadXAdd) (protocol.StreamID, bool, error) {
+	defer s.lockKeys(true, payload.Key)()
+
+	stream, err := storage.GetStream(s.storage, payload.Key)
+	if err != nil {
+		return protocol.StreamID{}, false, err
+	}
+	if stream == nil {
+		if payload.NoMkStream {
+			return protocol.StreamID{}, false, nil
+		}
+		stream = storage.NewStream()
+	}
+
+	id, err := stream.NextID(payload, uint64(nowMs()))
+	if err != nil {
+		return protocol.StreamID{}, false, err
+	}
+	entries := []storage.WalEntry[protocol.Resp2Value]{{
+		OpType: protocol.XADD,
+		Key:    payload.Key,
+		Value:  stringsValue(append([]string{id.String()}, payload.Fields...)),
+	}}
+	if payload.Trim != nil {
+		entries = append(entries, storage.WalEntry[protocol.Resp2Value]{
+			OpType: protocol.XTRIM,
+			Key:    payload.Key,
+			Value:  storage.EncodeStreamTrim(*payload.Trim),
+		})
+	}
+	if err := s.commit(entries...); err != nil {
+		return protocol.StreamID{}, false, err
+	}
+
+	s.notifyWaiters(payload.Key)
+	return id, true, nil
+}
+
+func (s *StorageService) XRange(payload protocol.OpPayloadXRange) ([]storage.StreamEntry, error) {
+	defer s.lockKeys(false, payload.Key)()
+
+	stream, err := storage.GetStream(s.storage, payload.Key)
+	if err != nil || stream == nil {
+		return []storage.StreamEntry{}, err
+	}
+	return stream.Range(payload.Start, payload.End, payload.Count, payload.Rev), nil
+}
+
+func (s *StorageService) XLen(key string) (int, error) {
+	defer s.lockKeys(false, key)()
+
+	stream, err := storage.GetStream(s.storage, key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	return stream.Len(), nil
+}
+
+// XTrim returns number of removed entries
+func (s *StorageService) XTrim(key string, trim protocol.StreamTrim) (int, error) {
+	defer s.lockKeys(true, key)()
+
+	stream, err := storage.GetStream(s.storage, key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	before := stream.Len()
+	err = s.commit(storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.XTRIM,
+		Key:    key,
+		Value:  storage.EncodeStreamTrim(trim),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return before - stream.Len(), nil
+}
+
+// XRead returns entries newer than given IDs, blocking up to payload.Block milliseconds
+// when there are none. nil result means the call timed out.
+func (s *StorageService) XRead(payload protocol.OpPayloadXRead) ([]StreamReadResult, error) {
+	ids := make([]protocol.StreamID, len(payload.IDs))
+
+	unlock := s.lockKeys(false, payload.Keys...)
+	// "$" is resolved once, later retries only look for entries added after the call
+	for i, id := range payload.IDs {
+		if !id.Last {
+			ids[i] = id.ID
+			continue
+		}
+		stream, err := storage.GetStream(s.storage, payload.Keys[i])
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		if stream != nil {
+			ids[i] = stream.LastID()
+		}
+	}
+	unlock()
+
+	read := func() ([]StreamReadResult, error) {
+		defer s.lockKeys(false, payload.Keys...)()
+		return s.xread(payload.Keys, ids, payload.Count)
+	}
+	return s.blockingRead(payload.Keys, payload.Block, read)
+}
+
+// Caller must hold the read lock.
+func (s *StorageService) xread(keys []string, ids []protocol.StreamID, count int64) ([]StreamReadResult, error) {
+	var results []StreamReadResult
+	for i, key := range keys {
+		stream, err := storage.GetStream(s.storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			continue
+		}
+		start, ok := ids[i].Next()
+		if !ok {
+			continue
+		}
+		entries := stream.Range(start, protocol.MaxStreamID, count, false)
+		if len(entries) > 0 {
+			results = append(results, StreamReadResult{Key: key, Entries: entries})
+		}
+	}
+	return results, nil
+}
+
+// blockingRead calls read until it returns something or block milliseconds pass.
+// Negative block means do not block at all, 0 means block forever.
+func (s *StorageService) blockingRead(keys []string, block int64, read func() ([]StreamReadResult, error)) ([]StreamReadResult, error) {
+	results, err := read()
+	// Commands inside a transaction never block, like in redis
+	if err != nil || len(results) > 0 || block < 0 || s.txEntries != nil {
+		return results, err
+	}
+
+	var timeout <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(time.Duration(block) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		wake := s.addWaiter(keys)
+		// Entries might have been added before the waiter was registered
+		results, err := read()
+		if err != nil || len(results) > 0 {
+			s.removeWaiter(keys, wake)
+			return results, err
+		}
+		select {
+		case <-wake:
+			s.removeWaiter(keys, wake)
+		case <-timeout:
+			s.removeWaiter(keys, wake)
+			return nil, nil
+		}
+	}
+}
+
+// keyWaiters holds wake up channels of clients blocked on keys,
+// readers of streams and waiters for released locks
+type keyWaiters struct {
+	mu    sync.Mutex
+	byKey map[string][]chan struct{}
+}
+
+func (s *StorageService) addWaiter(keys []string) chan struct{} {
+	s.waiters.mu.Lock()
+	defer s.waiters.mu.Unlock()
+
+	wake := make(chan struct{}, 1)
+	for _, key := range keys {
+		s.waiters.byKey[key] = append(s.waiters.byKey[key], wake)
+	}
+	return wake
+}
+
+func (s *StorageService) removeWaiter(keys []string, wake chan struct{}) {
+	s.waiters.mu.Lock()
+	defer s.waiters.mu.Unlock()
+
+	for _, key := range keys {
+		waiters := s.waiters.byKey[key]
+		for i, w := range waiters {
+			if w == wake {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(s.waiters.byKey, key)
+		} else {
+			s.waiters.byKey[key] = waiters
+		}
+	}
+}
+
+// notifyWaiters wakes up every client blocked on key
+func (s *StorageService) notifyWaiters(key string) {
+	s.waiters.mu.Lock()
+	defer s.waiters.mu.Unlock()
+
+	for _, wake := range s.waiters.byKey[key] {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	delete(s.waiters.byKey, key)
+}
+
+// XGroupCreate creates consumer group, "$" starts it at the current last ID.
+func (s *StorageService) XGroupCreate(key, group string, id protocol.StreamReadID, mkStream bool) error {
+	defer s.lockKeys(true, key)()
+
+	stream, err := storage.GetStream(s.storage, key)
+	if err != nil {
+		return err
+	}
+	if stream == nil && !mkStream {
+		return errXGroupNoKey
+	}
+	if stream != nil && stream.Group(group) != nil {
+		return storage.ErrBusyGroup
+	}
+
+	start := id.ID
+	if id.Last && stream != nil {
+		start = stream.LastID()
+	}
+	return s.commit(storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.XGROUP,
+		Key:    key,
+		Value:  stringsValue([]string{"CREATE", group, start.String(), flagString(mkStream)}),
+	})
+}
+
+// XGroupDestroy returns false if the group did not exist
+func (s *StorageService) XGroupDestroy(key, group string) (bool, error) {
+	defer s.lockKeys(true, key)()
+
+	stream, err := storage.GetStream(s.storage, key)
+	if err != nil {
+		return false, err
+	}
+	if stream == nil {
+		return false, errXGroupNoKey
+	}
+	if stream.Group(group) == nil {
+		return false, nil
+	}
+	err = s.commit(storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.XGROUP,
+		Key:    key,
+		Value:  stringsValue([]string{"DESTROY", group}),
+	})
+	return err == nil, err
+}
+
+// XReadGroup reads entries on behalf of a consumer.
+// ">" delivers never delivered entries and adds them to the pending entries list,
+// explicit ID returns consumer's own pending entries after it.
+func (s *StorageService) XReadGroup(payload protocol.OpPayloadXReadGroup) ([]StreamReadResult, error) {
+	read := func() ([]StreamReadResult, error) {
+		defer s.lockKeys(true, payload.Keys...)()
+		return s.xreadgroup(payload)
+	}
+	block := payload.Block
+	for _, id := range payload.IDs {
+		// Only new entries can show up while waiting
+		if !id.New {
+			block = -1
+		}
+	}
+	return s.blockingRead(payload.Keys, block, read)
+}
+
+// Caller must hold the write lock.
+func (s *StorageService) xreadgroup(payload protocol.OpPayloadXReadGroup) ([]StreamReadResult, error) {
+	var results []StreamReadResult
+	for i, key := range payload.Keys {
+		stream, err := storage.GetStream(s.storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil || stream.Group(payload.Group) == nil {
+			return nil, storage.ErrNoGroup
+		}
+		group := stream.Group(payload.Group)
+
+		if !payload.IDs[i].New {
+			pending := group.Pending(payload.IDs[i].ID, protocol.MaxStreamID, -1, payload.Consumer)
+			entries := make([]storage.StreamEntry, 0, len(pending))
+			for _, p := range pending {
+				if p.ID == payload.IDs[i].ID {
+					continue
+				}
+				if payload.Count > 0 && int64(len(entries)) >= payload.Count {
+					break
+				}
+				entry, ok := stream.Get(p.ID)
+				if !ok {
+					entry = storage.StreamEntry{ID: p.ID}
+				}
+				entries = append(entries, entry)
+			}
+			results = append(results, StreamReadResult{Key: key, Entries: entries})
+			continue
+		}
+
+		start, ok := group.LastDelivered.Next()
+		if !ok {
+			continue
+		}
+		entries := stream.Range(start, protocol.MaxStreamID, payload.Count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		ids := make([]protocol.StreamID, 0, len(entries))
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		args := []string{payload.Group, payload.Consumer, strconv.FormatInt(nowMs(), 10), flagString(payload.NoAck)}
+		err = s.commit(storage.WalEntry[protocol.Resp2Value]{
+			OpType: protocol.XREADGROUP,
+			Key:    key,
+			Value:  stringsValue(append(args, streamIDsToStrings(ids)...)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, StreamReadResult{Key: key, Entries: entries})
+	}
+	return results, nil
+}
+
+// XAck returns number of acknowledged entries
+func (s *StorageService) XAck(key, group string, ids []protocol.StreamID) (int, error) {
+	defer s.lockKeys(true, key)()
+
+	g, err := s.group(key, group)
+	if err != nil {
+		return 0, err
+	}
+	acked := make([]protocol.StreamID, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := g.PendingEntry(id); ok {
+			acked = append(acked, id)
+		}
+	}
+	if len(acked) == 0 {
+		return 0, nil
+	}
+	err = s.commit(storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.XACK,
+		Key:    key,
+		Value:  stringsValue(append([]string{group}, streamIDsToStrings(acked)...)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(acked), nil
+}
+
+func (s *StorageService) XPendingSummary(key, group string) (StreamPendingSummary, error) {
+	defer s.lockKeys(false, key)()
+
+	g, err := s.group(key, group)
+	if err != nil {
+		return StreamPendingSummary{}, err
+	}
+	pending := g.Pending(protocol.MinStreamID, protocol.MaxStreamID, -1, "")
+	summary := StreamPendingSummary{Count: len(pending)}
+	if len(pending) == 0 {
+		return summary, nil
+	}
+	summary.Min, summary.Max = pending[0].ID, pending[len(pending)-1].ID
+
+	counts := g.PendingCount()
+	for _, p := range pending {
+		if n, ok := counts[p.Consumer]; ok {
+			summary.Consumers = append(summary.Consumers, p.Consumer)
+			summary.Counts = append(summary.Counts, n)
+			delete(counts, p.Consumer)
+		}
+	}
+	return summary, nil
+}
+
+// XPending returns pending entries of the extended XPENDING form,
+// DeliveryTime of returned entries is replaced with idle time.
+func (s *StorageService) XPending(payload protocol.OpPayloadXPending) ([]storage.PendingEntry, error) {
+	defer s.lockKeys(false, payload.Key)()
+
+	g, err := s.group(payload.Key, payload.Group)
+	if err != nil {
+		return nil, err
+	}
+	now := nowMs()
+	result := []storage.PendingEntry{}
+	for _, p := range g.Pending(payload.Start, payload.End, -1, payload.Consumer) {
+		if int64(len(result)) >= payload.Count {
+			break
+		}
+		p.DeliveryTime = now - p.DeliveryTime
+		if p.DeliveryTime < payload.Idle {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// XClaim transfers pending entries idle for at least MinIdle milliseconds to the consumer.
+// Entries deleted from the stream are dropped from the pending entries list.
+func (s *StorageService) XClaim(payload protocol.OpPayloadXClaim) ([]storage.StreamEntry, error) {
+	defer s.lockKeys(true, payload.Key)()
+
+	stream, err := storage.GetStream(s.storage, payload.Key)
+	if err != nil {
+		return nil, err
+	}
+	g, err := s.group(payload.Key, payload.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	now := nowMs()
+	claimed := []storage.StreamEntry{}
+	var ids, deleted []protocol.StreamID
+	for _, id := range payload.IDs {
+		p, ok := g.PendingEntry(id)
+		if !ok || now-p.DeliveryTime < payload.MinIdle {
+			continue
+		}
+		entry, ok := stream.Get(id)
+		if !ok {
+			deleted = append(deleted, id)
+			continue
+		}
+		claimed = append(claimed, entry)
+		ids = append(ids, id)
+	}
+
+	var entries []storage.WalEntry[protocol.Resp2Value]
+	if len(deleted) > 0 {
+		entries = append(entries, storage.WalEntry[protocol.Resp2Value]{
+			OpType: protocol.XACK,
+			Key:    payload.Key,
+			Value:  stringsValue(append([]string{payload.Group}, streamIDsToStrings(deleted)...)),
+		})
+	}
+	if len(ids) > 0 {
+		args := []string{payload.Group, payload.Consumer, strconv.FormatInt(now, 10), flagString(payload.JustID)}
+		entries = append(entries, storage.WalEntry[protocol.Resp2Value]{
+			OpType: protocol.XCLAIM,
+			Key:    payload.Key,
+			Value:  stringsValue(append(args, streamIDsToStrings(ids)...)),
+		})
+	}
+	if len(entries) > 0 {
+		if err := s.commit(entries...); err != nil {
+			return nil, err
+		}
+	}
+	return claimed, nil
+}
+
+// Caller must hold the lock.
+func (s *StorageService) group(key, name string) (*storage.ConsumerGroup, error) {
+	stream, err := storage.GetStream(s.storage, key)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil || stream.Group(name) == nil {
+		return nil, storage.ErrNoGroup
+	}
+	return stream.Group(name), nil
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"main/src/protocol"
+	"main/src/storage"
+	"math"
+	"strconv"
+)
+
+// String commands of StorageService.
+// Every modification is logged as SET of the resulting value, so counters and
+// partial updates replay to exactly the same string. SET entries keep expiration
+// of the key, only SET command itself clears it.
+
+// Same limit as redis proto-max-bulk-len
+const maxStringLength = 512 * 1024 * 1024
+
+var (
+	errNotInteger     = errors.New("value is not an integer or out of range")
+	errNotFloat       = errors.New("value is not a valid float")
+	errOverflow       = errors.New("increment or decrement would overflow")
+	errNaNOrInfinity  = errors.New("increment would produce NaN or Infinity")
+	errStringTooLarge = errors.New("string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+func setEntry(key string, value protocol.Resp2Value) storage.WalEntry[protocol.Resp2Value] {
+	return storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.SET,
+		Key:    key,
+		Value:  value,
+	}
+}
+
+// getPlain returns value stored under key and false when the key does not exist,
+// composite values are reported as ErrWrongType.
+// Caller must hold the lock.
+func (s *StorageService) getPlain(key string) (protocol.Resp2Value, bool, error) {
+	value, found, err := s.storage.Get(key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	if _, ok := value.(storage.Encoder); ok {
+		return nil, false, storage.ErrWrongType
+	}
+	return value, true, nil
+}
+
+// SetWithOptions stores value applying SET options.
+// It returns the old value when payload.Get is set and false when NX/XX prevented the update.
+// Relative expiration is converted to unix time before it is logged.
+func (s *StorageService) SetWithOptions(payload protocol.OpPayloadSet) (protocol.Resp2Value, bool, error) {
+	defer s.lockKeys(true, payload.Key)()
+	return s.set(payload)
+}
+
+// set is SetWithOptions without locking.
+// Caller must hold the write lock.
+func (s *StorageService) set(payload protocol.OpPayloadSet) (protocol.Resp2Value, bool, error) {
+	var old protocol.Resp2Value
+	if payload.Get {
+		var err error
+		if old, _, err = s.getPlain(payload.Key); err != nil {
+			return nil, false, err
+		}
+	}
+	exists, err := s.storage.Exists(payload.Key)
+	if err != nil {
+		return nil, false, err
+	}
+	if (payload.NX && exists) || (payload.XX && !exists) {
+		return old, false, nil
+	}
+
+	entries := []storage.WalEntry[protocol.Resp2Value]{setEntry(payload.Key, payload.Value)}
+	switch {
+	case payload.ExpireMs > 0:
+		atMs := payload.ExpireMs
+		if !payload.ExpireAbsolute {
+			now := nowMs()
+			if atMs > math.MaxInt64-now {
+				return nil, false, fmt.Errorf("invalid expire time in 'set' command")
+			}
+			atMs += now
+		}
+		entries = append(entries, expireEntry(payload.Key, atMs))
+	case !payload.KeepTTL:
+		if _, ok := s.deadline(payload.Key); ok {
+			entries = append(entries, storage.WalEntry[protocol.Resp2Value]{OpType: protocol.PERSIST, Key: payload.Key})
+		}
+	}
+	if err := s.commit(entries...); err != nil {
+		return nil, false, err
+	}
+	return old, true, nil
+}
+
+// IncrBy adds delta to integer stored under key, missing key counts as 0.
+func (s *StorageService) IncrBy(key string, delta int64) (int64, error) {
+	defer s.lockKeys(true, key)()
+
+	str, _, err := storage.GetString(s.storage, key)
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	if str != "" {
+		if current, err = strconv.ParseInt(str, 10, 64); err != nil {
+			return 0, errNotInteger
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, errOverflow
+	}
+
+	result := current + delta
+	if err := s.commit(setEntry(key, protocol.Resp2BulkString(strconv.FormatInt(result, 10)))); err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// IncrByFloat adds delta to number stored under key and returns the new value as stored.
+func (s *StorageService) IncrByFloat(key string, delta float64) (string, error) {
+	defer s.lockKeys(true, key)()
+
+	str, _, err := storage.GetString(s.storage, key)
+	if err != nil {
+		return "", err
+	}
+	var current float64
+	if str != "" {
+		current, err = strconv.ParseFloat(str, 64)
+		if err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+			return "", errNotFloat
+		}
+	}
+
+	result := current + delta
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return "", errNaNOrInfinity
+	}
+	formatted := strconv.FormatFloat(result, 'f', -1, 64)
+	if err := s.commit(setEntry(key, protocol.Resp2BulkString(formatted))); err != nil {
+		return "", err
+	}
+	return formatted, nil
+}
+
+// Append returns length of the string after appending value
+func (s *StorageService) Append(key, value string) (int, error) {
+	defer s.lockKeys(true, key)()
+
+	current, _, err := storage.GetString(s.storage, key)
+	if err != nil {
+		return 0, err
+	}
+	if len(current)+len(value) > maxStringLength {
+		return 0, errStringTooLarge
+	}
+	result := current + value
+	if err := s.commit(setEntry(key, protocol.Resp2BulkString(result))); err != nil {
+		return 0, err
+	}
+	return len(result), nil
+}
+
+// GetRange returns substring between start and end inclusive,
+// negative offsets count from the end of the string.
+func (s *StorageService) GetRange(key string, start, end int64) (string, error) {
+	defer s.lockKeys(false, key)()
+
+	str, _, err := storage.GetString(s.storage, key)
+	if err != nil {
+		return "", err
+	}
+	length := int64(len(str))
+	if start < 0 {
+		start = max(start+length, 0)
+	}
+	if end < 0 {
+		end = max(end+length, 0)
+	}
+	end = min(end, length-1)
+	if length == 0 || start > end {
+		return "", nil
+	}
+	return str[start : end+1], nil
+}
+
+// SetRange overwrites part of the string starting at offset, padding it with zero bytes
+// when needed, and returns length of the resulting string.
+func (s *StorageService) SetRange(key string, offset int64, value string) (int, error) {
+	defer s.lockKeys(true, key)()
+
+	current, _, err := storage.GetString(s.storage, key)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return len(current), nil
+	}
+	// Compared without adding, a huge offset would overflow
+	if offset > maxStringLength-int64(len(value)) {
+		return 0, errStringTooLarge
+	}
+
+	buf := []byte(current)
+	if end := int(offset) + len(value); end > len(buf) {
+		buf = append(buf, make([]byte, end-len(buf))...)
+	}
+	copy(buf[offset:], value)
+
+	if err := s.commit(setEntry(key, protocol.Resp2BulkString(buf))); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (s *StorageService) StrLen(key string) (int, error) {
+	defer s.lockKeys(false, key)()
+
+	str, _, err := storage.GetString(s.storage, key)
+	return len(str), err
+}
+
+// GetSet stores value and returns the old one, like SET with GET option
+func (s *StorageService) GetSet(key string, value protocol.Resp2Value) (protocol.Resp2Value, error) {
+	old, _, err := s.SetWithOptions(protocol.OpPayloadSet{Key: key, Value: value, Get: true})
+	return old, err
+}
+
+// GetDel deletes the key and returns its value
+func (s *StorageService) GetDel(key string) (protocol.Resp2Value, error) {
+	defer s.lockKeys(true, key)()
+
+	value, found, err := s.getPlain(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	err = s.commit(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
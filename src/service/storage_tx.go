@@ -0,0 +1,161 @@
+package service
+
+import (
+	"main/src/protocol"
+	"main/src/storage"
+)
+
+// Optimistic transactions of StorageService.
+// Versions are tracked only for watched keys, every committed entry bumps
+// version of its key, so EXEC can detect modifications made since WATCH.
+
+type keyVersion struct {
+	version  uint64
+	watchers int
+}
+
+// WatchedKey is a key with its version at the moment it was watched
+type WatchedKey struct {
+	key     string
+	version uint64
+	exists  bool
+}
+
+// touch bumps version of a watched key.
+// Caller must hold the write lock.
+func (s *StorageService) touch(key string) {
+	if v, ok := s.versions[key]; ok {
+		v.version++
+	}
+}
+
+// Watch starts tracking versions of the keys, every returned key must be released by Unwatch
+func (s *StorageService) Watch(keys []string) ([]WatchedKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watched := make([]WatchedKey, 0, len(keys))
+	for _, key := range keys {
+		exists, err := s.storage.Exists(key)
+		if err != nil {
+			s.unwatch(watched)
+			return nil, err
+		}
+		v, ok := s.versions[key]
+		if !ok {
+			v = &keyVersion{}
+			s.versions[key] = v
+		}
+		v.watchers++
+		watched = append(watched, WatchedKey{key: key, version: v.version, exists: exists})
+	}
+	return watched, nil
+}
+
+func (s *StorageService) Unwatch(watched []WatchedKey) {
+	if len(watched) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unwatch(watched)
+}
+
+// Caller must hold the write lock.
+func (s *StorageService) unwatch(watched []WatchedKey) {
+	for _, w := range watched {
+		v, ok := s.versions[w.key]
+		if !ok {
+			continue
+		}
+		if v.watchers--; v.watchers <= 0 {
+			delete(s.versions, w.key)
+		}
+	}
+}
+
+// changed reports whether any of the watched keys was modified or expired.
+// Caller must hold the lock.
+func (s *StorageService) changed(watched []WatchedKey) (bool, error) {
+	for _, w := range watched {
+		if v, ok := s.versions[w.key]; !ok || v.version != w.version {
+			return true, nil
+		}
+		exists, err := s.storage.Exists(w.key)
+		if err != nil {
+			return false, err
+		}
+		if exists != w.exists {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Transaction runs fn holding the write lock, so no other command interleaves with it.
+// Modifications made through tx are logged as a single WAL record when fn returns.
+// Returns false without running fn when any of the watched keys changed.
+// Transaction started inside another one (e.g. EVAL queued in MULTI) joins the outer transaction.
+func (s *StorageService) Transaction(watched []WatchedKey, fn func(tx *StorageService)) (bool, error) {
+	if s.txEntries != nil {
+		fn(s)
+		return true, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if changed, err := s.changed(watched); err != nil || changed {
+		return false, err
+	}
+	s.reclaim()
+
+	_, err := s.transaction(fn)
+	return err == nil, err
+}
+
+// transaction runs fn on a copy of the service which collects committed entries
+// and logs them as a single WAL record. Returns index of the record, 0 when fn modified nothing.
+// Entries are applied as they are committed, so following commands see them. When the record
+// could not be written, keys they modified are restored and the transaction has no effect.
+// Caller must hold the write lock.
+func (s *StorageService) transaction(fn func(tx *StorageService)) (uint64, error) {
+	var entries []storage.WalEntry[protocol.Resp2Value]
+	tx := &StorageService{
+		wal:         s.wal,
+		snapshotter: s.snapshotter,
+		storage:     s.storage,
+		data:        s.data,
+		cfg:         s.cfg,
+		logger:      s.logger,
+		waiters:     s.waiters,
+		versions:    s.versions,
+		txEntries:   &entries,
+		txRollback:  storage.NewRollback(s.data),
+		notifier:    s.notifier,
+		feed:        s.feed,
+		memory:      s.memory,
+	}
+	fn(tx)
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	record := storage.NewBatch[protocol.Resp2Value]()
+	for _, e := range entries {
+		record.Add(e)
+	}
+	entry, err := record.Entry()
+	if err == nil {
+		entry, err = s.log(entry)
+	}
+	if err != nil {
+		s.logger.Error("Failed to log transaction: %v", err)
+		if restoreErr := tx.txRollback.Restore(s.data); restoreErr != nil {
+			s.logger.Error("Failed to restore keys of the transaction: %v", restoreErr)
+		}
+		return 0, err
+	}
+	storage.SetAppliedIndex(s.data, entry.Index)
+	return entry.Index, nil
+}
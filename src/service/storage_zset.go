@@ -0,0 +1,185 @@
+package service
+
+import (
+	"fmt"
+	"main/src/protocol"
+	"main/src/storage"
+	"math"
+)
+
+// Sorted set commands of StorageService.
+// Writes are logged as absolute scores, so ZINCRBY replays to the exact same value.
+
+// ZAdd returns number of added elements, or added and updated ones when CH flag is set.
+func (s *StorageService) ZAdd(key string, flags protocol.ZAddFlags, members []protocol.ZScoreMember) (int, error) {
+	defer s.lockKeys(true, key)()
+
+	added, changed, _, _, err := s.zadd(key, flags, members)
+	if err != nil {
+		return 0, err
+	}
+	if flags.CH {
+		return added + changed, nil
+	}
+	return added, nil
+}
+
+// ZIncrBy increments member score and returns the new one.
+// ok is false when flags (ZADD INCR with NX/XX/GT/LT) prevented the update.
+func (s *StorageService) ZIncrBy(key string, flags protocol.ZAddFlags, increment float64, member string) (float64, bool, error) {
+	defer s.lockKeys(true, key)()
+
+	flags.Incr = true
+	_, _, score, ok, err := s.zadd(key, flags, []protocol.ZScoreMember{{Score: increment, Member: member}})
+	return score, ok, err
+}
+
+// Caller must hold the write lock.
+func (s *StorageService) zadd(key string, flags protocol.ZAddFlags, members []protocol.ZScoreMember) (added int, changed int, last float64, applied bool, err error) {
+	zset, err := storage.GetZSet(s.storage, key)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+
+	// Scores after this command, later duplicates see earlier updates
+	pending := make(map[string]float64)
+	order := make([]string, 0, len(members))
+	current := func(member string) (float64, bool) {
+		if score, ok := pending[member]; ok {
+			return score, true
+		}
+		if zset == nil {
+			return 0, false
+		}
+		return zset.Score(member)
+	}
+
+	for _, m := range members {
+		old, exists := current(m.Member)
+		if (flags.NX && exists) || (flags.XX && !exists) {
+			applied = false
+			continue
+		}
+
+		score := m.Score
+		if flags.Incr {
+			score += old
+			if math.IsNaN(score) {
+				return 0, 0, 0, false, fmt.Errorf("resulting score is not a number (NaN)")
+			}
+		}
+
+		if exists && ((flags.GT && score <= old) || (flags.LT && score >= old)) {
+			applied = false
+			continue
+		}
+
+		if !exists {
+			added++
+		} else if score != old {
+			changed++
+		}
+		if _, ok := pending[m.Member]; !ok {
+			order = append(order, m.Member)
+		}
+		pending[m.Member] = score
+		last, applied = score, true
+	}
+
+	if added+changed == 0 {
+		return added, changed, last, applied, nil
+	}
+
+	entries := make([]storage.ZEntry, 0, len(order))
+	for _, member := range order {
+		entries = append(entries, storage.ZEntry{Member: member, Score: pending[member]})
+	}
+	err = s.commit(storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.ZADD,
+		Key:    key,
+		Value:  storage.EncodeZEntries(entries),
+	})
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	return added, changed, last, applied, nil
+}
+
+func (s *StorageService) ZRem(key string, members []string) (int, error) {
+	defer s.lockKeys(true, key)()
+
+	zset, err := storage.GetZSet(s.storage, key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+
+	removed := storage.NewSet()
+	for _, m := range members {
+		if _, ok := zset.Score(m); ok {
+			removed.Add(m)
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	err = s.commit(storage.WalEntry[protocol.Resp2Value]{
+		OpType: protocol.ZREM,
+		Key:    key,
+		Value:  removed.Encode(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+func (s *StorageService) ZCard(key string) (int, error) {
+	defer s.lockKeys(false, key)()
+
+	zset, err := storage.GetZSet(s.storage, key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.Len(), nil
+}
+
+func (s *StorageService) ZScore(key string, member string) (float64, bool, error) {
+	defer s.lockKeys(false, key)()
+
+	zset, err := storage.GetZSet(s.storage, key)
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+	score, ok := zset.Score(member)
+	return score, ok, nil
+}
+
+func (s *StorageService) ZRank(key string, member string, rev bool) (int, bool, error) {
+	defer s.lockKeys(false, key)()
+
+	zset, err := storage.GetZSet(s.storage, key)
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+	rank, ok := zset.Rank(member, rev)
+	return rank, ok, nil
+}
+
+func (s *StorageService) ZRange(spec protocol.OpPayloadZRange) ([]storage.ZEntry, error) {
+	defer s.lockKeys(false, spec.Key)()
+
+	zset, err := storage.GetZSet(s.storage, spec.Key)
+	if err != nil || zset == nil {
+		return []storage.ZEntry{}, err
+	}
+
+	switch spec.By {
+	case protocol.ZRangeByScore:
+		return zset.RangeByScore(spec.MinScore, spec.MaxScore, spec.Rev, spec.Offset, spec.Count), nil
+	case protocol.ZRangeByLex:
+		return zset.RangeByLex(spec.MinLex, spec.MaxLex, spec.Rev, spec.Offset, spec.Count), nil
+	default:
+		return zset.RangeByRank(spec.Start, spec.Stop, spec.Rev), nil
+	}
+}
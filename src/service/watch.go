@@ -0,0 +1,245 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft/pb"
+	"main/src/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Change data capture of StorageService.
+// Committed WAL entries are kept in a bounded in-memory history which watchers tail,
+// so a watcher can resume from any index which was not dropped from it yet,
+// entries before the last snapshot are available only while they stay in the history.
+
+var errCompacted = errors.New("requested index is no longer available")
+
+// changeFeed is history of committed entries ordered by index
+type changeFeed struct {
+	mu        sync.Mutex
+	limit     int
+	history   []storage.WalEntry[protocol.Resp2Value]
+	compacted uint64        // index of the last dropped entry
+	last      uint64        // index of the last committed entry
+	added     chan struct{} // closed when an entry is added
+}
+
+func newChangeFeed(limit int, last uint64, entries []storage.WalEntry[protocol.Resp2Value]) *changeFeed {
+	f := &changeFeed{limit: limit, compacted: last, last: last, added: make(chan struct{})}
+	for _, e := range entries {
+		// Entries logged before indexes were assigned can not be watched
+		if e.Index == 0 {
+			continue
+		}
+		if len(f.history) == 0 {
+			f.compacted = e.Index - 1
+		}
+		f.history = append(f.history, e)
+	}
+	f.trim()
+	return f
+}
+
+func (f *changeFeed) add(entry storage.WalEntry[protocol.Resp2Value]) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.history = append(f.history, entry)
+	f.last = entry.Index
+	f.trim()
+	close(f.added)
+	f.added = make(chan struct{})
+}
+
+// trim drops the oldest entries over the limit, history is copied only when
+// it doubles the limit so adding stays cheap.
+func (f *changeFeed) trim() {
+	if f.limit <= 0 {
+		f.history = nil
+		f.compacted = f.last
+		return
+	}
+	if len(f.history) < 2*f.limit {
+		return
+	}
+	dropped := len(f.history) - f.limit
+	f.compacted = f.history[dropped-1].Index
+	f.history = append([]storage.WalEntry[protocol.Resp2Value](nil), f.history[dropped:]...)
+}
+
+// lastIndex returns index of the last committed entry
+func (f *changeFeed) lastIndex() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.last
+}
+
+// reached reports whether entry with the index is committed,
+// otherwise it returns a channel closed when another entry is added
+func (f *changeFeed) reached(index uint64) (bool, <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.last >= index, f.added
+}
+
+// since returns entries starting from the index and a channel closed when another entry is added
+func (f *changeFeed) since(from uint64) ([]storage.WalEntry[protocol.Resp2Value], <-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if from <= f.compacted {
+		return nil, nil, fmt.Errorf("%w: index %d, oldest available is %d", errCompacted, from, f.compacted+1)
+	}
+	i := sort.Search(len(f.history), func(i int) bool { return f.history[i].Index >= from })
+	return f.history[i:], f.added, nil
+}
+
+// log assigns the next index to entry, appends it to the WAL and publishes it to watchers.
+// Caller must hold the write lock of the entry keys, the entry has to be applied already.
+// Writers of different keys may log concurrently, so the WAL keeps its own lock.
+func (s *StorageService) log(entry storage.WalEntry[protocol.Resp2Value]) (storage.WalEntry[protocol.Resp2Value], error) {
+	s.walMu.Lock()
+	defer s.walMu.Unlock()
+	entry.Index = s.feed.lastIndex() + 1
+	entry.Timestamp = nowMs()
+	if err := s.wal.Append(entry, true); err != nil {
+		return entry, err
+	}
+	s.feed.add(entry)
+	return entry, nil
+}
+
+// applyAndLog is like log, but applies the entry first and restores what it modified
+// when it could not be written, so the store and watchers only see entries in the WAL.
+// Caller must hold the write lock of the entry keys.
+func (s *StorageService) applyAndLog(entry storage.WalEntry[protocol.Resp2Value]) (storage.WalEntry[protocol.Resp2Value], error) {
+	s.walMu.Lock()
+	defer s.walMu.Unlock()
+	entry.Index = s.feed.lastIndex() + 1
+	entry.Timestamp = nowMs()
+	rollback := storage.NewRollback(s.data)
+	if err := rollback.Apply(s.data, entry); err != nil {
+		return entry, err
+	}
+	if err := s.wal.Append(entry, true); err != nil {
+		if restoreErr := rollback.Restore(s.data); restoreErr != nil {
+			return entry, fmt.Errorf("%w, %v", err, restoreErr)
+		}
+		return entry, err
+	}
+	s.feed.add(entry)
+	return entry, nil
+}
+
+// WatchService streams committed writes of StorageService over gRPC
+type WatchService struct {
+	pb.UnimplementedWatchServer
+
+	storage *StorageService
+	logger  *config.Logger
+}
+
+func NewWatchService(storage *StorageService, logger *config.Logger) *WatchService {
+	return &WatchService{storage: storage, logger: logger}
+}
+
+// Register adds the watch service to the server
+func (w *WatchService) Register(server grpc.ServiceRegistrar) {
+	pb.RegisterWatchServer(server, w)
+}
+
+func (w *WatchService) Watch(req *pb.WatchRequest, stream pb.Watch_WatchServer) error {
+	from := req.StartIndex
+	if req.ResumeToken != "" {
+		index, err := parseResumeToken(req.ResumeToken)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		from = index + 1
+	}
+	if from == 0 {
+		from = w.storage.feed.lastIndex() + 1
+	}
+
+	for {
+		entries, added, err := w.storage.feed.since(from)
+		if err != nil {
+			return status.Error(codes.OutOfRange, err.Error())
+		}
+		for _, entry := range entries {
+			from = entry.Index + 1
+			events, err := watchEvents(entry, req.Prefix)
+			if err != nil {
+				w.logger.Error("Failed to decode WAL entry %d: %v", entry.Index, err)
+				return status.Error(codes.Internal, err.Error())
+			}
+			if len(events) == 0 {
+				continue
+			}
+			resp := &pb.WatchResponse{Index: entry.Index, ResumeToken: resumeToken(entry.Index), Events: events}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-added:
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// watchEvents converts entry into events of keys matching the prefix, batches are split into their entries
+func watchEvents(entry storage.WalEntry[protocol.Resp2Value], prefix string) ([]*pb.WatchEvent, error) {
+	entries := []storage.WalEntry[protocol.Resp2Value]{entry}
+	if entry.OpType == protocol.BATCH {
+		var err error
+		if entries, err = storage.DecodeBatch(entry.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	parser := protocol.NewResp2ParserFromBytes(nil)
+	var events []*pb.WatchEvent
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Key, prefix) {
+			continue
+		}
+		var value []byte
+		if e.Value != nil {
+			var err error
+			if value, err = parser.Render(e.Value); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, &pb.WatchEvent{Op: e.OpType.String(), Key: e.Key, Value: value, Timestamp: entry.Timestamp})
+	}
+	return events, nil
+}
+
+// Resume tokens are opaque for clients, they hold index of the last sent entry
+func resumeToken(index uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("wal:" + strconv.FormatUint(index, 10)))
+}
+
+func parseResumeToken(token string) (uint64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil && strings.HasPrefix(string(data), "wal:") {
+		if index, err := strconv.ParseUint(string(data[4:]), 10, 64); err == nil {
+			return index, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid resume token")
+}
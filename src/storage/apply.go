@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"fmt"
+	"main/src/protocol"
+	"strconv"
+)
+
+// Apply applies a single WAL entry to the storage.
+// It is used both when executing commands and when replaying the log,
+// so live state and recovered state are always built the same way.
+func Apply[T any](store Storage[T], entry WalEntry[T]) error {
+	if err := apply(store, entry); err != nil {
+		return err
+	}
+	SetAppliedIndex(store, entry.Index)
+	return nil
+}
+
+// apply applies the entry and records it as modification revision of its key,
+// entries of a batch share index of the batch. Values modified in place are resized.
+func apply[T any](store Storage[T], entry WalEntry[T]) error {
+	switch entry.OpType {
+	case protocol.GET, protocol.PING:
+		// Read only, nothing to apply
+		return nil
+	case protocol.BATCH:
+		entries, err := DecodeBatch(entry.Value)
+		if err != nil {
+			return err
+		}
+		// Batch is applied all-or-nothing, keys modified before an entry failed are restored
+		rollback := NewRollback(store)
+		for _, e := range entries {
+			e.Index = entry.Index
+			if err := rollback.save(store, e); err != nil {
+				return err
+			}
+			if err := apply(store, e); err != nil {
+				if restoreErr := rollback.Restore(store); restoreErr != nil {
+					return fmt.Errorf("%w, %v", err, restoreErr)
+				}
+				return err
+			}
+		}
+		return nil
+	case protocol.LEASE:
+		// Lease table is not a key, so no revision is recorded
+		return applyLease(store, entry)
+	}
+	if err := applyKey(store, entry); err != nil {
+		return err
+	}
+	SetRevision(store, entry.Key, entry.Index)
+	Resize(store, entry.Key)
+	return nil
+}
+
+func applyKey[T any](store Storage[T], entry WalEntry[T]) error {
+	switch entry.OpType {
+	case protocol.SET:
+		return store.Set(entry.Key, entry.Value)
+	case protocol.DELETE:
+		return store.Delete(entry.Key)
+	case protocol.SADD, protocol.SREM:
+		return applySetMembers(store, entry)
+	case protocol.SINTERSTORE, protocol.SUNIONSTORE, protocol.SDIFFSTORE:
+		return applySetStore(store, entry)
+	case protocol.ZADD:
+		return applyZAdd(store, entry)
+	case protocol.ZREM:
+		return applyZRem(store, entry)
+	case protocol.XADD:
+		return applyXAdd(store, entry)
+	case protocol.XTRIM:
+		return applyXTrim(store, entry)
+	case protocol.XGROUP:
+		return applyXGroup(store, entry)
+	case protocol.XREADGROUP, protocol.XCLAIM:
+		return applyXDeliver(store, entry)
+	case protocol.XACK:
+		return applyXAck(store, entry)
+	case protocol.LOCK:
+		return applyLock(store, entry)
+	case protocol.RENAME, protocol.COPY:
+		return applyCopy(store, entry)
+	case protocol.EXPIRE:
+		return applyExpire(store, entry)
+	case protocol.PERSIST:
+		expirable, err := getExpirable(store)
+		if err != nil {
+			return err
+		}
+		expirable.Persist(entry.Key)
+		return nil
+	default:
+		return fmt.Errorf("unknown operation type in WAL: %v", entry.OpType)
+	}
+}
+
+func applySetMembers[T any](store Storage[T], entry WalEntry[T]) error {
+	members, err := respToMembers(any(entry.Value))
+	if err != nil {
+		return err
+	}
+	set, err := GetSet(store, entry.Key)
+	if err != nil {
+		return err
+	}
+
+	if entry.OpType == protocol.SREM {
+		set.Remove(members...)
+		// Empty sets are never kept around
+		if len(set) == 0 {
+			return store.Delete(entry.Key)
+		}
+		return nil
+	}
+
+	if set == nil {
+		set = NewSet()
+	}
+	set.Add(members...)
+	return storeValue(store, entry.Key, set)
+}
+
+// applySetStore replaces destination with members carried by the entry.
+func applySetStore[T any](store Storage[T], entry WalEntry[T]) error {
+	members, err := respToMembers(any(entry.Value))
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return store.Delete(entry.Key)
+	}
+	return storeValue(store, entry.Key, NewSet(members...))
+}
+
+// applyZAdd sets absolute scores carried as [member, score, ...] pairs
+func applyZAdd[T any](store Storage[T], entry WalEntry[T]) error {
+	entries, err := respToZEntries(any(entry.Value))
+	if err != nil {
+		return err
+	}
+	zset, err := GetZSet(store, entry.Key)
+	if err != nil {
+		return err
+	}
+	if zset == nil {
+		zset = NewZSet()
+	}
+	for _, e := range entries {
+		zset.Set(e.Member, e.Score)
+	}
+	return storeValue(store, entry.Key, zset)
+}
+
+func applyZRem[T any](store Storage[T], entry WalEntry[T]) error {
+	members, err := respToMembers(any(entry.Value))
+	if err != nil {
+		return err
+	}
+	zset, err := GetZSet(store, entry.Key)
+	if err != nil || zset == nil {
+		return err
+	}
+	for _, m := range members {
+		zset.Remove(m)
+	}
+	if zset.Len() == 0 {
+		return store.Delete(entry.Key)
+	}
+	return nil
+}
+
+// applyXAdd appends [id, field, value, ...] creating the stream if needed
+func applyXAdd[T any](store Storage[T], entry WalEntry[T]) error {
+	args, err := respToMembers(any(entry.Value))
+	if err != nil || len(args) < 1 {
+		return fmt.Errorf("invalid XADD entry format")
+	}
+	id, err := protocol.ParseStreamID(args[0], 0)
+	if err != nil {
+		return err
+	}
+	stream, err := GetStream(store, entry.Key)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		stream = NewStream()
+	}
+	if err := stream.Append(StreamEntry{ID: id, Fields: args[1:]}); err != nil {
+		return err
+	}
+	return storeValue(store, entry.Key, stream)
+}
+
+func applyXTrim[T any](store Storage[T], entry WalEntry[T]) error {
+	trim, err := decodeStreamTrim(any(entry.Value))
+	if err != nil {
+		return err
+	}
+	stream, err := GetStream(store, entry.Key)
+	if err != nil || stream == nil {
+		return err
+	}
+	stream.Trim(trim)
+	return nil
+}
+
+// applyXGroup handles [CREATE, group, id, mkstream] and [DESTROY, group],
+// "$" is resolved to a concrete ID before the entry is logged.
+func applyXGroup[T any](store Storage[T], entry WalEntry[T]) error {
+	args, err := respToMembers(any(entry.Value))
+	if err != nil || len(args) < 2 {
+		return fmt.Errorf("invalid XGROUP entry format")
+	}
+	stream, err := GetStream(store, entry.Key)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "CREATE":
+		if len(args) != 4 {
+			return fmt.Errorf("invalid XGROUP entry format")
+		}
+		id, err := protocol.ParseStreamID(args[2], 0)
+		if err != nil {
+			return err
+		}
+		if stream == nil {
+			if args[3] != "1" {
+				return ErrNoGroup
+			}
+			stream = NewStream()
+			if err := storeValue(store, entry.Key, stream); err != nil {
+				return err
+			}
+		}
+		return stream.CreateGroup(args[1], id)
+	case "DESTROY":
+		if stream != nil {
+			stream.DestroyGroup(args[1])
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid XGROUP entry format: unknown subcommand %s", args[0])
+	}
+}
+
+// applyXDeliver handles XREADGROUP [group, consumer, timeMs, noack, ids...]
+// and XCLAIM [group, consumer, timeMs, justid, ids...] entries.
+func applyXDeliver[T any](store Storage[T], entry WalEntry[T]) error {
+	args, err := respToMembers(any(entry.Value))
+	if err != nil || len(args) < 4 {
+		return fmt.Errorf("invalid %v entry format", entry.OpType)
+	}
+	now, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return err
+	}
+	ids, err := parseEntryIDs(args[4:])
+	if err != nil {
+		return err
+	}
+	group, err := getGroup(store, entry.Key, args[0])
+	if err != nil {
+		return err
+	}
+	if entry.OpType == protocol.XCLAIM {
+		group.Claim(args[1], ids, now, args[3] == "1")
+	} else {
+		group.Deliver(args[1], ids, now, args[3] == "1")
+	}
+	return nil
+}
+
+// applyXAck handles [group, ids...]
+func applyXAck[T any](store Storage[T], entry WalEntry[T]) error {
+	args, err := respToMembers(any(entry.Value))
+	if err != nil || len(args) < 1 {
+		return fmt.Errorf("invalid XACK entry format")
+	}
+	ids, err := parseEntryIDs(args[1:])
+	if err != nil {
+		return err
+	}
+	group, err := getGroup(store, entry.Key, args[0])
+	if err != nil {
+		return err
+	}
+	group.Ack(ids)
+	return nil
+}
+
+func parseEntryIDs(args []string) ([]protocol.StreamID, error) {
+	ids := make([]protocol.StreamID, 0, len(args))
+	for _, arg := range args {
+		id, err := protocol.ParseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// applyCopy replaces destination in entry.Key with value and expiration of the source carried
+// by the entry. RENAME moves the value and deletes the source, COPY stores a deep copy.
+func applyCopy[T any](store Storage[T], entry WalEntry[T]) error {
+	source, ok := any(entry.Value).(protocol.Resp2BulkString)
+	if !ok {
+		return fmt.Errorf("invalid %v entry format: expected bulk string", entry.OpType)
+	}
+	value, found, err := store.Get(string(source))
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("invalid %v entry: source key %q does not exist", entry.OpType, source)
+	}
+	if entry.OpType == protocol.COPY {
+		if value, err = CopyValue(value); err != nil {
+			return err
+		}
+	}
+	expirable, _ := any(store).(Expirable)
+	var expireAt int64
+	var expires bool
+	if expirable != nil {
+		expireAt, expires = expirable.GetExpire(string(source))
+	}
+
+	// Destination is replaced as a whole, with its expiration and lease
+	if err := store.Delete(entry.Key); err != nil {
+		return err
+	}
+	if err := store.Set(entry.Key, value); err != nil {
+		return err
+	}
+	if expires {
+		if err := expirable.SetExpire(entry.Key, expireAt); err != nil {
+			return err
+		}
+	}
+	if entry.OpType == protocol.RENAME {
+		return store.Delete(string(source))
+	}
+	return nil
+}
+
+func getExpirable[T any](store Storage[T]) (Expirable, error) {
+	expirable, ok := any(store).(Expirable)
+	if !ok {
+		return nil, fmt.Errorf("storage %T does not support expiration", store)
+	}
+	return expirable, nil
+}
+
+// applyExpire sets deadline carried as unix milliseconds
+func applyExpire[T any](store Storage[T], entry WalEntry[T]) error {
+	expirable, err := getExpirable(store)
+	if err != nil {
+		return err
+	}
+	value, ok := any(entry.Value).(protocol.Resp2BulkString)
+	if !ok {
+		return fmt.Errorf("invalid EXPIRE entry format: expected bulk string")
+	}
+	atMs, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return err
+	}
+	return expirable.SetExpire(entry.Key, atMs)
+}
+
+// storeValue stores composite value in generic storage
+func storeValue[T any](store Storage[T], key string, value any) error {
+	tValue, ok := value.(T)
+	if !ok {
+		return fmt.Errorf("storage of type %T cannot hold %T", *new(T), value)
+	}
+	return store.Set(key, tValue)
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"fmt"
+	"main/src/protocol"
+	"time"
+)
+
+// Batch collects operations which are committed together, they are logged
+// as a single WAL record and applied all-or-nothing.
+// It is not safe for concurrent use.
+type Batch[T any] struct {
+	entries []WalEntry[T]
+}
+
+func NewBatch[T any]() *Batch[T] {
+	return &Batch[T]{}
+}
+
+func (b *Batch[T]) Set(key string, value T) *Batch[T] {
+	return b.Add(WalEntry[T]{OpType: protocol.SET, Key: key, Value: value})
+}
+
+func (b *Batch[T]) Delete(key string) *Batch[T] {
+	return b.Add(WalEntry[T]{OpType: protocol.DELETE, Key: key})
+}
+
+// Add appends any operation Apply handles, index of the entry is replaced by index of the batch
+func (b *Batch[T]) Add(entry WalEntry[T]) *Batch[T] {
+	b.entries = append(b.entries, entry)
+	return b
+}
+
+// Len returns number of collected operations
+func (b *Batch[T]) Len() int {
+	return len(b.entries)
+}
+
+// Entries returns collected operations in order they were added
+func (b *Batch[T]) Entries() []WalEntry[T] {
+	return b.entries
+}
+
+// Entry returns the entry logged for the batch, index and timestamp are left to the caller.
+// Several operations are grouped into a BATCH entry, a single one is logged as is.
+func (b *Batch[T]) Entry() (WalEntry[T], error) {
+	if len(b.entries) == 1 {
+		return b.entries[0], nil
+	}
+	return NewBatchEntry(b.entries)
+}
+
+// Commit applies the batch to the store as entry with the index and then logs it.
+// Nothing is logged when applying fails and nothing stays applied when the record
+// could not be written, so the store always matches what replaying the log rebuilds.
+func (b *Batch[T]) Commit(wal Wal[T], store Storage[T], index uint64) (WalEntry[T], error) {
+	entry, err := b.Entry()
+	if err != nil {
+		return WalEntry[T]{}, err
+	}
+	entry.Index = index
+	entry.Timestamp = time.Now().UnixMilli()
+	rollback := NewRollback(store)
+	if err := rollback.Apply(store, entry); err != nil {
+		return WalEntry[T]{}, err
+	}
+	if err := wal.Append(entry, true); err != nil {
+		if restoreErr := rollback.Restore(store); restoreErr != nil {
+			return WalEntry[T]{}, fmt.Errorf("%w, %v", err, restoreErr)
+		}
+		return WalEntry[T]{}, err
+	}
+	return entry, nil
+}
+
+// NewBatchEntry groups entries into a single BATCH entry, so they are written
+// to the WAL as one record and a crash never leaves only part of them applied.
+// Entries are encoded as [[OpType, Key, Value], ...].
+func NewBatchEntry[T any](entries []WalEntry[T]) (WalEntry[T], error) {
+	arr := make([]protocol.Resp2Value, 0, len(entries))
+	for _, e := range entries {
+		arr = append(arr, []protocol.Resp2Value{
+			protocol.Resp2Integer(e.OpType),
+			protocol.Resp2BulkString(e.Key),
+			any(e.Value),
+		})
+	}
+	value, ok := any(arr).(T)
+	if !ok {
+		return WalEntry[T]{}, fmt.Errorf("storage of type %T cannot hold batch entries", *new(T))
+	}
+	return WalEntry[T]{OpType: protocol.BATCH, Value: value}, nil
+}
+
+// DecodeBatch returns entries grouped by NewBatchEntry, they carry only OpType, Key and Value
+func DecodeBatch[T any](value T) ([]WalEntry[T], error) {
+	arr, ok := any(value).([]protocol.Resp2Value)
+	if !ok {
+		return nil, fmt.Errorf("invalid batch entry format: expected array")
+	}
+	entries := make([]WalEntry[T], 0, len(arr))
+	for _, v := range arr {
+		fields, ok := v.([]protocol.Resp2Value)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("invalid batch entry format: expected [OpType, Key, Value]")
+		}
+		opType, ok := fields[0].(protocol.Resp2Integer)
+		if !ok {
+			return nil, fmt.Errorf("invalid batch entry format: expected integer for OpType")
+		}
+		key, ok := fields[1].(protocol.Resp2BulkString)
+		if !ok {
+			return nil, fmt.Errorf("invalid batch entry format: expected bulk string for Key")
+		}
+		var tValue T
+		if fields[2] != nil {
+			tValue, ok = fields[2].(T)
+			if !ok {
+				return nil, fmt.Errorf("invalid batch entry format: expected value of type %T", *new(T))
+			}
+		}
+		entries = append(entries, WalEntry[T]{OpType: protocol.OpType(opType), Key: string(key), Value: tValue})
+	}
+	return entries, nil
+}
@@ -0,0 +1,66 @@
+package storage
+
+// Bloom filter of SSTable keys, lookups of missing keys skip tables
+// without reading their blocks. Positions are derived from KeyHash by double hashing.
+
+type bloom struct {
+	bits []byte
+	k    uint8 // number of probes
+}
+
+func newBloom(hashes []uint64, bitsPerKey int) bloom {
+	n := len(hashes) * bitsPerKey
+	if n < 64 {
+		n = 64
+	}
+	// k = ln2 * bits per key minimizes false positives
+	k := uint8(float64(bitsPerKey) * 0.69)
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+	b := bloom{bits: make([]byte, (n+7)/8), k: k}
+	for _, h := range hashes {
+		b.add(h)
+	}
+	return b
+}
+
+func (b bloom) add(hash uint64) {
+	m := uint64(len(b.bits)) * 8
+	h1, h2 := hash&0xffffffff, hash>>32|1
+	for i := uint64(0); i < uint64(b.k); i++ {
+		pos := (h1 + i*h2) % m
+		b.bits[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+// mayContain returns false only when the key was not added
+func (b bloom) mayContain(hash uint64) bool {
+	if len(b.bits) == 0 {
+		return true
+	}
+	m := uint64(len(b.bits)) * 8
+	h1, h2 := hash&0xffffffff, hash>>32|1
+	for i := uint64(0); i < uint64(b.k); i++ {
+		pos := (h1 + i*h2) % m
+		if b.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encode renders filter as [k, bits...]
+func (b bloom) encode() []byte {
+	return append([]byte{b.k}, b.bits...)
+}
+
+func decodeBloom(data []byte) bloom {
+	if len(data) == 0 {
+		return bloom{}
+	}
+	return bloom{k: data[0], bits: data[1:]}
+}
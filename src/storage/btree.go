@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+)
+
+// B-tree of keys used by OrderedStorage.
+// Every node except the root holds between btreeDegree-1 and 2*btreeDegree-1 keys,
+// nodes are split on the way down when inserting and refilled on the way down when
+// deleting, so both need a single pass. Layout follows CLRS.
+
+const btreeDegree = 32
+
+type btreeNode struct {
+	keys     []string
+	children []*btreeNode // empty for leaves, otherwise len(keys)+1
+}
+
+type btree struct {
+	root *btreeNode
+	size int
+}
+
+func (n *btreeNode) leaf() bool {
+	return len(n.children) == 0
+}
+
+// find returns index of the first key not less than key and whether it is equal
+func (n *btreeNode) find(key string) (int, bool) {
+	i := sort.SearchStrings(n.keys, key)
+	return i, i < len(n.keys) && n.keys[i] == key
+}
+
+// insert adds the key, returns false when it is already present
+func (t *btree) insert(key string) bool {
+	if t.root == nil {
+		t.root = &btreeNode{keys: []string{key}}
+		t.size++
+		return true
+	}
+	if len(t.root.keys) == 2*btreeDegree-1 {
+		t.root = &btreeNode{children: []*btreeNode{t.root}}
+		t.root.splitChild(0)
+	}
+	if !t.root.insert(key) {
+		return false
+	}
+	t.size++
+	return true
+}
+
+func (n *btreeNode) insert(key string) bool {
+	for {
+		i, found := n.find(key)
+		if found {
+			return false
+		}
+		if n.leaf() {
+			n.keys = slices.Insert(n.keys, i, key)
+			return true
+		}
+		if len(n.children[i].keys) == 2*btreeDegree-1 {
+			n.splitChild(i)
+			if key == n.keys[i] {
+				return false
+			}
+			if key > n.keys[i] {
+				i++
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+// splitChild splits full child i around its median key which moves up to n
+func (n *btreeNode) splitChild(i int) {
+	child := n.children[i]
+	mid := btreeDegree - 1
+	right := &btreeNode{keys: slices.Clone(child.keys[mid+1:])}
+	if !child.leaf() {
+		right.children = slices.Clone(child.children[mid+1:])
+		clear(child.children[mid+1:])
+		child.children = child.children[:mid+1]
+	}
+	median := child.keys[mid]
+	clear(child.keys[mid:])
+	child.keys = child.keys[:mid]
+	n.keys = slices.Insert(n.keys, i, median)
+	n.children = slices.Insert(n.children, i+1, right)
+}
+
+// delete removes the key, returns false when it is not present
+func (t *btree) delete(key string) bool {
+	if t.root == nil {
+		return false
+	}
+	removed := t.root.delete(key)
+	if len(t.root.keys) == 0 {
+		if t.root.leaf() {
+			t.root = nil
+		} else {
+			t.root = t.root.children[0]
+		}
+	}
+	if removed {
+		t.size--
+	}
+	return removed
+}
+
+// delete removes the key from the subtree, n has at least btreeDegree keys unless it is the root
+func (n *btreeNode) delete(key string) bool {
+	i, found := n.find(key)
+	if n.leaf() {
+		if found {
+			n.keys = slices.Delete(n.keys, i, i+1)
+		}
+		return found
+	}
+	if found {
+		// Key is replaced by its predecessor or successor, or the children around it are merged
+		switch {
+		case len(n.children[i].keys) >= btreeDegree:
+			n.keys[i] = n.children[i].max()
+			return n.children[i].delete(n.keys[i])
+		case len(n.children[i+1].keys) >= btreeDegree:
+			n.keys[i] = n.children[i+1].min()
+			return n.children[i+1].delete(n.keys[i])
+		default:
+			n.merge(i)
+			return n.children[i].delete(key)
+		}
+	}
+	if len(n.children[i].keys) < btreeDegree {
+		i = n.fill(i)
+	}
+	return n.children[i].delete(key)
+}
+
+// fill makes child i hold at least btreeDegree keys by borrowing from a sibling
+// or merging with it, returns index of the child which covers the same keys
+func (n *btreeNode) fill(i int) int {
+	switch {
+	case i > 0 && len(n.children[i-1].keys) >= btreeDegree:
+		n.borrowLeft(i)
+	case i < len(n.children)-1 && len(n.children[i+1].keys) >= btreeDegree:
+		n.borrowRight(i)
+	case i < len(n.children)-1:
+		n.merge(i)
+	default:
+		n.merge(i - 1)
+		return i - 1
+	}
+	return i
+}
+
+func (n *btreeNode) borrowLeft(i int) {
+	child, left := n.children[i], n.children[i-1]
+	child.keys = slices.Insert(child.keys, 0, n.keys[i-1])
+	n.keys[i-1] = left.keys[len(left.keys)-1]
+	left.keys = slices.Delete(left.keys, len(left.keys)-1, len(left.keys))
+	if !left.leaf() {
+		child.children = slices.Insert(child.children, 0, left.children[len(left.children)-1])
+		left.children = slices.Delete(left.children, len(left.children)-1, len(left.children))
+	}
+}
+
+func (n *btreeNode) borrowRight(i int) {
+	child, right := n.children[i], n.children[i+1]
+	child.keys = append(child.keys, n.keys[i])
+	n.keys[i] = right.keys[0]
+	right.keys = slices.Delete(right.keys, 0, 1)
+	if !right.leaf() {
+		child.children = append(child.children, right.children[0])
+		right.children = slices.Delete(right.children, 0, 1)
+	}
+}
+
+// merge moves key i and child i+1 into child i
+func (n *btreeNode) merge(i int) {
+	child, right := n.children[i], n.children[i+1]
+	child.keys = append(append(child.keys, n.keys[i]), right.keys...)
+	child.children = append(child.children, right.children...)
+	n.keys = slices.Delete(n.keys, i, i+1)
+	n.children = slices.Delete(n.children, i+1, i+2)
+}
+
+func (n *btreeNode) min() string {
+	for !n.leaf() {
+		n = n.children[0]
+	}
+	return n.keys[0]
+}
+
+func (n *btreeNode) max() string {
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n.keys[len(n.keys)-1]
+}
+
+// ascend calls fn for keys not less than from in ascending order until it returns false
+func (t *btree) ascend(from string, fn func(string) bool) {
+	if t.root != nil {
+		t.root.ascend(from, fn)
+	}
+}
+
+func (n *btreeNode) ascend(from string, fn func(string) bool) bool {
+	i, _ := n.find(from)
+	for ; i <= len(n.keys); i++ {
+		if !n.leaf() && !n.children[i].ascend(from, fn) {
+			return false
+		}
+		if i < len(n.keys) && !fn(n.keys[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// descend calls fn for keys less than before in descending order until it returns false,
+// all keys are visited when bounded is false
+func (t *btree) descend(before string, bounded bool, fn func(string) bool) {
+	if t.root != nil {
+		t.root.descend(before, bounded, fn)
+	}
+}
+
+func (n *btreeNode) descend(before string, bounded bool, fn func(string) bool) bool {
+	i := len(n.keys)
+	if bounded {
+		i, _ = n.find(before)
+	}
+	for ; i >= 0; i-- {
+		if !n.leaf() && !n.children[i].descend(before, bounded, fn) {
+			return false
+		}
+		if i > 0 && !fn(n.keys[i-1]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"fmt"
+	"main/src/protocol"
+)
+
+// Encoder is implemented by composite values (sets, sorted sets, ...)
+// which are not plain RESP2 values and need their own on disk representation.
+// Snapshot entries for them are written as [Key, Encode(), Kind()].
+type Encoder interface {
+	Kind() string
+	Encode() protocol.Resp2Value
+}
+
+// decodeValue restores composite value written by Encoder
+func decodeValue(kind string, payload protocol.Resp2Value) (protocol.Resp2Value, error) {
+	switch kind {
+	case "set":
+		return DecodeSet(payload)
+	case "zset":
+		return DecodeZSet(payload)
+	case "stream":
+		return DecodeStream(payload)
+	case "lock":
+		return DecodeLock(payload)
+	default:
+		return nil, fmt.Errorf("unknown value kind: %s", kind)
+	}
+}
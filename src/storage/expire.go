@@ -0,0 +1,50 @@
+package storage
+
+import "fmt"
+
+// Expirable is implemented by storages which keep per-key expiration deadlines.
+// Storage never drops expired keys by itself, they are removed with DELETE entries
+// written by the leader, so replaying the log does not depend on the clock.
+type Expirable interface {
+	// SetExpire sets deadline in unix milliseconds, key has to exist.
+	SetExpire(key string, atMs int64) error
+	// GetExpire returns deadline of the key, false if key does not expire.
+	GetExpire(key string) (int64, bool)
+	// Persist removes deadline, returns false if key had none.
+	Persist(key string) bool
+	// SampleExpires returns up to n keys having a deadline, in no particular order.
+	SampleExpires(n int) []string
+}
+
+func (s *InMemoryStorage[T]) SetExpire(key string, atMs int64) error {
+	if _, ok := s.data[key]; !ok {
+		return fmt.Errorf("cannot set expiration of missing key %q", key)
+	}
+	s.expires[key] = atMs
+	return nil
+}
+
+func (s *InMemoryStorage[T]) GetExpire(key string) (int64, bool) {
+	atMs, ok := s.expires[key]
+	return atMs, ok
+}
+
+func (s *InMemoryStorage[T]) Persist(key string) bool {
+	if _, ok := s.expires[key]; !ok {
+		return false
+	}
+	delete(s.expires, key)
+	return true
+}
+
+// SampleExpires relies on randomized map iteration order
+func (s *InMemoryStorage[T]) SampleExpires(n int) []string {
+	keys := make([]string, 0, n)
+	for key := range s.expires {
+		if len(keys) >= n {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,70 @@
+package storage
+
+// Indexed is implemented by storages which remember index of the last applied WAL entry.
+// It is written to snapshots, so numbering of entries continues after the log is rotated.
+type Indexed interface {
+	AppliedIndex() uint64
+	SetAppliedIndex(index uint64)
+}
+
+// AppliedIndex returns index of the last entry applied to the store, 0 when it is not tracked
+func AppliedIndex[T any](store Storage[T]) uint64 {
+	if indexed, ok := any(store).(Indexed); ok {
+		return indexed.AppliedIndex()
+	}
+	return 0
+}
+
+// SetAppliedIndex records index of an entry applied to the store, older indexes are ignored
+func SetAppliedIndex[T any](store Storage[T], index uint64) {
+	if indexed, ok := any(store).(Indexed); ok && index > indexed.AppliedIndex() {
+		indexed.SetAppliedIndex(index)
+	}
+}
+
+func (s *InMemoryStorage[T]) AppliedIndex() uint64 {
+	return s.applied
+}
+
+func (s *InMemoryStorage[T]) SetAppliedIndex(index uint64) {
+	s.applied = index
+}
+
+// Versioned is implemented by storages which remember modification revision of keys,
+// it is index of the last WAL entry which modified the key. Revisions are written
+// to snapshots and rebuilt when the log is replayed, so they survive restarts.
+type Versioned interface {
+	Revision(key string) uint64
+	SetRevision(key string, revision uint64)
+}
+
+// Revision returns modification revision of the key, 0 when the key does not exist or revisions are not tracked
+func Revision[T any](store Storage[T], key string) uint64 {
+	if versioned, ok := any(store).(Versioned); ok {
+		return versioned.Revision(key)
+	}
+	return 0
+}
+
+// SetRevision records modification of an existing key by entry with the index
+func SetRevision[T any](store Storage[T], key string, index uint64) {
+	versioned, ok := any(store).(Versioned)
+	if !ok {
+		return
+	}
+	if exists, _ := store.Exists(key); exists {
+		versioned.SetRevision(key, index)
+	}
+}
+
+func (s *InMemoryStorage[T]) Revision(key string) uint64 {
+	return s.revisions[key]
+}
+
+func (s *InMemoryStorage[T]) SetRevision(key string, revision uint64) {
+	if revision == 0 {
+		delete(s.revisions, key)
+		return
+	}
+	s.revisions[key] = revision
+}
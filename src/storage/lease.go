@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"main/src/protocol"
+	"slices"
+	"strconv"
+)
+
+// Lease is a session granted with time to live, keys attached to it are deleted
+// together when it expires or is revoked. ID is index of the WAL entry which granted it.
+type Lease struct {
+	ID       uint64
+	TTL      int64 // granted time to live in milliseconds
+	ExpireAt int64 // unix milliseconds
+	Keys     map[string]struct{}
+}
+
+// Leased is implemented by storages which keep a table of leases.
+// Like expired keys, expired leases are never revoked by the storage itself,
+// they are revoked by entries written by the leader, so replay does not depend on the clock.
+type Leased interface {
+	GrantLease(id uint64, ttl, expireAt int64)
+	RenewLease(id uint64, expireAt int64) error
+	// AttachLease attaches existing key to the lease, detaching it from any other lease.
+	AttachLease(id uint64, key string) error
+	// RevokeLease drops the lease, keys attached to it are detached but kept.
+	RevokeLease(id uint64)
+	GetLease(id uint64) *Lease
+	// KeyLease returns lease the key is attached to.
+	KeyLease(key string) (uint64, bool)
+	// Leases returns all leases ordered by ID.
+	Leases() []*Lease
+}
+
+// leaseTable keeps leases and the reverse mapping of keys to them for storages implementing Leased
+type leaseTable struct {
+	leases map[uint64]*Lease
+	leased map[string]uint64 // lease the key is attached to
+}
+
+func makeLeaseTable() leaseTable {
+	return leaseTable{leases: make(map[uint64]*Lease), leased: make(map[string]uint64)}
+}
+
+func (s *leaseTable) GrantLease(id uint64, ttl, expireAt int64) {
+	s.leases[id] = &Lease{ID: id, TTL: ttl, ExpireAt: expireAt, Keys: make(map[string]struct{})}
+}
+
+func (s *leaseTable) RenewLease(id uint64, expireAt int64) error {
+	lease, ok := s.leases[id]
+	if !ok {
+		return fmt.Errorf("cannot renew missing lease %d", id)
+	}
+	lease.ExpireAt = expireAt
+	return nil
+}
+
+func (s *InMemoryStorage[T]) AttachLease(id uint64, key string) error {
+	_, exists := s.data[key]
+	return s.attachLease(id, key, exists)
+}
+
+// attachLease attaches the key, storage tells whether the key exists
+func (s *leaseTable) attachLease(id uint64, key string, exists bool) error {
+	lease, ok := s.leases[id]
+	if !ok {
+		return fmt.Errorf("cannot attach key %q to missing lease %d", key, id)
+	}
+	if !exists {
+		return fmt.Errorf("cannot attach missing key %q to lease %d", key, id)
+	}
+	s.detachLease(key)
+	lease.Keys[key] = struct{}{}
+	s.leased[key] = id
+	return nil
+}
+
+func (s *leaseTable) RevokeLease(id uint64) {
+	lease, ok := s.leases[id]
+	if !ok {
+		return
+	}
+	for key := range lease.Keys {
+		delete(s.leased, key)
+	}
+	delete(s.leases, id)
+}
+
+func (s *leaseTable) GetLease(id uint64) *Lease {
+	return s.leases[id]
+}
+
+func (s *leaseTable) KeyLease(key string) (uint64, bool) {
+	id, ok := s.leased[key]
+	return id, ok
+}
+
+func (s *leaseTable) Leases() []*Lease {
+	leases := make([]*Lease, 0, len(s.leases))
+	for _, lease := range s.leases {
+		leases = append(leases, lease)
+	}
+	slices.SortFunc(leases, func(a, b *Lease) int { return cmp.Compare(a.ID, b.ID) })
+	return leases
+}
+
+// detachLease removes deleted or moved key from its lease
+func (s *leaseTable) detachLease(key string) {
+	id, ok := s.leased[key]
+	if !ok {
+		return
+	}
+	if lease, ok := s.leases[id]; ok {
+		delete(lease.Keys, key)
+	}
+	delete(s.leased, key)
+}
+
+// SortedKeys returns keys attached to the lease in order
+func (l *Lease) SortedKeys() []string {
+	keys := make([]string, 0, len(l.Keys))
+	for key := range l.Keys {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func getLeased[T any](store Storage[T]) (Leased, error) {
+	leased, ok := any(store).(Leased)
+	if !ok {
+		return nil, fmt.Errorf("storage %T does not support leases", store)
+	}
+	return leased, nil
+}
+
+// applyLease handles [GRANT, ttl, expireAt], [KEEPALIVE, id, expireAt], [ATTACH, id, keys...]
+// and [REVOKE, id] entries. Granted lease gets index of the entry as its ID, deadlines
+// are absolute so they are decided once by the leader. Attached keys are deleted by
+// DELETE entries logged together with REVOKE.
+func applyLease[T any](store Storage[T], entry WalEntry[T]) error {
+	leased, err := getLeased(store)
+	if err != nil {
+		return err
+	}
+	args, err := respToMembers(any(entry.Value))
+	if err != nil || len(args) < 2 {
+		return fmt.Errorf("invalid LEASE entry format")
+	}
+	switch {
+	case args[0] == "GRANT" && len(args) == 3:
+		values, err := ints(args[1:])
+		if err != nil {
+			return err
+		}
+		leased.GrantLease(entry.Index, values[0], values[1])
+		return nil
+	case args[0] == "KEEPALIVE" && len(args) == 3:
+		values, err := ints(args[1:])
+		if err != nil {
+			return err
+		}
+		return leased.RenewLease(uint64(values[0]), values[1])
+	case args[0] == "ATTACH":
+		values, err := ints(args[1:2])
+		if err != nil {
+			return err
+		}
+		for _, key := range args[2:] {
+			if err := leased.AttachLease(uint64(values[0]), key); err != nil {
+				return err
+			}
+		}
+		return nil
+	case args[0] == "REVOKE" && len(args) == 2:
+		values, err := ints(args[1:])
+		if err != nil {
+			return err
+		}
+		leased.RevokeLease(uint64(values[0]))
+		return nil
+	default:
+		return fmt.Errorf("invalid LEASE entry format: unknown subcommand %s", args[0])
+	}
+}
+
+func ints(args []string) ([]int64, error) {
+	values := make([]int64, len(args))
+	for i, arg := range args {
+		var err error
+		if values[i], err = strconv.ParseInt(arg, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid LEASE entry format: %w", err)
+		}
+	}
+	return values, nil
+}
+
+// encodeLease renders lease as a snapshot entry [lease, id, ttl, expireAt, keys...]
+func encodeLease(lease *Lease) protocol.Resp2Value {
+	arr := []protocol.Resp2Value{
+		protocol.Resp2SimpleString("lease"),
+		protocol.Resp2Integer(lease.ID),
+		protocol.Resp2Integer(lease.TTL),
+		protocol.Resp2Integer(lease.ExpireAt),
+	}
+	for _, key := range lease.SortedKeys() {
+		arr = append(arr, protocol.Resp2BulkString(key))
+	}
+	return arr
+}
+
+// loadLease restores lease written by encodeLease, its keys have to be loaded already
+func loadLease[T any](store Storage[T], arr []protocol.Resp2Value) error {
+	leased, err := getLeased(store)
+	if err != nil {
+		return err
+	}
+	if len(arr) < 4 {
+		return fmt.Errorf("invalid snapshot lease format: expected at least 4 elements, got %d", len(arr))
+	}
+	var values [3]int64
+	for i := range values {
+		value, ok := arr[i+1].(protocol.Resp2Integer)
+		if !ok {
+			return fmt.Errorf("invalid snapshot lease format: expected integer")
+		}
+		values[i] = int64(value)
+	}
+	leased.GrantLease(uint64(values[0]), values[1], values[2])
+	for _, v := range arr[4:] {
+		key, ok := v.(protocol.Resp2BulkString)
+		if !ok {
+			return fmt.Errorf("invalid snapshot lease format: expected bulk string for key")
+		}
+		if err := leased.AttachLease(uint64(values[0]), string(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
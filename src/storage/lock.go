@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"fmt"
+	"main/src/protocol"
+)
+
+// Lock is value of a key held as a distributed lock.
+// Token is index of the WAL entry which acquired the lock, so tokens of later
+// holders are always greater and can be used to fence off stale holders.
+type Lock struct {
+	Owner string
+	Token uint64
+}
+
+func (l *Lock) Kind() string {
+	return "lock"
+}
+
+func (l *Lock) Encode() protocol.Resp2Value {
+	return []protocol.Resp2Value{
+		protocol.Resp2BulkString(l.Owner),
+		protocol.Resp2Integer(l.Token),
+	}
+}
+
+func (l *Lock) MemoryUsage() int64 {
+	return int64(len(l.Owner)) + 8 + memberOverhead
+}
+
+func DecodeLock(value protocol.Resp2Value) (*Lock, error) {
+	arr, ok := value.([]protocol.Resp2Value)
+	if !ok || len(arr) != 2 {
+		return nil, fmt.Errorf("invalid lock format: expected [owner, token]")
+	}
+	owner, ok := arr[0].(protocol.Resp2BulkString)
+	if !ok {
+		return nil, fmt.Errorf("invalid lock format: expected bulk string for owner")
+	}
+	token, ok := arr[1].(protocol.Resp2Integer)
+	if !ok {
+		return nil, fmt.Errorf("invalid lock format: expected integer for token")
+	}
+	return &Lock{Owner: string(owner), Token: uint64(token)}, nil
+}
+
+func GetLock[T any](store Storage[T], key string) (*Lock, error) {
+	value, found, err := store.Get(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	lock, ok := any(value).(*Lock)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return lock, nil
+}
+
+// applyLock creates lock held by owner carried by the entry, token is index of the entry
+func applyLock[T any](store Storage[T], entry WalEntry[T]) error {
+	owner, ok := any(entry.Value).(protocol.Resp2BulkString)
+	if !ok {
+		return fmt.Errorf("invalid LOCK entry format: expected bulk string")
+	}
+	return storeValue(store, entry.Key, &Lock{Owner: string(owner), Token: entry.Index})
+}
@@ -0,0 +1,604 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"main/src/protocol"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// LSMStorage is a log-structured merge tree keeping data on disk, so datasets do not
+// have to fit into memory.
+//
+// Writes go to a memtable, which is made durable by the WAL the same way in-memory
+// storages are. When the WAL is rotated the memtable is frozen by Checkpoint and the
+// following Snapshot writes it into a level 0 SSTable, after which the rotated log is
+// not needed anymore. Tables are merged by leveled compaction: level 0 tables may overlap
+// and are merged into level 1 once there are too many of them, deeper levels hold
+// non-overlapping tables and are merged into the next level once they grow over their size.
+//
+// Modification revision is stored with every record. Expiration deadlines and leases
+// are kept in memory, they are written to the manifest together with the list of tables.
+//
+// Like other storages it must not be modified concurrently, reads may run concurrently
+// with each other and with Snapshot.
+type LSMStorage[T any] struct {
+	dir  string
+	opts LSMOptions
+
+	// memtable, entries applied since the last checkpoint
+	mem     map[string]*memRecord[T]
+	memKeys btree
+	expires map[string]int64 // unix milliseconds
+	applied uint64
+	leaseTable
+
+	// Composite values are modified in place by Apply, instances decoded from tables
+	// are kept until the memtable takes them, so Apply stores the same instance it modified.
+	// Apply always records revision of the key it modified, which moves the value into the memtable.
+	cacheMu sync.Mutex
+	loaded  map[string]T
+
+	mu      sync.RWMutex // guards current
+	current *lsmVersion[T]
+
+	// Flushes and compactions are serialized, tables are modified only by them
+	flushMu   sync.Mutex
+	nextFile  uint64
+	persisted *frozenTable         // metadata of the last flushed checkpoint
+	pointers  [lsmMaxLevels]string // largest key of the last table compacted out of the level
+}
+
+// LSMOptions tune sizes of LSMStorage files and levels
+type LSMOptions struct {
+	BlockSize  int   // target size of SSTable data blocks in bytes
+	TableSize  int64 // target size of SSTables written by compaction in bytes
+	L0Tables   int   // number of level 0 tables which triggers their compaction
+	LevelSize  int64 // max size of level 1 in bytes, every next level is 10 times larger
+	BitsPerKey int   // size of bloom filters
+}
+
+func DefaultLSMOptions() LSMOptions {
+	return LSMOptions{
+		BlockSize:  4 * 1024,
+		TableSize:  2 * 1024 * 1024,
+		L0Tables:   4,
+		LevelSize:  10 * 1024 * 1024,
+		BitsPerKey: 10,
+	}
+}
+
+const (
+	lsmMaxLevels   = 7
+	lsmLoadedLimit = 1024 // max number of cached composite values
+)
+
+// memRecord is a memtable entry, deleted keys are kept as tombstones
+// shadowing their older records in tables
+type memRecord[T any] struct {
+	value    T
+	revision uint64
+	deleted  bool
+}
+
+// frozenTable is a checkpointed memtable waiting for flush, together with
+// metadata of the storage at the checkpoint
+type frozenTable struct {
+	entries []tableEntry
+	applied uint64
+	meta    []protocol.Resp2Value // expirations and leases as manifest entries
+}
+
+// lsmVersion is a set of tables readers work with, tables are released
+// once the version is replaced and no reader holds it
+type lsmVersion[T any] struct {
+	frozen []*frozenTable // newest last
+	levels [][]*sstable   // level 0 newest last, deeper levels ordered by key
+	refs   atomic.Int32
+}
+
+// Records are encoded as [flag, uvarint revision, RESP value]
+const (
+	recordDeleted byte = iota
+	recordPlain
+	recordComposite // RESP value is [kind, payload] of an Encoder
+)
+
+func encodeRecord[T any](rec *memRecord[T]) ([]byte, error) {
+	if rec.deleted {
+		return []byte{recordDeleted, 0}, nil
+	}
+	flag := recordPlain
+	var value protocol.Resp2Value = rec.value
+	if enc, ok := value.(Encoder); ok {
+		flag = recordComposite
+		value = []protocol.Resp2Value{protocol.Resp2SimpleString(enc.Kind()), enc.Encode()}
+	}
+	payload, err := protocol.NewResp2Parser(nil, 0).Render(value)
+	if err != nil {
+		return nil, err
+	}
+	data := binary.AppendUvarint([]byte{flag}, rec.revision)
+	return append(data, payload...), nil
+}
+
+// recordRevision returns revision of encoded record, 0 for tombstones
+func recordRevision(data []byte) uint64 {
+	if len(data) == 0 || data[0] == recordDeleted {
+		return 0
+	}
+	revision, _ := binary.Uvarint(data[1:])
+	return revision
+}
+
+func recordDeletedFlag(data []byte) bool {
+	return len(data) == 0 || data[0] == recordDeleted
+}
+
+func decodeRecord[T any](data []byte) (T, error) {
+	var zero T
+	if recordDeletedFlag(data) {
+		return zero, nil
+	}
+	_, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return zero, errTableCorrupted
+	}
+	value, err := protocol.NewResp2ParserFromBytes(data[1+n:]).Parse()
+	if err != nil {
+		return zero, err
+	}
+	if data[0] == recordComposite {
+		arr, ok := value.([]protocol.Resp2Value)
+		if !ok || len(arr) != 2 {
+			return zero, errTableCorrupted
+		}
+		kind, ok := arr[0].(protocol.Resp2SimpleString)
+		if !ok {
+			return zero, errTableCorrupted
+		}
+		if value, err = decodeValue(string(kind), arr[1]); err != nil {
+			return zero, err
+		}
+	}
+	if value == nil {
+		return zero, nil
+	}
+	if tValue, ok := value.(T); ok {
+		return tValue, nil
+	}
+	// Plain strings are rendered as RESP strings
+	switch s := value.(type) {
+	case protocol.Resp2SimpleString:
+		if tValue, ok := any(string(s)).(T); ok {
+			return tValue, nil
+		}
+	case protocol.Resp2BulkString:
+		if tValue, ok := any(string(s)).(T); ok {
+			return tValue, nil
+		}
+	}
+	return zero, fmt.Errorf("invalid record format: expected value of type %T", zero)
+}
+
+func (s *LSMStorage[T]) Get(key string) (T, bool, error) {
+	var zero T
+	if rec, ok := s.mem[key]; ok {
+		return rec.value, !rec.deleted, nil
+	}
+	s.cacheMu.Lock()
+	value, ok := s.loaded[key]
+	s.cacheMu.Unlock()
+	if ok {
+		return value, true, nil
+	}
+
+	data, found, err := s.find(key)
+	if err != nil || !found || recordDeletedFlag(data) {
+		return zero, false, err
+	}
+	if value, err = decodeRecord[T](data); err != nil {
+		return zero, false, fmt.Errorf("invalid record of key %q: %w", key, err)
+	}
+	if data[0] == recordComposite {
+		s.cacheMu.Lock()
+		if len(s.loaded) >= lsmLoadedLimit {
+			s.loaded = make(map[string]T)
+		}
+		if cached, ok := s.loaded[key]; ok {
+			// Concurrent reader decoded it first
+			value = cached
+		} else {
+			s.loaded[key] = value
+		}
+		s.cacheMu.Unlock()
+	}
+	return value, true, nil
+}
+
+func (s *LSMStorage[T]) Set(key string, value T) error {
+	// Apply records the revision right after, overwritten memtable entry keeps it meanwhile
+	var revision uint64
+	if rec, ok := s.mem[key]; ok {
+		revision = rec.revision
+	}
+	s.put(key, &memRecord[T]{value: value, revision: revision})
+	return nil
+}
+
+func (s *LSMStorage[T]) Delete(key string) error {
+	s.put(key, &memRecord[T]{deleted: true})
+	delete(s.expires, key)
+	s.detachLease(key)
+	return nil
+}
+
+func (s *LSMStorage[T]) put(key string, rec *memRecord[T]) {
+	s.mem[key] = rec
+	s.memKeys.insert(key)
+	s.cacheMu.Lock()
+	delete(s.loaded, key)
+	s.cacheMu.Unlock()
+}
+
+func (s *LSMStorage[T]) Exists(key string) (bool, error) {
+	if rec, ok := s.mem[key]; ok {
+		return !rec.deleted, nil
+	}
+	data, found, err := s.find(key)
+	return found && !recordDeletedFlag(data), err
+}
+
+// find returns the newest encoded record of the key in frozen memtables and tables
+func (s *LSMStorage[T]) find(key string) ([]byte, bool, error) {
+	v := s.acquire()
+	defer s.release(v)
+
+	for i := len(v.frozen) - 1; i >= 0; i-- {
+		entries := v.frozen[i].entries
+		j := sort.Search(len(entries), func(j int) bool { return entries[j].key >= key })
+		if j < len(entries) && entries[j].key == key {
+			return entries[j].value, true, nil
+		}
+	}
+	for i := len(v.levels[0]) - 1; i >= 0; i-- {
+		if data, found, err := v.levels[0][i].get(key); err != nil || found {
+			return data, found, err
+		}
+	}
+	for _, tables := range v.levels[1:] {
+		// Tables do not overlap, only one may hold the key
+		i := sort.Search(len(tables), func(i int) bool { return tables[i].largest >= key })
+		if i == len(tables) {
+			continue
+		}
+		if data, found, err := tables[i].get(key); err != nil || found {
+			return data, found, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *LSMStorage[T]) Iterator() func(func(string, T) bool) {
+	return s.Range("", "")
+}
+
+func (s *LSMStorage[T]) Range(start, end string) func(func(string, T) bool) {
+	return s.iterate(start, end, false)
+}
+
+func (s *LSMStorage[T]) Seek(key string) func(func(string, T) bool) {
+	return s.Range(key, "")
+}
+
+func (s *LSMStorage[T]) Reverse(start, end string) func(func(string, T) bool) {
+	return s.iterate(start, end, true)
+}
+
+// iterate merges the memtable, frozen memtables and tables, the newest record of a key wins
+func (s *LSMStorage[T]) iterate(start, end string, reverse bool) func(func(string, T) bool) {
+	return func(yield func(string, T) bool) {
+		v := s.acquire()
+		defer s.release(v)
+
+		cursors := []lsmCursor[T]{s.memCursor(start, end, reverse)}
+		for i := len(v.frozen) - 1; i >= 0; i-- {
+			entries := make([]lsmEntry[T], len(v.frozen[i].entries))
+			for j, e := range v.frozen[i].entries {
+				entries[j] = lsmEntry[T]{key: e.key, raw: e.value}
+			}
+			cursors = append(cursors, newSliceCursor(entries, start, end, reverse))
+		}
+		for level, tables := range v.levels {
+			if level == 0 {
+				for i := len(tables) - 1; i >= 0; i-- {
+					cursors = append(cursors, newTableCursor[T](tables[i], start, end, reverse))
+				}
+			} else if len(tables) > 0 {
+				cursors = append(cursors, newLevelCursor[T](tables, start, end, reverse))
+			}
+		}
+
+		mergeCursors(cursors, reverse, func(key string, rec *memRecord[T], raw []byte) bool {
+			if (reverse && key < start) || (!reverse && end != "" && key >= end) {
+				return false
+			}
+			if rec != nil {
+				if rec.deleted {
+					return true
+				}
+				return yield(key, rec.value)
+			}
+			if recordDeletedFlag(raw) {
+				return true
+			}
+			value, err := decodeRecord[T](raw)
+			if err != nil {
+				panic(fmt.Errorf("invalid record of key %q: %w", key, err))
+			}
+			return yield(key, value)
+		})
+	}
+}
+
+// memCursor collects memtable entries of the range, memtable is bounded by the WAL size
+func (s *LSMStorage[T]) memCursor(start, end string, reverse bool) lsmCursor[T] {
+	var entries []lsmEntry[T]
+	s.memKeys.ascend(start, func(key string) bool {
+		if end != "" && key >= end {
+			return false
+		}
+		entries = append(entries, lsmEntry[T]{key: key, rec: s.mem[key]})
+		return true
+	})
+	return newSliceCursor(entries, start, end, reverse)
+}
+
+func (s *LSMStorage[T]) SetExpire(key string, atMs int64) error {
+	if exists, err := s.Exists(key); err != nil || !exists {
+		return fmt.Errorf("cannot set expiration of missing key %q", key)
+	}
+	s.expires[key] = atMs
+	return nil
+}
+
+func (s *LSMStorage[T]) GetExpire(key string) (int64, bool) {
+	atMs, ok := s.expires[key]
+	return atMs, ok
+}
+
+func (s *LSMStorage[T]) Persist(key string) bool {
+	if _, ok := s.expires[key]; !ok {
+		return false
+	}
+	delete(s.expires, key)
+	return true
+}
+
+// SampleExpires relies on randomized map iteration order
+func (s *LSMStorage[T]) SampleExpires(n int) []string {
+	keys := make([]string, 0, n)
+	for key := range s.expires {
+		if len(keys) >= n {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *LSMStorage[T]) Revision(key string) uint64 {
+	if rec, ok := s.mem[key]; ok {
+		return rec.revision
+	}
+	data, found, err := s.find(key)
+	if err != nil || !found {
+		return 0
+	}
+	return recordRevision(data)
+}
+
+// SetRevision stores the key with the revision in the memtable
+func (s *LSMStorage[T]) SetRevision(key string, revision uint64) {
+	if rec, ok := s.mem[key]; ok {
+		if !rec.deleted {
+			rec.revision = revision
+		}
+		return
+	}
+	value, found, err := s.Get(key)
+	if err != nil || !found {
+		return
+	}
+	s.put(key, &memRecord[T]{value: value, revision: revision})
+}
+
+func (s *LSMStorage[T]) AppliedIndex() uint64 {
+	return s.applied
+}
+
+func (s *LSMStorage[T]) SetAppliedIndex(index uint64) {
+	s.applied = index
+}
+
+func (s *LSMStorage[T]) AttachLease(id uint64, key string) error {
+	exists, err := s.Exists(key)
+	if err != nil {
+		return err
+	}
+	return s.attachLease(id, key, exists)
+}
+
+func (s *LSMStorage[T]) acquire() *lsmVersion[T] {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v := s.current
+	v.refs.Add(1)
+	return v
+}
+
+func (s *LSMStorage[T]) release(v *lsmVersion[T]) {
+	if v.refs.Add(-1) > 0 {
+		return
+	}
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			t.unref()
+		}
+	}
+}
+
+// update replaces the current version with the one returned by fn
+func (s *LSMStorage[T]) update(fn func(frozen []*frozenTable, levels [][]*sstable) ([]*frozenTable, [][]*sstable)) {
+	s.mu.Lock()
+	old := s.current
+	v := &lsmVersion[T]{}
+	v.frozen, v.levels = fn(old.frozen, old.levels)
+	v.refs.Store(1)
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			t.refs.Add(1)
+		}
+	}
+	s.current = v
+	s.mu.Unlock()
+	s.release(old)
+}
+
+// lsmEntry is an entry returned by a cursor, either a memtable record or an encoded one
+type lsmEntry[T any] struct {
+	key string
+	rec *memRecord[T]
+	raw []byte
+}
+
+// lsmCursor walks sorted entries of a single source in the direction it was created with
+type lsmCursor[T any] interface {
+	valid() bool
+	key() string
+	record() (*memRecord[T], []byte)
+	next()
+}
+
+type sliceCursor[T any] struct {
+	entries []lsmEntry[T]
+	pos     int
+	reverse bool
+}
+
+func newSliceCursor[T any](entries []lsmEntry[T], start, end string, reverse bool) *sliceCursor[T] {
+	c := &sliceCursor[T]{entries: entries, reverse: reverse}
+	if !reverse {
+		c.pos = sort.Search(len(entries), func(i int) bool { return entries[i].key >= start })
+	} else if end == "" {
+		c.pos = len(entries) - 1
+	} else {
+		c.pos = sort.Search(len(entries), func(i int) bool { return entries[i].key >= end }) - 1
+	}
+	return c
+}
+
+func (c *sliceCursor[T]) valid() bool {
+	return c.pos >= 0 && c.pos < len(c.entries)
+}
+
+func (c *sliceCursor[T]) key() string {
+	return c.entries[c.pos].key
+}
+
+func (c *sliceCursor[T]) record() (*memRecord[T], []byte) {
+	return c.entries[c.pos].rec, c.entries[c.pos].raw
+}
+
+func (c *sliceCursor[T]) next() {
+	if c.reverse {
+		c.pos--
+	} else {
+		c.pos++
+	}
+}
+
+// levelCursor walks non-overlapping tables of a level one after another
+type levelCursor[T any] struct {
+	tables     []*sstable
+	i          int
+	start, end string
+	reverse    bool
+	*tableCursor[T]
+}
+
+func newLevelCursor[T any](tables []*sstable, start, end string, reverse bool) *levelCursor[T] {
+	c := &levelCursor[T]{tables: tables, start: start, end: end, reverse: reverse}
+	if !reverse {
+		c.i = sort.Search(len(tables), func(i int) bool { return tables[i].largest >= start })
+	} else if end == "" {
+		c.i = len(tables) - 1
+	} else {
+		c.i = sort.Search(len(tables), func(i int) bool { return tables[i].smallest >= end }) - 1
+	}
+	c.open()
+	return c
+}
+
+// open positions at the current table, skipping tables without entries in the range
+func (c *levelCursor[T]) open() {
+	for c.i >= 0 && c.i < len(c.tables) {
+		c.tableCursor = newTableCursor[T](c.tables[c.i], c.start, c.end, c.reverse)
+		if c.tableCursor.valid() {
+			return
+		}
+		c.step()
+	}
+	c.tableCursor = nil
+}
+
+func (c *levelCursor[T]) step() {
+	if c.reverse {
+		c.i--
+	} else {
+		c.i++
+	}
+}
+
+func (c *levelCursor[T]) valid() bool {
+	return c.tableCursor != nil && c.tableCursor.valid()
+}
+
+func (c *levelCursor[T]) next() {
+	c.tableCursor.next()
+	if !c.tableCursor.valid() {
+		c.step()
+		c.open()
+	}
+}
+
+// mergeCursors yields entries of all cursors in order, for keys present in several
+// cursors only the entry of the first of them is yielded
+func mergeCursors[T any](cursors []lsmCursor[T], reverse bool, yield func(string, *memRecord[T], []byte) bool) {
+	for {
+		best := -1
+		for i, c := range cursors {
+			if !c.valid() {
+				continue
+			}
+			if best < 0 || (!reverse && c.key() < cursors[best].key()) || (reverse && c.key() > cursors[best].key()) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return
+		}
+		key := cursors[best].key()
+		rec, raw := cursors[best].record()
+		for _, c := range cursors {
+			if c.valid() && c.key() == key {
+				c.next()
+			}
+		}
+		if !yield(key, rec, raw) {
+			return
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"main/src/protocol"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Files of LSMStorage.
+// Tables are named by increasing numbers, the manifest lists tables of every level
+// and metadata of the last flushed checkpoint. It is replaced atomically after tables
+// are written, files it does not list are leftovers of interrupted flushes and are removed on open.
+//
+// Manifest is a sequence of RESP values, index of the last flushed entry as a single integer followed by
+// [next, file number], [level, level, file numbers...], [expire, key, deadline] and snapshot lease entries.
+
+const lsmManifest = "MANIFEST"
+
+// OpenLSMStorage opens storage in the directory, creating it if needed
+func OpenLSMStorage[T any](dir string, opts LSMOptions) (*LSMStorage[T], error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &LSMStorage[T]{
+		dir:        dir,
+		opts:       opts,
+		mem:        make(map[string]*memRecord[T]),
+		expires:    make(map[string]int64),
+		leaseTable: makeLeaseTable(),
+		loaded:     make(map[string]T),
+		nextFile:   1,
+		persisted:  &frozenTable{},
+	}
+	levels := make([][]*sstable, lsmMaxLevels)
+	s.current = &lsmVersion[T]{levels: levels}
+	s.current.refs.Store(1)
+
+	leases, err := s.loadManifest(levels)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	for _, arr := range leases {
+		if err := loadLease(Storage[T](s), arr); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	s.persisted = &frozenTable{applied: s.applied, meta: s.encodeMeta()}
+
+	// Tables of interrupted flushes and compactions
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	listed := make(map[string]bool)
+	for _, tables := range levels {
+		for _, t := range tables {
+			listed[filepath.Base(t.path)] = true
+		}
+	}
+	for _, f := range files {
+		if (strings.HasSuffix(f.Name(), ".sst") && !listed[f.Name()]) || f.Name() == lsmManifest+".tmp" {
+			os.Remove(filepath.Join(dir, f.Name()))
+		}
+	}
+	return s, nil
+}
+
+// loadManifest opens tables listed in the manifest into levels, leases are returned
+// to be loaded once tables are open, as their keys have to exist
+func (s *LSMStorage[T]) loadManifest(levels [][]*sstable) ([][]protocol.Resp2Value, error) {
+	fd, err := os.Open(filepath.Join(s.dir, lsmManifest))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var leases [][]protocol.Resp2Value
+	parser := protocol.NewResp2Parser(fd, 0)
+	for {
+		val, err := parser.Parse()
+		if err == io.EOF {
+			return leases, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if index, ok := val.(protocol.Resp2Integer); ok {
+			s.applied = uint64(index)
+			continue
+		}
+		arr, ok := val.([]protocol.Resp2Value)
+		if !ok || len(arr) == 0 {
+			return nil, fmt.Errorf("invalid manifest entry format: expected array")
+		}
+		kind, _ := arr[0].(protocol.Resp2SimpleString)
+		switch {
+		case kind == "lease":
+			leases = append(leases, arr)
+		case kind == "next" && len(arr) == 2:
+			next, ok := arr[1].(protocol.Resp2Integer)
+			if !ok {
+				return nil, fmt.Errorf("invalid manifest entry format: expected integer for next file")
+			}
+			s.nextFile = uint64(next)
+		case kind == "level" && len(arr) >= 2:
+			level, ok := arr[1].(protocol.Resp2Integer)
+			if !ok || level < 0 || level >= lsmMaxLevels {
+				return nil, fmt.Errorf("invalid manifest entry format: expected level number")
+			}
+			for _, v := range arr[2:] {
+				file, ok := v.(protocol.Resp2Integer)
+				if !ok {
+					return nil, fmt.Errorf("invalid manifest entry format: expected integer for file")
+				}
+				t, err := openTable(s.tablePath(uint64(file)), uint64(file))
+				if err != nil {
+					return nil, err
+				}
+				t.refs.Store(1)
+				levels[level] = append(levels[level], t)
+			}
+		case kind == "expire" && len(arr) == 3:
+			key, ok := arr[1].(protocol.Resp2BulkString)
+			atMs, ok2 := arr[2].(protocol.Resp2Integer)
+			if !ok || !ok2 {
+				return nil, fmt.Errorf("invalid manifest entry format: expected key and deadline")
+			}
+			s.expires[string(key)] = int64(atMs)
+		default:
+			return nil, fmt.Errorf("invalid manifest entry format: unknown entry %v", arr[0])
+		}
+	}
+}
+
+func (s *LSMStorage[T]) tablePath(file uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%06d.sst", file))
+}
+
+// encodeMeta renders expirations and leases as manifest entries
+func (s *LSMStorage[T]) encodeMeta() []protocol.Resp2Value {
+	keys := make([]string, 0, len(s.expires))
+	for key := range s.expires {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	meta := make([]protocol.Resp2Value, 0, len(keys)+len(s.leases))
+	for _, key := range keys {
+		meta = append(meta, []protocol.Resp2Value{
+			protocol.Resp2SimpleString("expire"),
+			protocol.Resp2BulkString(key),
+			protocol.Resp2Integer(s.expires[key]),
+		})
+	}
+	for _, lease := range s.Leases() {
+		meta = append(meta, encodeLease(lease))
+	}
+	return meta
+}
+
+// writeManifest replaces the manifest with levels and metadata of the last flushed checkpoint
+func (s *LSMStorage[T]) writeManifest(levels [][]*sstable) error {
+	entries := []protocol.Resp2Value{
+		protocol.Resp2Integer(s.persisted.applied),
+		[]protocol.Resp2Value{protocol.Resp2SimpleString("next"), protocol.Resp2Integer(s.nextFile)},
+	}
+	for level, tables := range levels {
+		if len(tables) == 0 {
+			continue
+		}
+		arr := []protocol.Resp2Value{protocol.Resp2SimpleString("level"), protocol.Resp2Integer(level)}
+		for _, t := range tables {
+			arr = append(arr, protocol.Resp2Integer(t.file))
+		}
+		entries = append(entries, arr)
+	}
+	entries = append(entries, s.persisted.meta...)
+
+	parser := protocol.NewResp2Parser(nil, 0)
+	var data []byte
+	for _, e := range entries {
+		payload, err := parser.Render(e)
+		if err != nil {
+			return err
+		}
+		data = append(data, payload...)
+	}
+
+	path := filepath.Join(s.dir, lsmManifest)
+	fd, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Checkpoint freezes the memtable together with metadata, the following Snapshot writes it to disk.
+// It is called when the WAL is rotated, so the frozen memtable holds exactly entries of the rotated log.
+func (s *LSMStorage[T]) Checkpoint() error {
+	frozen := &frozenTable{entries: make([]tableEntry, 0, len(s.mem)), applied: s.applied, meta: s.encodeMeta()}
+	var err error
+	s.memKeys.ascend("", func(key string) bool {
+		var data []byte
+		if data, err = encodeRecord(s.mem[key]); err != nil {
+			err = fmt.Errorf("cannot encode key %q: %w", key, err)
+			return false
+		}
+		frozen.entries = append(frozen.entries, tableEntry{key: key, value: data})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	s.update(func(f []*frozenTable, levels [][]*sstable) ([]*frozenTable, [][]*sstable) {
+		return append(slices.Clip(f), frozen), levels
+	})
+	s.mem = make(map[string]*memRecord[T])
+	s.memKeys = btree{}
+	s.cacheMu.Lock()
+	s.loaded = make(map[string]T)
+	s.cacheMu.Unlock()
+	return nil
+}
+
+// LoadSnapshot returns the storage itself, it is already loaded from its directory.
+// Together with Snapshot it lets LSMStorage replace snapshots of in-memory storages.
+func (s *LSMStorage[T]) LoadSnapshot() (Storage[T], error) {
+	return s, nil
+}
+
+// Snapshot writes checkpointed memtables into level 0 and compacts levels.
+// Entries of the rotated log are already frozen by Checkpoint, so the log is not read.
+func (s *LSMStorage[T]) Snapshot(Wal[T]) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	for {
+		v := s.acquire()
+		var frozen *frozenTable
+		if len(v.frozen) > 0 {
+			frozen = v.frozen[0]
+		}
+		levels := v.levels
+		s.release(v)
+		if frozen == nil {
+			break
+		}
+		if err := s.flush(frozen, levels); err != nil {
+			return err
+		}
+	}
+	return s.compact()
+}
+
+// flush writes the oldest frozen memtable as a level 0 table
+func (s *LSMStorage[T]) flush(frozen *frozenTable, levels [][]*sstable) error {
+	levels = slices.Clone(levels)
+	if len(frozen.entries) > 0 {
+		w, err := s.createTable()
+		if err != nil {
+			return err
+		}
+		for _, e := range frozen.entries {
+			if err := w.add(e.key, e.value); err != nil {
+				w.abort()
+				return err
+			}
+		}
+		t, err := s.finishTable(w)
+		if err != nil {
+			return err
+		}
+		levels[0] = append(slices.Clip(levels[0]), t)
+	}
+
+	previous := s.persisted
+	s.persisted = frozen
+	if err := s.writeManifest(levels); err != nil {
+		s.persisted = previous
+		return err
+	}
+	s.update(func(f []*frozenTable, _ [][]*sstable) ([]*frozenTable, [][]*sstable) {
+		// Checkpoints only append, so the flushed memtable is still the first
+		return f[1:], levels
+	})
+	return nil
+}
+
+func (s *LSMStorage[T]) createTable() (*tableWriter, error) {
+	file := s.nextFile
+	s.nextFile++
+	return createTable(s.tablePath(file), file, s.opts.BlockSize, s.opts.BitsPerKey)
+}
+
+func (s *LSMStorage[T]) finishTable(w *tableWriter) (*sstable, error) {
+	if err := w.finish(); err != nil {
+		w.abort()
+		return nil, err
+	}
+	return openTable(w.path, w.file)
+}
+
+// maxLevelSize returns size of the level which triggers its compaction
+func (s *LSMStorage[T]) maxLevelSize(level int) int64 {
+	size := s.opts.LevelSize
+	for i := 1; i < level; i++ {
+		size *= 10
+	}
+	return size
+}
+
+// compact merges levels until none of them is over its limit
+func (s *LSMStorage[T]) compact() error {
+	for {
+		v := s.acquire()
+		levels := v.levels
+		s.release(v)
+
+		level, inputs := s.pickCompaction(levels)
+		if inputs == nil {
+			return nil
+		}
+		if err := s.compactLevel(levels, level, inputs); err != nil {
+			return err
+		}
+	}
+}
+
+// pickCompaction returns level to compact and its tables to merge into the next level.
+// Tables of a level are picked round-robin, so the whole key range is compacted over time.
+func (s *LSMStorage[T]) pickCompaction(levels [][]*sstable) (int, []*sstable) {
+	if len(levels[0]) >= s.opts.L0Tables {
+		return 0, levels[0]
+	}
+	for level := 1; level < lsmMaxLevels-1; level++ {
+		var size int64
+		for _, t := range levels[level] {
+			size += t.size
+		}
+		if size <= s.maxLevelSize(level) {
+			continue
+		}
+		tables := levels[level]
+		i := slices.IndexFunc(tables, func(t *sstable) bool { return t.smallest > s.pointers[level] })
+		if i < 0 {
+			i = 0
+		}
+		s.pointers[level] = tables[i].largest
+		return level, tables[i : i+1]
+	}
+	return 0, nil
+}
+
+// compactLevel merges inputs of the level with overlapping tables of the next level
+func (s *LSMStorage[T]) compactLevel(levels [][]*sstable, level int, inputs []*sstable) error {
+	smallest, largest := inputs[0].smallest, inputs[0].largest
+	for _, t := range inputs[1:] {
+		smallest, largest = min(smallest, t.smallest), max(largest, t.largest)
+	}
+	var overlapping, kept []*sstable
+	for _, t := range levels[level+1] {
+		if t.overlaps(smallest, largest) {
+			overlapping = append(overlapping, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+	// Tombstones shadow nothing once there are no older tables below
+	bottom := true
+	for _, tables := range levels[level+2:] {
+		bottom = bottom && len(tables) == 0
+	}
+
+	var cursors []lsmCursor[T]
+	for i := len(inputs) - 1; i >= 0; i-- {
+		cursors = append(cursors, newTableCursor[T](inputs[i], "", "", false))
+	}
+	if len(overlapping) > 0 {
+		cursors = append(cursors, newLevelCursor[T](overlapping, "", "", false))
+	}
+
+	var outputs []*sstable
+	var w *tableWriter
+	var err error
+	finish := func() error {
+		if w == nil {
+			return nil
+		}
+		t, err := s.finishTable(w)
+		w = nil
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, t)
+		return nil
+	}
+	mergeCursors(cursors, false, func(key string, _ *memRecord[T], raw []byte) bool {
+		if bottom && recordDeletedFlag(raw) {
+			return true
+		}
+		if w == nil {
+			if w, err = s.createTable(); err != nil {
+				return false
+			}
+		}
+		if err = w.add(key, raw); err != nil {
+			w.abort()
+			w = nil
+			return false
+		}
+		if int64(w.size()) >= s.opts.TableSize {
+			err = finish()
+		}
+		return err == nil
+	})
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		if w != nil {
+			w.abort()
+		}
+		for _, t := range outputs {
+			t.obsolete.Store(true)
+			t.refs.Store(1)
+			t.unref()
+		}
+		return err
+	}
+
+	next := slices.Clone(levels)
+	if level == 0 {
+		next[0] = nil
+	} else {
+		next[level] = slices.DeleteFunc(slices.Clone(levels[level]), func(t *sstable) bool { return slices.Contains(inputs, t) })
+	}
+	next[level+1] = append(kept, outputs...)
+	slices.SortFunc(next[level+1], func(a, b *sstable) int { return strings.Compare(a.smallest, b.smallest) })
+	if err := s.writeManifest(next); err != nil {
+		for _, t := range outputs {
+			t.obsolete.Store(true)
+			t.refs.Store(1)
+			t.unref()
+		}
+		return err
+	}
+	for _, t := range append(slices.Clone(inputs), overlapping...) {
+		t.obsolete.Store(true)
+	}
+	s.update(func(f []*frozenTable, _ [][]*sstable) ([]*frozenTable, [][]*sstable) {
+		return f, next
+	})
+	return nil
+}
+
+// Close releases files of the storage, it must not be used afterwards
+func (s *LSMStorage[T]) Close() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.release(s.current)
+	return nil
+}
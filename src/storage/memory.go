@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"main/src/protocol"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// Memory accounting of storages.
+// Sizes are estimates in bytes: plain values are measured exactly, composite values
+// extrapolate from a few sampled members like redis MEMORY USAGE does, so accounting
+// stays O(1) however large a set or a stream grows.
+
+const (
+	keyOverhead    = 64 // map entry, key header and metadata kept for every key
+	memberOverhead = 16 // map or slice entry of a member of a composite value
+	memorySamples  = 5  // members sampled to estimate size of a composite value
+
+	lfuInitCounter = 5     // counter of a new key, so it is not evicted right away
+	lfuLogFactor   = 10    // higher factor needs more accesses to increment the counter
+	lfuDecayMs     = 60000 // counter is decremented by one for every idle minute
+)
+
+// Sizer is implemented by composite values which estimate memory they hold
+type Sizer interface {
+	MemoryUsage() int64
+}
+
+// Evictable is implemented by storages which account memory used by keys
+// and track accesses of keys, so keys can be evicted once memory runs out.
+type Evictable interface {
+	// UsedMemory returns estimated number of bytes held by all keys and values.
+	UsedMemory() int64
+	// KeyMemory returns bytes accounted to the key, 0 when it does not exist.
+	KeyMemory(key string) int64
+	// Resize recomputes memory of the key after its value was modified in place.
+	Resize(key string)
+	// Touch records access of the key at unix milliseconds now, it is safe
+	// to call holding only a read lock of the storage.
+	Touch(key string, nowMs int64)
+	// SampleKeys returns up to n keys in no particular order with their statistics,
+	// only keys having a deadline when volatile.
+	SampleKeys(n int, volatile bool) []KeyStats
+}
+
+// KeyStats describes a key for eviction policies
+type KeyStats struct {
+	Key       string
+	Memory    int64
+	Access    int64 // unix milliseconds of the last access, 0 when never accessed
+	Frequency uint8 // logarithmic access counter, see DecayedFrequency
+	ExpireAt  int64 // unix milliseconds, 0 when the key does not expire
+}
+
+// DecayedFrequency returns the access counter decremented for the time the key was idle
+func (k KeyStats) DecayedFrequency(nowMs int64) uint8 {
+	return decayFrequency(k.Frequency, k.Access, nowMs)
+}
+
+// Resize recomputes memory of the key, entries modifying values in place call it after applying
+func Resize[T any](store Storage[T], key string) {
+	if evictable, ok := any(store).(Evictable); ok {
+		evictable.Resize(key)
+	}
+}
+
+// ValueSize returns estimated number of bytes held by the value
+func ValueSize(value any) int64 {
+	switch v := value.(type) {
+	case Sizer:
+		return v.MemoryUsage()
+	case protocol.Resp2BulkString:
+		return int64(len(v)) + memberOverhead
+	case protocol.Resp2SimpleString:
+		return int64(len(v)) + memberOverhead
+	case protocol.Resp2Error:
+		return int64(len(v)) + memberOverhead
+	case string:
+		return int64(len(v)) + memberOverhead
+	case protocol.Resp2Array:
+		return ValueSize([]protocol.Resp2Value(v))
+	case []protocol.Resp2Value:
+		size := int64(memberOverhead)
+		for _, item := range v {
+			size += ValueSize(item)
+		}
+		return size
+	default:
+		return memberOverhead
+	}
+}
+
+// sampledSize extrapolates total size of n members from sizes of the sampled ones
+func sampledSize(n int, sampled []int64) int64 {
+	if len(sampled) == 0 {
+		return keyOverhead
+	}
+	var total int64
+	for _, size := range sampled {
+		total += size
+	}
+	return keyOverhead + int64(n)*(total/int64(len(sampled))+memberOverhead)
+}
+
+func decayFrequency(counter uint8, access, nowMs int64) uint8 {
+	if access == 0 || nowMs <= access {
+		return counter
+	}
+	periods := (nowMs - access) / lfuDecayMs
+	if periods >= int64(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
+
+// keyMeta is accounting of a single key. Size changes with the value under the write lock,
+// access statistics change on reads too, so they are atomic.
+type keyMeta struct {
+	size    int64
+	access  atomic.Int64
+	counter atomic.Uint32
+}
+
+func newKeyMeta() *keyMeta {
+	m := &keyMeta{}
+	m.counter.Store(lfuInitCounter)
+	return m
+}
+
+// touch updates access time and increments the counter with probability
+// decreasing as the counter grows, like redis LFU does
+func (m *keyMeta) touch(nowMs int64) {
+	counter := decayFrequency(uint8(m.counter.Load()), m.access.Load(), nowMs)
+	if counter < 255 {
+		base := float64(counter) - lfuInitCounter
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	m.counter.Store(uint32(counter))
+	m.access.Store(nowMs)
+}
+
+func (m *keyMeta) stats(key string) KeyStats {
+	return KeyStats{Key: key, Memory: m.size, Access: m.access.Load(), Frequency: uint8(m.counter.Load())}
+}
+
+// account records the new size of the key
+func (s *InMemoryStorage[T]) account(key string, value T) {
+	m, ok := s.meta[key]
+	if !ok {
+		m = newKeyMeta()
+		s.meta[key] = m
+	}
+	size := int64(len(key)) + keyOverhead + ValueSize(value)
+	s.used += size - m.size
+	m.size = size
+}
+
+// unaccount drops accounting of a deleted key
+func (s *InMemoryStorage[T]) unaccount(key string) {
+	if m, ok := s.meta[key]; ok {
+		delete(s.meta, key)
+		s.used -= m.size
+	}
+}
+
+func (s *InMemoryStorage[T]) UsedMemory() int64 {
+	return s.used
+}
+
+func (s *InMemoryStorage[T]) KeyMemory(key string) int64 {
+	if m, ok := s.meta[key]; ok {
+		return m.size
+	}
+	return 0
+}
+
+func (s *InMemoryStorage[T]) Resize(key string) {
+	if value, ok := s.data[key]; ok {
+		s.account(key, value)
+	}
+}
+
+func (s *InMemoryStorage[T]) Touch(key string, nowMs int64) {
+	if m, ok := s.meta[key]; ok {
+		m.touch(nowMs)
+	}
+}
+
+// SampleKeys relies on randomized map iteration order like SampleExpires
+func (s *InMemoryStorage[T]) SampleKeys(n int, volatile bool) []KeyStats {
+	sample := make([]KeyStats, 0, n)
+	if volatile {
+		for key, atMs := range s.expires {
+			if len(sample) >= n {
+				break
+			}
+			if m, ok := s.meta[key]; ok {
+				stats := m.stats(key)
+				stats.ExpireAt = atMs
+				sample = append(sample, stats)
+			}
+		}
+		return sample
+	}
+	for key, m := range s.meta {
+		if len(sample) >= n {
+			break
+		}
+		stats := m.stats(key)
+		stats.ExpireAt = s.expires[key]
+		sample = append(sample, stats)
+	}
+	return sample
+}
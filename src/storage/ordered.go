@@ -0,0 +1,96 @@
+package storage
+
+import "fmt"
+
+// Ordered is implemented by storages which keep keys in order, so ranges and prefixes
+// can be listed without walking the whole keyspace. Storage must not be modified
+// while it is iterated.
+type Ordered[T any] interface {
+	Storage[T]
+	// Range iterates keys in [start, end) in ascending order, empty end means no upper bound.
+	Range(start, end string) func(func(string, T) bool)
+	// Seek iterates keys starting from the first key not less than key in ascending order.
+	Seek(key string) func(func(string, T) bool)
+	// Reverse iterates keys in [start, end) in descending order, empty end means no upper bound.
+	Reverse(start, end string) func(func(string, T) bool)
+}
+
+// Storage engines selectable by configuration
+const (
+	EngineHash    = "hash"    // InMemoryStorage, unordered
+	EngineOrdered = "ordered" // OrderedStorage
+	EngineLSM     = "lsm"     // LSMStorage, opened with OpenLSMStorage
+	EngineSharded = "sharded" // ShardedStorage, safe for concurrent use
+	EngineTiered  = "tiered"  // TieredStorage, made with MakeTieredStorage
+)
+
+// NewEngine returns constructor of empty storage of the engine
+func NewEngine[T any](engine string) (func() Storage[T], error) {
+	switch engine {
+	case EngineHash, "":
+		return func() Storage[T] { return MakeInMemoryStorage[T]() }, nil
+	case EngineOrdered:
+		return func() Storage[T] { return MakeOrderedStorage[T]() }, nil
+	case EngineSharded:
+		return func() Storage[T] { return MakeShardedStorage[T](DefaultShards) }, nil
+	case EngineLSM:
+		return nil, fmt.Errorf("storage engine %q keeps data on disk, it has to be opened with OpenLSMStorage", engine)
+	case EngineTiered:
+		return nil, fmt.Errorf("storage engine %q moves values to disk, it has to be made with MakeTieredStorage", engine)
+	default:
+		return nil, fmt.Errorf("unknown storage engine %q", engine)
+	}
+}
+
+// OrderedStorage keeps values and key metadata like InMemoryStorage does
+// and additionally indexes keys in a B-tree, the same way sorted sets pair
+// a map with a skiplist.
+type OrderedStorage[T any] struct {
+	*InMemoryStorage[T]
+	keys btree
+}
+
+func MakeOrderedStorage[T any]() *OrderedStorage[T] {
+	return &OrderedStorage[T]{InMemoryStorage: MakeInMemoryStorage[T]()}
+}
+
+func (s *OrderedStorage[T]) Set(key string, value T) error {
+	s.keys.insert(key)
+	return s.InMemoryStorage.Set(key, value)
+}
+
+func (s *OrderedStorage[T]) Delete(key string) error {
+	s.keys.delete(key)
+	return s.InMemoryStorage.Delete(key)
+}
+
+// Iterator walks keys in ascending order
+func (s *OrderedStorage[T]) Iterator() func(func(string, T) bool) {
+	return s.Range("", "")
+}
+
+func (s *OrderedStorage[T]) Range(start, end string) func(func(string, T) bool) {
+	return func(yield func(string, T) bool) {
+		s.keys.ascend(start, func(key string) bool {
+			if end != "" && key >= end {
+				return false
+			}
+			return yield(key, s.data[key])
+		})
+	}
+}
+
+func (s *OrderedStorage[T]) Seek(key string) func(func(string, T) bool) {
+	return s.Range(key, "")
+}
+
+func (s *OrderedStorage[T]) Reverse(start, end string) func(func(string, T) bool) {
+	return func(yield func(string, T) bool) {
+		s.keys.descend(end, end != "", func(key string) bool {
+			if key < start {
+				return false
+			}
+			return yield(key, s.data[key])
+		})
+	}
+}
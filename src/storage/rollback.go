@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"fmt"
+	"main/src/protocol"
+)
+
+// Rollback remembers keys and leases as they were before entries were applied through it,
+// so they can be restored when the entries turn out not to be committed, e.g. when a batch
+// fails halfway or the record of a transaction could not be written to the WAL.
+// Composite values are modified in place, so they are saved as deep copies.
+type Rollback[T any] struct {
+	applied uint64
+	keys    []keyState[T]
+	leases  []leaseState
+	saved   map[string]struct{}
+	granted map[uint64]struct{}
+}
+
+// keyState is a key as it was before the first entry modifying it was applied
+type keyState[T any] struct {
+	key      string
+	value    T
+	found    bool
+	expireAt int64
+	expires  bool
+	revision uint64
+	lease    uint64
+	leased   bool
+}
+
+// leaseState is a lease as it was before the first entry modifying it was applied, nil when it did not exist
+type leaseState struct {
+	id    uint64
+	lease *Lease
+	keys  []string // attached keys
+}
+
+// NewRollback starts remembering state of the store at its current applied index
+func NewRollback[T any](store Storage[T]) *Rollback[T] {
+	return &Rollback[T]{
+		applied: AppliedIndex(store),
+		saved:   make(map[string]struct{}),
+		granted: make(map[uint64]struct{}),
+	}
+}
+
+// Apply saves state of everything the entry modifies and applies it like Apply.
+// A failed entry leaves the store as it was, entries applied before stay until Restore.
+func (r *Rollback[T]) Apply(store Storage[T], entry WalEntry[T]) error {
+	keys, leases := len(r.keys), len(r.leases)
+	if err := r.save(store, entry); err != nil {
+		return err
+	}
+	if err := Apply(store, entry); err != nil {
+		if restoreErr := r.restore(store, keys, leases); restoreErr != nil {
+			return fmt.Errorf("%w, %v", err, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// save remembers keys and leases the entry modifies, unless they were saved already
+func (r *Rollback[T]) save(store Storage[T], entry WalEntry[T]) error {
+	switch entry.OpType {
+	case protocol.GET, protocol.PING:
+		return nil
+	case protocol.BATCH:
+		entries, err := DecodeBatch(entry.Value)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			e.Index = entry.Index
+			if err := r.save(store, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case protocol.LEASE:
+		return r.saveLease(store, entry)
+	case protocol.RENAME, protocol.COPY:
+		// Source of a rename is deleted
+		if source, ok := any(entry.Value).(protocol.Resp2BulkString); ok {
+			if err := r.saveKey(store, string(source)); err != nil {
+				return err
+			}
+		}
+	}
+	return r.saveKey(store, entry.Key)
+}
+
+func (r *Rollback[T]) saveKey(store Storage[T], key string) error {
+	if _, ok := r.saved[key]; ok {
+		return nil
+	}
+	value, found, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	if value, err = CopyValue(value); err != nil {
+		return err
+	}
+	state := keyState[T]{key: key, value: value, found: found, revision: Revision(store, key)}
+	if expirable, ok := any(store).(Expirable); ok {
+		state.expireAt, state.expires = expirable.GetExpire(key)
+	}
+	if leased, ok := any(store).(Leased); ok {
+		state.lease, state.leased = leased.KeyLease(key)
+	}
+	r.saved[key] = struct{}{}
+	r.keys = append(r.keys, state)
+	return nil
+}
+
+// saveLease remembers the lease of a LEASE entry together with keys attached to it,
+// as restoring the lease detaches them. Keys attached by the entry are saved too.
+func (r *Rollback[T]) saveLease(store Storage[T], entry WalEntry[T]) error {
+	leased, ok := any(store).(Leased)
+	if !ok {
+		return nil
+	}
+	args, err := respToMembers(any(entry.Value))
+	if err != nil || len(args) < 2 {
+		// Malformed entry fails to apply without modifying anything
+		return nil
+	}
+	id := entry.Index
+	if args[0] != "GRANT" {
+		values, err := ints(args[1:2])
+		if err != nil {
+			return nil
+		}
+		id = uint64(values[0])
+	}
+	if args[0] == "ATTACH" {
+		for _, key := range args[2:] {
+			if err := r.saveKey(store, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, ok := r.granted[id]; ok {
+		return nil
+	}
+	r.granted[id] = struct{}{}
+	state := leaseState{id: id}
+	if lease := leased.GetLease(id); lease != nil {
+		state.lease = &Lease{ID: lease.ID, TTL: lease.TTL, ExpireAt: lease.ExpireAt}
+		state.keys = lease.SortedKeys()
+		for _, key := range state.keys {
+			if err := r.saveKey(store, key); err != nil {
+				return err
+			}
+		}
+	}
+	r.leases = append(r.leases, state)
+	return nil
+}
+
+// Restore puts back saved keys and leases, the store is left as it was when the rollback started
+func (r *Rollback[T]) Restore(store Storage[T]) error {
+	if err := r.restore(store, 0, 0); err != nil {
+		return err
+	}
+	if indexed, ok := any(store).(Indexed); ok {
+		indexed.SetAppliedIndex(r.applied)
+	}
+	return nil
+}
+
+// restore puts back keys and leases saved after the first ones and forgets them
+func (r *Rollback[T]) restore(store Storage[T], keys, leases int) error {
+	// Leases first, so restored keys can be attached to them again
+	if leased, ok := any(store).(Leased); ok {
+		for i := len(r.leases) - 1; i >= leases; i-- {
+			state := r.leases[i]
+			leased.RevokeLease(state.id)
+			if state.lease != nil {
+				leased.GrantLease(state.id, state.lease.TTL, state.lease.ExpireAt)
+				// Keys restored below are attached again by themselves, the rest is attached here
+				for _, key := range state.keys {
+					if exists, _ := store.Exists(key); exists {
+						if err := leased.AttachLease(state.id, key); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			delete(r.granted, state.id)
+		}
+	}
+	r.leases = r.leases[:leases]
+	for i := len(r.keys) - 1; i >= keys; i-- {
+		if err := r.keys[i].restore(store); err != nil {
+			return fmt.Errorf("restoring key %q failed: %w", r.keys[i].key, err)
+		}
+		delete(r.saved, r.keys[i].key)
+	}
+	r.keys = r.keys[:keys]
+	return nil
+}
+
+// restore replaces the key as a whole, with its deadline, revision and lease
+func (state keyState[T]) restore(store Storage[T]) error {
+	if err := store.Delete(state.key); err != nil || !state.found {
+		return err
+	}
+	if err := store.Set(state.key, state.value); err != nil {
+		return err
+	}
+	if expirable, ok := any(store).(Expirable); ok && state.expires {
+		if err := expirable.SetExpire(state.key, state.expireAt); err != nil {
+			return err
+		}
+	}
+	if versioned, ok := any(store).(Versioned); ok {
+		versioned.SetRevision(state.key, state.revision)
+	}
+	if leased, ok := any(store).(Leased); ok && state.leased {
+		if err := leased.AttachLease(state.lease, state.key); err != nil {
+			return err
+		}
+	}
+	Resize(store, state.key)
+	return nil
+}
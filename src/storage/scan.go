@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// Keyspace iteration.
+// Map iteration order is random, so keys are iterated in order of their hash instead.
+// Position of a key depends only on the key itself, so a cursor stays valid while
+// the keyspace changes between calls, like a cursor of redis SCAN does.
+
+// KeyHash returns position of the key in the scan order
+func KeyHash(key string) uint64 {
+	// FNV-1a, inlined so hashing does not allocate, it runs on every access of sharded storage
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Scan returns keys whose hash is at least the cursor in scan order, at least count of them
+// unless the iteration completes, and cursor for the next call which is 0 once it completes.
+// Every key existing during the whole iteration is returned at least once.
+func Scan[T any](store Storage[T], cursor uint64, count int) ([]string, uint64) {
+	type position struct {
+		hash uint64
+		key  string
+	}
+	var found []position
+	store.Iterator()(func(key string, _ T) bool {
+		if hash := KeyHash(key); hash >= cursor {
+			found = append(found, position{hash, key})
+		}
+		return true
+	})
+	slices.SortFunc(found, func(a, b position) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.key, b.key))
+	})
+
+	if len(found) <= count {
+		keys := make([]string, 0, len(found))
+		for _, p := range found {
+			keys = append(keys, p.key)
+		}
+		return keys, 0
+	}
+	// Keys sharing a hash can not be told apart by a cursor, they are returned together
+	n := count
+	for n < len(found) && found[n].hash == found[n-1].hash {
+		n++
+	}
+	keys := make([]string, 0, n)
+	for _, p := range found[:n] {
+		keys = append(keys, p.key)
+	}
+	last := found[n-1].hash
+	if last == math.MaxUint64 {
+		return keys, 0
+	}
+	return keys, last + 1
+}
+
+// KindOf returns type of the value as reported by TYPE
+func KindOf(value any) string {
+	if enc, ok := value.(Encoder); ok {
+		return enc.Kind()
+	}
+	return "string"
+}
+
+// CopyValue returns a deep copy of the value, composite values are copied through their encoding
+func CopyValue[T any](value T) (T, error) {
+	enc, ok := any(value).(Encoder)
+	if !ok {
+		return value, nil
+	}
+	decoded, err := decodeValue(enc.Kind(), enc.Encode())
+	if err != nil {
+		return value, err
+	}
+	copied, ok := decoded.(T)
+	if !ok {
+		return value, ErrWrongType
+	}
+	return copied, nil
+}
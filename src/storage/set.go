@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"fmt"
+	"main/src/protocol"
+	"sort"
+)
+
+// Set is an unordered collection of unique string members.
+// It is stored directly as a value in Storage.
+type Set map[string]struct{}
+
+func NewSet(members ...string) Set {
+	s := make(Set, len(members))
+	s.Add(members...)
+	return s
+}
+
+// Add inserts members and returns how many of them were not present before.
+func (s Set) Add(members ...string) int {
+	added := 0
+	for _, m := range members {
+		if _, ok := s[m]; !ok {
+			s[m] = struct{}{}
+			added++
+		}
+	}
+	return added
+}
+
+// Remove deletes members and returns how many of them were present.
+func (s Set) Remove(members ...string) int {
+	removed := 0
+	for _, m := range members {
+		if _, ok := s[m]; ok {
+			delete(s, m)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (s Set) Contains(member string) bool {
+	_, ok := s[member]
+	return ok
+}
+
+// Members returns all members sorted, so replies and snapshots are deterministic.
+func (s Set) Members() []string {
+	members := make([]string, 0, len(s))
+	for m := range s {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (s Set) Kind() string {
+	return "set"
+}
+
+func (s Set) Encode() protocol.Resp2Value {
+	return membersToResp(s.Members())
+}
+
+// MemoryUsage extrapolates from a few members, map iteration starts at a random one
+func (s Set) MemoryUsage() int64 {
+	sampled := make([]int64, 0, memorySamples)
+	for member := range s {
+		if len(sampled) >= memorySamples {
+			break
+		}
+		sampled = append(sampled, int64(len(member)))
+	}
+	return sampledSize(len(s), sampled)
+}
+
+func DecodeSet(value protocol.Resp2Value) (Set, error) {
+	members, err := respToMembers(value)
+	if err != nil {
+		return nil, err
+	}
+	return NewSet(members...), nil
+}
+
+// InterSets returns members present in every set, nil sets are treated as empty.
+func InterSets(sets ...Set) Set {
+	result := NewSet()
+	if len(sets) == 0 {
+		return result
+	}
+
+	// Iterate over the smallest set to keep it cheap
+	smallest := 0
+	for i, s := range sets {
+		if len(s) < len(sets[smallest]) {
+			smallest = i
+		}
+	}
+
+outer:
+	for m := range sets[smallest] {
+		for i, s := range sets {
+			if i != smallest && !s.Contains(m) {
+				continue outer
+			}
+		}
+		result[m] = struct{}{}
+	}
+	return result
+}
+
+// UnionSets returns members present in any of the sets.
+func UnionSets(sets ...Set) Set {
+	result := NewSet()
+	for _, s := range sets {
+		for m := range s {
+			result[m] = struct{}{}
+		}
+	}
+	return result
+}
+
+// DiffSets returns members of the first set not present in any of the others.
+func DiffSets(first Set, others ...Set) Set {
+	result := NewSet()
+outer:
+	for m := range first {
+		for _, s := range others {
+			if s.Contains(m) {
+				continue outer
+			}
+		}
+		result[m] = struct{}{}
+	}
+	return result
+}
+
+// GetSet returns the set stored under key, nil if key does not exist
+// or ErrWrongType if key holds a different kind of value.
+func GetSet[T any](store Storage[T], key string) (Set, error) {
+	value, found, err := store.Get(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	set, ok := any(value).(Set)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return set, nil
+}
+
+func membersToResp(members []string) protocol.Resp2Value {
+	arr := make([]protocol.Resp2Value, 0, len(members))
+	for _, m := range members {
+		arr = append(arr, protocol.Resp2BulkString(m))
+	}
+	return arr
+}
+
+func respToMembers(value protocol.Resp2Value) ([]string, error) {
+	arr, ok := value.([]protocol.Resp2Value)
+	if !ok {
+		return nil, fmt.Errorf("invalid members format: expected array, got %T", value)
+	}
+	members := make([]string, 0, len(arr))
+	for _, v := range arr {
+		m, ok := v.(protocol.Resp2BulkString)
+		if !ok {
+			return nil, fmt.Errorf("invalid members format: expected bulk string, got %T", v)
+		}
+		members = append(members, string(m))
+	}
+	return members, nil
+}
@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"cmp"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Concurrent is implemented by storages which are safe for concurrent use.
+// Operations on keys of different shards run in parallel. Callers still have to
+// serialize operations on the same key, composite values are modified in place.
+type Concurrent interface {
+	// Shards returns number of independently locked partitions of the keyspace.
+	Shards() int
+}
+
+// DefaultShards is number of shards of the sharded engine
+const DefaultShards = 64
+
+// ShardedStorage partitions keys by KeyHash into InMemoryStorage shards,
+// each guarded by its own lock. Leases span keys of many shards, so the lease
+// table is kept once for the whole storage under a separate lock.
+type ShardedStorage[T any] struct {
+	shards  []storageShard[T]
+	applied atomic.Uint64
+	used    atomic.Int64 // sum of memory used by shards
+	leaseMu sync.RWMutex
+	leases  leaseTable
+}
+
+type storageShard[T any] struct {
+	mu sync.RWMutex
+	*InMemoryStorage[T]
+}
+
+func MakeShardedStorage[T any](shards int) *ShardedStorage[T] {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &ShardedStorage[T]{shards: make([]storageShard[T], shards), leases: makeLeaseTable()}
+	for i := range s.shards {
+		s.shards[i].InMemoryStorage = MakeInMemoryStorage[T]()
+	}
+	return s
+}
+
+func (s *ShardedStorage[T]) Shards() int {
+	return len(s.shards)
+}
+
+func (s *ShardedStorage[T]) shard(key string) *storageShard[T] {
+	return &s.shards[KeyHash(key)%uint64(len(s.shards))]
+}
+
+func (s *ShardedStorage[T]) Get(key string) (T, bool, error) {
+	shard := s.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.Get(key)
+}
+
+func (s *ShardedStorage[T]) Set(key string, value T) error {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	used := shard.used
+	err := shard.Set(key, value)
+	s.used.Add(shard.used - used)
+	return err
+}
+
+func (s *ShardedStorage[T]) Exists(key string) (bool, error) {
+	shard := s.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.Exists(key)
+}
+
+func (s *ShardedStorage[T]) Delete(key string) error {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	used := shard.used
+	err := shard.Delete(key)
+	s.used.Add(shard.used - used)
+	shard.mu.Unlock()
+
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	s.leases.detachLease(key)
+	return err
+}
+
+// Iterator walks shards one by one. Every shard is copied holding its lock,
+// so yield is free to call the storage and keys of other shards may change meanwhile.
+func (s *ShardedStorage[T]) Iterator() func(func(string, T) bool) {
+	return func(yield func(string, T) bool) {
+		type pair struct {
+			key   string
+			value T
+		}
+		for i := range s.shards {
+			shard := &s.shards[i]
+			shard.mu.RLock()
+			pairs := make([]pair, 0, len(shard.data))
+			for key, value := range shard.data {
+				pairs = append(pairs, pair{key, value})
+			}
+			shard.mu.RUnlock()
+			for _, p := range pairs {
+				if !yield(p.key, p.value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *ShardedStorage[T]) SetExpire(key string, atMs int64) error {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.SetExpire(key, atMs)
+}
+
+func (s *ShardedStorage[T]) GetExpire(key string) (int64, bool) {
+	shard := s.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.GetExpire(key)
+}
+
+func (s *ShardedStorage[T]) Persist(key string) bool {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.Persist(key)
+}
+
+// SampleExpires starts at a random shard, so keys of all shards get sampled over time
+func (s *ShardedStorage[T]) SampleExpires(n int) []string {
+	keys := make([]string, 0, n)
+	start := rand.IntN(len(s.shards))
+	for i := range s.shards {
+		if len(keys) >= n {
+			break
+		}
+		shard := &s.shards[(start+i)%len(s.shards)]
+		shard.mu.RLock()
+		keys = append(keys, shard.SampleExpires(n-len(keys))...)
+		shard.mu.RUnlock()
+	}
+	return keys
+}
+
+func (s *ShardedStorage[T]) AppliedIndex() uint64 {
+	return s.applied.Load()
+}
+
+// SetAppliedIndex keeps the highest index, entries of different keys may be applied out of order
+func (s *ShardedStorage[T]) SetAppliedIndex(index uint64) {
+	for {
+		current := s.applied.Load()
+		if index <= current || s.applied.CompareAndSwap(current, index) {
+			return
+		}
+	}
+}
+
+func (s *ShardedStorage[T]) Revision(key string) uint64 {
+	shard := s.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.Revision(key)
+}
+
+func (s *ShardedStorage[T]) SetRevision(key string, revision uint64) {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.SetRevision(key, revision)
+}
+
+func (s *ShardedStorage[T]) UsedMemory() int64 {
+	return s.used.Load()
+}
+
+func (s *ShardedStorage[T]) KeyMemory(key string) int64 {
+	shard := s.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.KeyMemory(key)
+}
+
+func (s *ShardedStorage[T]) Resize(key string) {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	used := shard.used
+	shard.Resize(key)
+	s.used.Add(shard.used - used)
+}
+
+func (s *ShardedStorage[T]) Touch(key string, nowMs int64) {
+	shard := s.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	shard.Touch(key, nowMs)
+}
+
+// SampleKeys starts at a random shard like SampleExpires
+func (s *ShardedStorage[T]) SampleKeys(n int, volatile bool) []KeyStats {
+	sample := make([]KeyStats, 0, n)
+	start := rand.IntN(len(s.shards))
+	for i := range s.shards {
+		if len(sample) >= n {
+			break
+		}
+		shard := &s.shards[(start+i)%len(s.shards)]
+		shard.mu.RLock()
+		sample = append(sample, shard.SampleKeys(n-len(sample), volatile)...)
+		shard.mu.RUnlock()
+	}
+	return sample
+}
+
+func (s *ShardedStorage[T]) GrantLease(id uint64, ttl, expireAt int64) {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	s.leases.GrantLease(id, ttl, expireAt)
+}
+
+func (s *ShardedStorage[T]) RenewLease(id uint64, expireAt int64) error {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	return s.leases.RenewLease(id, expireAt)
+}
+
+func (s *ShardedStorage[T]) AttachLease(id uint64, key string) error {
+	exists, _ := s.Exists(key)
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	return s.leases.attachLease(id, key, exists)
+}
+
+func (s *ShardedStorage[T]) RevokeLease(id uint64) {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	s.leases.RevokeLease(id)
+}
+
+// GetLease returns a copy, keys of the lease are detached by concurrent deletes
+func (s *ShardedStorage[T]) GetLease(id uint64) *Lease {
+	s.leaseMu.RLock()
+	defer s.leaseMu.RUnlock()
+	return copyLease(s.leases.GetLease(id))
+}
+
+func (s *ShardedStorage[T]) KeyLease(key string) (uint64, bool) {
+	s.leaseMu.RLock()
+	defer s.leaseMu.RUnlock()
+	return s.leases.KeyLease(key)
+}
+
+func (s *ShardedStorage[T]) Leases() []*Lease {
+	s.leaseMu.RLock()
+	defer s.leaseMu.RUnlock()
+	leases := make([]*Lease, 0, len(s.leases.leases))
+	for _, lease := range s.leases.leases {
+		leases = append(leases, copyLease(lease))
+	}
+	slices.SortFunc(leases, func(a, b *Lease) int { return cmp.Compare(a.ID, b.ID) })
+	return leases
+}
+
+func copyLease(lease *Lease) *Lease {
+	if lease == nil {
+		return nil
+	}
+	copied := *lease
+	copied.Keys = maps.Clone(lease.Keys)
+	return &copied
+}
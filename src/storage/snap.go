@@ -13,6 +13,21 @@ type Snapshoter[T any] interface {
 	Snapshot(wal Wal[T]) error
 }
 
+// Checkpointer is implemented by storages which persist data by themselves, like LSMStorage.
+// Checkpoint is called holding the write lock when the WAL is rotated, data applied so far
+// has to be made durable by the following Snapshot, as the rotated log is dropped after it.
+type Checkpointer interface {
+	Checkpoint() error
+}
+
+// Checkpoint checkpoints the store if it persists data by itself
+func Checkpoint[T any](store Storage[T]) error {
+	if checkpointer, ok := any(store).(Checkpointer); ok {
+		return checkpointer.Checkpoint()
+	}
+	return nil
+}
+
 type SnapshotEntry[T any] struct {
 	Key   string
 	Value T
@@ -20,18 +35,26 @@ type SnapshotEntry[T any] struct {
 
 type SimpleSnapshotter[T any] struct {
 	snapshotPath string
+	newStorage   func() Storage[T] // creates storage the snapshot is loaded into
 }
 
 func NewSimpleSnapshotter[T any](snapshotPath string) *SimpleSnapshotter[T] {
 	return &SimpleSnapshotter[T]{
 		snapshotPath: snapshotPath,
+		newStorage:   func() Storage[T] { return MakeInMemoryStorage[T]() },
 	}
 }
 
+// WithStorage makes snapshots load into storage created by newStorage, e.g. one returned by NewEngine
+func (s *SimpleSnapshotter[T]) WithStorage(newStorage func() Storage[T]) *SimpleSnapshotter[T] {
+	s.newStorage = newStorage
+	return s
+}
+
 func (s *SimpleSnapshotter[T]) LoadSnapshot() (Storage[T], error) {
 	_, err := os.Stat(s.snapshotPath)
 	if os.IsNotExist(err) {
-		return MakeInMemoryStorage[T](), nil
+		return s.newStorage(), nil
 	}
 	if err != nil {
 		return nil, err
@@ -43,8 +66,9 @@ func (s *SimpleSnapshotter[T]) LoadSnapshot() (Storage[T], error) {
 	}
 	defer fd.Close()
 
-	store := MakeInMemoryStorage[T]()
+	store := s.newStorage()
 	parser := protocol.NewResp2Parser(fd, 0)
+	var applied uint64
 
 	for {
 		val, err := parser.Parse()
@@ -55,13 +79,32 @@ func (s *SimpleSnapshotter[T]) LoadSnapshot() (Storage[T], error) {
 			return nil, err
 		}
 
+		// Index of the last applied WAL entry is stored as a single integer before the keys
+		if index, ok := val.(protocol.Resp2Integer); ok {
+			applied = uint64(index)
+			SetAppliedIndex(store, applied)
+			continue
+		}
+
 		arr, ok := val.([]protocol.Resp2Value)
 		if !ok {
 			return nil, fmt.Errorf("invalid snapshot entry format: expected array")
 		}
 
-		if len(arr) != 2 {
-			return nil, fmt.Errorf("invalid snapshot entry format: expected 2 elements, got %d", len(arr))
+		// Leases are written after the keys as [lease, id, ttl, expireAt, keys...]
+		if len(arr) > 0 && arr[0] == protocol.Resp2Value(protocol.Resp2SimpleString("lease")) {
+			if err := loadLease(store, arr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// [Key, Value] for plain values, [Key, Payload, Kind] for composite ones,
+		// keys with expiration have deadline appended as [Key, Payload, Kind, ExpireAt]
+		// where Kind is empty for plain values. Modification revision is appended
+		// as [Key, Payload, Kind, ExpireAt, Revision] where ExpireAt is 0 for keys without expiration.
+		if len(arr) < 2 || len(arr) > 5 {
+			return nil, fmt.Errorf("invalid snapshot entry format: expected 2 to 5 elements, got %d", len(arr))
 		}
 
 		key, ok := arr[0].(protocol.Resp2BulkString)
@@ -70,6 +113,18 @@ func (s *SimpleSnapshotter[T]) LoadSnapshot() (Storage[T], error) {
 		}
 
 		value := arr[1]
+		if len(arr) >= 3 {
+			kind, ok := arr[2].(protocol.Resp2SimpleString)
+			if !ok {
+				return nil, fmt.Errorf("invalid snapshot entry format: expected simple string for Kind")
+			}
+			if kind != "" {
+				value, err = decodeValue(string(kind), value)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
 		var tValue T
 		if value != nil {
 			var ok bool
@@ -80,6 +135,31 @@ func (s *SimpleSnapshotter[T]) LoadSnapshot() (Storage[T], error) {
 		}
 
 		store.Set(string(key), tValue)
+		if len(arr) >= 4 {
+			expireAt, ok := arr[3].(protocol.Resp2Integer)
+			if !ok {
+				return nil, fmt.Errorf("invalid snapshot entry format: expected integer for ExpireAt")
+			}
+			if len(arr) == 4 || expireAt != 0 {
+				expirable, err := getExpirable(store)
+				if err != nil {
+					return nil, err
+				}
+				if err := expirable.SetExpire(string(key), int64(expireAt)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(arr) == 5 {
+			revision, ok := arr[4].(protocol.Resp2Integer)
+			if !ok {
+				return nil, fmt.Errorf("invalid snapshot entry format: expected integer for Revision")
+			}
+			SetRevision(store, string(key), uint64(revision))
+		} else {
+			// Snapshots written before revisions were tracked, the key was modified at latest by the applied entry
+			SetRevision(store, string(key), applied)
+		}
 	}
 
 	return store, nil
@@ -90,6 +170,10 @@ func (s *SimpleSnapshotter[T]) Snapshot(wal Wal[T]) error {
 	if err != nil {
 		return err
 	}
+	// Storage moving values to disk releases its files once the snapshot is written
+	if closer, ok := cur.(io.Closer); ok {
+		defer closer.Close()
+	}
 
 	if err := modify_store(wal, cur); err != nil {
 		return err
@@ -119,6 +203,18 @@ func snapshot[T any](snapshotPath string, store Storage[T]) error {
 
 	parser := protocol.NewResp2Parser(nil, 0)
 
+	expirable, _ := any(store).(Expirable)
+
+	if index := AppliedIndex(store); index > 0 {
+		payload, err := parser.Render(protocol.Resp2Integer(index))
+		if err != nil {
+			return err
+		}
+		if _, err := fd.Write(payload); err != nil {
+			return err
+		}
+	}
+
 	// Write all key-value pairs to snapshot file
 	var writeErr error
 	store.Iterator()(func(k string, v T) bool {
@@ -126,6 +222,30 @@ func snapshot[T any](snapshotPath string, store Storage[T]) error {
 			protocol.Resp2BulkString(k),
 			v,
 		}
+		if enc, ok := any(v).(Encoder); ok {
+			arr = []protocol.Resp2Value{
+				protocol.Resp2BulkString(k),
+				enc.Encode(),
+				protocol.Resp2SimpleString(enc.Kind()),
+			}
+		}
+		if expirable != nil {
+			if expireAt, ok := expirable.GetExpire(k); ok {
+				if len(arr) == 2 {
+					arr = append(arr, protocol.Resp2SimpleString(""))
+				}
+				arr = append(arr, protocol.Resp2Integer(expireAt))
+			}
+		}
+		if revision := Revision(store, k); revision > 0 {
+			if len(arr) == 2 {
+				arr = append(arr, protocol.Resp2SimpleString(""))
+			}
+			if len(arr) == 3 {
+				arr = append(arr, protocol.Resp2Integer(0))
+			}
+			arr = append(arr, protocol.Resp2Integer(revision))
+		}
 		payload, err := parser.Render(arr)
 		if err != nil {
 			writeErr = err
@@ -145,6 +265,18 @@ func snapshot[T any](snapshotPath string, store Storage[T]) error {
 		return writeErr
 	}
 
+	if leased, ok := any(store).(Leased); ok {
+		for _, lease := range leased.Leases() {
+			payload, err := parser.Render(encodeLease(lease))
+			if err != nil {
+				return err
+			}
+			if _, err := fd.Write(payload); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Sync to ensure data is flushed
 	if err := fd.Sync(); err != nil {
 		return err
@@ -159,21 +291,8 @@ func modify_store[T any](wal Wal[T], store Storage[T]) error {
 	}
 
 	for _, entry := range entries {
-		switch entry.OpType {
-		case protocol.GET:
-			// No-op for snapshot
-		case protocol.SET:
-			if err := store.Set(entry.Key, entry.Value); err != nil {
-				return err
-			}
-		case protocol.DELETE:
-			if err := store.Delete(entry.Key); err != nil {
-				return err
-			}
-		case protocol.PING:
-			// No-op for snapshot
-		default:
-			return fmt.Errorf("unknown operation type in WAL: %v", entry.OpType)
+		if err := Apply(store, entry); err != nil {
+			return err
 		}
 	}
 
@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// Sorted string table, immutable file of LSMStorage.
+//
+//	[data block]...[index block][filter block][footer]
+//
+// Data blocks hold entries [uvarint len, key, uvarint len, value] in key order,
+// index block holds [uvarint len, smallest key] followed by [uvarint len, last key,
+// uvarint offset, uvarint size] of every data block, filter block is a bloom filter
+// of all keys. Every block ends with CRC32 of its content. Footer holds offset and size
+// of the index and filter blocks followed by the magic number, as little endian uint64s.
+
+const (
+	tableMagic      uint64 = 0x316c62746d736c6b // "klsmtbl1"
+	tableFooterSize        = 5 * 8
+)
+
+var errTableCorrupted = errors.New("sstable is corrupted")
+
+type tableEntry struct {
+	key   string
+	value []byte // encoded record, see encodeRecord
+}
+
+type blockHandle struct {
+	last   string // last key of the block
+	offset uint64
+	size   uint64 // including checksum
+}
+
+type sstable struct {
+	file     uint64 // number of the file in the LSM directory
+	path     string
+	fd       *os.File
+	size     int64
+	smallest string
+	largest  string
+	index    []blockHandle
+	filter   bloom
+	refs     atomic.Int32 // versions holding the table
+	obsolete atomic.Bool  // file is removed once no version holds it
+}
+
+// tableWriter writes entries added in key order into a new table
+type tableWriter struct {
+	file       uint64
+	path       string
+	fd         *os.File
+	w          *bufio.Writer
+	blockSize  int
+	bitsPerKey int
+	offset     uint64
+	block      []byte
+	last       string
+	smallest   string
+	index      []blockHandle
+	hashes     []uint64
+}
+
+func createTable(path string, file uint64, blockSize, bitsPerKey int) (*tableWriter, error) {
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{file: file, path: path, fd: fd, w: bufio.NewWriter(fd), blockSize: blockSize, bitsPerKey: bitsPerKey}, nil
+}
+
+func (w *tableWriter) add(key string, value []byte) error {
+	if len(w.hashes) == 0 {
+		w.smallest = key
+	}
+	w.block = binary.AppendUvarint(w.block, uint64(len(key)))
+	w.block = append(w.block, key...)
+	w.block = binary.AppendUvarint(w.block, uint64(len(value)))
+	w.block = append(w.block, value...)
+	w.last = key
+	w.hashes = append(w.hashes, KeyHash(key))
+	if len(w.block) >= w.blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// size returns number of bytes written so far
+func (w *tableWriter) size() uint64 {
+	return w.offset + uint64(len(w.block))
+}
+
+func (w *tableWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	handle, err := w.writeBlock(w.block)
+	if err != nil {
+		return err
+	}
+	handle.last = w.last
+	w.index = append(w.index, handle)
+	w.block = w.block[:0]
+	return nil
+}
+
+func (w *tableWriter) writeBlock(payload []byte) (blockHandle, error) {
+	handle := blockHandle{offset: w.offset, size: uint64(len(payload)) + 4}
+	if _, err := w.w.Write(payload); err != nil {
+		return handle, err
+	}
+	if _, err := w.w.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(payload))); err != nil {
+		return handle, err
+	}
+	w.offset += handle.size
+	return handle, nil
+}
+
+// finish writes index, filter and footer and syncs the file
+func (w *tableWriter) finish() error {
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+	index := binary.AppendUvarint(nil, uint64(len(w.smallest)))
+	index = append(index, w.smallest...)
+	for _, h := range w.index {
+		index = binary.AppendUvarint(index, uint64(len(h.last)))
+		index = append(index, h.last...)
+		index = binary.AppendUvarint(index, h.offset)
+		index = binary.AppendUvarint(index, h.size)
+	}
+	indexHandle, err := w.writeBlock(index)
+	if err != nil {
+		return err
+	}
+	filterHandle, err := w.writeBlock(newBloom(w.hashes, w.bitsPerKey).encode())
+	if err != nil {
+		return err
+	}
+	var footer []byte
+	for _, v := range []uint64{indexHandle.offset, indexHandle.size, filterHandle.offset, filterHandle.size, tableMagic} {
+		footer = binary.LittleEndian.AppendUint64(footer, v)
+	}
+	if _, err := w.w.Write(footer); err != nil {
+		return err
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if err := w.fd.Sync(); err != nil {
+		return err
+	}
+	return w.fd.Close()
+}
+
+// abort drops the partially written table
+func (w *tableWriter) abort() {
+	w.fd.Close()
+	os.Remove(w.path)
+}
+
+func openTable(path string, file uint64) (*sstable, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := loadTable(fd)
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	t.file = file
+	t.path = path
+	return t, nil
+}
+
+func loadTable(fd *os.File) (*sstable, error) {
+	stat, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < tableFooterSize {
+		return nil, errTableCorrupted
+	}
+	footer := make([]byte, tableFooterSize)
+	if _, err := fd.ReadAt(footer, stat.Size()-tableFooterSize); err != nil {
+		return nil, err
+	}
+	var values [5]uint64
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint64(footer[i*8:])
+	}
+	if values[4] != tableMagic {
+		return nil, errTableCorrupted
+	}
+
+	t := &sstable{fd: fd, size: stat.Size()}
+	index, err := readBlock(fd, values[0], values[1])
+	if err != nil {
+		return nil, err
+	}
+	smallest, index, ok := readBytes(index)
+	if !ok {
+		return nil, errTableCorrupted
+	}
+	t.smallest = string(smallest)
+	for len(index) > 0 {
+		var h blockHandle
+		var last []byte
+		if last, index, ok = readBytes(index); !ok {
+			return nil, errTableCorrupted
+		}
+		h.last = string(last)
+		if h.offset, index, ok = readUvarint(index); !ok {
+			return nil, errTableCorrupted
+		}
+		if h.size, index, ok = readUvarint(index); !ok {
+			return nil, errTableCorrupted
+		}
+		t.index = append(t.index, h)
+	}
+	if len(t.index) == 0 {
+		return nil, errTableCorrupted
+	}
+	t.largest = t.index[len(t.index)-1].last
+
+	filter, err := readBlock(fd, values[2], values[3])
+	if err != nil {
+		return nil, err
+	}
+	t.filter = decodeBloom(filter)
+	return t, nil
+}
+
+// readBlock reads block content and verifies its checksum
+func readBlock(fd *os.File, offset, size uint64) ([]byte, error) {
+	if size < 4 {
+		return nil, errTableCorrupted
+	}
+	data := make([]byte, size)
+	if _, err := fd.ReadAt(data, int64(offset)); err != nil {
+		return nil, err
+	}
+	payload := data[:size-4]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[size-4:]) {
+		return nil, errTableCorrupted
+	}
+	return payload, nil
+}
+
+func readUvarint(data []byte) (uint64, []byte, bool) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, data, false
+	}
+	return v, data[n:], true
+}
+
+func readBytes(data []byte) ([]byte, []byte, bool) {
+	n, rest, ok := readUvarint(data)
+	if !ok || uint64(len(rest)) < n {
+		return nil, data, false
+	}
+	return rest[:n], rest[n:], true
+}
+
+// block reads entries of the i-th data block
+func (t *sstable) block(i int) ([]tableEntry, error) {
+	data, err := readBlock(t.fd, t.index[i].offset, t.index[i].size)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", t.path, err)
+	}
+	var entries []tableEntry
+	for len(data) > 0 {
+		var key, value []byte
+		var ok bool
+		if key, data, ok = readBytes(data); !ok {
+			return nil, fmt.Errorf("%s: %w", t.path, errTableCorrupted)
+		}
+		if value, data, ok = readBytes(data); !ok {
+			return nil, fmt.Errorf("%s: %w", t.path, errTableCorrupted)
+		}
+		entries = append(entries, tableEntry{key: string(key), value: value})
+	}
+	return entries, nil
+}
+
+// seekBlock returns the first block which may hold keys not less than key
+func (t *sstable) seekBlock(key string) int {
+	return sort.Search(len(t.index), func(i int) bool { return t.index[i].last >= key })
+}
+
+// get returns encoded record of the key
+func (t *sstable) get(key string) ([]byte, bool, error) {
+	if key < t.smallest || key > t.largest || !t.filter.mayContain(KeyHash(key)) {
+		return nil, false, nil
+	}
+	entries, err := t.block(t.seekBlock(key))
+	if err != nil {
+		return nil, false, err
+	}
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].key >= key })
+	if i < len(entries) && entries[i].key == key {
+		return entries[i].value, true, nil
+	}
+	return nil, false, nil
+}
+
+// overlaps reports whether the table may hold keys in [smallest, largest]
+func (t *sstable) overlaps(smallest, largest string) bool {
+	return t.largest >= smallest && t.smallest <= largest
+}
+
+// unref releases the table held by a version, the last release closes it
+func (t *sstable) unref() {
+	if t.refs.Add(-1) > 0 {
+		return
+	}
+	t.fd.Close()
+	if t.obsolete.Load() {
+		os.Remove(t.path)
+	}
+}
+
+// tableCursor walks entries of a table in either direction, see lsmCursor
+type tableCursor[T any] struct {
+	t       *sstable
+	reverse bool
+	block   int
+	entries []tableEntry
+	pos     int
+}
+
+// newTableCursor positions at the first key not less than start,
+// or when reverse at the last key less than end, where empty end means no bound
+func newTableCursor[T any](t *sstable, start, end string, reverse bool) *tableCursor[T] {
+	c := &tableCursor[T]{t: t, reverse: reverse}
+	if !reverse {
+		c.load(t.seekBlock(start))
+		c.pos = sort.Search(len(c.entries), func(i int) bool { return c.entries[i].key >= start })
+		return c
+	}
+	if end == "" {
+		c.load(len(t.index) - 1)
+		c.pos = len(c.entries) - 1
+		return c
+	}
+	i := t.seekBlock(end)
+	if i == len(t.index) {
+		c.load(i - 1)
+		c.pos = len(c.entries) - 1
+		return c
+	}
+	c.load(i)
+	c.pos = sort.Search(len(c.entries), func(i int) bool { return c.entries[i].key >= end }) - 1
+	if c.pos < 0 {
+		c.next()
+	}
+	return c
+}
+
+// load reads the i-th block, the cursor becomes invalid past the last block.
+// Reads happen deep in iteration which can not return errors, failing disk is not recoverable there.
+func (c *tableCursor[T]) load(i int) {
+	c.block = i
+	c.entries = nil
+	if i < 0 || i >= len(c.t.index) {
+		return
+	}
+	entries, err := c.t.block(i)
+	if err != nil {
+		panic(err)
+	}
+	c.entries = entries
+}
+
+func (c *tableCursor[T]) valid() bool {
+	return c.pos >= 0 && c.pos < len(c.entries)
+}
+
+func (c *tableCursor[T]) key() string {
+	return c.entries[c.pos].key
+}
+
+func (c *tableCursor[T]) record() (*memRecord[T], []byte) {
+	return nil, c.entries[c.pos].value
+}
+
+func (c *tableCursor[T]) next() {
+	if !c.reverse {
+		c.pos++
+		if c.pos >= len(c.entries) && c.block < len(c.t.index)-1 {
+			c.load(c.block + 1)
+			c.pos = 0
+		}
+		return
+	}
+	c.pos--
+	if c.pos < 0 && c.block > 0 {
+		c.load(c.block - 1)
+		c.pos = len(c.entries) - 1
+	}
+}
@@ -1,8 +1,16 @@
 package storage
 
-// IMPORTANT! storage is not intended to be thread safe
+import "errors"
+
+// ErrWrongType is returned when an operation is used against a key holding a different kind of value.
+// Message follows redis so it can be passed to clients as is.
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// IMPORTANT! storage is not intended to be thread safe, unless it implements Concurrent
 type Storage[T any] interface {
-	Get(key string) (T, error)
+	// Get returns the value and true, or false when the key does not exist.
+	// Zero value is a valid value, e.g. nil stored as protocol.Resp2Value.
+	Get(key string) (T, bool, error)
 	Set(key string, value T) error
 	Delete(key string) error
 	Exists(key string) (bool, error)
@@ -14,26 +22,33 @@ type Storage[T any] interface {
 
 // Simple in memory implementation of storage
 type InMemoryStorage[T any] struct {
-	data map[string]T
+	data      map[string]T
+	expires   map[string]int64  // unix milliseconds
+	revisions map[string]uint64 // index of the last WAL entry modifying the key
+	applied   uint64            // index of the last applied WAL entry
+	meta      map[string]*keyMeta
+	used      int64 // bytes accounted to all keys
+	leaseTable
 }
 
 func MakeInMemoryStorage[T any]() *InMemoryStorage[T] {
 	return &InMemoryStorage[T]{
-		data: make(map[string]T),
+		data:       make(map[string]T),
+		expires:    make(map[string]int64),
+		revisions:  make(map[string]uint64),
+		meta:       make(map[string]*keyMeta),
+		leaseTable: makeLeaseTable(),
 	}
 }
 
-func (s *InMemoryStorage[T]) Get(key string) (T, error) {
+func (s *InMemoryStorage[T]) Get(key string) (T, bool, error) {
 	value, exists := s.data[key]
-	if !exists {
-		var zero T
-		return zero, nil
-	}
-	return value, nil
+	return value, exists, nil
 }
 
 func (s *InMemoryStorage[T]) Set(key string, value T) error {
 	s.data[key] = value
+	s.account(key, value)
 	return nil
 }
 
@@ -44,6 +59,10 @@ func (s *InMemoryStorage[T]) Exists(key string) (bool, error) {
 
 func (s *InMemoryStorage[T]) Delete(key string) error {
 	delete(s.data, key)
+	delete(s.expires, key)
+	delete(s.revisions, key)
+	s.unaccount(key)
+	s.detachLease(key)
 	return nil
 }
 
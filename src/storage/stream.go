@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"main/src/protocol"
+	"math"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrStreamIDTooSmall = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	ErrStreamIDZero     = errors.New("The ID specified in XADD must be greater than 0-0")
+)
+
+// Number of entries kept in a single stream segment.
+// Segments play the role of redis listpacks, approximate trimming drops whole segments.
+const streamSegmentSize = 128
+
+type StreamEntry struct {
+	ID     protocol.StreamID
+	Fields []string // flat field, value pairs
+}
+
+type streamSegment struct {
+	entries []StreamEntry
+}
+
+func (seg *streamSegment) first() protocol.StreamID {
+	return seg.entries[0].ID
+}
+
+func (seg *streamSegment) last() protocol.StreamID {
+	return seg.entries[len(seg.entries)-1].ID
+}
+
+// Stream is an append only log of entries ordered by ID.
+// Entries are kept in fixed size segments, so appends never copy the whole log
+// and trimming from the head is cheap.
+type Stream struct {
+	segments []*streamSegment
+	length   int
+	lastID   protocol.StreamID
+	groups   map[string]*ConsumerGroup
+}
+
+func NewStream() *Stream {
+	return &Stream{
+		groups: make(map[string]*ConsumerGroup),
+	}
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+// LastID returns the greatest ID ever added, it does not go back after trimming.
+func (s *Stream) LastID() protocol.StreamID {
+	return s.lastID
+}
+
+// NextID resolves ID for XADD: "*" uses nowMs, "<ms>-*" picks next sequence,
+// explicit IDs are validated against the top item.
+func (s *Stream) NextID(payload protocol.OpPayloadXAdd, nowMs uint64) (protocol.StreamID, error) {
+	switch {
+	case payload.AutoID:
+		if nowMs > s.lastID.Ms {
+			return protocol.StreamID{Ms: nowMs}, nil
+		}
+		id, ok := s.lastID.Next()
+		if !ok {
+			return id, ErrStreamIDTooSmall
+		}
+		return id, nil
+	case payload.AutoSeq:
+		if payload.ID.Ms > s.lastID.Ms {
+			return protocol.StreamID{Ms: payload.ID.Ms}, nil
+		}
+		if payload.ID.Ms < s.lastID.Ms || s.lastID.Seq == math.MaxUint64 {
+			return payload.ID, ErrStreamIDTooSmall
+		}
+		return protocol.StreamID{Ms: payload.ID.Ms, Seq: s.lastID.Seq + 1}, nil
+	default:
+		if payload.ID == protocol.MinStreamID {
+			return payload.ID, ErrStreamIDZero
+		}
+		if !s.lastID.Less(payload.ID) {
+			return payload.ID, ErrStreamIDTooSmall
+		}
+		return payload.ID, nil
+	}
+}
+
+// Append adds entry at the end, its ID has to be greater than LastID.
+func (s *Stream) Append(entry StreamEntry) error {
+	if !s.lastID.Less(entry.ID) {
+		return ErrStreamIDTooSmall
+	}
+
+	n := len(s.segments)
+	if n == 0 || len(s.segments[n-1].entries) >= streamSegmentSize {
+		s.segments = append(s.segments, &streamSegment{
+			entries: make([]StreamEntry, 0, streamSegmentSize),
+		})
+		n++
+	}
+	seg := s.segments[n-1]
+	seg.entries = append(seg.entries, entry)
+	s.length++
+	s.lastID = entry.ID
+	return nil
+}
+
+// Range returns entries with start <= ID <= end, at most count of them (negative means all).
+// When rev is set entries are returned from the end of the range.
+func (s *Stream) Range(start, end protocol.StreamID, count int64, rev bool) []StreamEntry {
+	result := []StreamEntry{}
+	if end.Less(start) || count == 0 {
+		return result
+	}
+
+	if rev {
+		// Last segment starting at or before end
+		si := sort.Search(len(s.segments), func(i int) bool { return end.Less(s.segments[i].first()) }) - 1
+		for ; si >= 0; si-- {
+			entries := s.segments[si].entries
+			ei := sort.Search(len(entries), func(i int) bool { return end.Less(entries[i].ID) }) - 1
+			for ; ei >= 0; ei-- {
+				if entries[ei].ID.Less(start) {
+					return result
+				}
+				result = append(result, entries[ei])
+				if count > 0 && int64(len(result)) >= count {
+					return result
+				}
+			}
+		}
+		return result
+	}
+
+	// First segment ending at or after start
+	si := sort.Search(len(s.segments), func(i int) bool { return !s.segments[i].last().Less(start) })
+	for ; si < len(s.segments); si++ {
+		entries := s.segments[si].entries
+		ei := sort.Search(len(entries), func(i int) bool { return !entries[i].ID.Less(start) })
+		for ; ei < len(entries); ei++ {
+			if end.Less(entries[ei].ID) {
+				return result
+			}
+			result = append(result, entries[ei])
+			if count > 0 && int64(len(result)) >= count {
+				return result
+			}
+		}
+	}
+	return result
+}
+
+// Get returns entry with exact ID
+func (s *Stream) Get(id protocol.StreamID) (StreamEntry, bool) {
+	entries := s.Range(id, id, 1, false)
+	if len(entries) == 0 {
+		return StreamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// Trim removes oldest entries according to MAXLEN or MINID strategy
+// and returns the number of removed entries.
+func (s *Stream) Trim(trim protocol.StreamTrim) int {
+	removed := 0
+	// Whole segments first
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+		var drop bool
+		if trim.MinID {
+			drop = seg.last().Less(trim.Threshold)
+		} else {
+			drop = int64(s.length-len(seg.entries)) >= trim.MaxLen
+		}
+		if !drop {
+			break
+		}
+		removed += len(seg.entries)
+		s.length -= len(seg.entries)
+		s.segments[0] = nil
+		s.segments = s.segments[1:]
+	}
+
+	if trim.Approx || len(s.segments) == 0 {
+		return removed
+	}
+
+	// Exact trimming cuts into the first segment
+	seg := s.segments[0]
+	var cut int
+	if trim.MinID {
+		cut = sort.Search(len(seg.entries), func(i int) bool { return !seg.entries[i].ID.Less(trim.Threshold) })
+	} else {
+		cut = s.length - int(trim.MaxLen)
+		if cut < 0 {
+			cut = 0
+		}
+	}
+	if cut > 0 {
+		seg.entries = append(make([]StreamEntry, 0, streamSegmentSize), seg.entries[cut:]...)
+		s.length -= cut
+		removed += cut
+	}
+	return removed
+}
+
+// Entries returns all entries in order
+func (s *Stream) Entries() []StreamEntry {
+	return s.Range(protocol.MinStreamID, protocol.MaxStreamID, -1, false)
+}
+
+func (s *Stream) Kind() string {
+	return "stream"
+}
+
+// MemoryUsage extrapolates from entries at the head and the tail,
+// pending entries of consumer groups are counted too
+func (s *Stream) MemoryUsage() int64 {
+	var sampled []int64
+	sample := func(seg *streamSegment) {
+		for i := 0; i < len(seg.entries) && i < memorySamples; i++ {
+			size := int64(16) // ID
+			for _, field := range seg.entries[i].Fields {
+				size += int64(len(field)) + memberOverhead
+			}
+			sampled = append(sampled, size)
+		}
+	}
+	if len(s.segments) > 0 {
+		sample(s.segments[0])
+		if len(s.segments) > 1 {
+			sample(s.segments[len(s.segments)-1])
+		}
+	}
+	size := sampledSize(s.length, sampled)
+	for name, g := range s.groups {
+		size += int64(len(name)) + keyOverhead + int64(len(g.pel))*(48+memberOverhead)
+		for consumer := range g.consumers {
+			size += int64(len(consumer)) + 24 + memberOverhead
+		}
+	}
+	return size
+}
+
+// Encode renders stream as [lastID, [id, [fields]]..., [groups]...]
+func (s *Stream) Encode() protocol.Resp2Value {
+	entries := make([]protocol.Resp2Value, 0, s.length)
+	for _, e := range s.Entries() {
+		entries = append(entries, EncodeStreamEntry(e))
+	}
+
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	groups := make([]protocol.Resp2Value, 0, len(names))
+	for _, name := range names {
+		groups = append(groups, s.groups[name].encode())
+	}
+
+	return []protocol.Resp2Value{
+		protocol.Resp2BulkString(s.lastID.String()),
+		entries,
+		groups,
+	}
+}
+
+func DecodeStream(value protocol.Resp2Value) (*Stream, error) {
+	arr, ok := value.([]protocol.Resp2Value)
+	if !ok || len(arr) != 3 {
+		return nil, fmt.Errorf("invalid stream format: expected 3 element array")
+	}
+	lastID, err := decodeStreamID(arr[0])
+	if err != nil {
+		return nil, err
+	}
+	entries, ok := arr[1].([]protocol.Resp2Value)
+	if !ok {
+		return nil, fmt.Errorf("invalid stream format: expected entries array")
+	}
+	groups, ok := arr[2].([]protocol.Resp2Value)
+	if !ok {
+		return nil, fmt.Errorf("invalid stream format: expected groups array")
+	}
+
+	s := NewStream()
+	for _, v := range entries {
+		entry, err := DecodeStreamEntry(v)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Append(entry); err != nil {
+			return nil, err
+		}
+	}
+	s.lastID = lastID
+
+	for _, v := range groups {
+		g, err := decodeConsumerGroup(v)
+		if err != nil {
+			return nil, err
+		}
+		s.groups[g.Name] = g
+	}
+	return s, nil
+}
+
+// EncodeStreamEntry renders entry as [id, [field, value, ...]],
+// the same shape is used in XRANGE replies.
+func EncodeStreamEntry(e StreamEntry) protocol.Resp2Value {
+	return []protocol.Resp2Value{
+		protocol.Resp2BulkString(e.ID.String()),
+		membersToResp(e.Fields),
+	}
+}
+
+func DecodeStreamEntry(value protocol.Resp2Value) (StreamEntry, error) {
+	arr, ok := value.([]protocol.Resp2Value)
+	if !ok || len(arr) != 2 {
+		return StreamEntry{}, fmt.Errorf("invalid stream entry format: expected [id, fields]")
+	}
+	id, err := decodeStreamID(arr[0])
+	if err != nil {
+		return StreamEntry{}, err
+	}
+	fields, err := respToMembers(arr[1])
+	if err != nil {
+		return StreamEntry{}, err
+	}
+	return StreamEntry{ID: id, Fields: fields}, nil
+}
+
+func decodeStreamID(value protocol.Resp2Value) (protocol.StreamID, error) {
+	s, ok := value.(protocol.Resp2BulkString)
+	if !ok {
+		return protocol.StreamID{}, fmt.Errorf("invalid stream id format: expected bulk string, got %T", value)
+	}
+	return protocol.ParseStreamID(string(s), 0)
+}
+
+// GetStream returns the stream stored under key, nil if key does not exist
+// or ErrWrongType if key holds a different kind of value.
+func GetStream[T any](store Storage[T], key string) (*Stream, error) {
+	value, found, err := store.Get(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	stream, ok := any(value).(*Stream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return stream, nil
+}
+
+// EncodeStreamTrim renders trimming strategy for XTRIM WAL entries
+func EncodeStreamTrim(trim protocol.StreamTrim) protocol.Resp2Value {
+	strategy, threshold := "MAXLEN", strconv.FormatInt(trim.MaxLen, 10)
+	if trim.MinID {
+		strategy, threshold = "MINID", trim.Threshold.String()
+	}
+	mode := "="
+	if trim.Approx {
+		mode = "~"
+	}
+	return membersToResp([]string{strategy, mode, threshold})
+}
+
+func decodeStreamTrim(value protocol.Resp2Value) (protocol.StreamTrim, error) {
+	args, err := respToMembers(value)
+	if err != nil || len(args) != 3 {
+		return protocol.StreamTrim{}, fmt.Errorf("invalid stream trim format")
+	}
+	trim := protocol.StreamTrim{MinID: args[0] == "MINID", Approx: args[1] == "~"}
+	if trim.MinID {
+		trim.Threshold, err = protocol.ParseStreamID(args[2], 0)
+	} else {
+		trim.MaxLen, err = strconv.ParseInt(args[2], 10, 64)
+	}
+	return trim, err
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"main/src/protocol"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrNoGroup   = errors.New("NOGROUP No such key or consumer group")
+	ErrBusyGroup = errors.New("BUSYGROUP Consumer Group name already exists")
+)
+
+// PendingEntry is a message delivered to a consumer but not acknowledged yet.
+type PendingEntry struct {
+	ID            protocol.StreamID
+	Consumer      string
+	DeliveryTime  int64 // unix milliseconds of the last delivery
+	DeliveryCount int64
+}
+
+type StreamConsumer struct {
+	Name     string
+	SeenTime int64 // unix milliseconds of the last interaction
+}
+
+// ConsumerGroup tracks delivery of stream entries to a set of consumers.
+// Times are always passed in by caller, so state does not depend on the clock during replay.
+type ConsumerGroup struct {
+	Name          string
+	LastDelivered protocol.StreamID
+	pel           map[protocol.StreamID]*PendingEntry
+	consumers     map[string]*StreamConsumer
+}
+
+func newConsumerGroup(name string, lastDelivered protocol.StreamID) *ConsumerGroup {
+	return &ConsumerGroup{
+		Name:          name,
+		LastDelivered: lastDelivered,
+		pel:           make(map[protocol.StreamID]*PendingEntry),
+		consumers:     make(map[string]*StreamConsumer),
+	}
+}
+
+func (s *Stream) CreateGroup(name string, lastDelivered protocol.StreamID) error {
+	if _, ok := s.groups[name]; ok {
+		return ErrBusyGroup
+	}
+	s.groups[name] = newConsumerGroup(name, lastDelivered)
+	return nil
+}
+
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Group returns consumer group or nil if it does not exist
+func (s *Stream) Group(name string) *ConsumerGroup {
+	return s.groups[name]
+}
+
+// getGroup returns consumer group of the stream stored under key or ErrNoGroup
+func getGroup[T any](store Storage[T], key, name string) (*ConsumerGroup, error) {
+	stream, err := GetStream(store, key)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil || stream.Group(name) == nil {
+		return nil, ErrNoGroup
+	}
+	return stream.Group(name), nil
+}
+
+func (g *ConsumerGroup) touch(consumer string, now int64) {
+	c, ok := g.consumers[consumer]
+	if !ok {
+		c = &StreamConsumer{Name: consumer}
+		g.consumers[consumer] = c
+	}
+	c.SeenTime = now
+}
+
+// Deliver records delivery of ids to consumer and moves LastDelivered forward.
+// With noack entries are not added to the pending entries list.
+func (g *ConsumerGroup) Deliver(consumer string, ids []protocol.StreamID, now int64, noack bool) {
+	g.touch(consumer, now)
+	for _, id := range ids {
+		if g.LastDelivered.Less(id) {
+			g.LastDelivered = id
+		}
+		if noack {
+			continue
+		}
+		pending, ok := g.pel[id]
+		if !ok {
+			pending = &PendingEntry{ID: id}
+			g.pel[id] = pending
+		}
+		pending.Consumer = consumer
+		pending.DeliveryTime = now
+		pending.DeliveryCount++
+	}
+}
+
+// Ack removes ids from the pending entries list, returns number of acknowledged entries.
+func (g *ConsumerGroup) Ack(ids []protocol.StreamID) int {
+	acked := 0
+	for _, id := range ids {
+		if _, ok := g.pel[id]; ok {
+			delete(g.pel, id)
+			acked++
+		}
+	}
+	return acked
+}
+
+// Claim changes ownership of pending entries, delivery counter is not
+// incremented when justID is set (like XCLAIM JUSTID).
+func (g *ConsumerGroup) Claim(consumer string, ids []protocol.StreamID, now int64, justID bool) {
+	g.touch(consumer, now)
+	for _, id := range ids {
+		pending, ok := g.pel[id]
+		if !ok {
+			continue
+		}
+		pending.Consumer = consumer
+		pending.DeliveryTime = now
+		if !justID {
+			pending.DeliveryCount++
+		}
+	}
+}
+
+// PendingEntry returns pending entry with given id
+func (g *ConsumerGroup) PendingEntry(id protocol.StreamID) (PendingEntry, bool) {
+	pending, ok := g.pel[id]
+	if !ok {
+		return PendingEntry{}, false
+	}
+	return *pending, true
+}
+
+// Pending returns pending entries within range ordered by ID,
+// optionally filtered by consumer (empty means all) and count (negative means all).
+func (g *ConsumerGroup) Pending(start, end protocol.StreamID, count int64, consumer string) []PendingEntry {
+	result := []PendingEntry{}
+	for _, id := range g.pendingIDs() {
+		if id.Less(start) || end.Less(id) {
+			continue
+		}
+		pending := g.pel[id]
+		if consumer != "" && pending.Consumer != consumer {
+			continue
+		}
+		if count >= 0 && int64(len(result)) >= count {
+			break
+		}
+		result = append(result, *pending)
+	}
+	return result
+}
+
+// PendingCount returns number of pending entries per consumer
+func (g *ConsumerGroup) PendingCount() map[string]int {
+	counts := make(map[string]int)
+	for _, pending := range g.pel {
+		counts[pending.Consumer]++
+	}
+	return counts
+}
+
+func (g *ConsumerGroup) pendingIDs() []protocol.StreamID {
+	ids := make([]protocol.StreamID, 0, len(g.pel))
+	for id := range g.pel {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+	return ids
+}
+
+// encode renders group as [name, lastDelivered, [id, consumer, time, count]..., [consumer, seen]...]
+func (g *ConsumerGroup) encode() protocol.Resp2Value {
+	pel := make([]string, 0, len(g.pel)*4)
+	for _, id := range g.pendingIDs() {
+		p := g.pel[id]
+		pel = append(pel, id.String(), p.Consumer,
+			strconv.FormatInt(p.DeliveryTime, 10), strconv.FormatInt(p.DeliveryCount, 10))
+	}
+
+	names := make([]string, 0, len(g.consumers))
+	for name := range g.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	consumers := make([]string, 0, len(names)*2)
+	for _, name := range names {
+		consumers = append(consumers, name, strconv.FormatInt(g.consumers[name].SeenTime, 10))
+	}
+
+	return []protocol.Resp2Value{
+		protocol.Resp2BulkString(g.Name),
+		protocol.Resp2BulkString(g.LastDelivered.String()),
+		membersToResp(pel),
+		membersToResp(consumers),
+	}
+}
+
+func decodeConsumerGroup(value protocol.Resp2Value) (*ConsumerGroup, error) {
+	arr, ok := value.([]protocol.Resp2Value)
+	if !ok || len(arr) != 4 {
+		return nil, fmt.Errorf("invalid consumer group format: expected 4 element array")
+	}
+	name, ok := arr[0].(protocol.Resp2BulkString)
+	if !ok {
+		return nil, fmt.Errorf("invalid consumer group format: expected bulk string for name")
+	}
+	lastDelivered, err := decodeStreamID(arr[1])
+	if err != nil {
+		return nil, err
+	}
+	pel, err := respToMembers(arr[2])
+	if err != nil || len(pel)%4 != 0 {
+		return nil, fmt.Errorf("invalid consumer group format: malformed pending entries")
+	}
+	consumers, err := respToMembers(arr[3])
+	if err != nil || len(consumers)%2 != 0 {
+		return nil, fmt.Errorf("invalid consumer group format: malformed consumers")
+	}
+
+	g := newConsumerGroup(string(name), lastDelivered)
+	for i := 0; i < len(pel); i += 4 {
+		id, err := protocol.ParseStreamID(pel[i], 0)
+		if err != nil {
+			return nil, err
+		}
+		deliveryTime, err1 := strconv.ParseInt(pel[i+2], 10, 64)
+		deliveryCount, err2 := strconv.ParseInt(pel[i+3], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid consumer group format: malformed pending entry")
+		}
+		g.pel[id] = &PendingEntry{ID: id, Consumer: pel[i+1], DeliveryTime: deliveryTime, DeliveryCount: deliveryCount}
+	}
+	for i := 0; i < len(consumers); i += 2 {
+		seen, err := strconv.ParseInt(consumers[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid consumer group format: malformed consumer")
+		}
+		g.consumers[consumers[i]] = &StreamConsumer{Name: consumers[i], SeenTime: seen}
+	}
+	return g, nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"main/src/protocol"
+	"strconv"
+)
+
+// StringValue converts plain value to its string form,
+// ok is false for values string commands cannot operate on (composite values, arrays).
+// Stored nil is an empty string, so found keys are never reported as missing.
+func StringValue(value any) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case protocol.Resp2BulkString:
+		return string(v), true
+	case protocol.Resp2SimpleString:
+		return string(v), true
+	case string:
+		return v, true
+	case protocol.Resp2Integer:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case int:
+		return strconv.Itoa(v), true
+	default:
+		return "", false
+	}
+}
+
+// GetString returns value stored under key as string, false if key does not exist
+// or ErrWrongType if key holds a value string commands cannot operate on.
+func GetString[T any](store Storage[T], key string) (string, bool, error) {
+	value, found, err := store.Get(key)
+	if err != nil || !found {
+		return "", false, err
+	}
+	s, ok := StringValue(value)
+	if !ok {
+		return "", false, ErrWrongType
+	}
+	return s, true, nil
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// TieredStorage keeps recently used values in memory and moves cold ones to a value log
+// on disk, while keys with their deadlines, revisions and leases always stay in memory.
+//
+// Once values held in memory grow over HotSize, least recently used keys sampled the same
+// way eviction samples them are moved to the log. Reading a cold key loads its value back,
+// it is promoted to memory by the next write. Composite values modified in place by Apply
+// are promoted right away, as Apply records revision of every key it modified.
+//
+// Like InMemoryStorage it must not be modified concurrently, reads may run concurrently
+// with each other. The value log is compacted in the background.
+type TieredStorage[T any] struct {
+	*InMemoryStorage[T] // hot values and metadata of all keys
+	opts                TieredOptions
+	vlog                *valueLog
+	coldUsed            int64 // memory of keys whose values are in the log
+
+	// Values of cold keys read since the last write, see LSMStorage.loaded
+	loadMu sync.Mutex
+	loaded map[string]T
+}
+
+// TieredOptions tune what TieredStorage keeps in memory and sizes of its value log
+type TieredOptions struct {
+	HotSize      int64   // bytes of values kept in memory
+	SegmentSize  int64   // target size of value log segments in bytes
+	GarbageRatio float64 // share of garbage which gets a sealed segment compacted
+	Samples      int     // keys sampled to pick each key moved to disk
+}
+
+func DefaultTieredOptions() TieredOptions {
+	return TieredOptions{
+		HotSize:      64 * 1024 * 1024,
+		SegmentSize:  4 * 1024 * 1024,
+		GarbageRatio: 0.5,
+		Samples:      5,
+	}
+}
+
+const tieredLoadedLimit = 1024 // max number of loaded values waiting for promotion
+
+// MakeTieredStorage returns empty storage keeping its value log in dir,
+// segments are created once values are moved out of memory
+func MakeTieredStorage[T any](dir string, opts TieredOptions) *TieredStorage[T] {
+	return &TieredStorage[T]{
+		InMemoryStorage: MakeInMemoryStorage[T](),
+		opts:            opts,
+		vlog:            newValueLog(dir, opts.SegmentSize, opts.GarbageRatio),
+		loaded:          make(map[string]T),
+	}
+}
+
+// coldKeySize is memory held by a key whose value is in the log
+func coldKeySize(key string) int64 {
+	return int64(len(key)) + keyOverhead
+}
+
+func (s *TieredStorage[T]) Get(key string) (T, bool, error) {
+	if value, ok := s.data[key]; ok {
+		return value, true, nil
+	}
+	s.loadMu.Lock()
+	value, ok := s.loaded[key]
+	s.loadMu.Unlock()
+	if ok {
+		return value, true, nil
+	}
+
+	value, found, err := s.load(key)
+	if err != nil || !found {
+		return value, false, err
+	}
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	if cached, ok := s.loaded[key]; ok {
+		// Concurrent reader loaded it first
+		return cached, true, nil
+	}
+	if len(s.loaded) >= tieredLoadedLimit {
+		s.loaded = make(map[string]T)
+	}
+	s.loaded[key] = value
+	return value, true, nil
+}
+
+// load reads value of a cold key from the log
+func (s *TieredStorage[T]) load(key string) (T, bool, error) {
+	var zero T
+	data, found, err := s.vlog.get(key)
+	if err != nil || !found {
+		return zero, false, err
+	}
+	value, err := decodeRecord[T](data)
+	if err != nil {
+		return zero, false, fmt.Errorf("invalid record of key %q: %w", key, err)
+	}
+	return value, true, nil
+}
+
+// Set keeps the value in memory and moves other keys to the log if needed
+func (s *TieredStorage[T]) Set(key string, value T) error {
+	s.forget(key)
+	s.InMemoryStorage.Set(key, value)
+	s.meta[key].access.Store(time.Now().UnixMilli())
+	return s.balance(key)
+}
+
+func (s *TieredStorage[T]) Delete(key string) error {
+	s.forget(key)
+	return s.InMemoryStorage.Delete(key)
+}
+
+// forget drops the loaded value and the record of a cold key
+func (s *TieredStorage[T]) forget(key string) {
+	s.loadMu.Lock()
+	delete(s.loaded, key)
+	s.loadMu.Unlock()
+	if s.vlog.remove(key) {
+		s.coldUsed -= coldKeySize(key)
+	}
+}
+
+func (s *TieredStorage[T]) Exists(key string) (bool, error) {
+	if _, ok := s.data[key]; ok {
+		return true, nil
+	}
+	return s.vlog.contains(key), nil
+}
+
+// Iterator walks keys in memory first, cold values are read without being promoted
+func (s *TieredStorage[T]) Iterator() func(func(string, T) bool) {
+	return func(yield func(string, T) bool) {
+		for key, value := range s.data {
+			if !yield(key, value) {
+				return
+			}
+		}
+		for _, key := range s.vlog.keys() {
+			s.loadMu.Lock()
+			value, ok := s.loaded[key]
+			s.loadMu.Unlock()
+			if !ok {
+				var err error
+				if value, ok, err = s.load(key); err != nil {
+					panic(err)
+				}
+			}
+			if ok && !yield(key, value) {
+				return
+			}
+		}
+	}
+}
+
+func (s *TieredStorage[T]) SetExpire(key string, atMs int64) error {
+	if exists, _ := s.Exists(key); !exists {
+		return fmt.Errorf("cannot set expiration of missing key %q", key)
+	}
+	s.expires[key] = atMs
+	return nil
+}
+
+func (s *TieredStorage[T]) AttachLease(id uint64, key string) error {
+	exists, _ := s.Exists(key)
+	return s.attachLease(id, key, exists)
+}
+
+// SetRevision promotes value of the key loaded by Apply, it may have been modified in place
+func (s *TieredStorage[T]) SetRevision(key string, revision uint64) {
+	s.loadMu.Lock()
+	value, ok := s.loaded[key]
+	delete(s.loaded, key)
+	s.loadMu.Unlock()
+	if ok {
+		s.promote(key, value)
+	}
+	s.InMemoryStorage.SetRevision(key, revision)
+}
+
+// promote moves value of a cold key back to memory
+func (s *TieredStorage[T]) promote(key string, value T) {
+	if !s.vlog.remove(key) {
+		return
+	}
+	s.coldUsed -= coldKeySize(key)
+	s.InMemoryStorage.Set(key, value)
+	s.meta[key].access.Store(time.Now().UnixMilli())
+}
+
+// Resize is called by Apply once an entry is applied, so values loaded by reads are promoted
+// and keys are moved to the log here. Keys failing to be moved stay in memory.
+func (s *TieredStorage[T]) Resize(key string) {
+	s.loadMu.Lock()
+	loaded := s.loaded
+	s.loaded = make(map[string]T)
+	s.loadMu.Unlock()
+	for k, value := range loaded {
+		s.promote(k, value)
+	}
+	s.InMemoryStorage.Resize(key)
+	s.balance("")
+}
+
+// balance moves least recently used values to the log until values in memory fit HotSize,
+// keep is never moved as its value is being stored
+func (s *TieredStorage[T]) balance(keep string) error {
+	for s.used > s.opts.HotSize {
+		victim := ""
+		oldest := int64(math.MaxInt64)
+		// One more than needed, so the kept key alone is never sampled
+		for _, stats := range s.InMemoryStorage.SampleKeys(s.opts.Samples+1, false) {
+			if stats.Key != keep && stats.Access < oldest {
+				victim, oldest = stats.Key, stats.Access
+			}
+		}
+		if victim == "" {
+			return nil
+		}
+		if err := s.demote(victim); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// demote moves value of the key to the log
+func (s *TieredStorage[T]) demote(key string) error {
+	data, err := encodeRecord(&memRecord[T]{value: s.data[key]})
+	if err != nil {
+		return err
+	}
+	if err := s.vlog.put(key, data); err != nil {
+		return err
+	}
+	delete(s.data, key)
+	s.unaccount(key)
+	s.coldUsed += coldKeySize(key)
+	return nil
+}
+
+// UsedMemory counts values in memory and keys of cold values, values in the log are on disk
+func (s *TieredStorage[T]) UsedMemory() int64 {
+	return s.used + s.coldUsed
+}
+
+func (s *TieredStorage[T]) KeyMemory(key string) int64 {
+	if _, ok := s.data[key]; ok {
+		return s.InMemoryStorage.KeyMemory(key)
+	}
+	if s.vlog.contains(key) {
+		return coldKeySize(key)
+	}
+	return 0
+}
+
+// SampleKeys samples cold keys first, they were not accessed since they were moved to the log
+func (s *TieredStorage[T]) SampleKeys(n int, volatile bool) []KeyStats {
+	if volatile {
+		sample := make([]KeyStats, 0, n)
+		for key, atMs := range s.expires {
+			if len(sample) >= n {
+				break
+			}
+			stats := KeyStats{Key: key, Memory: coldKeySize(key)}
+			if m, ok := s.meta[key]; ok {
+				stats = m.stats(key)
+			}
+			stats.ExpireAt = atMs
+			sample = append(sample, stats)
+		}
+		return sample
+	}
+	sample := make([]KeyStats, 0, n)
+	for _, key := range s.vlog.sample(n) {
+		sample = append(sample, KeyStats{Key: key, Memory: coldKeySize(key), ExpireAt: s.expires[key]})
+	}
+	return append(sample, s.InMemoryStorage.SampleKeys(n-len(sample), false)...)
+}
+
+// ColdKeys returns number of keys whose values are in the log
+func (s *TieredStorage[T]) ColdKeys() int {
+	return s.vlog.len()
+}
+
+// DiskUsage returns bytes of value log segments and bytes of live records in them,
+// the difference is garbage waiting for compaction
+func (s *TieredStorage[T]) DiskUsage() (size, live int64) {
+	return s.vlog.usage()
+}
+
+// Close releases the value log, the storage must not be used afterwards
+func (s *TieredStorage[T]) Close() error {
+	return s.vlog.close()
+}
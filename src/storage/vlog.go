@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"os"
+	"sync"
+)
+
+// Value log of TieredStorage.
+// Cold values are appended to segment files, their keys stay in memory with a pointer
+// to the record. The log only moves values out of memory, data is made durable by the WAL
+// and snapshots like for in-memory storages, so segments are unlinked right after they are
+// created and never outlive the process. Records of overwritten, deleted and promoted keys
+// become garbage, sealed segments with enough of it are compacted in the background by
+// copying their live records into the active segment.
+
+type vlogSegment struct {
+	fd   *os.File
+	size int64               // bytes written
+	live int64               // bytes of records keys still point to
+	keys map[string]struct{} // keys pointing into the segment
+}
+
+type valuePointer struct {
+	segment *vlogSegment
+	offset  int64
+	size    int64
+}
+
+func (p valuePointer) read() ([]byte, error) {
+	data := make([]byte, p.size)
+	if _, err := p.segment.fd.ReadAt(data, p.offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type valueLog struct {
+	dir          string
+	segmentSize  int64
+	garbageRatio float64
+
+	mu         sync.RWMutex // guards everything below, readers of records hold it shared
+	pointers   map[string]valuePointer
+	active     *vlogSegment
+	sealed     map[*vlogSegment]struct{}
+	compacting bool
+	closed     bool
+	compactor  sync.WaitGroup
+}
+
+func newValueLog(dir string, segmentSize int64, garbageRatio float64) *valueLog {
+	return &valueLog{
+		dir:          dir,
+		segmentSize:  segmentSize,
+		garbageRatio: garbageRatio,
+		pointers:     make(map[string]valuePointer),
+		sealed:       make(map[*vlogSegment]struct{}),
+	}
+}
+
+// get returns the record of the key, false if the key is not in the log
+func (l *valueLog) get(key string) ([]byte, bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	p, ok := l.pointers[key]
+	if !ok {
+		return nil, false, nil
+	}
+	data, err := p.read()
+	return data, err == nil, err
+}
+
+func (l *valueLog) contains(key string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.pointers[key]
+	return ok
+}
+
+func (l *valueLog) len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.pointers)
+}
+
+// keys returns keys having a record in the log
+func (l *valueLog) keys() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	keys := make([]string, 0, len(l.pointers))
+	for key := range l.pointers {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// sample relies on randomized map iteration order
+func (l *valueLog) sample(n int) []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	keys := make([]string, 0, n)
+	for key := range l.pointers {
+		if len(keys) >= n {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// usage returns bytes of all segments and bytes of live records
+func (l *valueLog) usage() (size, live int64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for seg := range l.sealed {
+		size += seg.size
+		live += seg.live
+	}
+	if l.active != nil {
+		size += l.active.size
+		live += l.active.live
+	}
+	return size, live
+}
+
+// put appends the record of the key, its previous record becomes garbage
+func (l *valueLog) put(key string, data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, err := l.append(data)
+	if err != nil {
+		return err
+	}
+	l.drop(key)
+	l.point(key, p)
+	l.maybeCompact()
+	return nil
+}
+
+// remove drops the record of the key, returns false if the key was not in the log
+func (l *valueLog) remove(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.drop(key) {
+		return false
+	}
+	l.maybeCompact()
+	return true
+}
+
+// append writes the record to the active segment, a full segment is sealed first.
+// Caller must hold the lock.
+func (l *valueLog) append(data []byte) (valuePointer, error) {
+	if l.active == nil || l.active.size+int64(len(data)) > l.segmentSize && l.active.size > 0 {
+		seg, err := l.newSegment()
+		if err != nil {
+			return valuePointer{}, err
+		}
+		if l.active != nil {
+			l.seal(l.active)
+		}
+		l.active = seg
+	}
+	if _, err := l.active.fd.WriteAt(data, l.active.size); err != nil {
+		return valuePointer{}, err
+	}
+	p := valuePointer{segment: l.active, offset: l.active.size, size: int64(len(data))}
+	l.active.size += p.size
+	return p, nil
+}
+
+func (l *valueLog) newSegment() (*vlogSegment, error) {
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+	fd, err := os.CreateTemp(l.dir, "*.vlog")
+	if err != nil {
+		return nil, err
+	}
+	// Nothing is recovered from the log, the file goes away together with the descriptor
+	if err := os.Remove(fd.Name()); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &vlogSegment{fd: fd, keys: make(map[string]struct{})}, nil
+}
+
+// seal stops writes to the segment, once nothing points into it, it is closed right away
+func (l *valueLog) seal(seg *vlogSegment) {
+	if seg.live == 0 {
+		seg.fd.Close()
+		return
+	}
+	l.sealed[seg] = struct{}{}
+}
+
+func (l *valueLog) point(key string, p valuePointer) {
+	l.pointers[key] = p
+	p.segment.live += p.size
+	p.segment.keys[key] = struct{}{}
+}
+
+// drop turns the record of the key into garbage. Caller must hold the lock.
+func (l *valueLog) drop(key string) bool {
+	p, ok := l.pointers[key]
+	if !ok {
+		return false
+	}
+	delete(l.pointers, key)
+	delete(p.segment.keys, key)
+	p.segment.live -= p.size
+	if _, sealed := l.sealed[p.segment]; sealed && p.segment.live == 0 {
+		delete(l.sealed, p.segment)
+		p.segment.fd.Close()
+	}
+	return true
+}
+
+// candidate returns a sealed segment worth compacting, nil if there is none
+func (l *valueLog) candidate() *vlogSegment {
+	for seg := range l.sealed {
+		if float64(seg.size-seg.live) >= l.garbageRatio*float64(seg.size) {
+			return seg
+		}
+	}
+	return nil
+}
+
+// maybeCompact starts the compactor when some segment has enough garbage. Caller must hold the lock.
+func (l *valueLog) maybeCompact() {
+	if l.compacting || l.closed || l.candidate() == nil {
+		return
+	}
+	l.compacting = true
+	l.compactor.Add(1)
+	go l.compact()
+}
+
+// compact rewrites segments picked by candidate until none is left
+func (l *valueLog) compact() {
+	defer l.compactor.Done()
+	for {
+		l.mu.Lock()
+		seg := l.candidate()
+		if seg == nil || l.closed {
+			l.compacting = false
+			l.mu.Unlock()
+			return
+		}
+		l.mu.Unlock()
+
+		if err := l.compactSegment(seg); err != nil {
+			// Records stay where they are, the next write of garbage retries
+			l.mu.Lock()
+			l.compacting = false
+			l.mu.Unlock()
+			return
+		}
+	}
+}
+
+// compactSegment reads live records sharing the lock with readers, so only moving
+// them to the active segment excludes readers and writers
+func (l *valueLog) compactSegment(seg *vlogSegment) error {
+	type record struct {
+		key  string
+		from valuePointer
+		data []byte
+	}
+	l.mu.RLock()
+	records := make([]record, 0, len(seg.keys))
+	for key := range seg.keys {
+		p := l.pointers[key]
+		data, err := p.read()
+		if err != nil {
+			l.mu.RUnlock()
+			return err
+		}
+		records = append(records, record{key, p, data})
+	}
+	l.mu.RUnlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.sealed[seg]; !ok {
+		// Every record became garbage meanwhile, the segment is closed already
+		return nil
+	}
+	for _, r := range records {
+		// Keys written or deleted meanwhile point elsewhere
+		if l.pointers[r.key] != r.from {
+			continue
+		}
+		p, err := l.append(r.data)
+		if err != nil {
+			return err
+		}
+		l.drop(r.key)
+		l.point(r.key, p)
+	}
+	// Records appended to the segment after it was read are never left behind, it is sealed
+	if _, ok := l.sealed[seg]; ok {
+		delete(l.sealed, seg)
+		seg.fd.Close()
+	}
+	return nil
+}
+
+// close waits for the compactor and closes segments
+func (l *valueLog) close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.compactor.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	for seg := range l.sealed {
+		if closeErr := seg.fd.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if l.active != nil {
+		if closeErr := l.active.fd.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	l.pointers = make(map[string]valuePointer)
+	l.sealed = make(map[*vlogSegment]struct{})
+	l.active = nil
+	return err
+}
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"main/src/protocol"
 	"os"
@@ -104,9 +107,17 @@ func (w *SimpleWal[T]) Size() int64 {
 	return w.size
 }
 
+// Records are framed by a checksum of their fields, so a record torn by a crash
+// is told apart from a complete one. CRC32 (Castagnoli) of the bytes of the first
+// six fields is appended as the last field. It is verified against bytes read
+// from the file, as RESP does not render every value back the same way.
+var walChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errWalChecksum = errors.New("WAL record checksum mismatch")
+
 func (w *SimpleWal[T]) Append(entry WalEntry[T], sync bool) error {
 	// Serialize entry to RESP2 Array
-	// [Index, Timestamp, Term, OpType, Key, Value]
+	// [Index, Timestamp, Term, OpType, Key, Value, Checksum]
 	arr := []protocol.Resp2Value{
 		protocol.Resp2Integer(entry.Index),
 		protocol.Resp2Integer(entry.Timestamp),
@@ -117,18 +128,26 @@ func (w *SimpleWal[T]) Append(entry WalEntry[T], sync bool) error {
 	}
 
 	parser := protocol.NewResp2Parser(nil, 0)
-	payload, err := parser.Render(arr)
+	rendered, err := parser.Render(arr)
 	if err != nil {
 		return err
 	}
-
-	// Write to file
-	n, err := w.fd.Write(payload)
+	fields := rendered[bytes.Index(rendered, []byte("\r\n"))+2:]
+	checksum, err := parser.Render(protocol.Resp2Integer(crc32.Checksum(fields, walChecksumTable)))
 	if err != nil {
 		return err
 	}
+	payload := make([]byte, 0, len(rendered)+len(checksum))
+	payload = append(payload, "*7\r\n"...)
+	payload = append(payload, fields...)
+	payload = append(payload, checksum...)
 
+	// Record is written by a single call, a crash leaves at most its prefix at the end of the file
+	n, err := w.fd.Write(payload)
 	w.size += int64(n)
+	if err != nil {
+		return err
+	}
 
 	if sync {
 		return w.fd.Sync()
@@ -136,84 +155,147 @@ func (w *SimpleWal[T]) Append(entry WalEntry[T], sync bool) error {
 	return nil
 }
 
+// verifyChecksum checks the checksum field of a parsed record against its raw bytes,
+// they are the array header, the fields and the checksum, which is the only integer
+// field containing no ':' after its type byte
+func verifyChecksum(record []byte, checksum protocol.Resp2Integer) error {
+	start := bytes.IndexByte(record, '*')
+	if start < 0 {
+		return errWalChecksum
+	}
+	header := bytes.Index(record[start:], []byte("\r\n"))
+	end := bytes.LastIndexByte(record, ':')
+	if header < 0 || end < start+header+2 {
+		return errWalChecksum
+	}
+	if crc32.Checksum(record[start+header+2:end], walChecksumTable) != uint32(checksum) {
+		return errWalChecksum
+	}
+	return nil
+}
+
 // Replay reads the log from the beginning and returns all entries.
+// A record torn by a crash at the end of the log is dropped and truncated,
+// so neither it nor any part of a batch it carries is ever applied.
+// Records written before checksums were added are read without verification.
 func (w *SimpleWal[T]) Replay() ([]WalEntry[T], error) {
 	// Seek to the beginning of the file
 	if _, err := w.fd.Seek(0, 0); err != nil {
 		return nil, err
 	}
+	stat, err := w.fd.Stat()
+	if err != nil {
+		return nil, err
+	}
 
 	var entries []WalEntry[T]
-	parser := protocol.NewResp2Parser(w.fd, 0)
+	// Bytes read ahead by the parser wait in raw until their record is parsed
+	var raw bytes.Buffer
+	parser := protocol.NewResp2Parser(io.TeeReader(w.fd, &raw), 0)
+	var offset int64 // end of the last complete record
 
 	for {
 		val, err := parser.Parse()
-		if err == io.EOF {
+		if err == io.EOF && parser.BytesRead() == 0 {
 			break
 		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return entries, w.truncate(offset)
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		arr, ok := val.([]protocol.Resp2Value)
-		if !ok {
-			return nil, fmt.Errorf("invalid WAL entry format: expected array")
+		entry, err := decodeWalEntry[T](raw.Next(int(parser.BytesRead())), val)
+		if err == errWalChecksum && offset+parser.BytesRead() == stat.Size() {
+			return entries, w.truncate(offset)
 		}
-
-		if len(arr) != 6 {
-			return nil, fmt.Errorf("invalid WAL entry format: expected 6 elements, got %d", len(arr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid WAL record at offset %d: %w", offset, err)
 		}
+		entries = append(entries, entry)
+		offset += parser.BytesRead()
+	}
+	return entries, nil
+}
 
-		index, ok := arr[0].(protocol.Resp2Integer)
-		if !ok {
-			return nil, fmt.Errorf("invalid WAL entry format: expected integer for Index")
-		}
+// truncate drops a torn record at the end of the log, following appends start after the last complete one
+func (w *SimpleWal[T]) truncate(offset int64) error {
+	if err := w.fd.Truncate(offset); err != nil {
+		return err
+	}
+	w.size = offset
+	return nil
+}
 
-		timestamp, ok := arr[1].(protocol.Resp2Integer)
-		if !ok {
-			return nil, fmt.Errorf("invalid WAL entry format: expected integer for Timestamp")
-		}
+func decodeWalEntry[T any](record []byte, val protocol.Resp2Value) (WalEntry[T], error) {
+	arr, ok := val.([]protocol.Resp2Value)
+	if !ok {
+		return WalEntry[T]{}, fmt.Errorf("invalid WAL entry format: expected array")
+	}
 
-		term, ok := arr[2].(protocol.Resp2Integer)
-		if !ok {
-			return nil, fmt.Errorf("invalid WAL entry format: expected integer for Term")
-		}
+	if len(arr) != 6 && len(arr) != 7 {
+		return WalEntry[T]{}, fmt.Errorf("invalid WAL entry format: expected 6 or 7 elements, got %d", len(arr))
+	}
 
-		opType, ok := arr[3].(protocol.Resp2Integer)
+	if len(arr) == 7 {
+		checksum, ok := arr[6].(protocol.Resp2Integer)
 		if !ok {
-			return nil, fmt.Errorf("invalid WAL entry format: expected integer for OpType")
+			return WalEntry[T]{}, fmt.Errorf("invalid WAL entry format: expected integer for Checksum")
 		}
-
-		key, ok := arr[4].(protocol.Resp2BulkString)
-		if !ok {
-			return nil, fmt.Errorf("invalid WAL entry format: expected bulk string for Key")
+		if err := verifyChecksum(record, checksum); err != nil {
+			return WalEntry[T]{}, err
 		}
+	}
 
-		value := arr[5]
-
-		// Cast value to T
-		// Since T is likely Resp2Value (interface{}), this should work.
-		// If T is a concrete type, we might have issues if value is not that type.
-		// But we assume T is Resp2Value.
-		var tValue T
-		if value != nil {
-			var ok bool
-			tValue, ok = value.(T)
-			if !ok {
-				return nil, fmt.Errorf("invalid WAL entry format: expected value of type %T", *new(T))
-			}
-		}
+	index, ok := arr[0].(protocol.Resp2Integer)
+	if !ok {
+		return WalEntry[T]{}, fmt.Errorf("invalid WAL entry format: expected integer for Index")
+	}
 
-		entries = append(entries, WalEntry[T]{
-			Index:     uint64(index),
-			Timestamp: int64(timestamp),
-			Term:      Term(term),
-			OpType:    protocol.OpType(opType),
-			Key:       string(key),
-			Value:     tValue,
-		})
+	timestamp, ok := arr[1].(protocol.Resp2Integer)
+	if !ok {
+		return WalEntry[T]{}, fmt.Errorf("invalid WAL entry format: expected integer for Timestamp")
 	}
-	return entries, nil
+
+	term, ok := arr[2].(protocol.Resp2Integer)
+	if !ok {
+		return WalEntry[T]{}, fmt.Errorf("invalid WAL entry format: expected integer for Term")
+	}
+
+	opType, ok := arr[3].(protocol.Resp2Integer)
+	if !ok {
+		return WalEntry[T]{}, fmt.Errorf("invalid WAL entry format: expected integer for OpType")
+	}
+
+	key, ok := arr[4].(protocol.Resp2BulkString)
+	if !ok {
+		return WalEntry[T]{}, fmt.Errorf("invalid WAL entry format: expected bulk string for Key")
+	}
+
+	value := arr[5]
+
+	// Cast value to T
+	// Since T is likely Resp2Value (interface{}), this should work.
+	// If T is a concrete type, we might have issues if value is not that type.
+	// But we assume T is Resp2Value.
+	var tValue T
+	if value != nil {
+		var ok bool
+		tValue, ok = value.(T)
+		if !ok {
+			return WalEntry[T]{}, fmt.Errorf("invalid WAL entry format: expected value of type %T", *new(T))
+		}
+	}
+
+	return WalEntry[T]{
+		Index:     uint64(index),
+		Timestamp: int64(timestamp),
+		Term:      Term(term),
+		OpType:    protocol.OpType(opType),
+		Key:       string(key),
+		Value:     tValue,
+	}, nil
 }
 
 // Rotate closes the current file, renames it to a backup/old path, and opens a fresh file at the original path.
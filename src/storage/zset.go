@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"fmt"
+	"main/src/protocol"
+)
+
+type ZEntry struct {
+	Member string
+	Score  float64
+}
+
+// ZSet is a sorted set, members are unique and ordered by (score, member).
+// Hash index gives O(1) score lookups while skiplist keeps the order.
+type ZSet struct {
+	dict map[string]float64
+	zsl  *skiplist
+}
+
+func NewZSet() *ZSet {
+	return &ZSet{
+		dict: make(map[string]float64),
+		zsl:  newSkiplist(),
+	}
+}
+
+func (z *ZSet) Len() int {
+	return len(z.dict)
+}
+
+func (z *ZSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Set adds member or updates its score, returns true if member was added.
+func (z *ZSet) Set(member string, score float64) bool {
+	old, exists := z.dict[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.zsl.delete(old, member)
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	return !exists
+}
+
+// Remove deletes member, returns false if it was not present.
+func (z *ZSet) Remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	return true
+}
+
+// Rank returns 0-based position of the member, counted from the highest score if rev is set.
+func (z *ZSet) Rank(member string, rev bool) (int, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member)
+	if rev {
+		return z.zsl.length - rank, true
+	}
+	return rank - 1, true
+}
+
+// RangeByRank returns elements between start and stop inclusive,
+// negative indexes count from the end like in redis.
+func (z *ZSet) RangeByRank(start, stop int64, rev bool) []ZEntry {
+	length := int64(z.zsl.length)
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return []ZEntry{}
+	}
+
+	result := make([]ZEntry, 0, stop-start+1)
+	if rev {
+		x := z.zsl.byRank(int(length - start))
+		for i := start; i <= stop; i++ {
+			result = append(result, ZEntry{Member: x.member, Score: x.score})
+			x = x.backward
+		}
+	} else {
+		x := z.zsl.byRank(int(start + 1))
+		for i := start; i <= stop; i++ {
+			result = append(result, ZEntry{Member: x.member, Score: x.score})
+			x = x.level[0].forward
+		}
+	}
+	return result
+}
+
+// RangeByScore returns elements with score within bounds, skipping offset elements
+// and returning at most count of them (negative count means all).
+func (z *ZSet) RangeByScore(min, max protocol.ScoreBound, rev bool, offset, count int64) []ZEntry {
+	if min.Value > max.Value || (min.Value == max.Value && (min.Exclusive || max.Exclusive)) {
+		return []ZEntry{}
+	}
+	gteMin := func(n *skiplistNode) bool { return scoreGteMin(n.score, min) }
+	lteMax := func(n *skiplistNode) bool { return scoreLteMax(n.score, max) }
+	return z.rangeWhere(gteMin, lteMax, rev, offset, count)
+}
+
+// RangeByLex returns elements with member within lexicographical bounds.
+// Like in redis it is only meaningful when all elements have the same score.
+func (z *ZSet) RangeByLex(min, max protocol.LexBound, rev bool, offset, count int64) []ZEntry {
+	if min.Inf > 0 || max.Inf < 0 {
+		return []ZEntry{}
+	}
+	if min.Inf == 0 && max.Inf == 0 &&
+		(min.Value > max.Value || (min.Value == max.Value && (min.Exclusive || max.Exclusive))) {
+		return []ZEntry{}
+	}
+	gteMin := func(n *skiplistNode) bool { return lexGteMin(n.member, min) }
+	lteMax := func(n *skiplistNode) bool { return lexLteMax(n.member, max) }
+	return z.rangeWhere(gteMin, lteMax, rev, offset, count)
+}
+
+func (z *ZSet) rangeWhere(gteMin, lteMax func(*skiplistNode) bool, rev bool, offset, count int64) []ZEntry {
+	result := []ZEntry{}
+	if offset < 0 {
+		return result
+	}
+
+	var x *skiplistNode
+	if rev {
+		x = z.zsl.lastMatching(lteMax)
+	} else {
+		x = z.zsl.firstMatching(gteMin)
+	}
+
+	next := func(n *skiplistNode) *skiplistNode {
+		if rev {
+			return n.backward
+		}
+		return n.level[0].forward
+	}
+
+	for ; x != nil && offset > 0; offset-- {
+		x = next(x)
+	}
+
+	for x != nil && count != 0 {
+		if rev && !gteMin(x) || !rev && !lteMax(x) {
+			break
+		}
+		result = append(result, ZEntry{Member: x.member, Score: x.score})
+		x = next(x)
+		count--
+	}
+	return result
+}
+
+// Entries returns all elements in order
+func (z *ZSet) Entries() []ZEntry {
+	return z.RangeByRank(0, -1, false)
+}
+
+func (z *ZSet) Kind() string {
+	return "zset"
+}
+
+// Encode renders elements as flat [member, score, member, score, ...] array
+func (z *ZSet) Encode() protocol.Resp2Value {
+	return EncodeZEntries(z.Entries())
+}
+
+// MemoryUsage extrapolates from a few members, each of them is held by the map and the skiplist
+func (z *ZSet) MemoryUsage() int64 {
+	sampled := make([]int64, 0, memorySamples)
+	for member := range z.dict {
+		if len(sampled) >= memorySamples {
+			break
+		}
+		sampled = append(sampled, int64(len(member))+8+memberOverhead*2)
+	}
+	return sampledSize(len(z.dict), sampled)
+}
+
+func DecodeZSet(value protocol.Resp2Value) (*ZSet, error) {
+	entries, err := respToZEntries(value)
+	if err != nil {
+		return nil, err
+	}
+	z := NewZSet()
+	for _, e := range entries {
+		z.Set(e.Member, e.Score)
+	}
+	return z, nil
+}
+
+// GetZSet returns the sorted set stored under key, nil if key does not exist
+// or ErrWrongType if key holds a different kind of value.
+func GetZSet[T any](store Storage[T], key string) (*ZSet, error) {
+	value, found, err := store.Get(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	zset, ok := any(value).(*ZSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return zset, nil
+}
+
+// EncodeZEntries renders entries as flat [member, score, ...] array,
+// the format used both by snapshots and ZADD WAL entries.
+func EncodeZEntries(entries []ZEntry) protocol.Resp2Value {
+	arr := make([]protocol.Resp2Value, 0, len(entries)*2)
+	for _, e := range entries {
+		arr = append(arr, protocol.Resp2BulkString(e.Member), protocol.Resp2BulkString(protocol.FormatScore(e.Score)))
+	}
+	return arr
+}
+
+func respToZEntries(value protocol.Resp2Value) ([]ZEntry, error) {
+	flat, err := respToMembers(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(flat)%2 != 0 {
+		return nil, fmt.Errorf("invalid sorted set format: expected member score pairs")
+	}
+	entries := make([]ZEntry, 0, len(flat)/2)
+	for i := 0; i < len(flat); i += 2 {
+		score, err := protocol.ParseScore(flat[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sorted set format: %w", err)
+		}
+		entries = append(entries, ZEntry{Member: flat[i], Score: score})
+	}
+	return entries, nil
+}
@@ -0,0 +1,360 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"main/src/protocol"
+	"main/src/storage"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func openTestWal(t *testing.T, path string) *storage.SimpleWal[protocol.Resp2Value] {
+	t.Helper()
+	wal, err := storage.NewSimpleWal[protocol.Resp2Value](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wal.Close() })
+	return wal
+}
+
+func replayInto(t *testing.T, path string) (*storage.InMemoryStorage[protocol.Resp2Value], []storage.WalEntry[protocol.Resp2Value]) {
+	t.Helper()
+	entries, err := openTestWal(t, path).Replay()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	store := storage.MakeInMemoryStorage[protocol.Resp2Value]()
+	for _, e := range entries {
+		if err := storage.Apply(storage.Storage[protocol.Resp2Value](store), e); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+	return store, entries
+}
+
+func TestBatch_CommitAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	wal := openTestWal(t, path)
+	store := storage.MakeInMemoryStorage[protocol.Resp2Value]()
+	store.Set("c", protocol.Resp2BulkString("old"))
+
+	batch := storage.NewBatch[protocol.Resp2Value]().
+		Set("a", protocol.Resp2BulkString("1")).
+		Set("b", protocol.Resp2BulkString("2")).
+		Delete("c")
+	if batch.Len() != 3 {
+		t.Fatalf("Expected 3 operations, got %d", batch.Len())
+	}
+	entry, err := batch.Commit(wal, store, 7)
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if entry.OpType != protocol.BATCH || entry.Index != 7 {
+		t.Errorf("Expected BATCH entry with index 7, got %v %d", entry.OpType, entry.Index)
+	}
+	wal.Close()
+
+	replayed, entries := replayInto(t, path)
+	if len(entries) != 1 {
+		t.Fatalf("Expected batch logged as a single record, got %d", len(entries))
+	}
+	for _, s := range []*storage.InMemoryStorage[protocol.Resp2Value]{store, replayed} {
+		if value, _, _ := s.Get("a"); value != protocol.Resp2BulkString("1") {
+			t.Errorf("Expected a=1, got %v", value)
+		}
+		if value, _, _ := s.Get("b"); value != protocol.Resp2BulkString("2") {
+			t.Errorf("Expected b=2, got %v", value)
+		}
+		if exists, _ := s.Exists("c"); exists {
+			t.Errorf("Expected c to be deleted")
+		}
+		if s.Revision("a") != 7 || s.Revision("b") != 7 {
+			t.Errorf("Expected keys of the batch to share its revision, got %d and %d", s.Revision("a"), s.Revision("b"))
+		}
+	}
+}
+
+func TestBatch_CommitFailsWithoutWal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	wal := openTestWal(t, path)
+	wal.Close()
+	store := storage.MakeInMemoryStorage[protocol.Resp2Value]()
+
+	_, err := storage.NewBatch[protocol.Resp2Value]().Set("a", protocol.Resp2BulkString("1")).Commit(wal, store, 1)
+	if err == nil {
+		t.Fatalf("Expected commit to fail when the record cannot be written")
+	}
+	if exists, _ := store.Exists("a"); exists {
+		t.Errorf("Expected nothing applied when logging failed")
+	}
+}
+
+// failingStorage fails to store one key, so a batch fails after applying some of its operations
+type failingStorage struct {
+	*storage.InMemoryStorage[protocol.Resp2Value]
+	failKey string
+}
+
+var errFailingKey = errors.New("cannot store the key")
+
+func (s *failingStorage) Set(key string, value protocol.Resp2Value) error {
+	if key == s.failKey {
+		return errFailingKey
+	}
+	return s.InMemoryStorage.Set(key, value)
+}
+
+func TestBatch_ApplyAllOrNothing(t *testing.T) {
+	store := &failingStorage{InMemoryStorage: storage.MakeInMemoryStorage[protocol.Resp2Value](), failKey: "bad"}
+	expireAt := time.Now().Add(time.Hour).UnixMilli()
+	store.Set("a", protocol.Resp2BulkString("old"))
+	store.SetExpire("a", expireAt)
+	store.SetRevision("a", 3)
+	store.Set("leased", protocol.Resp2BulkString("v"))
+	store.SetRevision("leased", 4)
+	store.GrantLease(4, 1000, expireAt)
+	store.AttachLease(4, "leased")
+
+	batch := storage.NewBatch[protocol.Resp2Value]().
+		Set("a", protocol.Resp2BulkString("new")).
+		Delete("leased").
+		Set("fresh", protocol.Resp2BulkString("v")).
+		Set("bad", protocol.Resp2BulkString("v"))
+	entry, err := batch.Entry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Index = 10
+	if err := storage.Apply(storage.Storage[protocol.Resp2Value](store), entry); !errors.Is(err, errFailingKey) {
+		t.Fatalf("Expected error of the failed operation, got %v", err)
+	}
+
+	if value, _, _ := store.Get("a"); value != protocol.Resp2BulkString("old") {
+		t.Errorf("Expected a to keep its value, got %v", value)
+	}
+	if at, ok := store.GetExpire("a"); !ok || at != expireAt {
+		t.Errorf("Expected a to keep its deadline, got %d, %v", at, ok)
+	}
+	if store.Revision("a") != 3 || store.Revision("leased") != 4 {
+		t.Errorf("Expected revisions 3 and 4, got %d and %d", store.Revision("a"), store.Revision("leased"))
+	}
+	if id, ok := store.KeyLease("leased"); !ok || id != 4 {
+		t.Errorf("Expected deleted key restored with its lease, got %d, %v", id, ok)
+	}
+	if exists, _ := store.Exists("fresh"); exists {
+		t.Errorf("Expected key created by the failed batch to be gone")
+	}
+	if store.AppliedIndex() != 0 {
+		t.Errorf("Expected failed batch not to be recorded as applied, got %d", store.AppliedIndex())
+	}
+}
+
+func TestSimpleWal_TornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	wal := openTestWal(t, path)
+	for i, key := range []string{"k1", "k2"} {
+		if err := wal.Append(storage.WalEntry[protocol.Resp2Value]{Index: uint64(i + 1), OpType: protocol.SET, Key: key, Value: protocol.Resp2BulkString("v")}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	complete := wal.Size()
+	batch := storage.NewBatch[protocol.Resp2Value]().Set("k3", protocol.Resp2BulkString("v")).Set("k4", protocol.Resp2BulkString("v"))
+	if _, err := batch.Commit(wal, storage.MakeInMemoryStorage[protocol.Resp2Value](), 3); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	// Crash in the middle of writing the batch leaves its prefix behind
+	for _, cut := range []int64{1, 10, 4} {
+		if err := os.Truncate(path, wal.Size()-cut); err != nil {
+			t.Fatal(err)
+		}
+		store, entries := replayInto(t, path)
+		if len(entries) != 2 {
+			t.Fatalf("Expected torn batch to be dropped, got %d entries", len(entries))
+		}
+		if exists, _ := store.Exists("k3"); exists {
+			t.Errorf("Expected no part of the torn batch applied")
+		}
+		if stat, _ := os.Stat(path); stat.Size() != complete {
+			t.Errorf("Expected torn record truncated to %d bytes, got %d", complete, stat.Size())
+		}
+		// Restore the torn tail for the next cut
+		wal = openTestWal(t, path)
+		if _, err := batch.Commit(wal, storage.MakeInMemoryStorage[protocol.Resp2Value](), 3); err != nil {
+			t.Fatal(err)
+		}
+		wal.Close()
+	}
+
+	// Records appended after the truncation are replayed
+	store, entries := replayInto(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if exists, _ := store.Exists("k4"); !exists {
+		t.Errorf("Expected batch appended after truncation to be applied")
+	}
+}
+
+func TestSimpleWal_Corruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	wal := openTestWal(t, path)
+	for i, key := range []string{"k1", "k2"} {
+		if err := wal.Append(storage.WalEntry[protocol.Resp2Value]{Index: uint64(i + 1), OpType: protocol.SET, Key: key, Value: protocol.Resp2BulkString("value")}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wal.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Value of the first record changes, it still parses
+	data[bytes.Index(data, []byte("value"))] = 'V'
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openTestWal(t, path).Replay(); err == nil {
+		t.Errorf("Expected corrupted record in the middle of the log to fail replay")
+	}
+}
+
+func TestSimpleWal_LegacyRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	// Record written before checksums were added
+	record, err := protocol.NewResp2Parser(nil, 0).Render([]protocol.Resp2Value{
+		protocol.Resp2Integer(1), protocol.Resp2Integer(0), protocol.Resp2Integer(0),
+		protocol.Resp2Integer(protocol.SET), protocol.Resp2BulkString("old"), protocol.Resp2BulkString("v"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, record, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wal := openTestWal(t, path)
+	if err := wal.Append(storage.WalEntry[protocol.Resp2Value]{Index: 2, OpType: protocol.SET, Key: "new", Value: protocol.Resp2BulkString("v")}, true); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	store, entries := replayInto(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("Expected legacy and new record, got %d", len(entries))
+	}
+	for _, key := range []string{"old", "new"} {
+		if exists, _ := store.Exists(key); !exists {
+			t.Errorf("Expected key %s replayed", key)
+		}
+	}
+}
+
+func TestSimpleWal_ValuesRenderedDifferently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	wal := openTestWal(t, path)
+	// Nil array is written as *-1 but parsed back as nil, which renders as $-1
+	values := []protocol.Resp2Value{protocol.Resp2BulkString("x"), []protocol.Resp2Value(nil), protocol.Resp2BulkString("y")}
+	for i, value := range values {
+		if err := wal.Append(storage.WalEntry[protocol.Resp2Value]{Index: uint64(i + 1), OpType: protocol.SET, Key: string(rune('a' + i)), Value: value}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := wal.Size()
+	wal.Close()
+
+	_, entries := replayInto(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if stat, _ := os.Stat(path); stat.Size() != size {
+		t.Errorf("Expected complete records to stay, got %d bytes of %d", stat.Size(), size)
+	}
+}
+
+func TestBatch_ApplyAllOrNothing_MixedOperations(t *testing.T) {
+	store := &failingStorage{InMemoryStorage: storage.MakeInMemoryStorage[protocol.Resp2Value](), failKey: "bad"}
+	generic := storage.Storage[protocol.Resp2Value](store)
+	expireAt := time.Now().Add(time.Hour).UnixMilli()
+	bulks := func(values ...string) protocol.Resp2Value {
+		arr := make([]protocol.Resp2Value, len(values))
+		for i, v := range values {
+			arr[i] = protocol.Resp2BulkString(v)
+		}
+		return arr
+	}
+	for i, e := range []storage.WalEntry[protocol.Resp2Value]{
+		{OpType: protocol.SADD, Key: "set", Value: bulks("a")},
+		{OpType: protocol.SET, Key: "k", Value: protocol.Resp2BulkString("v")},
+		{OpType: protocol.EXPIRE, Key: "k", Value: protocol.Resp2BulkString(strconv.FormatInt(expireAt, 10))},
+		{OpType: protocol.SET, Key: "src", Value: protocol.Resp2BulkString("moved")},
+		{OpType: protocol.LEASE, Value: bulks("GRANT", "1000", strconv.FormatInt(expireAt, 10))},
+	} {
+		e.Index = uint64(i + 1)
+		if err := storage.Apply(generic, e); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+
+	var batch storage.Batch[protocol.Resp2Value]
+	batch.Add(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.SADD, Key: "set", Value: bulks("b")}).
+		Add(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.EXPIRE, Key: "k", Value: protocol.Resp2BulkString("1")}).
+		Add(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.RENAME, Key: "dst", Value: protocol.Resp2BulkString("src")}).
+		Add(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.LEASE, Value: bulks("ATTACH", "5", "k", "dst")}).
+		Add(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.LEASE, Value: bulks("REVOKE", "5")}).
+		Set("bad", protocol.Resp2BulkString("v"))
+
+	path := filepath.Join(t.TempDir(), "wal.log")
+	wal := openTestWal(t, path)
+	if _, err := batch.Commit(wal, generic, 10); !errors.Is(err, errFailingKey) {
+		t.Fatalf("Expected error of the failed operation, got %v", err)
+	}
+	if wal.Size() != 0 {
+		t.Errorf("Expected failed batch not to be logged, got %d bytes", wal.Size())
+	}
+
+	if set, err := storage.GetSet(generic, "set"); err != nil || set.Contains("b") || !set.Contains("a") {
+		t.Errorf("Expected set modified in place to be restored, got %v, %v", set, err)
+	}
+	if at, _ := store.GetExpire("k"); at != expireAt {
+		t.Errorf("Expected deadline of k to stay, got %d", at)
+	}
+	if value, found, _ := store.Get("src"); !found || value != protocol.Resp2BulkString("moved") {
+		t.Errorf("Expected renamed source restored, got %v", value)
+	}
+	if exists, _ := store.Exists("dst"); exists {
+		t.Errorf("Expected rename destination to be gone")
+	}
+	if lease := store.GetLease(5); lease == nil || len(lease.Keys) != 0 {
+		t.Errorf("Expected revoked lease restored without keys, got %v", lease)
+	}
+	if store.AppliedIndex() != 5 {
+		t.Errorf("Expected applied index 5, got %d", store.AppliedIndex())
+	}
+}
+
+func TestBatch_CommitRestoresWhenLoggingFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	wal := openTestWal(t, path)
+	wal.Close()
+	store := storage.MakeInMemoryStorage[protocol.Resp2Value]()
+	store.Set("a", protocol.Resp2BulkString("old"))
+	store.SetRevision("a", 1)
+
+	batch := storage.NewBatch[protocol.Resp2Value]().Set("a", protocol.Resp2BulkString("new")).Delete("a").Set("b", protocol.Resp2BulkString("v"))
+	if _, err := batch.Commit(wal, store, 2); err == nil {
+		t.Fatalf("Expected commit to fail when the record cannot be written")
+	}
+	if value, _, _ := store.Get("a"); value != protocol.Resp2BulkString("old") || store.Revision("a") != 1 {
+		t.Errorf("Expected a restored with revision 1, got %v, %d", value, store.Revision("a"))
+	}
+	if exists, _ := store.Exists("b"); exists {
+		t.Errorf("Expected b to be gone")
+	}
+}
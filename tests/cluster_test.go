@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"context"
+	"main/src/config"
+	"main/src/raft"
+	"main/src/raft/pb"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"", 0},
+		{"123456789", 12739},
+		{"foo", 12182},
+		{"bar", 5061},
+		{"{user1000}.following", 3443},
+		{"{user1000}.followers", 3443},
+		{"user1000", 3443},
+		{"foo{}{bar}", 8363},
+		{"foo{{bar}}zap", 4015},
+	}
+	for _, tt := range tests {
+		if got := raft.KeySlot(tt.key); got != tt.slot {
+			t.Errorf("KeySlot(%q): expected %d, got %d", tt.key, tt.slot, got)
+		}
+	}
+}
+
+func TestNetwork_SlotOwner(t *testing.T) {
+	network := raft.NewNetwork(config.NetworkConfig{
+		Self:  config.PeerConfig{ID: "a"},
+		Peers: []config.PeerConfig{{ID: "a"}, {ID: "b"}, {ID: "c"}},
+	})
+	for slot, expected := range map[int]string{0: "a", 5461: "a", 5462: "b", 10922: "b", 10923: "c", raft.SlotCount - 1: "c"} {
+		if got := network.SlotOwner(slot).ID; got != expected {
+			t.Errorf("SlotOwner(%d): expected %s, got %s", slot, expected, got)
+		}
+	}
+
+	alone := raft.NewNetwork(config.NetworkConfig{Self: config.PeerConfig{ID: "a"}})
+	if got := alone.SlotOwner(100).ID; got != "a" {
+		t.Errorf("Expected node without peers to own all slots, got %s", got)
+	}
+}
+
+// deliveries records messages delivered by a broadcaster
+type deliveries struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (d *deliveries) deliver(channel, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.messages = append(d.messages, channel+":"+message)
+}
+
+func (d *deliveries) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		got := append([]string(nil), d.messages...)
+		d.mu.Unlock()
+		if len(got) >= n {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d messages, got %v", n, d.messages)
+	return nil
+}
+
+func TestBroadcaster_ForwardsToPeers(t *testing.T) {
+	ids := []string{"node-1", "node-2", "node-3"}
+	listeners := make([]net.Listener, len(ids))
+	peers := make([]config.PeerConfig, len(ids))
+	for i, id := range ids {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		listeners[i] = l
+		peers[i] = config.PeerConfig{ID: id, Address: l.Addr().String()}
+	}
+
+	received := make([]*deliveries, len(ids))
+	broadcasters := make([]*raft.Broadcaster, len(ids))
+	for i := range ids {
+		received[i] = &deliveries{}
+		network := raft.NewNetwork(config.NetworkConfig{Self: peers[i], Peers: peers})
+		broadcasters[i] = raft.NewBroadcaster(network, config.NewLogger("Test"), received[i].deliver)
+		server := grpc.NewServer()
+		broadcasters[i].Register(server)
+		go server.Serve(listeners[i])
+		defer server.Stop()
+		broadcasters[i].Start()
+		defer broadcasters[i].Stop()
+	}
+
+	broadcasters[0].Broadcast("news", "one")
+	broadcasters[0].Broadcast("news", "two")
+	broadcasters[2].Broadcast("sport", "three")
+
+	expected := map[int][]string{
+		0: {"sport:three"},
+		1: {"news:one", "news:two", "sport:three"},
+		2: {"news:one", "news:two"},
+	}
+	for i, messages := range expected {
+		got := received[i].wait(t, len(messages))
+		for _, m := range messages {
+			found := false
+			for _, g := range got {
+				found = found || g == m
+			}
+			if !found {
+				t.Errorf("Node %d: expected %q among %v", i, m, got)
+			}
+		}
+		if len(got) != len(messages) {
+			t.Errorf("Node %d: expected %d messages, got %v", i, len(messages), got)
+		}
+	}
+}
+
+func TestBroadcaster_DeduplicatesResentMessages(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	received := &deliveries{}
+	network := raft.NewNetwork(config.NetworkConfig{Self: config.PeerConfig{ID: "node-1", Address: l.Addr().String()}})
+	broadcaster := raft.NewBroadcaster(network, config.NewLogger("Test"), received.deliver)
+	server := grpc.NewServer()
+	broadcaster.Register(server)
+	go server.Serve(l)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewBroadcastClient(conn)
+
+	send := func(messages ...*pb.PublishMessage) {
+		stream, err := client.Publish(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		for _, m := range messages {
+			if err := stream.Send(m); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+		}
+		if _, err := stream.CloseAndRecv(); err != nil {
+			t.Fatalf("Failed to close stream: %v", err)
+		}
+	}
+	msg := func(epoch int64, seq uint64, message string) *pb.PublishMessage {
+		return &pb.PublishMessage{Origin: "node-2", Epoch: epoch, Seq: seq, Channel: "c", Message: []byte(message)}
+	}
+
+	send(msg(1, 1, "a"), msg(1, 2, "b"))
+	// Reconnected sender resends the message which might have been lost
+	send(msg(1, 2, "b"), msg(1, 3, "c"))
+	// Restarted sender starts a new epoch, messages of the old one are late
+	send(msg(2, 1, "d"), msg(1, 4, "late"))
+
+	got := received.wait(t, 4)
+	expected := []string{"c:a", "c:b", "c:c", "c:d"}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, got)
+			break
+		}
+	}
+}
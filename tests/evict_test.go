@@ -0,0 +1,277 @@
+package tests
+
+import (
+	"fmt"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/service"
+	"main/src/storage"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorageMemoryAccounting(t *testing.T) {
+	stores := map[string]storage.Storage[protocol.Resp2Value]{
+		"hash":    storage.MakeInMemoryStorage[protocol.Resp2Value](),
+		"ordered": storage.MakeOrderedStorage[protocol.Resp2Value](),
+		"sharded": storage.MakeShardedStorage[protocol.Resp2Value](4),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			evictable := store.(storage.Evictable)
+			apply := func(entry storage.WalEntry[protocol.Resp2Value]) {
+				t.Helper()
+				if err := storage.Apply(store, entry); err != nil {
+					t.Fatalf("Apply failed: %v", err)
+				}
+			}
+
+			apply(storage.WalEntry[protocol.Resp2Value]{Index: 1, OpType: protocol.SET, Key: "str", Value: protocol.Resp2BulkString(strings.Repeat("x", 1000))})
+			str := evictable.KeyMemory("str")
+			if str < 1000 || evictable.UsedMemory() != str {
+				t.Errorf("Expected string of 1000 bytes accounted, got key %d, total %d", str, evictable.UsedMemory())
+			}
+
+			// Sets grow in place, the size follows them
+			members := func(from, to int) protocol.Resp2Value {
+				arr := []protocol.Resp2Value{}
+				for i := from; i < to; i++ {
+					arr = append(arr, protocol.Resp2BulkString(fmt.Sprintf("member-%04d", i)))
+				}
+				return arr
+			}
+			apply(storage.WalEntry[protocol.Resp2Value]{Index: 2, OpType: protocol.SADD, Key: "set", Value: members(0, 10)})
+			small := evictable.KeyMemory("set")
+			apply(storage.WalEntry[protocol.Resp2Value]{Index: 3, OpType: protocol.SADD, Key: "set", Value: members(10, 1000)})
+			large := evictable.KeyMemory("set")
+			if large < 1000*11 || large < small*50 {
+				t.Errorf("Expected set size to grow with members, got %d then %d", small, large)
+			}
+			if evictable.UsedMemory() != str+large {
+				t.Errorf("Expected total %d, got %d", str+large, evictable.UsedMemory())
+			}
+
+			apply(storage.WalEntry[protocol.Resp2Value]{Index: 4, OpType: protocol.DELETE, Key: "str"})
+			apply(storage.WalEntry[protocol.Resp2Value]{Index: 5, OpType: protocol.SREM, Key: "set", Value: members(0, 1000)})
+			if used := evictable.UsedMemory(); used != 0 {
+				t.Errorf("Expected no memory used once keys are deleted, got %d", used)
+			}
+		})
+	}
+}
+
+func newEvictTestService(t *testing.T, policy string, maxMemory int64) (*service.StorageService, *config.Config) {
+	t.Helper()
+	cfg := watchTestConfig(t)
+	cfg.Storage.MaxMemory = maxMemory
+	cfg.Storage.MaxMemoryPolicy = policy
+	cfg.Storage.MaxMemorySamples = 64
+	return service.NewStorageService(cfg, config.NewLogger("Test")), cfg
+}
+
+// fillKeys writes keys of roughly 1KB each
+func fillKeys(t *testing.T, svc *service.StorageService, prefix string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := svc.Set(fmt.Sprintf("%s-%02d", prefix, i), protocol.Resp2BulkString(strings.Repeat("v", 1000))); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+}
+
+func existingKeys(svc *service.StorageService, prefix string) []string {
+	var keys []string
+	for _, key := range svc.Keys(prefix + "*") {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestStorageService_NoEviction(t *testing.T) {
+	svc, _ := newEvictTestService(t, "noeviction", 10*1024)
+	// The tenth key of roughly 1KB gets memory over the limit
+	fillKeys(t, svc, "key", 10)
+
+	if err := svc.Set("more", protocol.Resp2BulkString("v")); err == nil || !strings.HasPrefix(err.Error(), "OOM") {
+		t.Fatalf("Expected OOM error over the limit, got %v", err)
+	}
+	if _, err := svc.SAdd("set", []string{"a"}); err == nil {
+		t.Errorf("Expected SADD to be rejected over the limit")
+	}
+	if len(existingKeys(svc, "key")) != 10 {
+		t.Errorf("Expected no key to be evicted")
+	}
+	// Deletions free memory and are always allowed
+	if n, err := svc.Del([]string{"key-00", "key-01"}); err != nil || n != 2 {
+		t.Fatalf("Expected DEL to be allowed over the limit, got %d, %v", n, err)
+	}
+	if err := svc.Set("more", protocol.Resp2BulkString("v")); err != nil {
+		t.Errorf("Expected write to succeed under the limit, got %v", err)
+	}
+}
+
+func TestStorageService_EvictionLRU(t *testing.T) {
+	svc, cfg := newEvictTestService(t, "allkeys-lru", 20*1024)
+	var mu sync.Mutex
+	var evicted []string
+	svc.SetKeyspaceEvents("Ee")
+	svc.SetKeyspaceNotifier(func(channel, message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		evicted = append(evicted, message)
+	})
+
+	fillKeys(t, svc, "cold", 10)
+	time.Sleep(5 * time.Millisecond)
+	fillKeys(t, svc, "hot", 5)
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		svc.Get(fmt.Sprintf("hot-%02d", i))
+	}
+	time.Sleep(5 * time.Millisecond)
+	fillKeys(t, svc, "new", 10)
+
+	if used := svc.UsedMemory(); used > 20*1024+2048 {
+		t.Errorf("Expected memory near the limit, got %d", used)
+	}
+	if hot := existingKeys(svc, "hot"); len(hot) != 5 {
+		t.Errorf("Expected recently used keys to stay, got %v", hot)
+	}
+	cold := existingKeys(svc, "cold")
+	if len(cold) >= 10 {
+		t.Fatalf("Expected least recently used keys to be evicted, got %v", cold)
+	}
+	mu.Lock()
+	if len(evicted) != 10-len(cold) {
+		t.Errorf("Expected %d evicted notifications, got %v", 10-len(cold), evicted)
+	}
+	mu.Unlock()
+
+	// Evictions are logged, replay ends with the same keys
+	restored := service.NewStorageService(cfg, config.NewLogger("Test"))
+	if got := existingKeys(restored, ""); !slices.Equal(got, existingKeys(svc, "")) {
+		t.Errorf("Expected restored keys %v, got %v", existingKeys(svc, ""), got)
+	}
+}
+
+func TestStorageService_EvictionLFU(t *testing.T) {
+	svc, _ := newEvictTestService(t, "allkeys-lfu", 20*1024)
+	fillKeys(t, svc, "key", 15)
+	// Logarithmic counter needs many accesses to grow past the initial value
+	for round := 0; round < 200; round++ {
+		for i := 0; i < 5; i++ {
+			svc.Get(fmt.Sprintf("key-%02d", i))
+		}
+	}
+	fillKeys(t, svc, "new", 5)
+
+	keys := existingKeys(svc, "key")
+	for i := 0; i < 5; i++ {
+		if !slices.Contains(keys, fmt.Sprintf("key-%02d", i)) {
+			t.Errorf("Expected frequently used key-%02d to stay, got %v", i, keys)
+		}
+	}
+	// Rarely used old and new keys have the same counter, either may be evicted
+	if n := svc.DBSize(); n >= 20 {
+		t.Errorf("Expected rarely used keys to be evicted, got %d keys", n)
+	}
+}
+
+func TestStorageService_EvictionVolatileTTL(t *testing.T) {
+	svc, _ := newEvictTestService(t, "volatile-ttl", 20*1024)
+	fillKeys(t, svc, "keep", 10)
+	fillKeys(t, svc, "ttl", 8)
+	for i := 0; i < 8; i++ {
+		svc.Expire(protocol.OpPayloadExpire{Key: fmt.Sprintf("ttl-%02d", i), Ms: int64(i+1) * time.Hour.Milliseconds()})
+	}
+	fillKeys(t, svc, "new", 3)
+
+	if keep := existingKeys(svc, "keep"); len(keep) != 10 {
+		t.Errorf("Expected keys without expiration to stay, got %v", keep)
+	}
+	ttl := existingKeys(svc, "ttl")
+	if len(ttl) == 0 || len(ttl) == 8 {
+		t.Fatalf("Expected some volatile keys to be evicted, got %v", ttl)
+	}
+	// Keys closest to their deadline go first
+	if expected := 8 - len(ttl); ttl[0] != fmt.Sprintf("ttl-%02d", expected) {
+		t.Errorf("Expected keys expiring first to be evicted, got %v", ttl)
+	}
+
+	// Once no volatile key is left, writes are rejected
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		err = svc.Set(fmt.Sprintf("more-%02d", i), protocol.Resp2BulkString(strings.Repeat("v", 1000)))
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "OOM") {
+		t.Errorf("Expected OOM error without volatile keys, got %v", err)
+	}
+	if ttl := existingKeys(svc, "ttl"); len(ttl) != 0 {
+		t.Errorf("Expected all volatile keys to be evicted first, got %v", ttl)
+	}
+}
+
+func TestStorageService_EvictionSharded(t *testing.T) {
+	cfg := watchTestConfig(t)
+	cfg.Storage.Engine = storage.EngineSharded
+	cfg.Storage.MaxMemory = 50 * 1024
+	cfg.Storage.MaxMemoryPolicy = "allkeys-lru"
+	svc := service.NewStorageService(cfg, config.NewLogger("Test"))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := svc.Set(fmt.Sprintf("w%d-%d", w, i), protocol.Resp2BulkString(strings.Repeat("v", 1000))); err != nil {
+					t.Errorf("Set failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	// Writers racing past the check may overshoot by a key each
+	if used := svc.UsedMemory(); used > 50*1024+8*2048 {
+		t.Errorf("Expected memory near the limit, got %d", used)
+	}
+	if n := svc.DBSize(); n >= 400 || n == 0 {
+		t.Errorf("Expected keys to be evicted, got %d keys", n)
+	}
+}
+
+func TestRedisService_ConfigMaxMemory(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	got := runCommands(t, svc,
+		[]string{"CONFIG", "GET", "maxmemory*"},
+		[]string{"CONFIG", "SET", "maxmemory", "1kb"},
+		[]string{"CONFIG", "SET", "maxmemory-policy", "allkeys-lfu"},
+		[]string{"CONFIG", "SET", "maxmemory-policy", "random"},
+		[]string{"CONFIG", "SET", "maxmemory-samples", "10"},
+		[]string{"CONFIG", "GET", "maxmemory*"},
+		[]string{"CONFIG", "SET", "maxmemory-policy", "noeviction"},
+		[]string{"SET", "big", strings.Repeat("v", 2000)},
+		[]string{"SET", "small", "v"},
+		[]string{"CONFIG", "SET", "maxmemory", "0"},
+		[]string{"SET", "small", "v"},
+	)
+	expected := "*6\r\n$9\r\nmaxmemory\r\n$1\r\n0\r\n$16\r\nmaxmemory-policy\r\n$10\r\nnoeviction\r\n$17\r\nmaxmemory-samples\r\n$1\r\n5\r\n" +
+		"+OK\r\n+OK\r\n" +
+		"-ERR CONFIG SET failed (possibly related to argument 'maxmemory-policy') - unknown maxmemory policy \"random\"\r\n" +
+		"+OK\r\n" +
+		"*6\r\n$9\r\nmaxmemory\r\n$4\r\n1024\r\n$16\r\nmaxmemory-policy\r\n$11\r\nallkeys-lfu\r\n$17\r\nmaxmemory-samples\r\n$2\r\n10\r\n" +
+		"+OK\r\n+OK\r\n" +
+		"-OOM command not allowed when used memory > 'maxmemory'.\r\n" +
+		"+OK\r\n+OK\r\n"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,275 @@
+package tests
+
+import (
+	"fmt"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/service"
+	"main/src/storage"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOpParserExpireCommands(t *testing.T) {
+	parse := func(args ...string) (*protocol.Op, error) {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		return opParser.Parse()
+	}
+
+	tests := []struct {
+		args     []string
+		expected protocol.OpPayload
+	}{
+		{[]string{"EXPIRE", "k", "10", "NX"}, protocol.OpPayloadExpire{Key: "k", Ms: 10000, Flags: protocol.ExpireFlags{NX: true}}},
+		{[]string{"PEXPIREAT", "k", "1700000000000", "GT"}, protocol.OpPayloadExpire{Key: "k", Ms: 1700000000000, Absolute: true, Flags: protocol.ExpireFlags{GT: true}}},
+		{[]string{"PTTL", "k"}, protocol.OpPayloadTTL{Key: "k", Ms: true}},
+		{[]string{"EXPIRETIME", "k"}, protocol.OpPayloadTTL{Key: "k", Absolute: true}},
+		{[]string{"SET", "k", "v", "EX", "5"}, protocol.OpPayloadSet{Key: "k", Value: protocol.Resp2BulkString("v"), ExpireMs: 5000}},
+		{[]string{"SET", "k", "v", "pxat", "123"}, protocol.OpPayloadSet{Key: "k", Value: protocol.Resp2BulkString("v"), ExpireMs: 123, ExpireAbsolute: true}},
+		{[]string{"SET", "k", "v", "KEEPTTL"}, protocol.OpPayloadSet{Key: "k", Value: protocol.Resp2BulkString("v"), KeepTTL: true}},
+	}
+	for _, tt := range tests {
+		op, err := parse(tt.args...)
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(op.Payload, tt.expected) {
+			t.Errorf("Parse %v: expected %+v, got %+v", tt.args, tt.expected, op.Payload)
+		}
+
+		// Rendered command parses back to the same payload
+		renderParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(nil))
+		data, err := renderParser.Render(op)
+		if err != nil {
+			t.Errorf("Render %v failed: %v", tt.args, err)
+			continue
+		}
+		reparser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(data))
+		again, err := reparser.Parse()
+		if err != nil || !reflect.DeepEqual(again.Payload, tt.expected) {
+			t.Errorf("Re-parse %v: expected %+v, got %+v (%v)", tt.args, tt.expected, again, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"EXPIRE", "k", "ten"},
+		{"EXPIRE", "k", "10", "NX", "XX"},
+		{"EXPIRE", "k", "10", "GT", "LT"},
+		{"SET", "k", "v", "EX", "0"},
+		{"SET", "k", "v", "EX", "5", "PX", "5"},
+		{"SET", "k", "v", "EX", "5", "KEEPTTL"},
+		{"SET", "k", "v", "PX"},
+		{"PEXPIRE", "k", "9223372036854775807"},
+		{"EXPIRE", "k", "9223372036854775"},
+		{"SET", "k", "v", "EX", "9223372036854775"},
+		{"SET", "k", "v", "PX", "9223372036854775807"},
+		{"TTL", "a", "b"},
+	}
+	for _, args := range invalid {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_ExpireCommands(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmd      []string
+		expected string
+	}{
+		{"TTL missing key", []string{"TTL", "k"}, ":-2\r\n"},
+		{"EXPIRE missing key", []string{"EXPIRE", "k", "10"}, ":0\r\n"},
+		{"SET", []string{"SET", "k", "v"}, "+OK\r\n"},
+		{"TTL without expiration", []string{"TTL", "k"}, ":-1\r\n"},
+		{"EXPIRE XX without expiration", []string{"EXPIRE", "k", "10", "XX"}, ":0\r\n"},
+		{"EXPIRE GT without expiration", []string{"EXPIRE", "k", "10", "GT"}, ":0\r\n"},
+		{"EXPIRE", []string{"EXPIRE", "k", "100"}, ":1\r\n"},
+		{"TTL", []string{"TTL", "k"}, ":100\r\n"},
+		{"EXPIRE NX with expiration", []string{"EXPIRE", "k", "10", "NX"}, ":0\r\n"},
+		{"EXPIRE LT", []string{"EXPIRE", "k", "50", "LT"}, ":1\r\n"},
+		{"EXPIRE GT lower", []string{"EXPIRE", "k", "10", "GT"}, ":0\r\n"},
+		{"EXPIRETIME", []string{"PEXPIREAT", "k", "32503680000000"}, ":1\r\n"},
+		{"PEXPIRETIME", []string{"PEXPIRETIME", "k"}, ":32503680000000\r\n"},
+		{"SET KEEPTTL", []string{"SET", "k", "v2", "KEEPTTL"}, "+OK\r\n"},
+		{"EXPIRETIME kept", []string{"EXPIRETIME", "k"}, ":32503680000\r\n"},
+		{"SET clears expiration", []string{"SET", "k", "v3"}, "+OK\r\n"},
+		{"TTL cleared", []string{"TTL", "k"}, ":-1\r\n"},
+		{"SET EX", []string{"SET", "k", "v", "EX", "30"}, "+OK\r\n"},
+		{"TTL after SET EX", []string{"TTL", "k"}, ":30\r\n"},
+		{"PERSIST", []string{"PERSIST", "k"}, ":1\r\n"},
+		{"PERSIST again", []string{"PERSIST", "k"}, ":0\r\n"},
+		{"EXPIRE in the past deletes", []string{"EXPIRE", "k", "-1"}, ":1\r\n"},
+		{"GET deleted", []string{"GET", "k"}, "$-1\r\n"},
+		{"SADD", []string{"SADD", "s", "a"}, ":1\r\n"},
+		{"PEXPIRE composite value", []string{"PEXPIRE", "s", "5000"}, ":1\r\n"},
+		{"SADD keeps expiration", []string{"SADD", "s", "b"}, ":1\r\n"},
+		{"TTL composite value", []string{"TTL", "s"}, ":5\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmd); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRedisService_LazyExpiration(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	runCommands(t, svc,
+		[]string{"SET", "k", "v", "PX", "20"},
+		[]string{"SADD", "s", "a", "b"},
+		[]string{"PEXPIRE", "s", "20"},
+	)
+	time.Sleep(40 * time.Millisecond)
+
+	got := runCommands(t, svc,
+		[]string{"GET", "k"},
+		[]string{"TTL", "k"},
+		[]string{"SCARD", "s"},
+		// Write to expired key starts from an empty set
+		[]string{"SADD", "s", "c"},
+		[]string{"SMEMBERS", "s"},
+		[]string{"TTL", "s"},
+	)
+	expected := "$-1\r\n:-2\r\n:0\r\n:1\r\n*1\r\n$1\r\nc\r\n:-1\r\n"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func newExpireTestService(t *testing.T) (*service.StorageService, *config.Config, string) {
+	tmpDir, err := os.MkdirTemp("", "expire_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = filepath.Join(tmpDir, "wal.log")
+	return service.NewStorageService(cfg, config.NewLogger("Test")), cfg, tmpDir
+}
+
+func TestStorageService_ExpireOverflow(t *testing.T) {
+	svc, _, tmpDir := newExpireTestService(t)
+	defer os.RemoveAll(tmpDir)
+
+	if _, _, err := svc.SetWithOptions(protocol.OpPayloadSet{Key: "k", Value: protocol.Resp2BulkString("v")}); err != nil {
+		t.Fatalf("SetWithOptions failed: %v", err)
+	}
+	if _, err := svc.Expire(protocol.OpPayloadExpire{Key: "k", Ms: math.MaxInt64}); err == nil {
+		t.Errorf("Expected Expire with overflowing deadline to fail")
+	}
+	if _, _, err := svc.SetWithOptions(protocol.OpPayloadSet{Key: "k", Value: protocol.Resp2BulkString("v2"), ExpireMs: math.MaxInt64}); err == nil {
+		t.Errorf("Expected SET with overflowing deadline to fail")
+	}
+	if value, found, _ := svc.Get("k"); !found || value != protocol.Resp2BulkString("v") {
+		t.Errorf("Expected key to be kept, got %v (found %v)", value, found)
+	}
+	if ttl, _ := svc.TTL(protocol.OpPayloadTTL{Key: "k"}); ttl != -1 {
+		t.Errorf("Expected key to stay without expiration, got TTL %d", ttl)
+	}
+}
+
+func TestStorageService_ExpireCycle(t *testing.T) {
+	svc, cfg, tmpDir := newExpireTestService(t)
+	defer os.RemoveAll(tmpDir)
+
+	for i := 0; i < 100; i++ {
+		svc.SetWithOptions(protocol.OpPayloadSet{Key: fmt.Sprintf("short%d", i), Value: protocol.Resp2BulkString("v"), ExpireMs: 10})
+	}
+	svc.SetWithOptions(protocol.OpPayloadSet{Key: "long", Value: protocol.Resp2BulkString("v"), ExpireMs: 60000})
+	time.Sleep(20 * time.Millisecond)
+
+	deleted := 0
+	for i := 0; i < 10 && deleted < 100; i++ {
+		n, err := svc.ExpireCycle(20)
+		if err != nil {
+			t.Fatalf("ExpireCycle failed: %v", err)
+		}
+		deleted += n
+	}
+	if deleted != 100 {
+		t.Errorf("Expected 100 expired keys to be deleted, got %d", deleted)
+	}
+	if exists, _ := svc.Exists("long"); !exists {
+		t.Errorf("Expected key without passed deadline to survive")
+	}
+
+	// Expirations are replicated as DELETE entries
+	wal, err := storage.NewSimpleWal[protocol.Resp2Value](cfg.WAL.Path)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	defer wal.Close()
+	entries, err := wal.Replay()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	deletes := 0
+	for _, e := range entries {
+		if e.OpType == protocol.DELETE {
+			deletes++
+		}
+		if e.OpType != protocol.BATCH {
+			continue
+		}
+		batch, _ := e.Value.([]protocol.Resp2Value)
+		for _, sub := range batch {
+			if fields := sub.([]protocol.Resp2Value); fields[0] == protocol.Resp2Integer(protocol.DELETE) {
+				deletes++
+			}
+		}
+	}
+	if deletes != 100 {
+		t.Errorf("Expected 100 DELETE entries in the WAL, got %d", deletes)
+	}
+}
+
+func TestExpirePersistence(t *testing.T) {
+	svc, cfg, tmpDir := newExpireTestService(t)
+	defer os.RemoveAll(tmpDir)
+	logger := config.NewLogger("Test")
+
+	svc.SetWithOptions(protocol.OpPayloadSet{Key: "snap", Value: protocol.Resp2BulkString("v"), ExpireMs: 32503680000000, ExpireAbsolute: true})
+	svc.SAdd("set", []string{"a"})
+	svc.Expire(protocol.OpPayloadExpire{Key: "set", Ms: 60000})
+	if err := svc.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	svc.SetWithOptions(protocol.OpPayloadSet{Key: "wal", Value: protocol.Resp2BulkString("v"), ExpireMs: 32503680000000, ExpireAbsolute: true})
+	svc.SetWithOptions(protocol.OpPayloadSet{Key: "gone", Value: protocol.Resp2BulkString("v"), ExpireMs: 10})
+	svc.Persist("set")
+
+	time.Sleep(20 * time.Millisecond)
+	restored := service.NewStorageService(cfg, logger)
+	for key, expected := range map[string]int64{"snap": 32503680000000, "wal": 32503680000000, "set": -1, "gone": -2} {
+		got, err := restored.TTL(protocol.OpPayloadTTL{Key: key, Ms: true, Absolute: true})
+		if err != nil {
+			t.Fatalf("TTL failed: %v", err)
+		}
+		if got != expected {
+			t.Errorf("Key %s: expected %d, got %d", key, expected, got)
+		}
+	}
+
+	// Snapshot keeps deadlines of keys which expired but were not deleted yet
+	if err := restored.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	again := service.NewStorageService(cfg, logger)
+	if ttl, _ := again.TTL(protocol.OpPayloadTTL{Key: "gone"}); ttl != -2 {
+		t.Errorf("Expected expired key to stay hidden, got TTL %d", ttl)
+	}
+}
@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/service"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func setupHTTP(t *testing.T) (*service.RedisService, *httptest.Server) {
+	t.Helper()
+	cfg := watchTestConfig(t)
+	cfg.HTTP.MaxValueSize = 16
+	cfg.HTTP.MaxPageSize = 3
+	logger := config.NewLogger("Test")
+	storageSvc := service.NewStorageService(cfg, logger)
+	redisSvc := service.NewRedisServices(storageSvc, raft.NewNetwork(cfg.Network), cfg, logger)
+	server := httptest.NewServer(service.NewHttpServiceManager(storageSvc, cfg, logger).Handler())
+	t.Cleanup(server.Close)
+	return redisSvc, server
+}
+
+// request sends request to the gateway and decodes JSON response
+func request(t *testing.T, server *httptest.Server, method, path, body string) (int, map[string]any) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Invalid JSON response %q: %v", data, err)
+	}
+	return resp.StatusCode, decoded
+}
+
+func toJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestHttpGateway_Keys(t *testing.T) {
+	svc, server := setupHTTP(t)
+
+	code, body := request(t, server, "PUT", "/v1/keys/user/1", "alice")
+	if code != http.StatusOK || toJSON(body) != `{"key":"user/1","revision":1}` {
+		t.Errorf("Unexpected PUT response %d %v", code, toJSON(body))
+	}
+	code, body = request(t, server, "GET", "/v1/keys/user/1", "")
+	if code != http.StatusOK || toJSON(body) != `{"key":"user/1","mod_revision":1,"revision":1,"type":"string","value":"alice"}` {
+		t.Errorf("Unexpected GET response %d %v", code, toJSON(body))
+	}
+	if out := runCommands(t, svc, []string{"GET", "user/1"}); out != "$5\r\nalice\r\n" {
+		t.Errorf("Expected value visible over RESP, got %q", out)
+	}
+
+	code, body = request(t, server, "PUT", "/v1/keys/session?ttl=60000", "token")
+	if code != http.StatusOK || body["expire_at"] != nil {
+		t.Errorf("Unexpected PUT response %d %v", code, toJSON(body))
+	}
+	_, body = request(t, server, "GET", "/v1/keys/session", "")
+	if expireAt, _ := body["expire_at"].(float64); expireAt <= 0 {
+		t.Errorf("Expected expiration, got %v", toJSON(body))
+	}
+	if code, _ := request(t, server, "PUT", "/v1/keys/session?ttl=-1", "x"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid ttl, got %d", code)
+	}
+	if code, _ := request(t, server, "PUT", "/v1/keys/big", strings.Repeat("x", 17)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for too large value, got %d", code)
+	}
+
+	// Composite values are rendered as JSON arrays
+	runCommands(t, svc, []string{"SADD", "tags", "b", "a"})
+	code, body = request(t, server, "GET", "/v1/keys/tags", "")
+	if code != http.StatusOK || body["type"] != "set" || toJSON(body["value"]) != `["a","b"]` {
+		t.Errorf("Unexpected GET response %d %v", code, toJSON(body))
+	}
+
+	code, body = request(t, server, "DELETE", "/v1/keys/tags", "")
+	if code != http.StatusOK || toJSON(body) != `{"deleted":1,"key":"tags","revision":4}` {
+		t.Errorf("Unexpected DELETE response %d %v", code, toJSON(body))
+	}
+	code, body = request(t, server, "DELETE", "/v1/keys/tags", "")
+	if code != http.StatusNotFound || body["error"] != "key not found" {
+		t.Errorf("Unexpected DELETE response %d %v", code, toJSON(body))
+	}
+	code, _ = request(t, server, "GET", "/v1/keys/tags", "")
+	if code != http.StatusNotFound {
+		t.Errorf("Expected 404 for deleted key, got %d", code)
+	}
+}
+
+func TestHttpGateway_List(t *testing.T) {
+	svc, server := setupHTTP(t)
+	runCommands(t, svc,
+		[]string{"MSET", "user/1", "a", "user/2", "b", "user/3", "c", "user/4", "d", "video/1", "e"},
+		[]string{"SADD", "user/5", "m"},
+	)
+
+	var keys []string
+	cursor := ""
+	pages := 0
+	for {
+		path := "/v1/keys?prefix=user/&limit=2"
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+		code, body := request(t, server, "GET", path, "")
+		if code != http.StatusOK {
+			t.Fatalf("Unexpected list response %d %v", code, toJSON(body))
+		}
+		pages++
+		for _, k := range body["keys"].([]any) {
+			kv := k.(map[string]any)
+			keys = append(keys, kv["key"].(string)+"="+kv["value"].(string))
+		}
+		cursor, _ = body["cursor"].(string)
+		if cursor == "" {
+			break
+		}
+	}
+	equalLines(t, []string{"user/1=a", "user/2=b", "user/3=c", "user/4=d"}, keys)
+	if pages != 2 {
+		t.Errorf("Expected 2 pages, got %d", pages)
+	}
+
+	// Page size is capped by configuration
+	_, body := request(t, server, "GET", "/v1/keys", "")
+	if len(body["keys"].([]any)) != 3 || body["cursor"] == nil {
+		t.Errorf("Expected a page of 3 keys, got %v", toJSON(body))
+	}
+	if code, _ := request(t, server, "GET", "/v1/keys?cursor=!", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid cursor, got %d", code)
+	}
+}
+
+func TestHttpGateway_Admin(t *testing.T) {
+	_, server := setupHTTP(t)
+	request(t, server, "PUT", "/v1/keys/a", "1")
+
+	code, body := request(t, server, "GET", "/v1/admin/status", "")
+	if code != http.StatusOK || toJSON(body) != `{"node":"self","revision":1}` {
+		t.Errorf("Unexpected status response %d %v", code, toJSON(body))
+	}
+	code, body = request(t, server, "POST", "/v1/admin/snapshot", "")
+	if code != http.StatusOK || toJSON(body) != `{"revision":1}` {
+		t.Errorf("Unexpected snapshot response %d %v", code, toJSON(body))
+	}
+	_, body = request(t, server, "GET", "/v1/keys/a", "")
+	if body["value"] != "1" {
+		t.Errorf("Expected value kept after snapshot, got %v", toJSON(body))
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	value := protocol.Resp2Array{
+		protocol.Resp2SimpleString("OK"),
+		protocol.Resp2BulkString("bulk"),
+		protocol.Resp2Integer(42),
+		nil,
+		protocol.Resp2Error("ERR failed"),
+		[]protocol.Resp2Value{protocol.Resp2BulkString("nested")},
+	}
+	data, err := protocol.RenderJSON(value)
+	if err != nil {
+		t.Fatalf("RenderJSON failed: %v", err)
+	}
+	if expected := `["OK","bulk",42,null,{"error":"ERR failed"},["nested"]]`; string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+}
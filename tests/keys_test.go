@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"fmt"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/service"
+	"main/src/storage"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestOpParserKeysCommands(t *testing.T) {
+	parse := func(args ...string) (*protocol.Op, error) {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		return opParser.Parse()
+	}
+
+	tests := []struct {
+		args     []string
+		expected protocol.OpPayload
+	}{
+		{[]string{"DEL", "a", "b"}, protocol.OpPayloadDel{Keys: []string{"a", "b"}}},
+		{[]string{"EXISTS", "a", "a"}, protocol.OpPayloadExists{Keys: []string{"a", "a"}}},
+		{[]string{"MGET", "a"}, protocol.OpPayloadMGet{Keys: []string{"a"}}},
+		{[]string{"MSET", "a", "1", "b", "2"}, protocol.OpPayloadMSet{
+			Keys:   []string{"a", "b"},
+			Values: []protocol.Resp2Value{protocol.Resp2BulkString("1"), protocol.Resp2BulkString("2")},
+		}},
+		{[]string{"MSETNX", "a", "1"}, protocol.OpPayloadMSet{
+			Keys:   []string{"a"},
+			Values: []protocol.Resp2Value{protocol.Resp2BulkString("1")},
+			NX:     true,
+		}},
+		{[]string{"SCAN", "0"}, protocol.OpPayloadScan{}},
+		{[]string{"SCAN", "17", "match", "a*", "COUNT", "5", "TYPE", "Set"}, protocol.OpPayloadScan{Cursor: 17, Pattern: "a*", Count: 5, Type: "set"}},
+		{[]string{"KEYS", "*"}, protocol.OpPayloadKeys{Pattern: "*"}},
+		{[]string{"TYPE", "a"}, protocol.OpPayloadType{Key: "a"}},
+		{[]string{"RENAME", "a", "b"}, protocol.OpPayloadRename{Key: "a", NewKey: "b"}},
+		{[]string{"COPY", "a", "b", "replace"}, protocol.OpPayloadCopy{Source: "a", Destination: "b", Replace: true}},
+		{[]string{"RANDOMKEY"}, protocol.OpPayloadRandomKey{}},
+		{[]string{"DBSIZE"}, protocol.OpPayloadDBSize{}},
+	}
+	for _, tt := range tests {
+		op, err := parse(tt.args...)
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(op.Payload, tt.expected) {
+			t.Errorf("Parse %v: expected %+v, got %+v", tt.args, tt.expected, op.Payload)
+		}
+
+		renderParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(nil))
+		data, err := renderParser.Render(op)
+		if err != nil {
+			t.Errorf("Render %v failed: %v", tt.args, err)
+			continue
+		}
+		reparser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(data))
+		again, err := reparser.Parse()
+		if err != nil || !reflect.DeepEqual(again.Payload, tt.expected) {
+			t.Errorf("Re-parse %v: expected %+v, got %+v (%v)", tt.args, tt.expected, again, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"DEL"},
+		{"MGET"},
+		{"MSET", "a"},
+		{"MSETNX", "a", "1", "b"},
+		{"SCAN"},
+		{"SCAN", "-1"},
+		{"SCAN", "0", "COUNT", "0"},
+		{"SCAN", "0", "MATCH"},
+		{"KEYS"},
+		{"RENAME", "a"},
+		{"COPY", "a", "b", "DB"},
+		{"DBSIZE", "x"},
+	}
+	for _, args := range invalid {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_KeysCommands(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmd      []string
+		expected string
+	}{
+		{"MSET", []string{"MSET", "a", "1", "b", "2", "a", "3"}, "+OK\r\n"},
+		{"MGET", []string{"MGET", "a", "b", "missing"}, "*3\r\n$1\r\n3\r\n$1\r\n2\r\n$-1\r\n"},
+		{"MSETNX existing key", []string{"MSETNX", "c", "1", "a", "1"}, ":0\r\n"},
+		{"MSETNX stored nothing", []string{"EXISTS", "c"}, ":0\r\n"},
+		{"MSETNX", []string{"MSETNX", "c", "1", "d", "2"}, ":1\r\n"},
+		{"EXISTS counts repeated keys", []string{"EXISTS", "a", "a", "c", "missing"}, ":3\r\n"},
+		{"SADD", []string{"SADD", "s", "m"}, ":1\r\n"},
+		{"MGET composite value", []string{"MGET", "s", "d"}, "*2\r\n$-1\r\n$1\r\n2\r\n"},
+		{"DEL", []string{"DEL", "a", "b", "s", "missing", "a"}, ":3\r\n"},
+		{"EXISTS after DEL", []string{"EXISTS", "a", "b", "s", "c"}, ":1\r\n"},
+		{"DELETE still supported", []string{"DELETE", "c"}, "+OK\r\n"},
+		{"DEL missing keys", []string{"DEL", "c"}, ":0\r\n"},
+		{"EXPIRE", []string{"EXPIRE", "d", "100"}, ":1\r\n"},
+		{"MSET clears expiration", []string{"MSET", "d", "3"}, "+OK\r\n"},
+		{"TTL after MSET", []string{"TTL", "d"}, ":-1\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmd); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStorageService_MultiKeyWalRecords(t *testing.T) {
+	svc, cfg, tmpDir := newExpireTestService(t)
+	defer os.RemoveAll(tmpDir)
+
+	svc.MSet(protocol.OpPayloadMSet{
+		Keys:   []string{"a", "b", "c"},
+		Values: []protocol.Resp2Value{protocol.Resp2BulkString("1"), protocol.Resp2BulkString("2"), protocol.Resp2BulkString("3")},
+	})
+	svc.Del([]string{"a", "b", "missing"})
+
+	wal, err := storage.NewSimpleWal[protocol.Resp2Value](cfg.WAL.Path)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	entries, err := wal.Replay()
+	wal.Close()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].OpType != protocol.BATCH || entries[1].OpType != protocol.BATCH {
+		t.Fatalf("Expected MSET and DEL to be logged as one BATCH record each, got %+v", entries)
+	}
+
+	restored := service.NewStorageService(cfg, config.NewLogger("Test"))
+	values, err := restored.MGet([]string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	expected := []protocol.Resp2Value{nil, nil, protocol.Resp2BulkString("3")}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+}
+
+func TestRedisService_KeyspaceCommands(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmds     [][]string
+		expected string
+	}{
+		{
+			"empty keyspace",
+			[][]string{{"DBSIZE"}, {"RANDOMKEY"}, {"SCAN", "0"}, {"TYPE", "a"}},
+			":0\r\n$-1\r\n*2\r\n$1\r\n0\r\n*0\r\n+none\r\n",
+		},
+		{
+			"types",
+			[][]string{{"MSET", "a1", "1", "a2", "2", "b", "3"}, {"SADD", "s", "m"}, {"ZADD", "z", "1", "m"}, {"TYPE", "a1"}, {"TYPE", "s"}, {"TYPE", "z"}},
+			"+OK\r\n:1\r\n:1\r\n+string\r\n+set\r\n+zset\r\n",
+		},
+		{
+			"keys and dbsize",
+			[][]string{{"KEYS", "a*"}, {"KEYS", "[bs]"}, {"DBSIZE"}},
+			"*2\r\n$2\r\na1\r\n$2\r\na2\r\n*2\r\n$1\r\nb\r\n$1\r\ns\r\n:5\r\n",
+		},
+		{
+			"scan everything with a large count",
+			[][]string{{"SCAN", "0", "COUNT", "100", "TYPE", "set"}, {"SCAN", "0", "COUNT", "100", "MATCH", "z"}},
+			"*2\r\n$1\r\n0\r\n*1\r\n$1\r\ns\r\n*2\r\n$1\r\n0\r\n*1\r\n$1\r\nz\r\n",
+		},
+		{
+			"rename keeps expiration",
+			[][]string{{"EXPIRE", "a1", "100"}, {"RENAME", "a1", "b"}, {"EXISTS", "a1"}, {"GET", "b"}, {"TTL", "b"}},
+			":1\r\n+OK\r\n:0\r\n$1\r\n1\r\n:100\r\n",
+		},
+		{
+			"rename errors",
+			[][]string{{"RENAME", "missing", "x"}, {"RENAME", "b", "b"}},
+			"-ERR no such key\r\n+OK\r\n",
+		},
+		{
+			"rename composite value",
+			[][]string{{"RENAME", "s", "s2"}, {"SMEMBERS", "s2"}, {"TYPE", "s"}},
+			"+OK\r\n*1\r\n$1\r\nm\r\n+none\r\n",
+		},
+		{
+			"copy",
+			[][]string{{"COPY", "s2", "a2"}, {"COPY", "s2", "a2", "REPLACE"}, {"SADD", "a2", "n"}, {"SCARD", "s2"}, {"SCARD", "a2"}, {"COPY", "x", "y"}, {"COPY", "b", "b"}},
+			":0\r\n:1\r\n:1\r\n:1\r\n:2\r\n:0\r\n-ERR source and destination objects are the same\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmds...); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStorageService_ScanCursor(t *testing.T) {
+	svc, cfg, tmpDir := newExpireTestService(t)
+	defer os.RemoveAll(tmpDir)
+
+	initial := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		initial[key] = true
+		if err := svc.Set(key, protocol.Resp2BulkString("v")); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	// Keys existing during the whole iteration are returned although the keyspace changes
+	seen := make(map[string]bool)
+	var cursor uint64
+	for calls := 0; ; calls++ {
+		keys, next, err := svc.Scan(protocol.OpPayloadScan{Cursor: cursor, Count: 7})
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+		svc.Set(fmt.Sprintf("added:%d", calls), protocol.Resp2BulkString("v"))
+		svc.Del([]string{fmt.Sprintf("key:%d", 99-calls)})
+		delete(initial, fmt.Sprintf("key:%d", 99-calls))
+		if next == 0 {
+			break
+		}
+		if calls > 100 {
+			t.Fatal("Scan did not complete")
+		}
+		cursor = next
+	}
+	for key := range initial {
+		if !seen[key] {
+			t.Errorf("Key %s was not returned", key)
+		}
+	}
+
+	// Renames are replayed from the WAL
+	if err := svc.Rename("key:0", "renamed"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	restored := service.NewStorageService(cfg, config.NewLogger("Test"))
+	if kind, _ := restored.Type("renamed"); kind != "string" {
+		t.Errorf("Expected renamed key to be restored, got %s", kind)
+	}
+	if kind, _ := restored.Type("key:0"); kind != "none" {
+		t.Errorf("Expected source of the rename to be gone, got %s", kind)
+	}
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"main/src/config"
+	"main/src/raft"
+	"main/src/raft/pb"
+	"main/src/service"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setupKeyValue(t *testing.T) (*service.RedisService, pb.KeyValueClient) {
+	t.Helper()
+	cfg := watchTestConfig(t)
+	logger := config.NewLogger("Test")
+	storageSvc := service.NewStorageService(cfg, logger)
+	redisSvc := service.NewRedisServices(storageSvc, raft.NewNetwork(cfg.Network), cfg, logger)
+	conn := startGrpc(t, service.NewKeyValueService(storageSvc, logger).Register)
+	return redisSvc, pb.NewKeyValueClient(conn)
+}
+
+func kvKeys(kvs []*pb.KeyValuePair) []string {
+	keys := []string{}
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key+"="+string(kv.Value))
+	}
+	return keys
+}
+
+func TestKeyValueService_PutGet(t *testing.T) {
+	svc, client := setupKeyValue(t)
+	ctx := context.Background()
+
+	put, err := client.Put(ctx, &pb.PutRequest{Key: "a", Value: []byte("1")})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if put.Header.Revision != 1 || put.PrevKv != nil {
+		t.Errorf("Expected revision 1 without previous value, got %v", put)
+	}
+	put, err = client.Put(ctx, &pb.PutRequest{Key: "a", Value: []byte("2"), PrevKv: true})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if put.Header.Revision != 2 || string(put.PrevKv.GetValue()) != "1" {
+		t.Errorf("Expected revision 2 with previous value 1, got %v", put)
+	}
+
+	get, err := client.Get(ctx, &pb.GetRequest{Key: "a"})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if get.Header.Revision != 2 || string(get.Kv.GetValue()) != "2" {
+		t.Errorf("Expected value 2 at revision 2, got %v", get)
+	}
+	get, err = client.Get(ctx, &pb.GetRequest{Key: "missing"})
+	if err != nil || get.Kv != nil {
+		t.Errorf("Expected missing key, got %v, %v", get, err)
+	}
+
+	// Same keyspace as the redis port
+	out := runCommands(t, svc, []string{"GET", "a"}, []string{"SET", "b", "x"}, []string{"SADD", "s", "m"})
+	if expected := "$1\r\n2\r\n+OK\r\n:1\r\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+	get, err = client.Get(ctx, &pb.GetRequest{Key: "b"})
+	if err != nil || string(get.Kv.GetValue()) != "x" || get.Header.Revision != 4 {
+		t.Errorf("Expected value x at revision 4, got %v, %v", get, err)
+	}
+	if _, err := client.Get(ctx, &pb.GetRequest{Key: "s"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition for set key, got %v", err)
+	}
+	if _, err := client.Put(ctx, &pb.PutRequest{Value: []byte("v")}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for empty key, got %v", err)
+	}
+}
+
+func TestKeyValueService_RangeDelete(t *testing.T) {
+	svc, client := setupKeyValue(t)
+	ctx := context.Background()
+	runCommands(t, svc, []string{"MSET", "a", "1", "b1", "2", "b2", "3", "b3", "4", "c", "5"}, []string{"SADD", "b4", "m"})
+
+	rng, err := client.Range(ctx, &pb.RangeRequest{Key: "b", RangeEnd: "c"})
+	if err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	equalLines(t, []string{"b1=2", "b2=3", "b3=4"}, kvKeys(rng.Kvs))
+	if rng.More || rng.Header.Revision != 2 {
+		t.Errorf("Expected complete range at revision 2, got %v", rng)
+	}
+
+	rng, err = client.Range(ctx, &pb.RangeRequest{Key: "b2", RangeEnd: service.RangeAll, Limit: 2, KeysOnly: true})
+	if err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	equalLines(t, []string{"b2=", "b3="}, kvKeys(rng.Kvs))
+	if !rng.More {
+		t.Error("Expected more keys after the limit")
+	}
+
+	del, err := client.Delete(ctx, &pb.DeleteRequest{Key: "b", RangeEnd: "c", PrevKv: true})
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	equalLines(t, []string{"b1=2", "b2=3", "b3=4"}, kvKeys(del.PrevKvs))
+	if del.Deleted != 3 || del.Header.Revision != 3 {
+		t.Errorf("Expected 3 keys deleted in revision 3, got %v", del)
+	}
+
+	// Single key is deleted regardless of its type
+	del, err = client.Delete(ctx, &pb.DeleteRequest{Key: "b4"})
+	if err != nil || del.Deleted != 1 {
+		t.Errorf("Expected set key deleted, got %v, %v", del, err)
+	}
+	if out := runCommands(t, svc, []string{"MGET", "a", "b1", "c"}); out != "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n5\r\n" {
+		t.Errorf("Expected only a and c left, got %q", out)
+	}
+	if out := runCommands(t, svc, []string{"EXISTS", "b4"}); out != ":0\r\n" {
+		t.Errorf("Expected b4 deleted, got %q", out)
+	}
+}
+
+func TestKeyValueService_Txn(t *testing.T) {
+	svc, client := setupKeyValue(t)
+	ctx := context.Background()
+	runCommands(t, svc, []string{"SET", "balance", "10"})
+
+	txn := &pb.TxnRequest{
+		Compare: []*pb.Compare{
+			{Key: "balance", Result: pb.Compare_EQUAL, Target: &pb.Compare_Value{Value: []byte("10")}},
+			{Key: "lock", Result: pb.Compare_EQUAL, Target: &pb.Compare_Exists{Exists: false}},
+		},
+		Success: []*pb.RequestOp{
+			{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: "balance", Value: []byte("5")}}},
+			{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: "spent", Value: []byte("5")}}},
+			{Request: &pb.RequestOp_RequestRange{RequestRange: &pb.RangeRequest{Key: "balance"}}},
+		},
+		Failure: []*pb.RequestOp{
+			{Request: &pb.RequestOp_RequestRange{RequestRange: &pb.RangeRequest{Key: "balance"}}},
+		},
+	}
+	resp, err := client.Txn(ctx, txn)
+	if err != nil {
+		t.Fatalf("Txn failed: %v", err)
+	}
+	// Both puts are a single write
+	if !resp.Succeeded || resp.Header.Revision != 2 || len(resp.Responses) != 3 {
+		t.Fatalf("Expected successful transaction at revision 2, got %v", resp)
+	}
+	equalLines(t, []string{"balance=5"}, kvKeys(resp.Responses[2].GetResponseRange().Kvs))
+
+	resp, err = client.Txn(ctx, txn)
+	if err != nil {
+		t.Fatalf("Txn failed: %v", err)
+	}
+	if resp.Succeeded || resp.Header.Revision != 2 || len(resp.Responses) != 1 {
+		t.Fatalf("Expected failed read-only transaction at revision 2, got %v", resp)
+	}
+	equalLines(t, []string{"balance=5"}, kvKeys(resp.Responses[0].GetResponseRange().Kvs))
+
+	_, err = client.Txn(ctx, &pb.TxnRequest{
+		Compare: []*pb.Compare{{Key: "a", Result: pb.Compare_GREATER, Target: &pb.Compare_Exists{Exists: true}}},
+		Success: []*pb.RequestOp{{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: "a"}}}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+	if out := runCommands(t, svc, []string{"EXISTS", "a"}); out != ":0\r\n" {
+		t.Errorf("Expected invalid transaction to modify nothing, got %q", out)
+	}
+}
+
+func TestKeyValueService_Consistency(t *testing.T) {
+	_, client := setupKeyValue(t)
+	ctx := context.Background()
+
+	_, err := client.Get(ctx, &pb.GetRequest{Key: "a", MinRevision: 1, Consistency: pb.Consistency_SERIALIZABLE})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition for future revision, got %v", err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = client.Get(short, &pb.GetRequest{Key: "a", MinRevision: 1})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+
+	// Linearizable read waits for the revision
+	done := make(chan *pb.RangeResponse, 1)
+	go func() {
+		resp, err := client.Range(ctx, &pb.RangeRequest{Key: "a", RangeEnd: service.RangeAll, MinRevision: 2})
+		if err != nil {
+			t.Errorf("Range failed: %v", err)
+		}
+		done <- resp
+	}()
+	for _, key := range []string{"a", "b"} {
+		if _, err := client.Put(ctx, &pb.PutRequest{Key: key, Value: []byte("v")}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	select {
+	case resp := <-done:
+		if resp == nil || resp.Header.Revision < 2 || len(resp.Kvs) != 2 {
+			t.Errorf("Expected both keys at revision 2, got %v", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Range did not finish")
+	}
+}
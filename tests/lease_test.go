@@ -0,0 +1,302 @@
+package tests
+
+import (
+	"context"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft/pb"
+	"main/src/service"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOpParserLeaseCommands(t *testing.T) {
+	parse := func(args ...string) (*protocol.Op, error) {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		return opParser.Parse()
+	}
+
+	tests := []struct {
+		args     []string
+		expected protocol.OpPayload
+	}{
+		{[]string{"LEASE", "grant", "1000"}, protocol.OpPayloadLeaseGrant{TTL: 1000}},
+		{[]string{"LEASE", "ATTACH", "3", "a", "b"}, protocol.OpPayloadLeaseAttach{ID: 3, Keys: []string{"a", "b"}}},
+		{[]string{"LEASE", "KEEPALIVE", "3"}, protocol.OpPayloadLeaseKeepAlive{ID: 3}},
+		{[]string{"LEASE", "REVOKE", "3"}, protocol.OpPayloadLeaseRevoke{ID: 3}},
+		{[]string{"LEASE", "TTL", "3"}, protocol.OpPayloadLeaseTTL{ID: 3}},
+		{[]string{"LEASE", "LIST"}, protocol.OpPayloadLeaseList{}},
+	}
+	for _, tt := range tests {
+		op, err := parse(tt.args...)
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(op.Payload, tt.expected) {
+			t.Errorf("Expected %+v, got %+v", tt.expected, op.Payload)
+		}
+		opParser := protocol.MakeOpParser(nil)
+		rendered, err := opParser.Render(op)
+		if err != nil {
+			t.Errorf("Render %v failed: %v", tt.args, err)
+			continue
+		}
+		reparser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(rendered))
+		reparsed, err := reparser.Parse()
+		if err != nil || !reflect.DeepEqual(reparsed, op) {
+			t.Errorf("Expected %+v after rendering, got %+v, %v", op, reparsed, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"LEASE"},
+		{"LEASE", "GRANT", "0"},
+		{"LEASE", "GRANT", "9223372036854775807"},
+		{"LEASE", "ATTACH", "3"},
+		{"LEASE", "KEEPALIVE", "x"},
+		{"LEASE", "LIST", "1"},
+		{"LEASE", "UNKNOWN", "1"},
+	}
+	for _, args := range invalid {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_Leases(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmds     [][]string
+		expected string
+	}{
+		{
+			"grant returns index of the entry",
+			[][]string{{"SET", "a", "1"}, {"SET", "b", "2"}, {"LEASE", "GRANT", "60000"}, {"LEASE", "LIST"}},
+			"+OK\r\n+OK\r\n:3\r\n*1\r\n:3\r\n",
+		},
+		{
+			"attach existing keys",
+			[][]string{{"LEASE", "ATTACH", "3", "a", "b", "missing"}, {"LEASE", "KEEPALIVE", "3"}},
+			":2\r\n:1\r\n",
+		},
+		{
+			"deleted key is detached",
+			[][]string{{"SET", "c", "3"}, {"LEASE", "ATTACH", "3", "c"}, {"DEL", "c"}, {"SET", "c", "3"}},
+			"+OK\r\n:1\r\n:1\r\n+OK\r\n",
+		},
+		{
+			"revoke deletes attached keys",
+			[][]string{{"LEASE", "REVOKE", "3"}, {"EXISTS", "a", "b", "c"}, {"LEASE", "TTL", "3"}, {"LEASE", "LIST"}},
+			":1\r\n:1\r\n$-1\r\n*0\r\n",
+		},
+		{
+			"revoked lease",
+			[][]string{{"LEASE", "REVOKE", "3"}, {"LEASE", "KEEPALIVE", "3"}, {"LEASE", "ATTACH", "3", "c"}},
+			":0\r\n:0\r\n-ERR lease not found\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmds...); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStorageService_LeaseExpiry(t *testing.T) {
+	svc := service.NewStorageService(watchTestConfig(t), config.NewLogger("Test"))
+	for _, key := range []string{"a", "b", "c"} {
+		if err := svc.Set(key, protocol.Resp2BulkString("v")); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	id, err := svc.GrantLease(50)
+	if err != nil {
+		t.Fatalf("GrantLease failed: %v", err)
+	}
+	if n, err := svc.AttachLease(id, []string{"a", "b"}); err != nil || n != 2 {
+		t.Fatalf("Expected 2 attached keys, got %d, %v", n, err)
+	}
+	info := svc.LeaseTimeToLive(id)
+	if info == nil || info.TTL != 50 || info.Remaining > 50 || !reflect.DeepEqual(info.Keys, []string{"a", "b"}) {
+		t.Fatalf("Unexpected lease info %+v", info)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	// Keys are hidden as soon as the lease expires, before it is revoked
+	if n, _ := svc.CountExisting([]string{"a", "b", "c"}); n != 1 {
+		t.Errorf("Expected only c to exist, got %d keys", n)
+	}
+	if ttl, err := svc.KeepAliveLease(id); err != nil || ttl != 0 {
+		t.Errorf("Expected expired lease not to be kept alive, got %d, %v", ttl, err)
+	}
+	if svc.LeaseTimeToLive(id) != nil || len(svc.Leases()) != 0 {
+		t.Errorf("Expected expired lease to be gone")
+	}
+
+	revision := svc.Revision()
+	if n, err := svc.ExpireLeases(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 expired lease, got %d, %v", n, err)
+	}
+	// Lease and its keys are deleted by a single record
+	if got := svc.Revision(); got != revision+1 {
+		t.Errorf("Expected revision %d, got %d", revision+1, got)
+	}
+	if n, _ := svc.ExpireLeases(); n != 0 {
+		t.Errorf("Expected no leases left, got %d", n)
+	}
+}
+
+func TestStorageService_LeaseMaxTTL(t *testing.T) {
+	svc := service.NewStorageService(watchTestConfig(t), config.NewLogger("Test"))
+	if err := svc.Set("k", protocol.Resp2BulkString("v")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	// Deadline saturates instead of wrapping around into the past
+	id, err := svc.GrantLease(math.MaxInt64)
+	if err != nil {
+		t.Fatalf("GrantLease failed: %v", err)
+	}
+	if n, err := svc.AttachLease(id, []string{"k"}); err != nil || n != 1 {
+		t.Fatalf("Expected 1 attached key, got %d, %v", n, err)
+	}
+	if ttl, err := svc.KeepAliveLease(id); err != nil || ttl != math.MaxInt64 {
+		t.Errorf("Expected lease to be kept alive, got %d, %v", ttl, err)
+	}
+	if exists, _ := svc.Exists("k"); !exists {
+		t.Errorf("Expected attached key to exist")
+	}
+	if n, err := svc.ExpireLeases(); err != nil || n != 0 {
+		t.Errorf("Expected no expired leases, got %d, %v", n, err)
+	}
+}
+
+func TestStorageService_LeasesSurviveRestart(t *testing.T) {
+	cfg := watchTestConfig(t)
+	logger := config.NewLogger("Test")
+	svc := service.NewStorageService(cfg, logger)
+	for _, key := range []string{"a", "b"} {
+		if err := svc.Set(key, protocol.Resp2BulkString("v")); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	id, err := svc.GrantLease(60000)
+	if err != nil {
+		t.Fatalf("GrantLease failed: %v", err)
+	}
+	if _, err := svc.AttachLease(id, []string{"a", "b"}); err != nil {
+		t.Fatalf("AttachLease failed: %v", err)
+	}
+
+	check := func(svc *service.StorageService) {
+		t.Helper()
+		info := svc.LeaseTimeToLive(id)
+		if info == nil || info.TTL != 60000 || !reflect.DeepEqual(info.Keys, []string{"a", "b"}) {
+			t.Errorf("Expected lease %d with keys a and b, got %+v", id, info)
+		}
+	}
+
+	// Rebuilt from the WAL
+	restored := service.NewStorageService(cfg, logger)
+	check(restored)
+
+	// Loaded from the snapshot
+	if err := restored.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	restored = service.NewStorageService(cfg, logger)
+	check(restored)
+	if ok, err := restored.RevokeLease(id); err != nil || !ok {
+		t.Fatalf("Expected lease to be revoked, got %v, %v", ok, err)
+	}
+	if n, _ := restored.CountExisting([]string{"a", "b"}); n != 0 {
+		t.Errorf("Expected keys of the lease to be deleted, %d left", n)
+	}
+}
+
+func TestLeaseService(t *testing.T) {
+	svc := service.NewStorageService(watchTestConfig(t), config.NewLogger("Test"))
+	logger := config.NewLogger("Test")
+	conn := startGrpc(t, func(server grpc.ServiceRegistrar) {
+		service.NewLeaseService(svc, logger).Register(server)
+		service.NewKeyValueService(svc, logger).Register(server)
+	})
+	leases, kv := pb.NewLeaseClient(conn), pb.NewKeyValueClient(conn)
+	ctx := context.Background()
+
+	grant, err := leases.Grant(ctx, &pb.LeaseGrantRequest{TtlMs: 60000})
+	if err != nil || grant.Id == 0 {
+		t.Fatalf("Grant failed: %v, %v", grant, err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := kv.Put(ctx, &pb.PutRequest{Key: key, Value: []byte("v"), Lease: grant.Id}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if _, err := kv.Put(ctx, &pb.PutRequest{Key: "c", Value: []byte("v"), Lease: 1000}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected not found lease, got %v", err)
+	}
+	txn := &pb.TxnRequest{Success: []*pb.RequestOp{
+		{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: "c", Value: []byte("v")}}},
+		{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: "d", Value: []byte("v"), Lease: 1000}}},
+	}}
+	if _, err := kv.Txn(ctx, txn); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected not found lease, got %v", err)
+	}
+	if resp, _ := kv.Get(ctx, &pb.GetRequest{Key: "c"}); resp.GetKv() != nil {
+		t.Errorf("Expected failed transaction not to write, got %v", resp.Kv)
+	}
+
+	ttl, err := leases.TimeToLive(ctx, &pb.LeaseTimeToLiveRequest{Id: grant.Id, Keys: true})
+	if err != nil || ttl.GrantedTtlMs != 60000 || ttl.TtlMs <= 0 || !reflect.DeepEqual(ttl.Keys, []string{"a", "b"}) {
+		t.Errorf("Unexpected time to live %v, %v", ttl, err)
+	}
+	list, err := leases.Leases(ctx, &pb.LeaseLeasesRequest{})
+	if err != nil || !reflect.DeepEqual(list.Ids, []uint64{grant.Id}) {
+		t.Errorf("Expected lease %d listed, got %v, %v", grant.Id, list, err)
+	}
+
+	stream, err := leases.KeepAlive(ctx)
+	if err != nil {
+		t.Fatalf("KeepAlive failed: %v", err)
+	}
+	for _, id := range []uint64{grant.Id, 1000} {
+		if err := stream.Send(&pb.LeaseKeepAliveRequest{Id: id}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if expected := map[uint64]int64{grant.Id: 60000}[id]; resp.Id != id || resp.TtlMs != expected {
+			t.Errorf("Expected ttl %d for lease %d, got %v", expected, id, resp)
+		}
+	}
+	stream.CloseSend()
+
+	revoke, err := leases.Revoke(ctx, &pb.LeaseRevokeRequest{Id: grant.Id})
+	if err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	rng, err := kv.Range(ctx, &pb.RangeRequest{Key: "a", RangeEnd: service.RangeAll})
+	if err != nil || len(rng.Kvs) != 0 || rng.Header.Revision != revoke.Header.Revision {
+		t.Errorf("Expected keys deleted at revision %d, got %v, %v", revoke.Header.Revision, rng, err)
+	}
+	if _, err := leases.Revoke(ctx, &pb.LeaseRevokeRequest{Id: grant.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected not found lease, got %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package tests
+
+import (
+	"context"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft/pb"
+	"main/src/service"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOpParserLockCommands(t *testing.T) {
+	parse := func(args ...string) (*protocol.Op, error) {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		return opParser.Parse()
+	}
+
+	tests := []struct {
+		args     []string
+		expected protocol.OpPayload
+	}{
+		{[]string{"LOCK", "l", "me", "1000"}, protocol.OpPayloadLock{Name: "l", Owner: "me", TTL: 1000, Block: -1}},
+		{[]string{"LOCK", "l", "me", "1000", "block", "0"}, protocol.OpPayloadLock{Name: "l", Owner: "me", TTL: 1000, Block: 0}},
+		{[]string{"LOCKRENEW", "l", "7", "500"}, protocol.OpPayloadLockRenew{Name: "l", Token: 7, TTL: 500}},
+		{[]string{"UNLOCK", "l", "7"}, protocol.OpPayloadUnlock{Name: "l", Token: 7}},
+	}
+	for _, tt := range tests {
+		op, err := parse(tt.args...)
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(op.Payload, tt.expected) {
+			t.Errorf("Expected %+v, got %+v", tt.expected, op.Payload)
+		}
+		opParser := protocol.MakeOpParser(nil)
+		rendered, err := opParser.Render(op)
+		if err != nil {
+			t.Errorf("Render %v failed: %v", tt.args, err)
+			continue
+		}
+		reparser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(rendered))
+		reparsed, err := reparser.Parse()
+		if err != nil || !reflect.DeepEqual(reparsed, op) {
+			t.Errorf("Expected %+v after rendering, got %+v, %v", op, reparsed, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"LOCK", "l", "me"},
+		{"LOCK", "l", "me", "0"},
+		{"LOCK", "l", "me", "100", "BLOCK"},
+		{"LOCK", "l", "me", "100", "BLOCK", "-1"},
+		{"LOCKRENEW", "l", "x", "100"},
+		{"UNLOCK", "l"},
+	}
+	for _, args := range invalid {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_Locks(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmds     [][]string
+		expected string
+	}{
+		{
+			"acquire returns index of the entry",
+			[][]string{{"SET", "k", "v"}, {"LOCK", "l", "a", "10000"}},
+			"+OK\r\n:2\r\n",
+		},
+		{
+			"held by another owner",
+			[][]string{{"LOCK", "l", "b", "10000"}, {"GET", "l"}},
+			"$-1\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		},
+		{
+			"owner keeps its token",
+			[][]string{{"LOCK", "l", "a", "10000"}, {"LOCKRENEW", "l", "2", "10000"}, {"LOCKRENEW", "l", "3", "10000"}},
+			":2\r\n:1\r\n:0\r\n",
+		},
+		{
+			"release with stale token",
+			[][]string{{"UNLOCK", "l", "1"}, {"UNLOCK", "l", "2"}, {"UNLOCK", "l", "2"}},
+			":0\r\n:1\r\n:0\r\n",
+		},
+		{
+			"next holder gets a greater token",
+			[][]string{{"LOCK", "l", "b", "10000"}},
+			":6\r\n",
+		},
+		{
+			"wrong type",
+			[][]string{{"LOCK", "k", "a", "10000"}, {"UNLOCK", "k", "1"}},
+			"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmds...); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStorageService_LockWaiters(t *testing.T) {
+	svc := service.NewStorageService(watchTestConfig(t), config.NewLogger("Test"))
+	ctx := context.Background()
+
+	first, err := svc.Lock(ctx, "l", "a", 10000, -1)
+	if err != nil || first == 0 {
+		t.Fatalf("Expected lock to be acquired, got %d, %v", first, err)
+	}
+	if token, err := svc.Lock(ctx, "l", "b", 10000, 20); err != nil || token != 0 {
+		t.Fatalf("Expected lock to time out, got %d, %v", token, err)
+	}
+
+	// Released lock goes to the waiter
+	acquired := make(chan uint64, 1)
+	go func() {
+		token, _ := svc.Lock(ctx, "l", "b", 100, 0)
+		acquired <- token
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if ok, err := svc.Unlock("l", first); err != nil || !ok {
+		t.Fatalf("Unlock failed: %v, %v", ok, err)
+	}
+	var second uint64
+	select {
+	case second = <-acquired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Waiter was not woken up by unlock")
+	}
+	if second <= first {
+		t.Errorf("Expected token greater than %d, got %d", first, second)
+	}
+
+	// Lease which is not renewed expires, expirer is not running
+	start := time.Now()
+	third, err := svc.Lock(ctx, "l", "c", 10000, 2000)
+	if err != nil || third <= second {
+		t.Fatalf("Expected token greater than %d, got %d, %v", second, third, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected lock after the lease of 100ms, waited %v", elapsed)
+	}
+	if ok, _ := svc.RenewLock("l", second, 1000); ok {
+		t.Errorf("Expected renew of expired lease to fail")
+	}
+
+	// Waiting is bounded by the context
+	cancelled, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if token, err := svc.Lock(cancelled, "l", "d", 10000, 0); token != 0 || err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %d, %v", token, err)
+	}
+}
+
+func TestStorageService_LockMaxLease(t *testing.T) {
+	svc := service.NewStorageService(watchTestConfig(t), config.NewLogger("Test"))
+	ctx := context.Background()
+
+	// Deadline saturates instead of wrapping around into the past
+	token, err := svc.Lock(ctx, "l", "o", math.MaxInt64, -1)
+	if err != nil || token == 0 {
+		t.Fatalf("Expected lock to be acquired, got %d, %v", token, err)
+	}
+	if got, err := svc.Lock(ctx, "l", "o2", 1000, -1); err != nil || got != 0 {
+		t.Errorf("Expected lock to stay held, got %d, %v", got, err)
+	}
+	if ok, err := svc.RenewLock("l", token, math.MaxInt64); err != nil || !ok {
+		t.Fatalf("RenewLock failed: %v, %v", ok, err)
+	}
+	if got, err := svc.Lock(ctx, "l", "o2", 1000, -1); err != nil || got != 0 {
+		t.Errorf("Expected renewed lock to stay held, got %d, %v", got, err)
+	}
+}
+
+func TestStorageService_LocksSurviveRestart(t *testing.T) {
+	cfg := watchTestConfig(t)
+	logger := config.NewLogger("Test")
+	svc := service.NewStorageService(cfg, logger)
+	token, err := svc.Lock(context.Background(), "l", "a", 60000, -1)
+	if err != nil || token == 0 {
+		t.Fatalf("Lock failed: %d, %v", token, err)
+	}
+
+	restored := service.NewStorageService(cfg, logger)
+	if err := restored.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	restored = service.NewStorageService(cfg, logger)
+	if got, err := restored.Lock(context.Background(), "l", "a", 60000, -1); err != nil || got != token {
+		t.Errorf("Expected owner to keep token %d, got %d, %v", token, got, err)
+	}
+	if got, err := restored.Lock(context.Background(), "l", "b", 60000, -1); err != nil || got != 0 {
+		t.Errorf("Expected lock to stay held, got %d, %v", got, err)
+	}
+	if ok, err := restored.Unlock("l", token); err != nil || !ok {
+		t.Errorf("Expected unlock with restored token, got %v, %v", ok, err)
+	}
+}
+
+func TestLockService(t *testing.T) {
+	svc := service.NewStorageService(watchTestConfig(t), config.NewLogger("Test"))
+	client := pb.NewLockClient(startGrpc(t, service.NewLockService(svc, config.NewLogger("Test")).Register))
+	ctx := context.Background()
+
+	acquired, err := client.Acquire(ctx, &pb.AcquireRequest{Name: "l", Owner: "a", TtlMs: 10000})
+	if err != nil || !acquired.Acquired || acquired.Token == 0 {
+		t.Fatalf("Expected lock to be acquired, got %v, %v", acquired, err)
+	}
+	if resp, err := client.Acquire(ctx, &pb.AcquireRequest{Name: "l", Owner: "b", TtlMs: 10000}); err != nil || resp.Acquired {
+		t.Errorf("Expected lock to be held, got %v, %v", resp, err)
+	}
+	if resp, err := client.Renew(ctx, &pb.RenewRequest{Name: "l", Token: acquired.Token, TtlMs: 10000}); err != nil || !resp.Renewed {
+		t.Errorf("Expected renew, got %v, %v", resp, err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Acquire(waitCtx, &pb.AcquireRequest{Name: "l", Owner: "b", TtlMs: 10000, Wait: true}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	waited := make(chan *pb.AcquireResponse, 1)
+	go func() {
+		resp, _ := client.Acquire(ctx, &pb.AcquireRequest{Name: "l", Owner: "b", TtlMs: 10000, Wait: true})
+		waited <- resp
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if resp, err := client.Release(ctx, &pb.ReleaseRequest{Name: "l", Token: acquired.Token}); err != nil || !resp.Released {
+		t.Fatalf("Expected release, got %v, %v", resp, err)
+	}
+	select {
+	case resp := <-waited:
+		if resp == nil || !resp.Acquired || resp.Token <= acquired.Token {
+			t.Errorf("Expected greater token than %d, got %v", acquired.Token, resp)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Waiter did not acquire released lock")
+	}
+
+	if _, err := client.Acquire(ctx, &pb.AcquireRequest{Name: "l", Owner: "a"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected invalid argument, got %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package tests
+
+import (
+	"fmt"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/service"
+	"main/src/storage"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Small sizes, so a few thousand keys are spread over several tables and levels
+func smallLSMOptions() storage.LSMOptions {
+	return storage.LSMOptions{BlockSize: 256, TableSize: 2048, L0Tables: 2, LevelSize: 8192, BitsPerKey: 10}
+}
+
+func openTestLSM[T any](t *testing.T, dir string) *storage.LSMStorage[T] {
+	t.Helper()
+	store, err := storage.OpenLSMStorage[T](dir, smallLSMOptions())
+	if err != nil {
+		t.Fatalf("OpenLSMStorage failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// flushLSM writes the memtable to tables, like StorageService.Snapshot does
+func flushLSM[T any](t *testing.T, store *storage.LSMStorage[T]) {
+	t.Helper()
+	if err := store.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if err := store.Snapshot(nil); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+}
+
+func TestLSMStorage(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestLSM[string](t, dir)
+	RunStorageTests(t, store)
+
+	// The same contract holds once values are read back from tables
+	flushLSM(t, store)
+	if value, found, err := store.Get("key-1024"); err != nil || !found || value != "value-1024" {
+		t.Errorf("Expected flushed value, got %q, %v", value, err)
+	}
+	if exists, _ := store.Exists("deleteKey"); exists {
+		t.Errorf("Expected deleted key to stay deleted after flush")
+	}
+}
+
+func TestLSMStorage_MatchesModel(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestLSM[protocol.Resp2Value](t, dir)
+	model := make(map[string]string)
+	rng := rand.New(rand.NewPCG(3, 4))
+
+	check := func(store *storage.LSMStorage[protocol.Resp2Value]) {
+		t.Helper()
+		keys := make([]string, 0, len(model))
+		for key := range model {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		var got []string
+		store.Iterator()(func(key string, value protocol.Resp2Value) bool {
+			if value != protocol.Resp2Value(protocol.Resp2BulkString(model[key])) {
+				t.Fatalf("Expected %s=%s, got %v", key, model[key], value)
+			}
+			got = append(got, key)
+			return true
+		})
+		if !slices.Equal(got, keys) {
+			t.Fatalf("Expected %d keys in order, got %d", len(keys), len(got))
+		}
+
+		reversed := slices.Clone(keys)
+		slices.Reverse(reversed)
+		got = got[:0]
+		store.Reverse("", "")(func(key string, _ protocol.Resp2Value) bool {
+			got = append(got, key)
+			return true
+		})
+		if !slices.Equal(got, reversed) {
+			t.Fatalf("Expected %d keys in reverse order, got %d", len(reversed), len(got))
+		}
+
+		// Bounded ranges start and end inside tables and blocks
+		start, end := "k0300", "k0700"
+		var expected []string
+		for _, key := range keys {
+			if key >= start && key < end {
+				expected = append(expected, key)
+			}
+		}
+		got = got[:0]
+		store.Range(start, end)(func(key string, _ protocol.Resp2Value) bool {
+			got = append(got, key)
+			return true
+		})
+		if !slices.Equal(got, expected) {
+			t.Fatalf("Expected range %v, got %v", expected, got)
+		}
+		slices.Reverse(expected)
+		got = got[:0]
+		store.Reverse(start, end)(func(key string, _ protocol.Resp2Value) bool {
+			got = append(got, key)
+			return true
+		})
+		if !slices.Equal(got, expected) {
+			t.Fatalf("Expected reversed range %v, got %v", expected, got)
+		}
+
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("k%04d", i)
+			value, found, err := store.Get(key)
+			exists, _ := store.Exists(key)
+			expected, ok := model[key]
+			if err != nil || exists != ok || found != ok || (ok && value != protocol.Resp2Value(protocol.Resp2BulkString(expected))) || (!ok && value != nil) {
+				t.Fatalf("Expected %s=%q (%v), got %v, %v, %v", key, expected, ok, value, exists, err)
+			}
+		}
+	}
+
+	flushes := 0
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 300; i++ {
+			key := fmt.Sprintf("k%04d", rng.IntN(1000))
+			if rng.IntN(3) == 0 {
+				store.Delete(key)
+				delete(model, key)
+				continue
+			}
+			value := fmt.Sprintf("%s-%d-%d", key, round, i)
+			store.Set(key, protocol.Resp2BulkString(value))
+			model[key] = value
+		}
+		check(store)
+		flushLSM(t, store)
+		flushes++
+		check(store)
+	}
+
+	// Compaction merges flushed tables instead of keeping all of them
+	tables, _ := filepath.Glob(filepath.Join(dir, "*.sst"))
+	if len(tables) == 0 || len(tables) >= flushes*2 {
+		t.Errorf("Expected compacted tables, got %d files after %d flushes", len(tables), flushes)
+	}
+
+	store.Close()
+	check(openTestLSM[protocol.Resp2Value](t, dir))
+}
+
+func TestLSMStorage_ApplyPersistsMetadata(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestLSM[protocol.Resp2Value](t, dir)
+	members := func(names ...string) protocol.Resp2Value {
+		arr := []protocol.Resp2Value{}
+		for _, name := range names {
+			arr = append(arr, protocol.Resp2BulkString(name))
+		}
+		return arr
+	}
+	apply := func(entry storage.WalEntry[protocol.Resp2Value]) {
+		t.Helper()
+		if err := storage.Apply(storage.Storage[protocol.Resp2Value](store), entry); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Hour).UnixMilli()
+
+	apply(storage.WalEntry[protocol.Resp2Value]{Index: 1, OpType: protocol.SADD, Key: "set", Value: members("a", "b", "c")})
+	apply(storage.WalEntry[protocol.Resp2Value]{Index: 2, OpType: protocol.SET, Key: "str", Value: protocol.Resp2BulkString("v")})
+	apply(storage.WalEntry[protocol.Resp2Value]{Index: 3, OpType: protocol.EXPIRE, Key: "str", Value: protocol.Resp2BulkString(strconv.FormatInt(deadline, 10))})
+	flushLSM(t, store)
+
+	// Set read back from a table is modified in place and stored again
+	apply(storage.WalEntry[protocol.Resp2Value]{Index: 4, OpType: protocol.SREM, Key: "set", Value: members("b")})
+	flushLSM(t, store)
+	store.Close()
+
+	restored := openTestLSM[protocol.Resp2Value](t, dir)
+	set, err := storage.GetSet(storage.Storage[protocol.Resp2Value](restored), "set")
+	if err != nil || !reflect.DeepEqual(set, storage.NewSet("a", "c")) {
+		t.Errorf("Expected set [a c], got %v, %v", set, err)
+	}
+	if revision := restored.Revision("set"); revision != 4 {
+		t.Errorf("Expected revision 4 of set, got %d", revision)
+	}
+	if revision := restored.Revision("str"); revision != 3 {
+		t.Errorf("Expected revision 3 of str, got %d", revision)
+	}
+	if atMs, ok := restored.GetExpire("str"); !ok || atMs != deadline {
+		t.Errorf("Expected deadline %d, got %d, %v", deadline, atMs, ok)
+	}
+	if index := restored.AppliedIndex(); index != 4 {
+		t.Errorf("Expected applied index 4, got %d", index)
+	}
+}
+
+func TestStorageEngine_LSM(t *testing.T) {
+	if _, err := storage.NewEngine[protocol.Resp2Value](storage.EngineLSM); err == nil {
+		t.Errorf("Expected error for engine which has to be opened from disk")
+	}
+
+	cfg := watchTestConfig(t)
+	cfg.Storage.Engine = storage.EngineLSM
+	cfg.Storage.Dir = filepath.Join(t.TempDir(), "lsm")
+	logger := config.NewLogger("Test")
+	svc := service.NewStorageService(cfg, logger)
+
+	for _, key := range []string{"user/2", "user/1", "gone"} {
+		if err := svc.Set(key, protocol.Resp2BulkString(key)); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if _, err := svc.SAdd("members", []string{"a", "b"}); err != nil {
+		t.Fatalf("SAdd failed: %v", err)
+	}
+	svc.Expire(protocol.OpPayloadExpire{Key: "user/1", Ms: time.Hour.Milliseconds()})
+	id, err := svc.GrantLease(time.Hour.Milliseconds())
+	if err != nil {
+		t.Fatalf("GrantLease failed: %v", err)
+	}
+	if _, err := svc.AttachLease(id, []string{"user/2"}); err != nil {
+		t.Fatalf("AttachLease failed: %v", err)
+	}
+	if err := svc.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if _, err := os.Stat(cfg.Snapshot.Path); !os.IsNotExist(err) {
+		t.Errorf("Expected no snapshot file, tables replace it, got %v", err)
+	}
+
+	// Written after the flush, recovered from the WAL
+	svc.Del([]string{"gone"})
+	if _, err := svc.SRem("members", []string{"a"}); err != nil {
+		t.Fatalf("SRem failed: %v", err)
+	}
+	if err := svc.Set("user/3", protocol.Resp2BulkString("user/3")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	restored := service.NewStorageService(cfg, logger)
+	kvs, _, _, err := restored.Range("", service.RangeAll, 0)
+	var keys []string
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	if err != nil || !reflect.DeepEqual(keys, []string{"user/1", "user/2", "user/3"}) {
+		t.Errorf("Expected restored string keys in order, got %v, %v", keys, err)
+	}
+	if members, err := restored.SMembers("members"); err != nil || !reflect.DeepEqual(members, []string{"b"}) {
+		t.Errorf("Expected restored set [b], got %v, %v", members, err)
+	}
+	if ttl, err := restored.TTL(protocol.OpPayloadTTL{Key: "user/1"}); err != nil || ttl <= 0 {
+		t.Errorf("Expected restored expiration, got %d, %v", ttl, err)
+	}
+	if info := restored.LeaseTimeToLive(id); info == nil || !reflect.DeepEqual(info.Keys, []string{"user/2"}) {
+		t.Errorf("Expected restored lease with user/2, got %+v", info)
+	}
+	if restored.Revision() != svc.Revision() {
+		t.Errorf("Expected restored revision %d, got %d", svc.Revision(), restored.Revision())
+	}
+}
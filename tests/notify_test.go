@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/service"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpParserConfigCommands(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected protocol.OpPayload
+	}{
+		{[]string{"CONFIG", "get", "Notify-*", "maxmemory"}, protocol.OpPayloadConfigGet{Patterns: []string{"notify-*", "maxmemory"}}},
+		{[]string{"CONFIG", "SET", "NOTIFY-KEYSPACE-EVENTS", "KEA"}, protocol.OpPayloadConfigSet{Parameter: "notify-keyspace-events", Value: "KEA"}},
+	}
+	for _, tt := range tests {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(tt.args...))))
+		op, err := opParser.Parse()
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", tt.args, err)
+			continue
+		}
+		if op.Kind != protocol.CONFIG || !reflect.DeepEqual(op.Payload, tt.expected) {
+			t.Errorf("Parse %v: expected %+v, got %+v", tt.args, tt.expected, op.Payload)
+		}
+	}
+
+	for _, args := range [][]string{{"CONFIG"}, {"CONFIG", "GET"}, {"CONFIG", "SET", "a"}, {"CONFIG", "RESETSTAT"}} {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		if _, err := opParser.Parse(); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_ConfigKeyspaceEvents(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	got := runCommands(t, svc,
+		[]string{"CONFIG", "GET", "notify-keyspace-events"},
+		[]string{"CONFIG", "SET", "notify-keyspace-events", "KEA"},
+		[]string{"CONFIG", "GET", "notify-*"},
+		[]string{"CONFIG", "SET", "notify-keyspace-events", "zK$g"},
+		[]string{"CONFIG", "GET", "notify-keyspace-events"},
+		[]string{"CONFIG", "SET", "notify-keyspace-events", "Kq"},
+		[]string{"CONFIG", "SET", "unknown", "1"},
+		[]string{"CONFIG", "GET", "unknown"},
+	)
+	expected := "*2\r\n$22\r\nnotify-keyspace-events\r\n$0\r\n\r\n" +
+		"+OK\r\n" +
+		"*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\nAKE\r\n" +
+		"+OK\r\n" +
+		"*2\r\n$22\r\nnotify-keyspace-events\r\n$4\r\ng$zK\r\n" +
+		"-ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - Invalid event class character 'q'\r\n" +
+		"-ERR Unknown option or number of arguments for CONFIG SET - 'unknown'\r\n" +
+		"*0\r\n"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+// readMessages reads n pub/sub messages and joins their fields with spaces
+func readMessages(t *testing.T, parser *protocol.Resp2Parser, n int) []string {
+	t.Helper()
+	messages := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		value, err := parser.Parse()
+		if err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		var fields []string
+		for _, v := range value.([]protocol.Resp2Value) {
+			fields = append(fields, string(v.(protocol.Resp2BulkString)))
+		}
+		messages = append(messages, strings.Join(fields, " "))
+	}
+	return messages
+}
+
+func TestRedisService_KeyspaceNotifications(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "notify_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = filepath.Join(tmpDir, "wal.log")
+	cfg.Redis.NotifyKeyspaceEvents = "KEA"
+	logger := config.NewLogger("Test")
+	storageSvc := service.NewStorageService(cfg, logger)
+	svc := service.NewRedisServices(storageSvc, raft.NewNetwork(cfg.Network), cfg, logger)
+
+	conn, parser, done := subscribe(t, svc, "PSUBSCRIBE", "__key*__:*")
+	defer func() {
+		conn.Close()
+		<-done
+	}()
+
+	runCommands(t, svc,
+		[]string{"SET", "k", "v"},
+		[]string{"DEL", "k"},
+		[]string{"SADD", "s", "a"},
+		[]string{"EXPIRE", "s", "100"},
+		[]string{"GET", "s"},
+	)
+	expected := []string{
+		"pmessage __key*__:* __keyspace@0__:k set", "pmessage __key*__:* __keyevent@0__:set k",
+		"pmessage __key*__:* __keyspace@0__:k del", "pmessage __key*__:* __keyevent@0__:del k",
+		"pmessage __key*__:* __keyspace@0__:s sadd", "pmessage __key*__:* __keyevent@0__:sadd s",
+		"pmessage __key*__:* __keyspace@0__:s expire", "pmessage __key*__:* __keyevent@0__:expire s",
+	}
+	if got := readMessages(t, parser, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	// Only key events of the expired class
+	runCommands(t, svc,
+		[]string{"CONFIG", "SET", "notify-keyspace-events", "Ex"},
+		[]string{"SET", "temp", "v", "PX", "1"},
+	)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := storageSvc.ExpireCycle(20); err != nil {
+		t.Fatalf("ExpireCycle failed: %v", err)
+	}
+	expected = []string{"pmessage __key*__:* __keyevent@0__:expired temp"}
+	if got := readMessages(t, parser, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
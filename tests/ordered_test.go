@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft/pb"
+	"main/src/service"
+	"main/src/storage"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func collectKeys(seq func(func(string, int) bool), limit int) []string {
+	keys := []string{}
+	seq(func(key string, _ int) bool {
+		keys = append(keys, key)
+		return limit <= 0 || len(keys) < limit
+	})
+	return keys
+}
+
+func TestOrderedStorage_MatchesSortedKeys(t *testing.T) {
+	store := storage.MakeOrderedStorage[int]()
+	present := make(map[string]bool)
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	check := func() {
+		t.Helper()
+		expected := make([]string, 0, len(present))
+		for key := range present {
+			expected = append(expected, key)
+		}
+		slices.Sort(expected)
+		if got := collectKeys(store.Iterator(), 0); !slices.Equal(got, expected) {
+			t.Fatalf("Expected %d keys in order, got %d", len(expected), len(got))
+		}
+		reversed := slices.Clone(expected)
+		slices.Reverse(reversed)
+		if got := collectKeys(store.Reverse("", ""), 0); !slices.Equal(got, reversed) {
+			t.Fatalf("Expected %d keys in reverse order, got %d", len(reversed), len(got))
+		}
+	}
+
+	// Enough keys for several levels of the tree, deletes exercise borrowing and merging
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 5000; i++ {
+			key := fmt.Sprintf("k%05d", rng.IntN(20000))
+			store.Set(key, i)
+			present[key] = true
+		}
+		check()
+		for i := 0; i < 6000; i++ {
+			key := fmt.Sprintf("k%05d", rng.IntN(20000))
+			store.Delete(key)
+			delete(present, key)
+		}
+		check()
+	}
+	for key := range present {
+		store.Delete(key)
+	}
+	if got := collectKeys(store.Iterator(), 0); len(got) != 0 {
+		t.Errorf("Expected empty storage, got %v", got)
+	}
+}
+
+func TestOrderedStorage_RangeSeekReverse(t *testing.T) {
+	store := storage.MakeOrderedStorage[int]()
+	for i, key := range []string{"a", "ab", "abc", "b", "ba", "c"} {
+		store.Set(key, i)
+	}
+	store.Set("b", 10)
+
+	tests := []struct {
+		name     string
+		seq      func(func(string, int) bool)
+		limit    int
+		expected []string
+	}{
+		{"range", store.Range("ab", "ba"), 0, []string{"ab", "abc", "b"}},
+		{"range without end", store.Range("b", ""), 0, []string{"b", "ba", "c"}},
+		{"range between keys", store.Range("abd", "abz"), 0, []string{}},
+		{"seek", store.Seek("aa"), 2, []string{"ab", "abc"}},
+		{"seek past the end", store.Seek("d"), 0, []string{}},
+		{"reverse", store.Reverse("ab", "ba"), 0, []string{"b", "abc", "ab"}},
+		{"reverse with limit", store.Reverse("", ""), 2, []string{"c", "ba"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collectKeys(tt.seq, tt.limit); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+	if value, _, _ := store.Get("b"); value != 10 {
+		t.Errorf("Expected overwritten value 10, got %d", value)
+	}
+}
+
+func TestStorageEngine_Config(t *testing.T) {
+	if _, err := storage.NewEngine[protocol.Resp2Value]("unknown"); err == nil {
+		t.Errorf("Expected error for unknown engine")
+	}
+
+	cfg := watchTestConfig(t)
+	cfg.Storage.Engine = storage.EngineOrdered
+	logger := config.NewLogger("Test")
+	svc := service.NewStorageService(cfg, logger)
+	client := pb.NewKeyValueClient(startGrpc(t, service.NewKeyValueService(svc, logger).Register))
+	ctx := context.Background()
+
+	for _, key := range []string{"user/3", "user/1", "user/2", "other"} {
+		if _, err := client.Put(ctx, &pb.PutRequest{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	svc.Expire(protocol.OpPayloadExpire{Key: "user/2", Ms: -1})
+	if _, err := svc.SAdd("user/0", []string{"m"}); err != nil {
+		t.Fatalf("SAdd failed: %v", err)
+	}
+
+	resp, err := client.Range(ctx, &pb.RangeRequest{Key: "user/", RangeEnd: "user0", Limit: 1})
+	if err != nil || !reflect.DeepEqual(kvKeys(resp.Kvs), []string{"user/1=user/1"}) || !resp.More {
+		t.Fatalf("Expected first user and more, got %v, %v", resp, err)
+	}
+	resp, err = client.Range(ctx, &pb.RangeRequest{Key: "user/", RangeEnd: service.RangeAll})
+	if err != nil || !reflect.DeepEqual(kvKeys(resp.Kvs), []string{"user/1=user/1", "user/3=user/3"}) || resp.More {
+		t.Fatalf("Expected live string keys, got %v, %v", resp, err)
+	}
+
+	// Snapshot is loaded into the configured engine
+	if err := svc.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	restored := service.NewStorageService(cfg, logger)
+	kvs, _, _, err := restored.Range("", service.RangeAll, 0)
+	if err != nil || len(kvs) != 3 || kvs[0].Key != "other" || kvs[2].Key != "user/3" {
+		t.Errorf("Expected restored keys in order, got %v, %v", kvs, err)
+	}
+	if kind, _ := restored.Type("user/0"); kind != "set" {
+		t.Errorf("Expected restored set, got %s", kind)
+	}
+}
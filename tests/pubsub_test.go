@@ -0,0 +1,312 @@
+package tests
+
+import (
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/service"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpParserPubSubCommands(t *testing.T) {
+	parse := func(args ...string) (*protocol.Op, error) {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		return opParser.Parse()
+	}
+
+	tests := []struct {
+		args     []string
+		kind     protocol.OpType
+		expected protocol.OpPayload
+	}{
+		{[]string{"SUBSCRIBE", "a", "b"}, protocol.SUBSCRIBE, protocol.OpPayloadSubscribe{Channels: []string{"a", "b"}}},
+		{[]string{"PSUBSCRIBE", "n*"}, protocol.PSUBSCRIBE, protocol.OpPayloadSubscribe{Channels: []string{"n*"}}},
+		{[]string{"UNSUBSCRIBE"}, protocol.UNSUBSCRIBE, protocol.OpPayloadUnsubscribe{Channels: []string{}}},
+		{[]string{"PUNSUBSCRIBE", "n*"}, protocol.PUNSUBSCRIBE, protocol.OpPayloadUnsubscribe{Channels: []string{"n*"}}},
+		{[]string{"PUBLISH", "a", "hello"}, protocol.PUBLISH, protocol.OpPayloadPublish{Channel: "a", Message: "hello"}},
+		{[]string{"SSUBSCRIBE", "a"}, protocol.SSUBSCRIBE, protocol.OpPayloadSubscribe{Channels: []string{"a"}}},
+		{[]string{"SUNSUBSCRIBE"}, protocol.SUNSUBSCRIBE, protocol.OpPayloadUnsubscribe{Channels: []string{}}},
+		{[]string{"SPUBLISH", "a", "hello"}, protocol.SPUBLISH, protocol.OpPayloadPublish{Channel: "a", Message: "hello"}},
+	}
+	for _, tt := range tests {
+		op, err := parse(tt.args...)
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", tt.args, err)
+			continue
+		}
+		if op.Kind != tt.kind || !reflect.DeepEqual(op.Payload, tt.expected) {
+			t.Errorf("Parse %v: expected %v %+v, got %v %+v", tt.args, tt.kind, tt.expected, op.Kind, op.Payload)
+		}
+
+		renderParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(nil))
+		data, err := renderParser.Render(op)
+		if err != nil {
+			t.Errorf("Render %v failed: %v", tt.args, err)
+			continue
+		}
+		reparser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(data))
+		again, err := reparser.Parse()
+		if err != nil || again.Kind != tt.kind || !reflect.DeepEqual(again.Payload, tt.expected) {
+			t.Errorf("Re-parse %v: expected %+v, got %+v (%v)", tt.args, tt.expected, again, err)
+		}
+	}
+
+	invalid := [][]string{{"SUBSCRIBE"}, {"PSUBSCRIBE"}, {"SSUBSCRIBE"}, {"PUBLISH", "a"}, {"PUBLISH", "a", "b", "c"}, {"SPUBLISH", "a"}}
+	for _, args := range invalid {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_SubscriberMode(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	got := runCommands(t, svc,
+		[]string{"UNSUBSCRIBE"},
+		[]string{"SUBSCRIBE", "a", "b", "a"},
+		[]string{"PSUBSCRIBE", "n*"},
+		[]string{"GET", "k"},
+		[]string{"PING"},
+		[]string{"UNSUBSCRIBE", "a", "missing"},
+		[]string{"PUNSUBSCRIBE"},
+		[]string{"UNSUBSCRIBE", "b"},
+		[]string{"PING"},
+		[]string{"MULTI"},
+		[]string{"SUBSCRIBE", "a"},
+	)
+	expected := "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:2\r\n" +
+		"*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:3\r\n" +
+		"-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context\r\n" +
+		"*2\r\n$4\r\npong\r\n$0\r\n\r\n" +
+		"*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:2\r\n" +
+		"*3\r\n$11\r\nunsubscribe\r\n$7\r\nmissing\r\n:2\r\n" +
+		"*3\r\n$12\r\npunsubscribe\r\n$2\r\nn*\r\n:1\r\n" +
+		"*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:0\r\n" +
+		"+PONG\r\n" +
+		"+OK\r\n" +
+		"-ERR SUBSCRIBE is not allowed inside MULTI\r\n"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+// subscribe connects a subscriber through a pipe and returns its client side
+func subscribe(t *testing.T, svc *service.RedisService, cmd ...string) (net.Conn, *protocol.Resp2Parser, chan error) {
+	t.Helper()
+	server, clientConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.OnMessage(server)
+		server.Close()
+	}()
+
+	if _, err := clientConn.Write([]byte(respCommand(cmd...))); err != nil {
+		t.Fatalf("Failed to send %v: %v", cmd, err)
+	}
+	parser := protocol.NewResp2Parser(clientConn, 1024*1024)
+	for range cmd[1:] {
+		if _, err := parser.Parse(); err != nil {
+			t.Fatalf("Failed to read %v reply: %v", cmd, err)
+		}
+	}
+	return clientConn, parser, done
+}
+
+func TestRedisService_PublishSubscribe(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	conn, parser, done := subscribe(t, svc, "SUBSCRIBE", "news", "sport")
+	pconn, pparser, pdone := subscribe(t, svc, "PSUBSCRIBE", "n*", "h?llo", "h[ae]llo", "h[^e]llo", "h\\*llo")
+
+	publish := []struct {
+		channel  string
+		expected string
+	}{
+		{"news", ":2\r\n"},
+		{"sport", ":1\r\n"},
+		{"hello", ":2\r\n"},
+		{"h*llo", ":3\r\n"},
+		{"weather", ":0\r\n"},
+	}
+	for _, p := range publish {
+		if got := runCommands(t, svc, []string{"PUBLISH", p.channel, "msg-" + p.channel}); got != p.expected {
+			t.Errorf("PUBLISH %s: expected %q, got %q", p.channel, p.expected, got)
+		}
+	}
+
+	read := func(parser *protocol.Resp2Parser) []string {
+		value, err := parser.Parse()
+		if err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		var fields []string
+		for _, v := range value.([]protocol.Resp2Value) {
+			fields = append(fields, string(v.(protocol.Resp2BulkString)))
+		}
+		return fields
+	}
+	for _, expected := range [][]string{{"message", "news", "msg-news"}, {"message", "sport", "msg-sport"}} {
+		if got := read(parser); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+	}
+	// Patterns matching the same channel are delivered in any order
+	var pmessages []string
+	for i := 0; i < 6; i++ {
+		pmessages = append(pmessages, strings.Join(read(pparser), " "))
+	}
+	for _, expected := range []string{"pmessage n* news msg-news", "pmessage h?llo hello msg-hello",
+		"pmessage h[ae]llo hello msg-hello", "pmessage h?llo h*llo msg-h*llo", "pmessage h[^e]llo h*llo msg-h*llo",
+		"pmessage h\\*llo h*llo msg-h*llo"} {
+		found := false
+		for _, m := range pmessages {
+			found = found || m == expected
+		}
+		if !found {
+			t.Errorf("Expected %q among %v", expected, pmessages)
+		}
+	}
+
+	conn.Close()
+	pconn.Close()
+	<-done
+	<-pdone
+	// Closed connections are removed from the registry
+	if got := runCommands(t, svc, []string{"PUBLISH", "news", "x"}); got != ":0\r\n" {
+		t.Errorf("Expected no subscribers, got %q", got)
+	}
+}
+
+func TestRedisService_SlowSubscriberDisconnected(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pubsub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = filepath.Join(tmpDir, "wal.log")
+	cfg.Redis.PubSubOutputLimit = 1024
+	logger := config.NewLogger("Test")
+	svc := service.NewRedisServices(service.NewStorageService(cfg, logger), raft.NewNetwork(cfg.Network), cfg, logger)
+
+	// Subscriber never reads messages
+	conn, _, done := subscribe(t, svc, "SUBSCRIBE", "news")
+	defer conn.Close()
+
+	start := time.Now()
+	message := strings.Repeat("x", 100)
+	for i := 0; i < 50; i++ {
+		runCommands(t, svc, []string{"PUBLISH", "news", message})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Publishers were blocked by slow subscriber for %v", elapsed)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected slow subscriber to be disconnected with an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Slow subscriber was not disconnected")
+	}
+	if got := runCommands(t, svc, []string{"PUBLISH", "news", "x"}); got != ":0\r\n" {
+		t.Errorf("Expected disconnected subscriber to be removed, got %q", got)
+	}
+}
+
+func TestRedisService_ShardedPubSub(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	got := runCommands(t, svc,
+		[]string{"SSUBSCRIBE", "{user}a", "{user}b"},
+		[]string{"SUBSCRIBE", "c"},
+		[]string{"SSUBSCRIBE", "x", "y"},
+		[]string{"SUNSUBSCRIBE"},
+		[]string{"SUNSUBSCRIBE"},
+	)
+	expected := "*3\r\n$10\r\nssubscribe\r\n$7\r\n{user}a\r\n:1\r\n" +
+		"*3\r\n$10\r\nssubscribe\r\n$7\r\n{user}b\r\n:2\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\nc\r\n:1\r\n" +
+		"-CROSSSLOT Keys in request don't hash to the same slot\r\n"
+	// Order of channels unsubscribed at once is not defined
+	if !strings.HasPrefix(got, expected) {
+		t.Fatalf("expected prefix %q, got %q", expected, got)
+	}
+	rest := got[len(expected):]
+	for _, reply := range []string{"*3\r\n$12\r\nsunsubscribe\r\n$7\r\n{user}a\r\n", "*3\r\n$12\r\nsunsubscribe\r\n$7\r\n{user}b\r\n"} {
+		if !strings.Contains(rest, reply) {
+			t.Errorf("expected %q in %q", reply, rest)
+		}
+	}
+	if !strings.HasSuffix(rest, ":0\r\n*3\r\n$12\r\nsunsubscribe\r\n$-1\r\n:0\r\n") {
+		t.Errorf("expected empty SUNSUBSCRIBE at the end of %q", rest)
+	}
+
+	conn, parser, done := subscribe(t, svc, "SSUBSCRIBE", "news")
+	if got := runCommands(t, svc, []string{"SPUBLISH", "news", "hi"}, []string{"PUBLISH", "news", "plain"}); got != ":1\r\n:0\r\n" {
+		t.Errorf("expected shard message delivered to shard subscriber only, got %q", got)
+	}
+	value, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	expectedMessage := []protocol.Resp2Value{protocol.Resp2BulkString("smessage"), protocol.Resp2BulkString("news"), protocol.Resp2BulkString("hi")}
+	if !reflect.DeepEqual(value, expectedMessage) {
+		t.Errorf("expected %v, got %v", expectedMessage, value)
+	}
+	conn.Close()
+	<-done
+}
+
+func TestRedisService_ShardedPubSubMoved(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pubsub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = filepath.Join(tmpDir, "wal.log")
+	cfg.Network.Peers = []config.PeerConfig{
+		cfg.Network.Self,
+		{ID: "other", Address: "localhost:5001", RedisAddress: "localhost:6380"},
+	}
+	logger := config.NewLogger("Test")
+	svc := service.NewRedisServices(service.NewStorageService(cfg, logger), raft.NewNetwork(cfg.Network), cfg, logger)
+
+	// First half of slots is served by self, the second one by the other node
+	local, remote := "bar", "foo"
+	if raft.KeySlot(local) >= raft.SlotCount/2 || raft.KeySlot(remote) < raft.SlotCount/2 {
+		t.Fatalf("unexpected slots %d and %d", raft.KeySlot(local), raft.KeySlot(remote))
+	}
+	got := runCommands(t, svc,
+		[]string{"SPUBLISH", local, "m"},
+		[]string{"SPUBLISH", remote, "m"},
+		[]string{"SSUBSCRIBE", remote},
+		[]string{"SSUBSCRIBE", local},
+	)
+	expected := ":0\r\n" +
+		"-MOVED 12182 localhost:6380\r\n" +
+		"-MOVED 12182 localhost:6380\r\n" +
+		"*3\r\n$10\r\nssubscribe\r\n$3\r\nbar\r\n:1\r\n"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
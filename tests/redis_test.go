@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"main/src/config"
+	"main/src/raft"
 	"main/src/service"
 	"net"
 	"os"
@@ -71,11 +72,34 @@ func setupTestRedis(t *testing.T) (*service.RedisService, string) {
 
 	logger := config.NewLogger("Test")
 	storageSvc := service.NewStorageService(cfg, logger)
-	redisSvc := service.NewRedisServices(storageSvc, cfg, logger)
+	redisSvc := service.NewRedisServices(storageSvc, raft.NewNetwork(cfg.Network), cfg, logger)
 
 	return redisSvc, tmpDir
 }
 
+// respCommand encodes command as RESP2 array of bulk strings like redis-cli does
+func respCommand(args ...string) string {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return cmd
+}
+
+// runCommands sends commands over a single connection and returns concatenated replies
+func runCommands(t testing.TB, svc *service.RedisService, cmds ...[]string) string {
+	t.Helper()
+	input := ""
+	for _, cmd := range cmds {
+		input += respCommand(cmd...)
+	}
+	conn := NewMockConn([]byte(input))
+	if err := svc.OnMessage(conn); err != nil {
+		t.Fatalf("OnMessage failed: %v", err)
+	}
+	return conn.writeBuf.String()
+}
+
 func TestRedisService_SimpleCommands(t *testing.T) {
 	svc, tmpDir := setupTestRedis(t)
 	defer os.RemoveAll(tmpDir)
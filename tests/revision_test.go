@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"context"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/raft/pb"
+	"main/src/service"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestOpParserRevisionCommands(t *testing.T) {
+	parse := func(args ...string) (*protocol.Op, error) {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		return opParser.Parse()
+	}
+
+	tests := []struct {
+		args     []string
+		expected protocol.OpPayload
+	}{
+		{[]string{"GETREV", "k"}, protocol.OpPayloadGetRev{Key: "k"}},
+		{[]string{"SETREV", "k", "7", "v"}, protocol.OpPayloadSetRev{Key: "k", Revision: 7, Value: protocol.Resp2BulkString("v")}},
+		{[]string{"DELREV", "k", "0"}, protocol.OpPayloadDelRev{Key: "k", Revision: 0}},
+	}
+	for _, tt := range tests {
+		op, err := parse(tt.args...)
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(op.Payload, tt.expected) {
+			t.Errorf("Expected %+v, got %+v", tt.expected, op.Payload)
+		}
+		opParser := protocol.MakeOpParser(nil)
+		rendered, err := opParser.Render(op)
+		if err != nil {
+			t.Errorf("Render %v failed: %v", tt.args, err)
+			continue
+		}
+		reparser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(rendered))
+		reparsed, err := reparser.Parse()
+		if err != nil || !reflect.DeepEqual(reparsed, op) {
+			t.Errorf("Expected %+v after rendering, got %+v, %v", op, reparsed, err)
+		}
+	}
+
+	invalid := [][]string{{"GETREV"}, {"SETREV", "k", "1"}, {"SETREV", "k", "-1", "v"}, {"DELREV", "k", "x"}}
+	for _, args := range invalid {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_Revisions(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmds     [][]string
+		expected string
+	}{
+		{
+			"missing key",
+			[][]string{{"GETREV", "k"}, {"SETREV", "k", "1", "v"}, {"DELREV", "k", "0"}},
+			"$-1\r\n$-1\r\n:0\r\n",
+		},
+		{
+			"create with revision 0",
+			[][]string{{"SETREV", "k", "0", "v1"}, {"GETREV", "k"}, {"SETREV", "k", "0", "v2"}},
+			":1\r\n*2\r\n$2\r\nv1\r\n:1\r\n$-1\r\n",
+		},
+		{
+			"compare and swap",
+			[][]string{{"SETREV", "k", "1", "v2"}, {"SETREV", "k", "1", "v3"}, {"GET", "k"}},
+			":2\r\n$-1\r\n$2\r\nv2\r\n",
+		},
+		{
+			"every modification bumps revision",
+			[][]string{{"APPEND", "k", "x"}, {"EXPIRE", "k", "100"}, {"GETREV", "k"}},
+			":3\r\n:1\r\n*2\r\n$3\r\nv2x\r\n:4\r\n",
+		},
+		{
+			"transaction shares revision",
+			[][]string{{"MULTI"}, {"SET", "a", "1"}, {"SET", "b", "2"}, {"GETREV", "a"}, {"EXEC"}, {"GETREV", "b"}},
+			"+OK\r\n+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n*3\r\n+OK\r\n+OK\r\n*2\r\n$1\r\n1\r\n:5\r\n*2\r\n$1\r\n2\r\n:5\r\n",
+		},
+		{
+			"compare and delete",
+			[][]string{{"DELREV", "k", "3"}, {"DELREV", "k", "4"}, {"EXISTS", "k"}},
+			":0\r\n:1\r\n:0\r\n",
+		},
+		{
+			"composite values",
+			[][]string{{"SADD", "s", "m"}, {"GETREV", "s"}, {"SETREV", "s", "7", "v"}, {"GET", "s"}},
+			":1\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n:8\r\n$1\r\nv\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmds...); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStorageService_RevisionsSurviveRestart(t *testing.T) {
+	cfg := watchTestConfig(t)
+	logger := config.NewLogger("Test")
+	svc := service.NewStorageService(cfg, logger)
+	for _, key := range []string{"a", "b", "a"} {
+		if err := svc.Set(key, protocol.Resp2BulkString("v")); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if _, err := svc.SAdd("s", []string{"m"}); err != nil {
+		t.Fatalf("SAdd failed: %v", err)
+	}
+
+	check := func(svc *service.StorageService, expected map[string]uint64) {
+		t.Helper()
+		for key, revision := range expected {
+			if got, _, _, _ := svc.Range(key, "", 0); key != "s" && (len(got) != 1 || got[0].Revision != revision) {
+				t.Errorf("Expected %s at revision %d, got %+v", key, revision, got)
+			}
+			if entry, _, _ := svc.Lookup(key); entry == nil || entry.Revision != revision {
+				t.Errorf("Expected %s at revision %d, got %+v", key, revision, entry)
+			}
+		}
+	}
+
+	// Rebuilt from the WAL
+	restored := service.NewStorageService(cfg, logger)
+	check(restored, map[string]uint64{"a": 3, "b": 2, "s": 4})
+
+	// Loaded from the snapshot
+	if err := restored.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	restored = service.NewStorageService(cfg, logger)
+	check(restored, map[string]uint64{"a": 3, "b": 2, "s": 4})
+	if revision, ok, err := restored.CompareAndSet("b", 2, protocol.Resp2BulkString("w")); err != nil || !ok || revision != 5 {
+		t.Errorf("Expected compare and set at revision 5, got %d, %v, %v", revision, ok, err)
+	}
+}
+
+func TestKeyValueService_ModRevision(t *testing.T) {
+	svc, client := setupKeyValue(t)
+	ctx := context.Background()
+	runCommands(t, svc, []string{"SET", "a", "1"}, []string{"SET", "b", "1"})
+
+	get, err := client.Get(ctx, &pb.GetRequest{Key: "a"})
+	if err != nil || get.Kv.GetModRevision() != 1 || get.Header.Revision != 2 {
+		t.Fatalf("Expected a modified at revision 1 of 2, got %v, %v", get, err)
+	}
+
+	casPut := func(revision uint64) *pb.TxnRequest {
+		return &pb.TxnRequest{
+			Compare: []*pb.Compare{{Key: "a", Target: &pb.Compare_ModRevision{ModRevision: revision}}},
+			Success: []*pb.RequestOp{{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: "a", Value: []byte("2")}}}},
+		}
+	}
+	resp, err := client.Txn(ctx, casPut(1))
+	if err != nil || !resp.Succeeded || resp.Header.Revision != 3 {
+		t.Fatalf("Expected successful compare and swap, got %v, %v", resp, err)
+	}
+	resp, err = client.Txn(ctx, casPut(1))
+	if err != nil || resp.Succeeded {
+		t.Fatalf("Expected stale revision to fail, got %v, %v", resp, err)
+	}
+
+	rng, err := client.Range(ctx, &pb.RangeRequest{Key: "a", RangeEnd: service.RangeAll})
+	if err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	if len(rng.Kvs) != 2 || rng.Kvs[0].ModRevision != 3 || rng.Kvs[1].ModRevision != 2 {
+		t.Errorf("Expected mod revisions 3 and 2, got %v", rng.Kvs)
+	}
+}
+
+func TestRedisService_GetRevStoredNil(t *testing.T) {
+	cfg := watchTestConfig(t)
+	logger := config.NewLogger("Test")
+	storageSvc := service.NewStorageService(cfg, logger)
+	svc := service.NewRedisServices(storageSvc, raft.NewNetwork(cfg.Network), cfg, logger)
+	client := pb.NewKeyValueClient(startGrpc(t, service.NewKeyValueService(storageSvc, logger).Register))
+
+	if err := storageSvc.Set("k", nil); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	// Stored nil value exists, only a missing key is replied as null
+	if got := runCommands(t, svc, []string{"GETREV", "k"}, []string{"GETREV", "missing"}); got != "*2\r\n$0\r\n\r\n:1\r\n$-1\r\n" {
+		t.Errorf("Expected empty value with revision, got %q", got)
+	}
+	get, err := client.Get(context.Background(), &pb.GetRequest{Key: "k"})
+	if err != nil || get.Kv == nil || len(get.Kv.Value) != 0 || get.Kv.ModRevision != 1 {
+		t.Errorf("Expected empty value at revision 1, got %v, %v", get, err)
+	}
+}
@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/service"
+	"main/src/storage"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpParserScriptCommands(t *testing.T) {
+	parse := func(args ...string) (*protocol.Op, error) {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		return opParser.Parse()
+	}
+
+	tests := []struct {
+		args     []string
+		expected protocol.OpPayload
+	}{
+		{[]string{"EVAL", "return 1", "1", "k", "a"}, protocol.OpPayloadEval{Script: "return 1", Keys: []string{"k"}, Args: []string{"a"}}},
+		{[]string{"EVALSHA", "ABC", "0", "a"}, protocol.OpPayloadEvalSha{SHA: "abc", Keys: []string{}, Args: []string{"a"}}},
+		{[]string{"SCRIPT", "load", "return 1"}, protocol.OpPayloadScriptLoad{Script: "return 1"}},
+		{[]string{"SCRIPT", "EXISTS", "a", "b"}, protocol.OpPayloadScriptExists{SHAs: []string{"a", "b"}}},
+		{[]string{"SCRIPT", "FLUSH", "ASYNC"}, protocol.OpPayloadScriptFlush{}},
+	}
+	for _, tt := range tests {
+		op, err := parse(tt.args...)
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(op.Payload, tt.expected) {
+			t.Errorf("Parse %v: expected %+v, got %+v", tt.args, tt.expected, op.Payload)
+		}
+
+		renderParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(nil))
+		data, err := renderParser.Render(op)
+		if err != nil {
+			t.Errorf("Render %v failed: %v", tt.args, err)
+			continue
+		}
+		reparser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(data))
+		again, err := reparser.Parse()
+		if err != nil || !reflect.DeepEqual(again.Payload, tt.expected) {
+			t.Errorf("Re-parse %v: expected %+v, got %+v (%v)", tt.args, tt.expected, again, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"EVAL", "return 1"},
+		{"EVAL", "return 1", "2", "k"},
+		{"EVAL", "return 1", "-1"},
+		{"SCRIPT", "KILL"},
+		{"SCRIPT", "FLUSH", "NOW"},
+	}
+	for _, args := range invalid {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_Scripts(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	incrScript := "local v = redis.call('INCRBY', KEYS[1], ARGV[1]) if v > 10 then redis.call('DEL', KEYS[1]) end return v"
+	tests := []struct {
+		name     string
+		cmd      []string
+		expected string
+	}{
+		{"EVAL returns values", []string{"EVAL", "return {1, 'two', {ok='OK'}, false, 3.9, true}", "0"},
+			"*6\r\n:1\r\n$3\r\ntwo\r\n+OK\r\n$-1\r\n:3\r\n:1\r\n"},
+		{"EVAL array stops at nil", []string{"EVAL", "return {1, nil, 3}", "0"}, "*1\r\n:1\r\n"},
+		{"EVAL calls commands", []string{"EVAL", incrScript, "1", "counter", "6"}, ":6\r\n"},
+		{"EVAL modifies atomically", []string{"EVAL", incrScript, "1", "counter", "6"}, ":12\r\n"},
+		{"key deleted by script", []string{"EXISTS", "counter"}, ":0\r\n"},
+		{"redis.call replies", []string{"EVAL", "return {redis.call('SET', KEYS[1], 'v'), redis.call('GET', KEYS[1]), redis.call('GET', 'missing')}", "1", "k"},
+			"*3\r\n+OK\r\n$1\r\nv\r\n$-1\r\n"},
+		{"lower case commands", []string{"EVAL", "return redis.call('get', 'k')", "0"}, "$1\r\nv\r\n"},
+		{"redis.call error aborts script", []string{"EVAL", "redis.call('SADD', 'k', 'a') return 1", "0"},
+			"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"redis.pcall returns error", []string{"EVAL", "local r = redis.pcall('INCR', 'k') return r.err", "0"},
+			"$43\r\nERR value is not an integer or out of range\r\n"},
+		{"error_reply", []string{"EVAL", "return redis.error_reply('MY error')", "0"}, "-MY error\r\n"},
+		{"status_reply", []string{"EVAL", "return redis.status_reply('FINE')", "0"}, "+FINE\r\n"},
+		{"numbers as arguments", []string{"EVAL", "redis.call('SET', 'n', 42) return redis.call('INCRBY', 'n', 1.0)", "0"}, ":43\r\n"},
+		{"not allowed command", []string{"EVAL", "return redis.pcall('MULTI').err", "0"},
+			"$49\r\nERR This Redis command is not allowed from script\r\n"},
+		{"runtime error", []string{"EVAL", "error('boom')", "0"}, "-ERR Error running script: user_script:1: boom\r\n"},
+		{"no file system access", []string{"EVAL", "return type(io) .. type(os) .. type(dofile)", "0"}, "$9\r\nnilnilnil\r\n"},
+		{"sha1hex", []string{"EVAL", "return redis.sha1hex('')", "0"}, "$40\r\nda39a3ee5e6b4b0d3255bfef95601890afd80709\r\n"},
+		{"SCRIPT LOAD", []string{"SCRIPT", "LOAD", "return ARGV[1]"}, "$40\r\n098e0f0d1448c0a81dafe820f66d460eb09263da\r\n"},
+		{"SCRIPT EXISTS", []string{"SCRIPT", "EXISTS", "098e0f0d1448c0a81dafe820f66d460eb09263da", "ffff"}, "*2\r\n:1\r\n:0\r\n"},
+		{"EVALSHA", []string{"EVALSHA", "098E0F0D1448C0A81DAFE820F66D460EB09263DA", "0", "hello"}, "$5\r\nhello\r\n"},
+		{"SCRIPT FLUSH", []string{"SCRIPT", "FLUSH"}, "+OK\r\n"},
+		{"EVALSHA after flush", []string{"EVALSHA", "098e0f0d1448c0a81dafe820f66d460eb09263da", "0"}, "-NOSCRIPT No matching script. Please use EVAL.\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmd); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("compile error", func(t *testing.T) {
+		got := runCommands(t, svc, []string{"EVAL", "return (", "0"})
+		if !strings.HasPrefix(got, "-ERR Error compiling script") {
+			t.Errorf("expected compile error, got %q", got)
+		}
+	})
+
+	t.Run("EVAL inside MULTI", func(t *testing.T) {
+		got := runCommands(t, svc,
+			[]string{"MULTI"},
+			[]string{"SET", "m", "1"},
+			[]string{"EVAL", "return redis.call('INCR', 'm')", "0"},
+			[]string{"EXEC"},
+		)
+		expected := "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n:2\r\n"
+		if got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+}
+
+func TestRedisService_ScriptTimeLimit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "script_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = filepath.Join(tmpDir, "wal.log")
+	cfg.Redis.ScriptTimeLimit = 50
+	logger := config.NewLogger("Test")
+	svc := service.NewRedisServices(service.NewStorageService(cfg, logger), raft.NewNetwork(cfg.Network), cfg, logger)
+
+	got := runCommands(t, svc,
+		[]string{"EVAL", "redis.call('SET', 'before', '1') while true do end", "0"},
+		[]string{"GET", "before"},
+	)
+	expected := "-ERR Script exceeded time limit of 50 ms\r\n$1\r\n1\r\n"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestScriptEffectsReplication(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+	walPath := filepath.Join(tmpDir, "wal.log")
+
+	runCommands(t, svc, []string{"EVAL",
+		"redis.call('SET', KEYS[1], 'a') redis.call('APPEND', KEYS[1], 'b') redis.call('SADD', KEYS[2], 'm') return 1",
+		"2", "str", "set"})
+
+	wal, err := storage.NewSimpleWal[protocol.Resp2Value](walPath)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	entries, err := wal.Replay()
+	wal.Close()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	// Script is logged as the effects of its commands, in a single record
+	if len(entries) != 1 || entries[0].OpType != protocol.BATCH {
+		t.Fatalf("Expected a single BATCH record, got %+v", entries)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = walPath
+	restored := service.NewStorageService(cfg, config.NewLogger("Test"))
+	if v, _, _ := restored.Get("str"); v != protocol.Resp2BulkString("ab") {
+		t.Errorf("Expected restored string ab, got %v", v)
+	}
+	if ok, _ := restored.SIsMember("set", "m"); !ok {
+		t.Errorf("Expected restored set member")
+	}
+}
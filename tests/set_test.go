@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/service"
+	"main/src/storage"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSetAlgebra(t *testing.T) {
+	a := storage.NewSet("a", "b", "c", "d")
+	b := storage.NewSet("c", "d", "e")
+	c := storage.NewSet("d", "f")
+
+	t.Run("Inter", func(t *testing.T) {
+		got := storage.InterSets(a, b, c).Members()
+		if !reflect.DeepEqual(got, []string{"d"}) {
+			t.Errorf("Expected [d], got %v", got)
+		}
+	})
+
+	t.Run("Inter with missing set", func(t *testing.T) {
+		if got := storage.InterSets(a, nil); len(got) != 0 {
+			t.Errorf("Expected empty set, got %v", got.Members())
+		}
+	})
+
+	t.Run("Union", func(t *testing.T) {
+		got := storage.UnionSets(a, nil, c).Members()
+		want := []string{"a", "b", "c", "d", "f"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		got := storage.DiffSets(a, b, c).Members()
+		want := []string{"a", "b"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Add and Remove counts", func(t *testing.T) {
+		s := storage.NewSet("x")
+		if n := s.Add("x", "y", "y"); n != 1 {
+			t.Errorf("Expected 1 added, got %d", n)
+		}
+		if n := s.Remove("x", "z"); n != 1 {
+			t.Errorf("Expected 1 removed, got %d", n)
+		}
+	})
+}
+
+func TestOpParserSetCommands(t *testing.T) {
+	t.Run("SADD", func(t *testing.T) {
+		parser := protocol.NewResp2ParserFromBytes([]byte(respCommand("SADD", "key", "a", "")))
+		opParser := protocol.MakeOpParser(parser)
+		op, err := opParser.Parse()
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		payload, ok := op.Payload.(protocol.OpPayloadSAdd)
+		if !ok || op.Kind != protocol.SADD {
+			t.Fatalf("Expected SADD, got %v %T", op.Kind, op.Payload)
+		}
+		if payload.Key != "key" || !reflect.DeepEqual(payload.Members, []string{"a", ""}) {
+			t.Errorf("Unexpected payload: %+v", payload)
+		}
+	})
+
+	t.Run("SINTERSTORE", func(t *testing.T) {
+		parser := protocol.NewResp2ParserFromBytes([]byte(respCommand("SINTERSTORE", "dst", "k1", "k2")))
+		opParser := protocol.MakeOpParser(parser)
+		op, err := opParser.Parse()
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		payload := op.Payload.(protocol.OpPayloadSetAlgebraStore)
+		if op.Kind != protocol.SINTERSTORE || payload.Destination != "dst" || !reflect.DeepEqual(payload.Keys, []string{"k1", "k2"}) {
+			t.Errorf("Unexpected op: %v %+v", op.Kind, payload)
+		}
+	})
+
+	t.Run("SADD without members", func(t *testing.T) {
+		parser := protocol.NewResp2ParserFromBytes([]byte(respCommand("SADD", "key")))
+		opParser := protocol.MakeOpParser(parser)
+		if _, err := opParser.Parse(); err == nil {
+			t.Fatalf("Expected error for SADD without members")
+		}
+	})
+
+	t.Run("Render round trip", func(t *testing.T) {
+		op := &protocol.Op{Kind: protocol.SREM, Payload: protocol.OpPayloadSRem{Key: "k", Members: []string{"m1", "m2"}}}
+		renderParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(nil))
+		data, err := renderParser.Render(op)
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(data))
+		parsed, err := opParser.Parse()
+		if err != nil {
+			t.Fatalf("Re-parse failed: %v", err)
+		}
+		if !reflect.DeepEqual(parsed, op) {
+			t.Errorf("Expected %+v, got %+v", op, parsed)
+		}
+	})
+}
+
+func TestRedisService_SetCommands(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmd      []string
+		expected string
+	}{
+		{"SADD new", []string{"SADD", "s1", "a", "b", "c"}, ":3\r\n"},
+		{"SADD existing", []string{"SADD", "s1", "a", "d"}, ":1\r\n"},
+		{"SCARD", []string{"SCARD", "s1"}, ":4\r\n"},
+		{"SMEMBERS", []string{"SMEMBERS", "s1"}, "*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n"},
+		{"SISMEMBER yes", []string{"SISMEMBER", "s1", "a"}, ":1\r\n"},
+		{"SISMEMBER no", []string{"SISMEMBER", "s1", "z"}, ":0\r\n"},
+		{"SREM", []string{"SREM", "s1", "d", "z"}, ":1\r\n"},
+		{"SADD second", []string{"SADD", "s2", "b", "c", "x"}, ":3\r\n"},
+		{"SINTER", []string{"SINTER", "s1", "s2"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"SUNION", []string{"SUNION", "s1", "s2"}, "*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nx\r\n"},
+		{"SDIFF", []string{"SDIFF", "s1", "s2"}, "*1\r\n$1\r\na\r\n"},
+		{"SDIFF missing first", []string{"SDIFF", "missing", "s2"}, "*0\r\n"},
+		{"SUNIONSTORE", []string{"SUNIONSTORE", "dst", "s1", "s2"}, ":4\r\n"},
+		{"SMEMBERS stored", []string{"SMEMBERS", "dst"}, "*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nx\r\n"},
+		{"SINTERSTORE empty deletes", []string{"SINTERSTORE", "dst", "s1", "missing"}, ":0\r\n"},
+		{"dst deleted", []string{"GET", "dst"}, "$-1\r\n"},
+		{"SREM all deletes key", []string{"SREM", "s2", "b", "c", "x"}, ":3\r\n"},
+		{"SCARD deleted", []string{"SCARD", "s2"}, ":0\r\n"},
+		{"SET string", []string{"SET", "str", "v"}, "+OK\r\n"},
+		{"SADD wrong type", []string{"SADD", "str", "a"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"SINTER wrong type", []string{"SINTER", "s1", "str"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmd); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSetPersistence(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "set_persistence_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = filepath.Join(tmpDir, "wal.log")
+	logger := config.NewLogger("Test")
+
+	svc := service.NewStorageService(cfg, logger)
+	svc.SAdd("s1", []string{"a", "b", "c"})
+	svc.SAdd("s2", []string{"b", "c", "d"})
+	svc.SetAlgebraStore(protocol.SINTERSTORE, "inter", []string{"s1", "s2"})
+
+	// Half of the state goes to the snapshot, the rest stays in WAL
+	if err := svc.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	svc.SRem("s1", []string{"a"})
+	svc.SAdd("s3", []string{"z"})
+
+	restored := service.NewStorageService(cfg, logger)
+
+	expected := map[string][]string{
+		"s1":    {"b", "c"},
+		"s2":    {"b", "c", "d"},
+		"s3":    {"z"},
+		"inter": {"b", "c"},
+	}
+	for key, want := range expected {
+		got, err := restored.SMembers(key)
+		if err != nil {
+			t.Fatalf("SMembers(%s) failed: %v", key, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", key, want, got)
+		}
+	}
+}
+
+func TestSetAlgebraStore_DiscardsExpiration(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(t.TempDir(), "snapshot.db")
+	cfg.WAL.Path = filepath.Join(t.TempDir(), "wal.log")
+	logger := config.NewLogger("Test")
+	svc := service.NewRedisServices(service.NewStorageService(cfg, logger), raft.NewNetwork(cfg.Network), cfg, logger)
+
+	// Whole-value overwrites drop the expiration of the destination like SET does
+	got := runCommands(t, svc,
+		[]string{"SADD", "t", "x"}, []string{"EXPIRE", "t", "100"}, []string{"SADD", "u", "y"},
+		[]string{"SUNIONSTORE", "t", "u"}, []string{"TTL", "t"},
+		[]string{"SET", "c", "v"}, []string{"EXPIRE", "c", "100"}, []string{"COPY", "u", "c", "REPLACE"}, []string{"TTL", "c"})
+	expected := ":1\r\n:1\r\n:1\r\n:1\r\n:-1\r\n+OK\r\n:1\r\n:1\r\n:-1\r\n"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	restored := service.NewRedisServices(service.NewStorageService(cfg, logger), raft.NewNetwork(cfg.Network), cfg, logger)
+	if got := runCommands(t, restored, []string{"TTL", "t"}, []string{"TTL", "c"}, []string{"SMEMBERS", "t"}); got != ":-1\r\n:-1\r\n*1\r\n$1\r\ny\r\n" {
+		t.Errorf("Expected expiration discarded after restart, got %q", got)
+	}
+}
@@ -0,0 +1,272 @@
+package tests
+
+import (
+	"fmt"
+	"main/src"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/service"
+	"main/src/storage"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedStorage(t *testing.T) {
+	RunStorageTests(t, storage.MakeShardedStorage[string](8))
+}
+
+func TestShardedStorage_Concurrent(t *testing.T) {
+	store := storage.MakeShardedStorage[int](8)
+	store.GrantLease(1, 60000, time.Now().Add(time.Hour).UnixMilli())
+	deadline := time.Now().Add(time.Hour).UnixMilli()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("w%d-%d", w, i)
+				store.Set(key, i)
+				store.SetRevision(key, uint64(i+1))
+				store.SetAppliedIndex(uint64(w*1000 + i))
+				if i%2 == 0 {
+					store.SetExpire(key, deadline)
+				}
+				if i%5 == 0 {
+					if err := store.AttachLease(1, key); err != nil {
+						t.Errorf("AttachLease failed: %v", err)
+					}
+				}
+				if i%3 == 0 {
+					store.Delete(key)
+				}
+			}
+		}(w)
+	}
+	// Whole keyspace readers run alongside the writers
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				store.Iterator()(func(string, int) bool { return true })
+				store.SampleExpires(20)
+				store.Leases()
+			}
+		}()
+	}
+	wg.Wait()
+
+	count := 0
+	store.Iterator()(func(key string, value int) bool {
+		count++
+		return true
+	})
+	// Every third key of 500 per writer was deleted
+	if expected := 8 * (500 - 167); count != expected {
+		t.Errorf("Expected %d keys, got %d", expected, count)
+	}
+	if index := store.AppliedIndex(); index != 7499 {
+		t.Errorf("Expected the highest applied index 7499, got %d", index)
+	}
+	// Keys attached to the lease and not deleted, i%5 == 0 && i%3 != 0
+	if lease := store.GetLease(1); lease == nil {
+		t.Errorf("Expected lease 1 to exist")
+	} else if len(lease.Keys) != 8*66 {
+		t.Errorf("Expected %d keys of the lease, got %d", 8*66, len(lease.Keys))
+	}
+	if atMs, ok := store.GetExpire("w0-2"); !ok || atMs != deadline {
+		t.Errorf("Expected deadline of w0-2, got %d, %v", atMs, ok)
+	}
+	if _, ok := store.GetExpire("w0-0"); ok {
+		t.Errorf("Expected deleted key to lose its deadline")
+	}
+}
+
+func shardedTestService(cfg *config.Config) *service.StorageService {
+	cfg.Storage.Engine = storage.EngineSharded
+	return service.NewStorageService(cfg, config.NewLogger("Test"))
+}
+
+func TestStorageService_ShardedParallelCommands(t *testing.T) {
+	cfg := watchTestConfig(t)
+	svc := shardedTestService(cfg)
+
+	const workers, rounds = 16, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			own := fmt.Sprintf("own-%d", w)
+			for i := 0; i < rounds; i++ {
+				// Counters shared by all workers must not lose increments
+				if _, err := svc.IncrBy("shared", 1); err != nil {
+					t.Errorf("IncrBy failed: %v", err)
+					return
+				}
+				if _, err := svc.IncrBy(own, 1); err != nil {
+					t.Errorf("IncrBy failed: %v", err)
+					return
+				}
+				// Multi-key commands lock overlapping keys in different order
+				keys := []string{fmt.Sprintf("m-%d", (w+i)%4), fmt.Sprintf("m-%d", (w+i+1)%4)}
+				if w%2 == 0 {
+					keys[0], keys[1] = keys[1], keys[0]
+				}
+				if _, err := svc.MSet(protocol.OpPayloadMSet{Keys: keys, Values: []protocol.Resp2Value{protocol.Resp2BulkString("a"), protocol.Resp2BulkString("b")}}); err != nil {
+					t.Errorf("MSet failed: %v", err)
+					return
+				}
+				if _, err := svc.Copy(protocol.OpPayloadCopy{Source: keys[0], Destination: fmt.Sprintf("c-%d", w), Replace: true}); err != nil {
+					t.Errorf("Copy failed: %v", err)
+					return
+				}
+				if _, err := svc.SAdd("set", []string{fmt.Sprintf("%d-%d", w, i)}); err != nil {
+					t.Errorf("SAdd failed: %v", err)
+					return
+				}
+				if _, err := svc.SMembers("set"); err != nil {
+					t.Errorf("SMembers failed: %v", err)
+					return
+				}
+				// Transactions exclude every other command
+				if i%10 == 0 {
+					svc.Transaction(nil, func(tx *service.StorageService) {
+						tx.IncrBy("tx", 1)
+						tx.IncrBy("shared", 1)
+					})
+				}
+				svc.Keys("*")
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	counter := func(svc *service.StorageService, key string) int {
+		value, _, err := svc.Get(key)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", key, err)
+		}
+		bulk, _ := value.(protocol.Resp2BulkString)
+		n, _ := strconv.Atoi(string(bulk))
+		return n
+	}
+	if n := counter(svc, "shared"); n != workers*rounds+workers*rounds/10 {
+		t.Errorf("Expected shared counter %d, got %d", workers*rounds+workers*rounds/10, n)
+	}
+	if n := counter(svc, "own-3"); n != rounds {
+		t.Errorf("Expected own counter %d, got %d", rounds, n)
+	}
+	if n, err := svc.SCard("set"); err != nil || n != workers*rounds {
+		t.Errorf("Expected %d set members, got %d, %v", workers*rounds, n, err)
+	}
+
+	// Entries applied out of order still replay to the same state
+	restored := service.NewStorageService(cfg, config.NewLogger("Test"))
+	kvs, _, revision, err := svc.Range("", service.RangeAll, 0)
+	if err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	restoredKvs, _, restoredRevision, err := restored.Range("", service.RangeAll, 0)
+	if err != nil || !reflect.DeepEqual(restoredKvs, kvs) || restoredRevision != revision {
+		t.Errorf("Expected restored state at revision %d, got %d keys at %d, %v", revision, len(restoredKvs), restoredRevision, err)
+	}
+	if restored.DBSize() != svc.DBSize() {
+		t.Errorf("Expected %d restored keys, got %d", svc.DBSize(), restored.DBSize())
+	}
+}
+
+// benchmarkWorkerPool sends pipelined commands over many connections processed
+// by the worker pool like TcpServiceManager does, most of them reads of distinct keys.
+func benchmarkWorkerPool(b *testing.B, engine string, connections int) {
+	dir := b.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = dir + "/snapshot.db"
+	cfg.WAL.Path = dir + "/wal.log"
+	cfg.Storage.Engine = engine
+	logger := config.NewLogger("Bench")
+	level, _ := config.ParseLevel("ERROR")
+	logger.SetLevel(level)
+	svc := service.NewRedisServices(service.NewStorageService(cfg, logger), raft.NewNetwork(cfg.Network), cfg, logger)
+
+	const keys, pipeline = 1024, 20
+	for i := 0; i < keys; i++ {
+		runCommands(b, svc, []string{"SET", fmt.Sprintf("key-%d", i), "value"})
+	}
+	inputs := make([][]byte, connections)
+	for c := range inputs {
+		input := ""
+		for i := 0; i < pipeline; i++ {
+			key := fmt.Sprintf("key-%d", (c*pipeline+i)%keys)
+			if i == 0 {
+				input += respCommand("SET", key, "value")
+				continue
+			}
+			input += respCommand("GET", key)
+		}
+		inputs[c] = []byte(input)
+	}
+
+	var done sync.WaitGroup
+	pool := src.NewWorkerPool(int64(connections), int64(connections), 1, time.Second, 0, func(conn net.Conn) error {
+		defer done.Done()
+		return svc.OnMessage(conn)
+	}, false, logger)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		done.Add(connections)
+		for c := 0; c < connections; c++ {
+			for pool.Put(NewMockConn(inputs[c])) != nil {
+				time.Sleep(time.Microsecond)
+			}
+		}
+		done.Wait()
+	}
+	b.ReportMetric(float64(b.N*connections*pipeline)/b.Elapsed().Seconds(), "cmds/s")
+}
+
+func BenchmarkRedisService_WorkerPool(b *testing.B) {
+	for _, engine := range []string{storage.EngineHash, storage.EngineSharded} {
+		for _, connections := range []int{1, 16, 128} {
+			b.Run(fmt.Sprintf("%s/%d", engine, connections), func(b *testing.B) {
+				benchmarkWorkerPool(b, engine, connections)
+			})
+		}
+	}
+}
+
+// BenchmarkStorageService_ParallelReads measures lock contention alone, reads never touch the WAL
+func BenchmarkStorageService_ParallelReads(b *testing.B) {
+	for _, engine := range []string{storage.EngineHash, storage.EngineSharded} {
+		b.Run(engine, func(b *testing.B) {
+			dir := b.TempDir()
+			cfg := config.DefaultConfig()
+			cfg.Snapshot.Path = dir + "/snapshot.db"
+			cfg.WAL.Path = dir + "/wal.log"
+			cfg.Storage.Engine = engine
+			svc := service.NewStorageService(cfg, config.NewLogger("Bench"))
+			const keys = 1024
+			for i := 0; i < keys; i++ {
+				svc.Set(fmt.Sprintf("key-%d", i), protocol.Resp2BulkString("value"))
+			}
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					svc.Get(fmt.Sprintf("key-%d", i%keys))
+					i++
+				}
+			})
+		})
+	}
+}
@@ -19,6 +19,7 @@ func RunSnapshotterTest_SnapshotAndLoad(t *testing.T,
 	wal.Append(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.SET, Key: "key1", Value: protocol.Resp2SimpleString("val1")}, true)
 	wal.Append(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.SET, Key: "key2", Value: protocol.Resp2SimpleString("val2")}, true)
 	wal.Append(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.DELETE, Key: "key1"}, true)
+	wal.Append(storage.WalEntry[protocol.Resp2Value]{OpType: protocol.SET, Key: "nil", Value: nil}, true)
 
 	snapper, cleanupSnap := createSnapshotter()
 	defer cleanupSnap()
@@ -42,10 +43,18 @@ func RunSnapshotterTest_SnapshotAndLoad(t *testing.T,
 	}
 
 	// key2 should exist
-	val, _ := store.Get("key2")
+	val, _, _ := store.Get("key2")
 	if val != protocol.Resp2SimpleString("val2") {
 		t.Errorf("Expected key2=val2, got %s", val)
 	}
+
+	// Stored nil survives the snapshot and is told apart from the deleted key
+	if val, found, err := store.Get("nil"); err != nil || !found || val != nil {
+		t.Errorf("Expected stored nil to be found, got %v, %v, %v", val, found, err)
+	}
+	if _, found, _ := store.Get("key1"); found {
+		t.Error("key1 should not be found")
+	}
 }
 
 func RunSnapshotterTest_IncrementalSnapshot(t *testing.T,
@@ -85,7 +94,7 @@ func RunSnapshotterTest_IncrementalSnapshot(t *testing.T,
 	if exists, _ := store.Exists("base"); exists {
 		t.Error("base key should be deleted")
 	}
-	if val, _ := store.Get("new"); val != protocol.Resp2SimpleString("stuff") {
+	if val, _, _ := store.Get("new"); val != protocol.Resp2SimpleString("stuff") {
 		t.Errorf("Expected new=stuff, got %s", val)
 	}
 }
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"fmt"
+	"main/src/protocol"
 	"main/src/storage"
 	"testing"
 )
@@ -19,12 +20,12 @@ func RunStorageTests(t *testing.T, s storage.Storage[string]) {
 			t.Fatalf("Set failed: %v", err)
 		}
 
-		retrievedValue, err := s.Get(key)
+		retrievedValue, found, err := s.Get(key)
 		if err != nil {
 			t.Fatalf("Get failed: %v", err)
 		}
-		if retrievedValue != value {
-			t.Errorf("Expected %s, got %s", value, retrievedValue)
+		if !found || retrievedValue != value {
+			t.Errorf("Expected %s, got %s, %v", value, retrievedValue, found)
 		}
 	})
 
@@ -79,12 +80,28 @@ func RunStorageTests(t *testing.T, s storage.Storage[string]) {
 	t.Run("Get non-existing key", func(t *testing.T) {
 		key := "nonExistingKey"
 
-		value, err := s.Get(key)
+		value, found, err := s.Get(key)
 		if err != nil {
 			t.Fatalf("Get failed: %v", err)
 		}
-		if value != "" {
-			t.Errorf("Expected empty value for non-existing key, got %s", value)
+		if found || value != "" {
+			t.Errorf("Expected non-existing key to be not found, got %q, %v", value, found)
+		}
+	})
+
+	t.Run("Get zero value", func(t *testing.T) {
+		key := "emptyKey"
+		if err := s.Set(key, ""); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		// Stored zero value is found, unlike a missing key
+		value, found, err := s.Get(key)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if !found || value != "" {
+			t.Errorf("Expected stored empty value to be found, got %q, %v", value, found)
 		}
 	})
 
@@ -102,11 +119,11 @@ func RunStorageTests(t *testing.T, s storage.Storage[string]) {
 			key := fmt.Sprintf("key-%d", i)
 			value := fmt.Sprintf("value-%d", i)
 
-			retrievedValue, err := s.Get(key)
+			retrievedValue, found, err := s.Get(key)
 			if err != nil {
 				t.Fatalf("Get failed: %v", err)
 			}
-			if retrievedValue != value {
+			if !found || retrievedValue != value {
 				t.Errorf("Expected %s, got %s", value, retrievedValue)
 			}
 		}
@@ -117,3 +134,56 @@ func TestInMemoryStorage(t *testing.T) {
 	storage := storage.MakeInMemoryStorage[string]()
 	RunStorageTests(t, storage)
 }
+
+func TestOrderedStorage(t *testing.T) {
+	storage := storage.MakeOrderedStorage[string]()
+	RunStorageTests(t, storage)
+}
+
+// Stored nil and a missing key are different for every engine, also when applied from the log
+func TestStorage_GetNotFound(t *testing.T) {
+	lsm := openTestLSM[protocol.Resp2Value](t, t.TempDir())
+	stores := map[string]storage.Storage[protocol.Resp2Value]{
+		"hash":    storage.MakeInMemoryStorage[protocol.Resp2Value](),
+		"ordered": storage.MakeOrderedStorage[protocol.Resp2Value](),
+		"sharded": storage.MakeShardedStorage[protocol.Resp2Value](4),
+		"lsm":     lsm,
+		"tiered":  makeTestTiered[protocol.Resp2Value](t),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.Apply(store, storage.WalEntry[protocol.Resp2Value]{Index: 1, OpType: protocol.SET, Key: "nil", Value: nil}); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			if value, found, err := store.Get("nil"); err != nil || !found || value != nil {
+				t.Errorf("Expected stored nil to be found, got %v, %v, %v", value, found, err)
+			}
+			if value, found, err := store.Get("missing"); err != nil || found || value != nil {
+				t.Errorf("Expected missing key to be not found, got %v, %v, %v", value, found, err)
+			}
+			// Copy of a stored nil is a value too
+			if err := storage.Apply(store, storage.WalEntry[protocol.Resp2Value]{Index: 2, OpType: protocol.COPY, Key: "copy", Value: protocol.Resp2BulkString("nil")}); err != nil {
+				t.Fatalf("Apply COPY failed: %v", err)
+			}
+			if _, found, _ := store.Get("copy"); !found {
+				t.Errorf("Expected copy of stored nil to be found")
+			}
+			if err := storage.Apply(store, storage.WalEntry[protocol.Resp2Value]{Index: 3, OpType: protocol.DELETE, Key: "nil"}); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			if _, found, _ := store.Get("nil"); found {
+				t.Errorf("Expected deleted key to be not found")
+			}
+		})
+	}
+
+	// Typed storages return zero values too
+	ints := storage.MakeInMemoryStorage[int]()
+	ints.Set("zero", 0)
+	if value, found, _ := ints.Get("zero"); !found || value != 0 {
+		t.Errorf("Expected stored 0 to be found, got %d, %v", value, found)
+	}
+	if _, found, _ := ints.Get("missing"); found {
+		t.Errorf("Expected missing key to be not found")
+	}
+}
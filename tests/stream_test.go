@@ -0,0 +1,274 @@
+package tests
+
+import (
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/service"
+	"main/src/storage"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func appendEntries(t *testing.T, s *storage.Stream, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		id := protocol.StreamID{Ms: uint64(i)}
+		if err := s.Append(storage.StreamEntry{ID: id, Fields: []string{"n", strconv.Itoa(i)}}); err != nil {
+			t.Fatalf("Append %v failed: %v", id, err)
+		}
+	}
+}
+
+func TestStreamRangeAndTrim(t *testing.T) {
+	s := storage.NewStream()
+	appendEntries(t, s, 300)
+
+	if err := s.Append(storage.StreamEntry{ID: protocol.StreamID{Ms: 300}}); err == nil {
+		t.Errorf("Expected error appending non increasing ID")
+	}
+
+	got := s.Range(protocol.StreamID{Ms: 127}, protocol.StreamID{Ms: 130}, -1, false)
+	if len(got) != 4 || got[0].ID.Ms != 127 || got[3].ID.Ms != 130 {
+		t.Errorf("Unexpected range across segments: %v", got)
+	}
+	got = s.Range(protocol.MinStreamID, protocol.MaxStreamID, 2, true)
+	if len(got) != 2 || got[0].ID.Ms != 300 || got[1].ID.Ms != 299 {
+		t.Errorf("Unexpected reverse range: %v", got)
+	}
+
+	// Approximate trim removes only whole segments
+	removed := s.Trim(protocol.StreamTrim{MaxLen: 100, Approx: true})
+	if removed != 128 || s.Len() != 172 {
+		t.Errorf("Expected 128 removed and 172 left, got %d and %d", removed, s.Len())
+	}
+	removed = s.Trim(protocol.StreamTrim{MaxLen: 100})
+	if removed != 72 || s.Len() != 100 {
+		t.Errorf("Expected 72 removed and 100 left, got %d and %d", removed, s.Len())
+	}
+	removed = s.Trim(protocol.StreamTrim{MinID: true, Threshold: protocol.StreamID{Ms: 250}})
+	if removed != 49 || s.Entries()[0].ID.Ms != 250 {
+		t.Errorf("Unexpected MINID trim result: removed %d, first %v", removed, s.Entries()[0].ID)
+	}
+	if s.LastID() != (protocol.StreamID{Ms: 300}) {
+		t.Errorf("Trim must not change last ID, got %v", s.LastID())
+	}
+}
+
+func TestStreamNextID(t *testing.T) {
+	s := storage.NewStream()
+	appendEntries(t, s, 5)
+
+	id, err := s.NextID(protocol.OpPayloadXAdd{AutoID: true}, 3)
+	if err != nil || id != (protocol.StreamID{Ms: 5, Seq: 1}) {
+		t.Errorf("Expected 5-1 when clock is behind, got %v %v", id, err)
+	}
+	id, err = s.NextID(protocol.OpPayloadXAdd{AutoSeq: true, ID: protocol.StreamID{Ms: 5}}, 0)
+	if err != nil || id != (protocol.StreamID{Ms: 5, Seq: 1}) {
+		t.Errorf("Expected 5-1, got %v %v", id, err)
+	}
+	if _, err := s.NextID(protocol.OpPayloadXAdd{ID: protocol.StreamID{Ms: 4}}, 0); err != storage.ErrStreamIDTooSmall {
+		t.Errorf("Expected ErrStreamIDTooSmall, got %v", err)
+	}
+	if _, err := storage.NewStream().NextID(protocol.OpPayloadXAdd{}, 0); err != storage.ErrStreamIDZero {
+		t.Errorf("Expected ErrStreamIDZero, got %v", err)
+	}
+}
+
+func TestOpParserStreamCommands(t *testing.T) {
+	valid := [][]string{
+		{"XADD", "s", "NOMKSTREAM", "MAXLEN", "~", "10", "*", "f", "v"},
+		{"XADD", "s", "MINID", "5-0", "7-*", "f", "v"},
+		{"XREVRANGE", "s", "+", "(1-0", "COUNT", "3"},
+		{"XREAD", "COUNT", "2", "BLOCK", "0", "STREAMS", "a", "b", "$", "0-0"},
+		{"XREADGROUP", "GROUP", "g", "c", "NOACK", "STREAMS", "s", ">"},
+		{"XGROUP", "CREATE", "s", "g", "$", "MKSTREAM"},
+		{"XPENDING", "s", "g", "IDLE", "10", "-", "+", "5", "c"},
+		{"XCLAIM", "s", "g", "c", "100", "1-0", "2-0", "JUSTID"},
+	}
+	for _, args := range valid {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		op, err := opParser.Parse()
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", args, err)
+			continue
+		}
+
+		// Render parses back to the same payload
+		data, err := opParser.Render(op)
+		if err != nil {
+			t.Errorf("Render %v failed: %v", args, err)
+			continue
+		}
+		reparser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(data))
+		again, err := reparser.Parse()
+		if err != nil {
+			t.Errorf("Re-parse %v failed: %v", args, err)
+			continue
+		}
+		if !reflect.DeepEqual(op, again) {
+			t.Errorf("Expected %+v, got %+v", op, again)
+		}
+	}
+
+	invalid := [][]string{
+		{"XADD", "s", "*", "f"},
+		{"XADD", "s", "1-x", "f", "v"},
+		{"XREAD", "STREAMS", "a", "b", "0"},
+		{"XREAD", "STREAMS", "a", ">"},
+		{"XGROUP", "CREATE", "s", "g"},
+		{"XRANGE", "s", "+", "-", "COUNT"},
+	}
+	for _, args := range invalid {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		if _, err := opParser.Parse(); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_StreamCommands(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmd      []string
+		expected string
+	}{
+		{"XADD", []string{"XADD", "s", "1-1", "name", "a"}, "$3\r\n1-1\r\n"},
+		{"XADD auto seq", []string{"XADD", "s", "1-*", "name", "b"}, "$3\r\n1-2\r\n"},
+		{"XADD explicit", []string{"XADD", "s", "2-0", "name", "c", "age", "3"}, "$3\r\n2-0\r\n"},
+		{"XADD too small", []string{"XADD", "s", "2-0", "name", "d"}, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{"XADD NOMKSTREAM", []string{"XADD", "missing", "NOMKSTREAM", "*", "f", "v"}, "$-1\r\n"},
+		{"XLEN", []string{"XLEN", "s"}, ":3\r\n"},
+		{"XRANGE", []string{"XRANGE", "s", "1", "(2-0", "COUNT", "5"}, "*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$4\r\nname\r\n$1\r\na\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$4\r\nname\r\n$1\r\nb\r\n"},
+		{"XREVRANGE", []string{"XREVRANGE", "s", "+", "-", "COUNT", "1"}, "*1\r\n*2\r\n$3\r\n2-0\r\n*4\r\n$4\r\nname\r\n$1\r\nc\r\n$3\r\nage\r\n$1\r\n3\r\n"},
+		{"XREAD", []string{"XREAD", "COUNT", "1", "STREAMS", "s", "1-1"}, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$4\r\nname\r\n$1\r\nb\r\n"},
+		{"XREAD nothing new", []string{"XREAD", "STREAMS", "s", "$"}, "*-1\r\n"},
+		{"XGROUP CREATE", []string{"XGROUP", "CREATE", "s", "g", "0"}, "+OK\r\n"},
+		{"XGROUP CREATE busy", []string{"XGROUP", "CREATE", "s", "g", "$"}, "-BUSYGROUP Consumer Group name already exists\r\n"},
+		{"XREADGROUP new", []string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"}, "*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$4\r\nname\r\n$1\r\na\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$4\r\nname\r\n$1\r\nb\r\n"},
+		{"XREADGROUP history", []string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "1-1"}, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$4\r\nname\r\n$1\r\nb\r\n"},
+		{"XPENDING summary", []string{"XPENDING", "s", "g"}, "*4\r\n:2\r\n$3\r\n1-1\r\n$3\r\n1-2\r\n*1\r\n*2\r\n$5\r\nalice\r\n$1\r\n2\r\n"},
+		{"XACK", []string{"XACK", "s", "g", "1-1", "9-9"}, ":1\r\n"},
+		{"XCLAIM JUSTID", []string{"XCLAIM", "s", "g", "bob", "0", "1-2", "JUSTID"}, "*1\r\n$3\r\n1-2\r\n"},
+		{"XPENDING extended", []string{"XPENDING", "s", "g", "-", "+", "10", "alice"}, "*0\r\n"},
+		{"XREADGROUP missing group", []string{"XREADGROUP", "GROUP", "nope", "c", "STREAMS", "s", ">"}, "-NOGROUP No such key or consumer group\r\n"},
+		{"XTRIM", []string{"XTRIM", "s", "MAXLEN", "1"}, ":2\r\n"},
+		{"XGROUP DESTROY", []string{"XGROUP", "DESTROY", "s", "g"}, ":1\r\n"},
+		{"XGROUP CREATE missing key", []string{"XGROUP", "CREATE", "nokey", "g", "$", "MKSTREAM"}, "+OK\r\n"},
+		{"XLEN empty stream", []string{"XLEN", "nokey"}, ":0\r\n"},
+		{"SADD set", []string{"SADD", "set", "a"}, ":1\r\n"},
+		{"XADD wrong type", []string{"XADD", "set", "*", "f", "v"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmd); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRedisService_BlockingXRead(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	start := time.Now()
+	if got := runCommands(t, svc, []string{"XREAD", "BLOCK", "50", "STREAMS", "s", "$"}); got != "*-1\r\n" {
+		t.Errorf("Expected timeout reply, got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("XREAD returned before timeout: %v", elapsed)
+	}
+
+	done := make(chan string)
+	go func() {
+		done <- runCommands(t, svc, []string{"XREAD", "BLOCK", "0", "STREAMS", "other", "s", "$", "$"})
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	runCommands(t, svc, []string{"XADD", "s", "5-0", "f", "v"})
+
+	select {
+	case got := <-done:
+		expected := "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n5-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"
+		if got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Blocked XREAD was not woken up by XADD")
+	}
+}
+
+func TestStreamPersistence(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "stream_persistence_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = filepath.Join(tmpDir, "wal.log")
+	logger := config.NewLogger("Test")
+
+	svc := service.NewStorageService(cfg, logger)
+	for i := 1; i <= 4; i++ {
+		if _, _, err := svc.XAdd(protocol.OpPayloadXAdd{Key: "s", ID: protocol.StreamID{Ms: uint64(i)}, Fields: []string{"n", strconv.Itoa(i)}}); err != nil {
+			t.Fatalf("XAdd failed: %v", err)
+		}
+	}
+	if err := svc.XGroupCreate("s", "g", protocol.StreamReadID{ID: protocol.MinStreamID}, false); err != nil {
+		t.Fatalf("XGroupCreate failed: %v", err)
+	}
+	_, err = svc.XReadGroup(protocol.OpPayloadXReadGroup{Group: "g", Consumer: "c", Count: 2, Block: -1,
+		Keys: []string{"s"}, IDs: []protocol.StreamReadID{{New: true}}})
+	if err != nil {
+		t.Fatalf("XReadGroup failed: %v", err)
+	}
+	if err := svc.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	svc.XAck("s", "g", []protocol.StreamID{{Ms: 1}})
+	svc.XTrim("s", protocol.StreamTrim{MaxLen: 3})
+	svc.XAdd(protocol.OpPayloadXAdd{Key: "s", AutoSeq: true, ID: protocol.StreamID{Ms: 4}, Fields: []string{"n", "5"}})
+
+	restored := service.NewStorageService(cfg, logger)
+	entries, err := restored.XRange(protocol.OpPayloadXRange{Key: "s", Start: protocol.MinStreamID, End: protocol.MaxStreamID, Count: -1})
+	if err != nil {
+		t.Fatalf("XRange failed: %v", err)
+	}
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.ID.String())
+	}
+	if got := strings.Join(ids, " "); got != "2-0 3-0 4-0 4-1" {
+		t.Errorf("Expected entries 2-0 3-0 4-0 4-1, got %s", got)
+	}
+
+	pending, err := restored.XPending(protocol.OpPayloadXPending{Key: "s", Group: "g", Extended: true,
+		Start: protocol.MinStreamID, End: protocol.MaxStreamID, Count: 10})
+	if err != nil {
+		t.Fatalf("XPending failed: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != (protocol.StreamID{Ms: 2}) || pending[0].Consumer != "c" {
+		t.Errorf("Unexpected pending entries %+v", pending)
+	}
+
+	// Group continues after last delivered entry
+	results, err := restored.XReadGroup(protocol.OpPayloadXReadGroup{Group: "g", Consumer: "c", Count: -1, Block: -1,
+		Keys: []string{"s"}, IDs: []protocol.StreamReadID{{New: true}}})
+	if err != nil {
+		t.Fatalf("XReadGroup failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Entries) != 3 || results[0].Entries[0].ID != (protocol.StreamID{Ms: 3}) {
+		t.Errorf("Unexpected XREADGROUP result %+v", results)
+	}
+}
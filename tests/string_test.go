@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/service"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOpParserStringCommands(t *testing.T) {
+	parse := func(args ...string) (*protocol.Op, error) {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		return opParser.Parse()
+	}
+
+	tests := []struct {
+		args     []string
+		expected protocol.OpPayload
+	}{
+		{[]string{"INCR", "k"}, protocol.OpPayloadIncrBy{Key: "k", Delta: 1}},
+		{[]string{"DECRBY", "k", "5"}, protocol.OpPayloadIncrBy{Key: "k", Delta: -5}},
+		{[]string{"INCRBYFLOAT", "k", "0.5"}, protocol.OpPayloadIncrByFloat{Key: "k", Delta: 0.5}},
+		{[]string{"GETRANGE", "k", "0", "-1"}, protocol.OpPayloadGetRange{Key: "k", Start: 0, End: -1}},
+		{[]string{"SETRANGE", "k", "3", "abc"}, protocol.OpPayloadSetRange{Key: "k", Offset: 3, Value: "abc"}},
+		{[]string{"GETDEL", "k"}, protocol.OpPayloadGetDel{Key: "k"}},
+		{[]string{"SET", "k", "v", "nx", "GET", "EX", "5"}, protocol.OpPayloadSet{Key: "k", Value: protocol.Resp2BulkString("v"), NX: true, Get: true, ExpireMs: 5000}},
+	}
+	for _, tt := range tests {
+		op, err := parse(tt.args...)
+		if err != nil {
+			t.Errorf("Parse %v failed: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(op.Payload, tt.expected) {
+			t.Errorf("Parse %v: expected %+v, got %+v", tt.args, tt.expected, op.Payload)
+		}
+
+		renderParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(nil))
+		data, err := renderParser.Render(op)
+		if err != nil {
+			t.Errorf("Render %v failed: %v", tt.args, err)
+			continue
+		}
+		reparser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(data))
+		again, err := reparser.Parse()
+		if err != nil || !reflect.DeepEqual(again.Payload, tt.expected) {
+			t.Errorf("Re-parse %v: expected %+v, got %+v (%v)", tt.args, tt.expected, again, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"INCRBY", "k", "x"},
+		{"DECRBY", "k", "-9223372036854775808"},
+		{"INCRBYFLOAT", "k", "inf"},
+		{"SETRANGE", "k", "-1", "v"},
+		{"SET", "k", "v", "NX", "XX"},
+		{"APPEND", "k"},
+	}
+	for _, args := range invalid {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_StringCommands(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmd      []string
+		expected string
+	}{
+		{"SET XX missing key", []string{"SET", "k", "v", "XX"}, "$-1\r\n"},
+		{"SET NX", []string{"SET", "k", "v", "NX"}, "+OK\r\n"},
+		{"SET NX existing key", []string{"SET", "k", "w", "NX"}, "$-1\r\n"},
+		{"SET GET", []string{"SET", "k", "w", "GET"}, "$1\r\nv\r\n"},
+		{"SET NX GET", []string{"SET", "k", "x", "NX", "GET"}, "$1\r\nw\r\n"},
+		{"GETSET", []string{"GETSET", "k", "hello"}, "$1\r\nw\r\n"},
+		{"APPEND", []string{"APPEND", "k", " world"}, ":11\r\n"},
+		{"STRLEN", []string{"STRLEN", "k"}, ":11\r\n"},
+		{"GETRANGE", []string{"GETRANGE", "k", "0", "4"}, "$5\r\nhello\r\n"},
+		{"GETRANGE negative", []string{"GETRANGE", "k", "-5", "-1"}, "$5\r\nworld\r\n"},
+		{"GETRANGE out of range", []string{"GETRANGE", "k", "20", "30"}, "$0\r\n\r\n"},
+		{"SETRANGE", []string{"SETRANGE", "k", "6", "Redis"}, ":11\r\n"},
+		{"GET after SETRANGE", []string{"GET", "k"}, "$11\r\nhello Redis\r\n"},
+		{"SETRANGE pads", []string{"SETRANGE", "p", "2", "x"}, ":3\r\n"},
+		{"GET padded", []string{"GET", "p"}, "$3\r\n\x00\x00x\r\n"},
+		{"SETRANGE max offset", []string{"SETRANGE", "p", "9223372036854775807", "a"}, "-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n"},
+		{"INCR missing key", []string{"INCR", "n"}, ":1\r\n"},
+		{"INCRBY", []string{"INCRBY", "n", "10"}, ":11\r\n"},
+		{"DECR", []string{"DECR", "n"}, ":10\r\n"},
+		{"DECRBY", []string{"DECRBY", "n", "20"}, ":-10\r\n"},
+		{"INCR not integer", []string{"INCR", "k"}, "-ERR value is not an integer or out of range\r\n"},
+		{"SET max", []string{"SET", "m", "9223372036854775807"}, "+OK\r\n"},
+		{"INCR overflow", []string{"INCR", "m"}, "-ERR increment or decrement would overflow\r\n"},
+		{"INCRBYFLOAT", []string{"INCRBYFLOAT", "f", "10.5"}, "$4\r\n10.5\r\n"},
+		{"INCRBYFLOAT integer result", []string{"INCRBYFLOAT", "f", "0.5"}, "$2\r\n11\r\n"},
+		{"INCR after INCRBYFLOAT", []string{"INCR", "f"}, ":12\r\n"},
+		{"INCRBYFLOAT not float", []string{"INCRBYFLOAT", "k", "1"}, "-ERR value is not a valid float\r\n"},
+		{"GETDEL", []string{"GETDEL", "n"}, "$3\r\n-10\r\n"},
+		{"GETDEL missing key", []string{"GETDEL", "n"}, "$-1\r\n"},
+		{"SADD", []string{"SADD", "s", "a"}, ":1\r\n"},
+		{"GET composite value", []string{"GET", "s"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"INCR composite value", []string{"INCR", "s"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"SET GET composite value", []string{"SET", "s", "v", "GET"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"SET overwrites composite value", []string{"SET", "s", "v"}, "+OK\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmd); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRedisService_StringCommandsKeepTTL(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	got := runCommands(t, svc,
+		[]string{"SET", "n", "1", "EX", "100"},
+		[]string{"INCR", "n"},
+		[]string{"APPEND", "n", "0"},
+		[]string{"TTL", "n"},
+		[]string{"GETSET", "n", "0"},
+		[]string{"TTL", "n"},
+	)
+	expected := ":2\r\n:2\r\n:100\r\n$2\r\n20\r\n:-1\r\n"
+	if got != "+OK\r\n"+expected {
+		t.Errorf("expected %q, got %q", "+OK\r\n"+expected, got)
+	}
+}
+
+func TestStringPersistence(t *testing.T) {
+	svc, cfg, tmpDir := newExpireTestService(t)
+	defer os.RemoveAll(tmpDir)
+
+	svc.IncrBy("counter", 5)
+	svc.IncrByFloat("float", 0.1)
+	svc.IncrByFloat("float", 0.2)
+	svc.Append("str", "hello")
+	svc.SetRange("str", 7, "!")
+	svc.GetSet("old", protocol.Resp2BulkString("v"))
+	svc.GetDel("old")
+
+	restored := service.NewStorageService(cfg, config.NewLogger("Test"))
+	expected := map[string]protocol.Resp2Value{
+		"counter": protocol.Resp2BulkString("5"),
+		"float":   protocol.Resp2BulkString("0.30000000000000004"),
+		"str":     protocol.Resp2BulkString("hello\x00\x00!"),
+		"old":     nil,
+	}
+	for key, value := range expected {
+		got, found, err := restored.Get(key)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", key, err)
+		}
+		if found != (value != nil) {
+			t.Errorf("Key %s: expected found %v", key, value != nil)
+		}
+		if !reflect.DeepEqual(got, value) {
+			t.Errorf("Key %s: expected %#v, got %#v", key, value, got)
+		}
+	}
+}
+
+func TestRedisService_GetStoredNil(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(t.TempDir(), "snapshot.db")
+	cfg.WAL.Path = filepath.Join(t.TempDir(), "wal.log")
+	logger := config.NewLogger("Test")
+	storageSvc := service.NewStorageService(cfg, logger)
+	svc := service.NewRedisServices(storageSvc, raft.NewNetwork(cfg.Network), cfg, logger)
+
+	if err := storageSvc.Set("nil", nil); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, found, err := storageSvc.Get("nil"); err != nil || !found || value != nil {
+		t.Errorf("Expected stored nil to be found, got %v, %v, %v", value, found, err)
+	}
+	if _, found, _ := storageSvc.Get("missing"); found {
+		t.Errorf("Expected missing key not to be found")
+	}
+	// Key-value API used by gRPC sees it as an empty string
+	if kvs, _, _, err := storageSvc.Range("nil", "", 0); err != nil || len(kvs) != 1 || kvs[0].Value != "" {
+		t.Errorf("Expected stored nil in range, got %v, %v", kvs, err)
+	}
+	// Only a missing key is replied as null
+	if got := runCommands(t, svc, []string{"GET", "nil"}, []string{"GET", "missing"}); got != "$0\r\n\r\n$-1\r\n" {
+		t.Errorf("Expected empty and null bulk strings, got %q", got)
+	}
+}
@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"fmt"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/service"
+	"main/src/storage"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Small sizes, so a few hundred values are spread over several segments
+func smallTieredOptions() storage.TieredOptions {
+	return storage.TieredOptions{HotSize: 4096, SegmentSize: 8192, GarbageRatio: 0.5, Samples: 5}
+}
+
+func makeTestTiered[T any](t *testing.T) *storage.TieredStorage[T] {
+	t.Helper()
+	store := storage.MakeTieredStorage[T](t.TempDir(), smallTieredOptions())
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestTieredStorage(t *testing.T) {
+	store := makeTestTiered[string](t)
+	RunStorageTests(t, store)
+
+	if store.ColdKeys() == 0 {
+		t.Errorf("Expected values over the hot size to be moved to disk")
+	}
+	// The contract holds once values are read back from the log
+	if value, found, err := store.Get("key-0"); err != nil || !found || value != "value-0" {
+		t.Errorf("Expected cold value, got %q, %v", value, err)
+	}
+	if exists, _ := store.Exists("deleteKey"); exists {
+		t.Errorf("Expected deleted key to stay deleted")
+	}
+}
+
+func TestTieredStorage_SpillsColdValues(t *testing.T) {
+	store := makeTestTiered[protocol.Resp2Value](t)
+	index := uint64(0)
+	apply := func(opType protocol.OpType, key string, value protocol.Resp2Value) {
+		t.Helper()
+		index++
+		if err := storage.Apply(storage.Storage[protocol.Resp2Value](store), storage.WalEntry[protocol.Resp2Value]{Index: index, OpType: opType, Key: key, Value: value}); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+	value := func(i int) protocol.Resp2Value {
+		return protocol.Resp2BulkString(fmt.Sprintf("%03d", i) + strings.Repeat("v", 200))
+	}
+
+	apply(protocol.SADD, "set", []protocol.Resp2Value{protocol.Resp2BulkString("a")})
+	store.SetExpire("set", time.Now().Add(time.Hour).UnixMilli())
+	for i := 0; i < 100; i++ {
+		apply(protocol.SET, fmt.Sprintf("key-%03d", i), value(i))
+	}
+	cold := store.ColdKeys()
+	if cold < 50 {
+		t.Fatalf("Expected most values moved to disk, got %d cold keys", cold)
+	}
+	// Keys of cold values still take some memory, values do not
+	if used := store.UsedMemory(); used > 4096+int64(cold)*100 {
+		t.Errorf("Expected values over the hot size to leave memory, got %d bytes", used)
+	}
+
+	// Cold set is modified in place, members survive moving it to disk again
+	if setMemory := store.KeyMemory("set"); setMemory > 100 {
+		t.Fatalf("Expected the first written set to be cold, got %d bytes", setMemory)
+	}
+	apply(protocol.SADD, "set", []protocol.Resp2Value{protocol.Resp2BulkString("b")})
+	for i := 100; i < 200; i++ {
+		apply(protocol.SET, fmt.Sprintf("key-%03d", i), value(i))
+	}
+	set, err := storage.GetSet(storage.Storage[protocol.Resp2Value](store), "set")
+	if err != nil || !set.Contains("a") || !set.Contains("b") {
+		t.Errorf("Expected set members a and b, got %v, %v", set, err)
+	}
+	if _, ok := store.GetExpire("set"); !ok || store.Revision("set") != 102 {
+		t.Errorf("Expected deadline and revision of a cold key to stay, got revision %d", store.Revision("set"))
+	}
+
+	// A read promotes the key on the next write
+	if store.KeyMemory("key-000") > 100 {
+		t.Fatalf("Expected key-000 to be cold before it is read")
+	}
+	// Promoted key is more recent than keys written so far, so it is not moved back right away
+	time.Sleep(2 * time.Millisecond)
+	store.Get("key-000")
+	apply(protocol.SET, "other", protocol.Resp2BulkString("v"))
+	if store.KeyMemory("key-000") < 200 {
+		t.Errorf("Expected key read before the write to be promoted to memory")
+	}
+	// Values are read back transparently
+	for i := 0; i < 200; i++ {
+		if got, _, err := store.Get(fmt.Sprintf("key-%03d", i)); err != nil || got != value(i) {
+			t.Fatalf("Expected value of key-%03d, got %v, %v", i, got, err)
+		}
+	}
+
+	count := 0
+	store.Iterator()(func(key string, v protocol.Resp2Value) bool {
+		count++
+		return true
+	})
+	if count != 202 {
+		t.Errorf("Expected iterator to walk hot and cold keys, got %d", count)
+	}
+	apply(protocol.DELETE, "key-001", nil)
+	if exists, _ := store.Exists("key-001"); exists {
+		t.Errorf("Expected deleted cold key to be gone")
+	}
+}
+
+func TestTieredStorage_Compaction(t *testing.T) {
+	store := makeTestTiered[string](t)
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 100; i++ {
+			store.Set(fmt.Sprintf("key-%03d", i), fmt.Sprintf("%d-%s", round, strings.Repeat("v", 200)))
+		}
+	}
+
+	// Every round turns records of the previous one into garbage, compactor reclaims it
+	var size, live int64
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		size, live = store.DiskUsage()
+		if size-live <= 2*8192 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if size-live > 2*8192 {
+		t.Errorf("Expected garbage to be compacted, got %d bytes of %d", size-live, size)
+	}
+	for i := 0; i < 100; i++ {
+		if value, _, err := store.Get(fmt.Sprintf("key-%03d", i)); err != nil || !strings.HasPrefix(value, "9-") {
+			t.Fatalf("Expected the last value of key-%03d, got %q, %v", i, value, err)
+		}
+	}
+}
+
+func TestStorageEngine_Tiered(t *testing.T) {
+	if _, err := storage.NewEngine[protocol.Resp2Value](storage.EngineTiered); err == nil {
+		t.Errorf("Expected error for engine which needs a directory")
+	}
+
+	cfg := watchTestConfig(t)
+	cfg.Storage.Engine = storage.EngineTiered
+	cfg.Storage.Dir = filepath.Join(t.TempDir(), "tiered")
+	cfg.Storage.HotSize = 4096
+	logger := config.NewLogger("Test")
+	svc := service.NewStorageService(cfg, logger)
+
+	for i := 0; i < 100; i++ {
+		if err := svc.Set(fmt.Sprintf("key-%03d", i), protocol.Resp2BulkString(strings.Repeat("v", 200))); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if _, err := svc.SAdd("members", []string{"a", "b"}); err != nil {
+		t.Fatalf("SAdd failed: %v", err)
+	}
+	if err := svc.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	// Written after the snapshot, recovered from the WAL
+	svc.Del([]string{"key-000"})
+	if _, err := svc.SRem("members", []string{"a"}); err != nil {
+		t.Fatalf("SRem failed: %v", err)
+	}
+
+	restored := service.NewStorageService(cfg, logger)
+	for _, s := range []*service.StorageService{svc, restored} {
+		if n := s.DBSize(); n != 100 {
+			t.Errorf("Expected 100 keys, got %d", n)
+		}
+		if value, found, err := s.Get("key-050"); err != nil || !found || value != protocol.Resp2BulkString(strings.Repeat("v", 200)) {
+			t.Errorf("Expected value of a cold key, got %v, %v", value, err)
+		}
+		if members, err := s.SMembers("members"); err != nil || !slices.Equal(members, []string{"b"}) {
+			t.Errorf("Expected members [b], got %v, %v", members, err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/raft/pb"
+	"main/src/service"
+	"main/src/storage"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpParserTxCommands(t *testing.T) {
+	parse := func(args ...string) (*protocol.Op, error) {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		return opParser.Parse()
+	}
+
+	op, err := parse("WATCH", "a", "b")
+	if err != nil {
+		t.Fatalf("Parse WATCH failed: %v", err)
+	}
+	if expected := (protocol.OpPayloadWatch{Keys: []string{"a", "b"}}); !reflect.DeepEqual(op.Payload, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, op.Payload)
+	}
+	for _, name := range []string{"MULTI", "EXEC", "DISCARD", "UNWATCH"} {
+		op, err := parse(name)
+		if err != nil {
+			t.Errorf("Parse %s failed: %v", name, err)
+			continue
+		}
+		if op.Kind.String() != name {
+			t.Errorf("Expected %s operation, got %v", name, op.Kind)
+		}
+	}
+
+	invalid := [][]string{{"WATCH"}, {"MULTI", "x"}, {"EXEC", "x"}}
+	for _, args := range invalid {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_Transactions(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmds     [][]string
+		expected string
+	}{
+		{
+			"MULTI EXEC",
+			[][]string{{"MULTI"}, {"SET", "k", "1"}, {"INCR", "k"}, {"GET", "k"}, {"EXEC"}},
+			"+OK\r\n+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n*3\r\n+OK\r\n:2\r\n$1\r\n2\r\n",
+		},
+		{
+			"empty transaction",
+			[][]string{{"MULTI"}, {"EXEC"}},
+			"+OK\r\n*0\r\n",
+		},
+		{
+			"errors do not abort transaction",
+			[][]string{{"MULTI"}, {"SADD", "k", "a"}, {"INCR", "k"}, {"EXEC"}},
+			"+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n:3\r\n",
+		},
+		{
+			"DISCARD",
+			[][]string{{"MULTI"}, {"SET", "k", "discarded"}, {"DISCARD"}, {"GET", "k"}},
+			"+OK\r\n+QUEUED\r\n+OK\r\n$1\r\n3\r\n",
+		},
+		{
+			"misplaced commands",
+			[][]string{{"EXEC"}, {"DISCARD"}, {"MULTI"}, {"MULTI"}, {"WATCH", "k"}, {"EXEC"}},
+			"-ERR EXEC without MULTI\r\n-ERR DISCARD without MULTI\r\n+OK\r\n" +
+				"-ERR MULTI calls can not be nested\r\n-ERR WATCH inside MULTI is not allowed\r\n*0\r\n",
+		},
+		{
+			"WATCH unchanged key",
+			[][]string{{"WATCH", "k", "missing"}, {"MULTI"}, {"INCR", "k"}, {"EXEC"}},
+			"+OK\r\n+OK\r\n+QUEUED\r\n*1\r\n:4\r\n",
+		},
+		{
+			"WATCH modified key",
+			[][]string{{"WATCH", "k"}, {"INCR", "k"}, {"MULTI"}, {"INCR", "k"}, {"EXEC"}, {"GET", "k"}},
+			"+OK\r\n:5\r\n+OK\r\n+QUEUED\r\n*-1\r\n$1\r\n5\r\n",
+		},
+		{
+			"WATCH created key",
+			[][]string{{"WATCH", "new"}, {"SET", "new", "v"}, {"MULTI"}, {"DEL", "new"}, {"EXEC"}},
+			"+OK\r\n+OK\r\n+OK\r\n+QUEUED\r\n*-1\r\n",
+		},
+		{
+			"UNWATCH",
+			[][]string{{"WATCH", "k"}, {"INCR", "k"}, {"UNWATCH"}, {"MULTI"}, {"INCR", "k"}, {"EXEC"}},
+			"+OK\r\n:6\r\n+OK\r\n+OK\r\n+QUEUED\r\n*1\r\n:7\r\n",
+		},
+		{
+			"XREAD BLOCK does not block inside transaction",
+			[][]string{{"MULTI"}, {"XREAD", "BLOCK", "0", "STREAMS", "stream", "$"}, {"EXEC"}},
+			"+OK\r\n+QUEUED\r\n*1\r\n*-1\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmds...); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStorageService_Transaction(t *testing.T) {
+	svc, cfg, tmpDir := newExpireTestService(t)
+	defer os.RemoveAll(tmpDir)
+
+	svc.Set("watched", protocol.Resp2BulkString("1"))
+	watched, err := svc.Watch([]string{"watched"})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	// Modification by another client between WATCH and EXEC aborts the transaction
+	svc.Set("watched", protocol.Resp2BulkString("1"))
+	ok, err := svc.Transaction(watched, func(tx *service.StorageService) {
+		t.Errorf("Transaction with modified watched key must not run")
+	})
+	if err != nil || ok {
+		t.Errorf("Expected transaction to be aborted, got %v (%v)", ok, err)
+	}
+	svc.Unwatch(watched)
+
+	// Commands of a transaction see each other's changes and are logged as one record
+	ok, err = svc.Transaction(nil, func(tx *service.StorageService) {
+		tx.IncrBy("counter", 5)
+		if n, _ := tx.IncrBy("counter", 1); n != 6 {
+			t.Errorf("Expected 6, got %d", n)
+		}
+		tx.SAdd("set", []string{"a", "b"})
+		tx.Del([]string{"watched"})
+	})
+	if err != nil || !ok {
+		t.Fatalf("Expected transaction to succeed, got %v (%v)", ok, err)
+	}
+
+	// Readers are not able to see the transaction half done
+	done := make(chan struct{})
+	go func() {
+		svc.Transaction(nil, func(tx *service.StorageService) {
+			tx.Set("a", protocol.Resp2BulkString("1"))
+			time.Sleep(20 * time.Millisecond)
+			tx.Set("b", protocol.Resp2BulkString("1"))
+		})
+		close(done)
+	}()
+	time.Sleep(5 * time.Millisecond)
+	if n, _ := svc.CountExisting([]string{"a", "b"}); n == 1 {
+		t.Errorf("Expected both or none of the keys to exist")
+	}
+	<-done
+
+	wal, err := storage.NewSimpleWal[protocol.Resp2Value](cfg.WAL.Path)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	entries, err := wal.Replay()
+	wal.Close()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	// Two SET commands and two transactions
+	if len(entries) != 4 || entries[2].OpType != protocol.BATCH || entries[3].OpType != protocol.BATCH {
+		t.Fatalf("Expected transactions to be logged as one BATCH record each, got %+v", entries)
+	}
+
+	restored := service.NewStorageService(cfg, config.NewLogger("Test"))
+	values, _ := restored.MGet([]string{"counter", "watched", "a", "b"})
+	expected := []protocol.Resp2Value{protocol.Resp2BulkString("6"), nil, protocol.Resp2BulkString("1"), protocol.Resp2BulkString("1")}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+	if ok, _ := restored.SIsMember("set", "b"); !ok {
+		t.Errorf("Expected set member to be restored")
+	}
+}
+
+// failingWal fails appends while fail is set
+type failingWal struct {
+	*storage.SimpleWal[protocol.Resp2Value]
+	fail atomic.Bool
+}
+
+func (w *failingWal) Append(entry storage.WalEntry[protocol.Resp2Value], sync bool) error {
+	if w.fail.Load() {
+		return errors.New("disk is full")
+	}
+	return w.SimpleWal.Append(entry, sync)
+}
+
+func TestRedisService_ExecWalFailure(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(t.TempDir(), "snapshot.db")
+	cfg.WAL.Path = filepath.Join(t.TempDir(), "wal.log")
+	simple, err := storage.NewSimpleWal[protocol.Resp2Value](cfg.WAL.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal := &failingWal{SimpleWal: simple}
+	logger := config.NewLogger("Test")
+	svc := service.NewRedisServices(service.NewStorageServiceWithWal(cfg, logger, wal), raft.NewNetwork(cfg.Network), cfg, logger)
+
+	runCommands(t, svc, []string{"SET", "k", "old"}, []string{"EXPIRE", "k", "100"}, []string{"SADD", "s", "a"})
+	wal.fail.Store(true)
+	got := runCommands(t, svc, []string{"MULTI"}, []string{"SET", "k", "new"}, []string{"SADD", "s", "b"},
+		[]string{"SET", "fresh", "v"}, []string{"DEL", "s"}, []string{"EXEC"})
+	if !strings.HasSuffix(got, "-ERR disk is full\r\n") {
+		t.Errorf("Expected EXEC to fail, got %q", got)
+	}
+	wal.fail.Store(false)
+
+	expected := ":100\r\n$3\r\nold\r\n*1\r\n$1\r\na\r\n:0\r\n"
+	check := [][]string{{"TTL", "k"}, {"GET", "k"}, {"SMEMBERS", "s"}, {"EXISTS", "fresh"}}
+	if got := runCommands(t, svc, check...); got != expected {
+		t.Errorf("Expected keyspace unchanged by failed EXEC, got %q", got)
+	}
+	wal.Close()
+	restored := service.NewRedisServices(service.NewStorageService(cfg, logger), raft.NewNetwork(cfg.Network), cfg, logger)
+	if got := runCommands(t, restored, check...); got != expected {
+		t.Errorf("Expected restored keyspace unchanged, got %q", got)
+	}
+}
+
+func TestRedisService_WriteWalFailure(t *testing.T) {
+	cfg := watchTestConfig(t)
+	simple, err := storage.NewSimpleWal[protocol.Resp2Value](cfg.WAL.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal := &failingWal{SimpleWal: simple}
+	logger := config.NewLogger("Test")
+	storageSvc := service.NewStorageServiceWithWal(cfg, logger, wal)
+	svc := service.NewRedisServices(storageSvc, raft.NewNetwork(cfg.Network), cfg, logger)
+	client := pb.NewWatchClient(startGrpc(t, service.NewWatchService(storageSvc, logger).Register))
+
+	runCommands(t, svc, []string{"SET", "k", "old"}, []string{"EXPIRE", "k", "100"}, []string{"SADD", "s", "a"})
+	wal.fail.Store(true)
+	for _, cmd := range [][]string{{"SET", "k", "new"}, {"PERSIST", "k"}, {"SADD", "s", "b"}, {"DEL", "s"}, {"SET", "fresh", "v"}} {
+		if got := runCommands(t, svc, cmd); got != "-ERR disk is full\r\n" {
+			t.Errorf("Expected %v to fail, got %q", cmd, got)
+		}
+	}
+	wal.fail.Store(false)
+
+	expected := ":100\r\n$3\r\nold\r\n*1\r\n$1\r\na\r\n:0\r\n"
+	check := [][]string{{"TTL", "k"}, {"GET", "k"}, {"SMEMBERS", "s"}, {"EXISTS", "fresh"}}
+	if got := runCommands(t, svc, check...); got != expected {
+		t.Errorf("Expected keyspace unchanged by failed writes, got %q", got)
+	}
+
+	// Failed writes are not published, the next write takes the following index
+	runCommands(t, svc, []string{"SET", "next", "v"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Watch(ctx, &pb.WatchRequest{StartIndex: 1})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	got, _ := receive(t, stream, 4)
+	for i, prefix := range []string{"1 SET k ", "2 EXPIRE k ", "3 SADD s ", "4 SET next "} {
+		if !strings.HasPrefix(got[i], prefix) {
+			t.Errorf("Expected event %q, got %q", prefix, got)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/raft"
+	"main/src/raft/pb"
+	"main/src/service"
+	"main/src/storage"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startGrpc serves services registered by register and returns client connection to them
+func startGrpc(t *testing.T, register func(server grpc.ServiceRegistrar)) *grpc.ClientConn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	register(server)
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// setupWatch starts watch service of a fresh storage and returns client connected to it
+func setupWatch(t *testing.T, cfg *config.Config) (*service.RedisService, pb.WatchClient) {
+	t.Helper()
+	logger := config.NewLogger("Test")
+	storageSvc := service.NewStorageService(cfg, logger)
+	redisSvc := service.NewRedisServices(storageSvc, raft.NewNetwork(cfg.Network), cfg, logger)
+	conn := startGrpc(t, service.NewWatchService(storageSvc, logger).Register)
+	return redisSvc, pb.NewWatchClient(conn)
+}
+
+func watchTestConfig(t *testing.T) *config.Config {
+	tmpDir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = filepath.Join(tmpDir, "wal.log")
+	return cfg
+}
+
+// receive reads n responses and renders them as "index op key value" lines
+func receive(t *testing.T, stream grpc.ServerStreamingClient[pb.WatchResponse], n int) ([]string, []*pb.WatchResponse) {
+	t.Helper()
+	var lines []string
+	var responses []*pb.WatchResponse
+	for i := 0; i < n; i++ {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Failed to receive: %v", err)
+		}
+		responses = append(responses, resp)
+		for _, e := range resp.Events {
+			lines = append(lines, fmt.Sprintf("%d %s %s %s", resp.Index, e.Op, e.Key, e.Value))
+		}
+	}
+	return lines, responses
+}
+
+func equalLines(t *testing.T, expected, got []string) {
+	t.Helper()
+	if len(expected) != len(got) {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+	for i := range expected {
+		if expected[i] != got[i] {
+			t.Fatalf("expected %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestWatchService_History(t *testing.T) {
+	svc, client := setupWatch(t, watchTestConfig(t))
+	runCommands(t, svc,
+		[]string{"SET", "a", "1"},
+		[]string{"SADD", "b", "m"},
+		[]string{"MULTI"},
+		[]string{"SET", "a", "2"},
+		[]string{"DEL", "b"},
+		[]string{"EXEC"},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &pb.WatchRequest{StartIndex: 1})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	got, responses := receive(t, stream, 3)
+	equalLines(t, []string{
+		"1 SET a $1\r\n1\r\n",
+		"2 SADD b *1\r\n$1\r\nm\r\n",
+		"3 SET a $1\r\n2\r\n",
+		"3 DELETE b ",
+	}, got)
+
+	// Only keys with the prefix
+	stream, err = client.Watch(ctx, &pb.WatchRequest{StartIndex: 1, Prefix: "a"})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	got, _ = receive(t, stream, 2)
+	equalLines(t, []string{"1 SET a $1\r\n1\r\n", "3 SET a $1\r\n2\r\n"}, got)
+
+	// Resume token continues after the response it came with
+	stream, err = client.Watch(ctx, &pb.WatchRequest{ResumeToken: responses[0].ResumeToken})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	got, _ = receive(t, stream, 1)
+	equalLines(t, []string{"2 SADD b *1\r\n$1\r\nm\r\n"}, got)
+
+	stream, err = client.Watch(ctx, &pb.WatchRequest{ResumeToken: "garbage"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for invalid token, got %v", err)
+	}
+}
+
+func TestWatchService_TailsNewWrites(t *testing.T) {
+	svc, client := setupWatch(t, watchTestConfig(t))
+	runCommands(t, svc, []string{"SET", "old", "1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Watch(ctx, &pb.WatchRequest{})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	// Stream is established once the first write is received, keep writing until then
+	received := make(chan []string, 1)
+	go func() {
+		got, _ := receive(t, stream, 1)
+		received <- got
+	}()
+	var got []string
+	for got == nil {
+		runCommands(t, svc, []string{"SET", "new", "v"})
+		select {
+		case got = <-received:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if len(got) != 1 || got[0][2:] != "SET new $1\r\nv\r\n" {
+		t.Errorf("Expected only new write, got %q", got)
+	}
+}
+
+func TestWatchService_Compacted(t *testing.T) {
+	cfg := watchTestConfig(t)
+	cfg.WAL.WatchHistory = 1
+	svc, client := setupWatch(t, cfg)
+	runCommands(t, svc, []string{"SET", "a", "1"}, []string{"SET", "a", "2"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Watch(ctx, &pb.WatchRequest{StartIndex: 1})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.OutOfRange {
+		t.Errorf("Expected OutOfRange for dropped history, got %v", err)
+	}
+
+	stream, err = client.Watch(ctx, &pb.WatchRequest{StartIndex: 2})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	got, _ := receive(t, stream, 1)
+	equalLines(t, []string{"2 SET a $1\r\n2\r\n"}, got)
+}
+
+func TestWalIndexSurvivesSnapshot(t *testing.T) {
+	cfg := watchTestConfig(t)
+	logger := config.NewLogger("Test")
+	svc := service.NewStorageService(cfg, logger)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := svc.Set(key, protocol.Resp2BulkString("v")); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if err := svc.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	restored := service.NewStorageService(cfg, logger)
+	if err := restored.Set("d", protocol.Resp2BulkString("v")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	wal, err := storage.NewSimpleWal[protocol.Resp2Value](cfg.WAL.Path)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	defer wal.Close()
+	entries, err := wal.Replay()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Index != 4 || entries[0].Timestamp == 0 {
+		t.Errorf("Expected entry with index 4 after snapshot and restart, got %+v", entries)
+	}
+}
@@ -0,0 +1,258 @@
+package tests
+
+import (
+	"fmt"
+	"main/src/config"
+	"main/src/protocol"
+	"main/src/service"
+	"main/src/storage"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestZSetAgainstSortedSlice(t *testing.T) {
+	z := storage.NewZSet()
+	reference := map[string]float64{}
+	rng := rand.New(rand.NewSource(42))
+
+	for i := 0; i < 5000; i++ {
+		member := fmt.Sprintf("m%d", rng.Intn(500))
+		if rng.Intn(4) == 0 {
+			_, existed := reference[member]
+			if z.Remove(member) != existed {
+				t.Fatalf("Remove(%s) mismatch", member)
+			}
+			delete(reference, member)
+			continue
+		}
+		score := float64(rng.Intn(100))
+		_, existed := reference[member]
+		if z.Set(member, score) == existed {
+			t.Fatalf("Set(%s) mismatch", member)
+		}
+		reference[member] = score
+	}
+
+	expected := make([]storage.ZEntry, 0, len(reference))
+	for m, s := range reference {
+		expected = append(expected, storage.ZEntry{Member: m, Score: s})
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		if expected[i].Score != expected[j].Score {
+			return expected[i].Score < expected[j].Score
+		}
+		return expected[i].Member < expected[j].Member
+	})
+
+	if z.Len() != len(expected) {
+		t.Fatalf("Expected length %d, got %d", len(expected), z.Len())
+	}
+	if got := z.Entries(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Entries order mismatch")
+	}
+
+	for i, e := range expected {
+		rank, ok := z.Rank(e.Member, false)
+		if !ok || rank != i {
+			t.Fatalf("Rank(%s) expected %d, got %d", e.Member, i, rank)
+		}
+		rank, _ = z.Rank(e.Member, true)
+		if rank != len(expected)-1-i {
+			t.Fatalf("RevRank(%s) expected %d, got %d", e.Member, len(expected)-1-i, rank)
+		}
+	}
+
+	t.Run("RangeByRank", func(t *testing.T) {
+		got := z.RangeByRank(10, 19, false)
+		if !reflect.DeepEqual(got, expected[10:20]) {
+			t.Errorf("Unexpected range %v", got)
+		}
+		got = z.RangeByRank(-3, -1, true)
+		want := []storage.ZEntry{expected[2], expected[1], expected[0]}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("RangeByScore", func(t *testing.T) {
+		min := protocol.ScoreBound{Value: 20, Exclusive: true}
+		max := protocol.ScoreBound{Value: 30}
+		var want []storage.ZEntry
+		for _, e := range expected {
+			if e.Score > 20 && e.Score <= 30 {
+				want = append(want, e)
+			}
+		}
+		got := z.RangeByScore(min, max, false, 0, -1)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Score range mismatch, expected %d elements got %d", len(want), len(got))
+		}
+		got = z.RangeByScore(min, max, true, 2, 3)
+		rev := []storage.ZEntry{want[len(want)-3], want[len(want)-4], want[len(want)-5]}
+		if !reflect.DeepEqual(got, rev) {
+			t.Errorf("Expected %v, got %v", rev, got)
+		}
+	})
+}
+
+func TestZSetRangeByLex(t *testing.T) {
+	z := storage.NewZSet()
+	for _, m := range []string{"a", "b", "c", "d", "e"} {
+		z.Set(m, 0)
+	}
+	bound := func(s string) protocol.LexBound {
+		b, err := protocol.ParseLexBound(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	members := func(entries []storage.ZEntry) []string {
+		out := []string{}
+		for _, e := range entries {
+			out = append(out, e.Member)
+		}
+		return out
+	}
+
+	if got := members(z.RangeByLex(bound("[b"), bound("(d"), false, 0, -1)); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Errorf("Expected [b c], got %v", got)
+	}
+	if got := members(z.RangeByLex(bound("-"), bound("+"), true, 1, 2)); !reflect.DeepEqual(got, []string{"d", "c"}) {
+		t.Errorf("Expected [d c], got %v", got)
+	}
+	if got := members(z.RangeByLex(bound("(e"), bound("+"), false, 0, -1)); len(got) != 0 {
+		t.Errorf("Expected empty range, got %v", got)
+	}
+}
+
+func TestOpParserZRangeVariants(t *testing.T) {
+	parse := func(args ...string) protocol.OpPayloadZRange {
+		t.Helper()
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		op, err := opParser.Parse()
+		if err != nil {
+			t.Fatalf("Parse %v failed: %v", args, err)
+		}
+		return op.Payload.(protocol.OpPayloadZRange)
+	}
+
+	legacy := parse("ZREVRANGEBYSCORE", "k", "+inf", "(5", "WITHSCORES", "LIMIT", "1", "2")
+	unified := parse("ZRANGE", "k", "+inf", "(5", "BYSCORE", "REV", "LIMIT", "1", "2", "WITHSCORES")
+	if !reflect.DeepEqual(legacy, unified) {
+		t.Errorf("Expected same payload, got %+v and %+v", legacy, unified)
+	}
+	if legacy.MinScore != (protocol.ScoreBound{Value: 5, Exclusive: true}) || !math.IsInf(legacy.MaxScore.Value, 1) {
+		t.Errorf("Unexpected bounds %+v %+v", legacy.MinScore, legacy.MaxScore)
+	}
+
+	// Render produces unified form which parses back to the same payload
+	renderParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(nil))
+	data, err := renderParser.Render(&protocol.Op{Kind: protocol.ZRANGE, Payload: legacy})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes(data))
+	op, err := opParser.Parse()
+	if err != nil {
+		t.Fatalf("Re-parse failed: %v", err)
+	}
+	if !reflect.DeepEqual(op.Payload, legacy) {
+		t.Errorf("Expected %+v, got %+v", legacy, op.Payload)
+	}
+
+	invalid := [][]string{
+		{"ZRANGE", "k", "0", "1", "LIMIT", "0", "1"},
+		{"ZRANGEBYSCORE", "k", "a", "1"},
+		{"ZRANGEBYLEX", "k", "a", "[b"},
+		{"ZADD", "k", "NX", "XX", "1", "a"},
+		{"ZADD", "k", "INCR", "1", "a", "2", "b"},
+	}
+	for _, args := range invalid {
+		opParser := protocol.MakeOpParser(protocol.NewResp2ParserFromBytes([]byte(respCommand(args...))))
+		if _, err := opParser.Parse(); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestRedisService_ZSetCommands(t *testing.T) {
+	svc, tmpDir := setupTestRedis(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		cmd      []string
+		expected string
+	}{
+		{"ZADD", []string{"ZADD", "lb", "10", "alice", "20", "bob", "15", "carol"}, ":3\r\n"},
+		{"ZADD NX", []string{"ZADD", "lb", "NX", "99", "alice", "5", "dave"}, ":1\r\n"},
+		{"ZADD XX CH", []string{"ZADD", "lb", "XX", "CH", "11", "alice", "1", "eve"}, ":1\r\n"},
+		{"ZADD GT skips lower", []string{"ZADD", "lb", "GT", "CH", "1", "bob"}, ":0\r\n"},
+		{"ZCARD", []string{"ZCARD", "lb"}, ":4\r\n"},
+		{"ZSCORE", []string{"ZSCORE", "lb", "alice"}, "$2\r\n11\r\n"},
+		{"ZSCORE missing", []string{"ZSCORE", "lb", "eve"}, "$-1\r\n"},
+		{"ZRANK", []string{"ZRANK", "lb", "carol"}, ":2\r\n"},
+		{"ZREVRANK", []string{"ZREVRANK", "lb", "carol"}, ":1\r\n"},
+		{"ZINCRBY", []string{"ZINCRBY", "lb", "2.5", "dave"}, "$3\r\n7.5\r\n"},
+		{"ZADD INCR", []string{"ZADD", "lb", "INCR", "-0.5", "dave"}, "$1\r\n7\r\n"},
+		{"ZADD INCR NX aborted", []string{"ZADD", "lb", "NX", "INCR", "1", "dave"}, "$-1\r\n"},
+		{"ZRANGE", []string{"ZRANGE", "lb", "0", "-1"}, "*4\r\n$4\r\ndave\r\n$5\r\nalice\r\n$5\r\ncarol\r\n$3\r\nbob\r\n"},
+		{"ZREVRANGE WITHSCORES", []string{"ZREVRANGE", "lb", "0", "1", "WITHSCORES"}, "*4\r\n$3\r\nbob\r\n$2\r\n20\r\n$5\r\ncarol\r\n$2\r\n15\r\n"},
+		{"ZRANGEBYSCORE", []string{"ZRANGEBYSCORE", "lb", "(7", "15"}, "*2\r\n$5\r\nalice\r\n$5\r\ncarol\r\n"},
+		{"ZRANGE BYSCORE REV LIMIT", []string{"ZRANGE", "lb", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2"}, "*2\r\n$5\r\ncarol\r\n$5\r\nalice\r\n"},
+		{"ZREM", []string{"ZREM", "lb", "bob", "nobody"}, ":1\r\n"},
+		{"ZADD lex", []string{"ZADD", "names", "0", "a", "0", "b", "0", "c"}, ":3\r\n"},
+		{"ZRANGEBYLEX", []string{"ZRANGEBYLEX", "names", "(a", "+"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"ZREVRANGEBYLEX", []string{"ZREVRANGEBYLEX", "names", "[b", "-"}, "*2\r\n$1\r\nb\r\n$1\r\na\r\n"},
+		{"ZREM all deletes key", []string{"ZREM", "names", "a", "b", "c"}, ":3\r\n"},
+		{"ZCARD deleted", []string{"ZCARD", "names"}, ":0\r\n"},
+		{"ZADD wrong type", []string{"SADD", "set", "a"}, ":1\r\n"},
+		{"ZSCORE wrong type", []string{"ZSCORE", "set", "a"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runCommands(t, svc, tt.cmd); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestZSetPersistence(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "zset_persistence_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.DefaultConfig()
+	cfg.Snapshot.Path = filepath.Join(tmpDir, "snapshot.db")
+	cfg.WAL.Path = filepath.Join(tmpDir, "wal.log")
+	logger := config.NewLogger("Test")
+
+	svc := service.NewStorageService(cfg, logger)
+	svc.ZAdd("lb", protocol.ZAddFlags{}, []protocol.ZScoreMember{{Score: 1.1, Member: "a"}, {Score: 2, Member: "b"}})
+	if err := svc.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	svc.ZIncrBy("lb", protocol.ZAddFlags{}, 0.2, "a")
+	svc.ZAdd("lb", protocol.ZAddFlags{}, []protocol.ZScoreMember{{Score: math.Inf(-1), Member: "c"}})
+	svc.ZRem("lb", []string{"b"})
+
+	restored := service.NewStorageService(cfg, logger)
+	got, err := restored.ZRange(protocol.OpPayloadZRange{Key: "lb", Start: 0, Stop: -1})
+	if err != nil {
+		t.Fatalf("ZRange failed: %v", err)
+	}
+	want := []storage.ZEntry{{Member: "c", Score: math.Inf(-1)}, {Member: "a", Score: 1.1 + 0.2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}